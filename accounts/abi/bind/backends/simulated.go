@@ -426,6 +426,9 @@ func (fb *filterBackend) HeaderByNumber(ctx context.Context, block rpc.BlockNumb
 	if block == rpc.LatestBlockNumber {
 		return fb.bc.CurrentHeader(), nil
 	}
+	if block == rpc.StableBlockNumber {
+		return fb.bc.StableBlock().Header(), nil
+	}
 	return fb.bc.GetHeaderByNumber(uint64(block.Int64())), nil
 }
 
@@ -454,6 +457,9 @@ func (fb *filterBackend) SubscribeTxPreEvent(ch chan<- core.TxPreEvent) event.Su
 func (fb *filterBackend) SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription {
 	return fb.bc.SubscribeChainEvent(ch)
 }
+func (fb *filterBackend) SubscribeChainStableEvent(ch chan<- core.ChainStableEvent) event.Subscription {
+	return fb.bc.SubscribeChainStableEvent(ch)
+}
 func (fb *filterBackend) SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription {
 	return fb.bc.SubscribeRemovedLogsEvent(ch)
 }
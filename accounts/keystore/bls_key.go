@@ -0,0 +1,92 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package keystore
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/crypto/bls"
+	"github.com/pborman/uuid"
+)
+
+// blsKeyDir is the subdirectory of the keystore holding the BLS keys stars vote
+// with, one file per address. The account cache skips directories, so BLS keys
+// are never mistaken for accounts.
+const blsKeyDir = "bls"
+
+// BLSKey decrypts the BLS key the given address votes with. If the keystore
+// holds no BLS key for the address yet, a random one is generated and stored,
+// encrypted with the passphrase. The address doesn't need to be an account of
+// the keystore, so stars signing with an external signer can vote too.
+func (ks *KeyStore) BLSKey(addr common.Address, passphrase string) (*bls.SecretKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	path := ks.storage.JoinPath(filepath.Join(blsKeyDir, hex.EncodeToString(addr[:])))
+	keyjson, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ks.storeBLSKey(path, addr, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	k := new(encryptedKeyJSONV3)
+	if err := json.Unmarshal(keyjson, k); err != nil {
+		return nil, err
+	}
+	// Make sure we're really operating on the requested key (no swap attacks)
+	if k.Address != hex.EncodeToString(addr[:]) {
+		return nil, fmt.Errorf("BLS key content mismatch: have account %s, want %x", k.Address, addr)
+	}
+	keyBytes, _, err := decryptKeyV3(k, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return bls.UnmarshalSecretKey(keyBytes)
+}
+
+// storeBLSKey generates a BLS key for the given address and stores it into the
+// given file, encrypted with the passphrase.
+func (ks *KeyStore) storeBLSKey(path string, addr common.Address, passphrase string) (*bls.SecretKey, error) {
+	key, err := bls.GenerateKey(crand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	N, P := StandardScryptN, StandardScryptP
+	if store, ok := ks.storage.(*keyStorePassphrase); ok {
+		N, P = store.scryptN, store.scryptP
+	}
+	cryptoStruct, err := encryptKeyBytes(key.Marshal(), passphrase, N, P)
+	if err != nil {
+		return nil, err
+	}
+	keyjson, err := json.Marshal(encryptedKeyJSONV3{hex.EncodeToString(addr[:]), cryptoStruct, uuid.NewRandom().String(), version})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKeyFile(path, keyjson); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
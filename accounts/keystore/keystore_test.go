@@ -17,6 +17,7 @@
 package keystore
 
 import (
+	"bytes"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -385,3 +386,33 @@ func tmpKeyStore(t *testing.T, encrypted bool) (string, *KeyStore) {
 	}
 	return d, newFunc(d)
 }
+
+// Tests that BLS keys are generated once per address, encrypted with the given
+// passphrase, and not listed as accounts.
+func TestBLSKey(t *testing.T) {
+	dir, ks := tmpKeyStore(t, true)
+	defer os.RemoveAll(dir)
+
+	addr := common.HexToAddress("0x0102")
+	key, err := ks.BLSKey(addr, "foo")
+	if err != nil {
+		t.Fatalf("failed to create BLS key: %v", err)
+	}
+	stored, err := ks.BLSKey(addr, "foo")
+	if err != nil {
+		t.Fatalf("failed to decrypt BLS key: %v", err)
+	}
+	if !bytes.Equal(stored.Marshal(), key.Marshal()) {
+		t.Errorf("BLS key changed between calls")
+	}
+	if _, err := ks.BLSKey(addr, "bar"); err != ErrDecrypt {
+		t.Errorf("wrong passphrase: have %v, want %v", err, ErrDecrypt)
+	}
+	if other, _ := ks.BLSKey(common.HexToAddress("0x0304"), "foo"); other == nil || bytes.Equal(other.Marshal(), key.Marshal()) {
+		t.Errorf("BLS key shared between addresses")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if accs := ks.Accounts(); len(accs) != 0 {
+		t.Errorf("BLS keys listed as accounts: %v", accs)
+	}
+}
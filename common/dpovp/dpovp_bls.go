@@ -0,0 +1,92 @@
+package dpovp
+
+import (
+	"errors"
+
+	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/crypto/bls"
+	"github.com/LoveBlock/loveblock/rlp"
+)
+
+// BLSRegistryAddress is the account holding the BLS public keys the stars vote
+// with. A star registers its key by sending a transaction from its coinbase to
+// this address, carrying an RLP encoded BLSRegistration as payload.
+var BLSRegistryAddress = common.HexToAddress("0x000000000000000000000000000000000000a003")
+
+// blsKeyWords is the number of storage slots holding one public key.
+const blsKeyWords = bls.PublicKeyLength / common.HashLength
+
+var errBLSRegistration = errors.New("invalid BLS key registration")
+
+// BLSRegistration is the payload of a transaction registering the BLS public key
+// of the sender, along with the proof that the sender holds the secret key.
+type BLSRegistration struct {
+	Pubkey []byte
+	Proof  []byte
+}
+
+// blsKeySlot returns the storage key of the i-th word of the BLS key of addr.
+func blsKeySlot(addr common.Address, i int) common.Hash {
+	return crypto.Keccak256Hash(addr.Bytes(), []byte{byte(i)})
+}
+
+// ReadBLSKey returns the BLS public key registered for the given address, or nil
+// if it has none.
+func ReadBLSKey(db StateReader, addr common.Address) []byte {
+	key := make([]byte, 0, bls.PublicKeyLength)
+	empty := true
+	for i := 0; i < blsKeyWords; i++ {
+		word := db.GetState(BLSRegistryAddress, blsKeySlot(addr, i))
+		if word != (common.Hash{}) {
+			empty = false
+		}
+		key = append(key, word[:]...)
+	}
+	if empty {
+		return nil
+	}
+	return key
+}
+
+// WriteBLSKey registers the BLS public key of the given address.
+func WriteBLSKey(db StateWriter, addr common.Address, key []byte) {
+	touchAccount(db, BLSRegistryAddress)
+	for i := 0; i < blsKeyWords; i++ {
+		db.SetState(BLSRegistryAddress, blsKeySlot(addr, i), common.BytesToHash(key[i*common.HashLength:(i+1)*common.HashLength]))
+	}
+}
+
+// BLSRegistryStorage returns the storage of a BLS key registry account holding
+// the given keys, e.g. for inclusion in a genesis allocation. The account must
+// also be given a non-zero nonce.
+func BLSRegistryStorage(keys map[common.Address][]byte) map[common.Hash]common.Hash {
+	storage := make(map[common.Hash]common.Hash)
+	db := &storageWriter{storage: storage}
+	for addr, key := range keys {
+		WriteBLSKey(db, addr, key)
+	}
+	return storage
+}
+
+// ApplyBLSRegistration decodes a BLS key registration from transaction data and,
+// if the proof of possession is valid, registers the key for the sender.
+func ApplyBLSRegistration(db StateWriter, sender common.Address, data []byte) error {
+	var reg BLSRegistration
+	if err := rlp.DecodeBytes(data, &reg); err != nil {
+		return errBLSRegistration
+	}
+	pubkey, err := bls.UnmarshalPublicKey(reg.Pubkey)
+	if err != nil {
+		return err
+	}
+	proof, err := bls.UnmarshalSignature(reg.Proof)
+	if err != nil {
+		return err
+	}
+	if !bls.VerifyPossession(pubkey, proof) {
+		return errBLSRegistration
+	}
+	WriteBLSKey(db, sender, reg.Pubkey)
+	return nil
+}
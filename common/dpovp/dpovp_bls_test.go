@@ -0,0 +1,71 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/crypto/bls"
+	"github.com/LoveBlock/loveblock/rlp"
+)
+
+// Tests that BLS keys are only registered with a valid proof of possession.
+func TestBLSRegistration(t *testing.T) {
+	var (
+		star  = common.BytesToAddress([]byte{0x01})
+		key   = bls.DeriveKey([]byte{0x01})
+		other = bls.DeriveKey([]byte{0x02})
+	)
+	encode := func(pubkey []byte, proof *bls.Signature) []byte {
+		data, err := rlp.EncodeToBytes(&BLSRegistration{Pubkey: pubkey, Proof: proof.Marshal()})
+		if err != nil {
+			t.Fatalf("failed to encode registration: %v", err)
+		}
+		return data
+	}
+	tests := []struct {
+		data []byte
+		ok   bool
+	}{
+		{encode(key.PublicKey().Marshal(), key.SignPossession()), true},
+		{encode(key.PublicKey().Marshal(), other.SignPossession()), false},
+		{encode(key.PublicKey().Marshal(), key.Sign(key.PublicKey().Marshal())), false},
+		{encode(key.PublicKey().Marshal()[1:], key.SignPossession()), false},
+		{[]byte{0xc0}, false},
+	}
+	for i, tt := range tests {
+		db := &storageWriter{storage: make(map[common.Hash]common.Hash)}
+		err := ApplyBLSRegistration(db, star, tt.data)
+		if (err == nil) != tt.ok {
+			t.Errorf("test %d: registration error mismatch: have %v, want ok %v", i, err, tt.ok)
+		}
+		registered := ReadBLSKey(db, star)
+		if tt.ok && !bytes.Equal(registered, key.PublicKey().Marshal()) {
+			t.Errorf("test %d: registered key mismatch: have %x", i, registered)
+		}
+		if !tt.ok && registered != nil {
+			t.Errorf("test %d: key registered despite error", i)
+		}
+	}
+	// Keys seeded for a genesis allocation can be read back
+	db := &storageWriter{storage: BLSRegistryStorage(map[common.Address][]byte{star: key.PublicKey().Marshal()})}
+	if have := ReadBLSKey(db, star); !bytes.Equal(have, key.PublicKey().Marshal()) {
+		t.Errorf("seeded key mismatch: have %x", have)
+	}
+}
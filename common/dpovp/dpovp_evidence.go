@@ -0,0 +1,26 @@
+package dpovp
+
+import (
+	"github.com/LoveBlock/loveblock/common"
+)
+
+// EvidenceAddress is the account receiving equivocation evidence. Transactions
+// sent to it carry an RLP encoded types.Evidence as payload, which the consensus
+// engine verifies and punishes according to the chain's slashing policy. The
+// account storage records the offences already punished, so each offence is
+// only punished once.
+var EvidenceAddress = common.HexToAddress("0x000000000000000000000000000000000000a002")
+
+// evidenceApplied is the storage value marking processed evidence.
+var evidenceApplied = common.BytesToHash([]byte{0x01})
+
+// HasEvidence reports whether the offence with the given key was punished.
+func HasEvidence(db StateReader, key common.Hash) bool {
+	return db.GetState(EvidenceAddress, key) == evidenceApplied
+}
+
+// WriteEvidence marks the offence with the given key as punished.
+func WriteEvidence(db StateWriter, key common.Hash) {
+	touchAccount(db, EvidenceAddress)
+	db.SetState(EvidenceAddress, key, evidenceApplied)
+}
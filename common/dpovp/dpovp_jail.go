@@ -0,0 +1,88 @@
+package dpovp
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/crypto"
+)
+
+// JailAddress is the account recording the slots missed in a row by the stars,
+// and the stars jailed for missing too many of them. Jailed stars are left out
+// of the production round until they send a transaction from their coinbase to
+// this address, proving they are back online.
+var JailAddress = common.HexToAddress("0x000000000000000000000000000000000000a004")
+
+// Fields of the jail record of a star.
+const (
+	jailMissed byte = iota // Number of slots missed in a row
+	jailSince              // Number of the block that jailed the star, plus one
+)
+
+var (
+	errNotJailed   = errors.New("star is not jailed")
+	errJailPending = errors.New("jail period not over")
+)
+
+// jailKey returns the storage key of a field of the jail record of addr.
+func jailKey(addr common.Address, field byte) common.Hash {
+	return crypto.Keccak256Hash(addr.Bytes(), []byte{field})
+}
+
+// ReadMissedSlots returns the number of slots the star missed in a row.
+func ReadMissedSlots(db StateReader, addr common.Address) uint64 {
+	return db.GetState(JailAddress, jailKey(addr, jailMissed)).Big().Uint64()
+}
+
+// WriteMissedSlots sets the number of slots the star missed in a row.
+func WriteMissedSlots(db StateWriter, addr common.Address, missed uint64) {
+	touchAccount(db, JailAddress)
+	db.SetState(JailAddress, jailKey(addr, jailMissed), common.BigToHash(new(big.Int).SetUint64(missed)))
+}
+
+// ReadJailed returns the number of the block that jailed the star, and whether
+// the star is jailed at all.
+func ReadJailed(db StateReader, addr common.Address) (uint64, bool) {
+	since := db.GetState(JailAddress, jailKey(addr, jailSince)).Big().Uint64()
+	if since == 0 {
+		return 0, false
+	}
+	return since - 1, true
+}
+
+// WriteJailed jails the star at the given block number.
+func WriteJailed(db StateWriter, addr common.Address, number uint64) {
+	touchAccount(db, JailAddress)
+	db.SetState(JailAddress, jailKey(addr, jailSince), common.BigToHash(new(big.Int).SetUint64(number+1)))
+}
+
+// ActiveStars returns the stars of the list that aren't jailed. Should all of
+// them be jailed, the full list is returned, so the chain never stalls.
+func ActiveStars(db StateReader, stars StarList) StarList {
+	active := make(StarList, 0, len(stars))
+	for _, star := range stars {
+		if _, jailed := ReadJailed(db, star.Addr); !jailed {
+			active = append(active, star)
+		}
+	}
+	if len(active) == 0 || len(active) == len(stars) {
+		return stars
+	}
+	return active
+}
+
+// ApplyUnjail returns a jailed star to the production round, once it has served
+// the given number of blocks since it was jailed.
+func ApplyUnjail(db StateWriter, sender common.Address, number, period uint64) error {
+	since, jailed := ReadJailed(db, sender)
+	if !jailed {
+		return errNotJailed
+	}
+	if number < since+period {
+		return errJailPending
+	}
+	db.SetState(JailAddress, jailKey(sender, jailSince), common.Hash{})
+	db.SetState(JailAddress, jailKey(sender, jailMissed), common.Hash{})
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"testing"
+
+	"github.com/LoveBlock/loveblock/common"
+)
+
+// Tests that jailed stars are left out of the active list, unless all of them
+// are jailed, and that they are only unjailed after the jail period.
+func TestJail(t *testing.T) {
+	db := &storageWriter{storage: make(map[common.Hash]common.Hash)}
+	stars := StarList{testStar(1), testStar(2)}
+
+	WriteJailed(db, stars[0].Addr, 10)
+	if since, jailed := ReadJailed(db, stars[0].Addr); !jailed || since != 10 {
+		t.Fatalf("jail record mismatch: have %d/%v, want 10/true", since, jailed)
+	}
+	if active := ActiveStars(db, stars); len(active) != 1 || active[0].Addr != stars[1].Addr {
+		t.Errorf("active stars mismatch: have %v", active)
+	}
+	WriteJailed(db, stars[1].Addr, 0)
+	if active := ActiveStars(db, stars); len(active) != 2 {
+		t.Errorf("all stars jailed: have %d active, want 2", len(active))
+	}
+	if err := ApplyUnjail(db, stars[0].Addr, 14, 5); err != errJailPending {
+		t.Errorf("early unjail error mismatch: have %v, want %v", err, errJailPending)
+	}
+	if err := ApplyUnjail(db, stars[0].Addr, 15, 5); err != nil {
+		t.Errorf("failed to unjail: %v", err)
+	}
+	if err := ApplyUnjail(db, stars[0].Addr, 20, 5); err != errNotJailed {
+		t.Errorf("repeated unjail error mismatch: have %v, want %v", err, errNotJailed)
+	}
+}
@@ -2,29 +2,145 @@ package dpovp
 
 import (
 	"crypto/ecdsa"
+	"errors"
 	"sync"
+
+	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/crypto/bls"
 )
 
+// SignerFn signs a hash with the key of the local star, returning a 65 byte
+// [R || S || V] secp256k1 signature. It is backed by the node key, an unlocked
+// keystore account or an external signer.
+type SignerFn func(hash []byte) ([]byte, error)
+
+// probeHash is signed to recover the public key of signers whose private key
+// isn't accessible.
+var probeHash = crypto.Keccak256([]byte("loveblock-signer-probe"))
+
+var errNoSigner = errors.New("no star signing key")
+
+// starKey is a key the node signs blocks and votes with.
+type starKey struct {
+	sign   SignerFn
+	pubKey []byte         // 签名公钥 65字节
+	blsKey *bls.SecretKey // BLS私钥，用于聚合确认签名
+}
+
 var (
-	privKey   ecdsa.PrivateKey
-	privKeyMu sync.Mutex
+	localKey  *starKey                            // 本节点的签名私钥
+	starKeys  = make(map[common.Address]*starKey) // 同一进程中运行的多个主节点各自的签名私钥
+	privKeyMu sync.RWMutex
 )
 
+// newStarKey returns the star key signing with the given private key, voting with
+// a BLS key derived from it.
+func newStarKey(key *ecdsa.PrivateKey) *starKey {
+	return &starKey{
+		sign: func(hash []byte) ([]byte, error) {
+			return crypto.Sign(hash, key)
+		},
+		pubKey: crypto.FromECDSAPub(&key.PublicKey),
+		blsKey: bls.DeriveKey(crypto.FromECDSA(key)),
+	}
+}
+
 // 设置私钥 非coinbase私钥
+// SetPrivKey makes the local star sign blocks and votes with the given key.
 func SetPrivKey(key *ecdsa.PrivateKey) {
 	privKeyMu.Lock()
 	defer privKeyMu.Unlock()
 
-	privKey.PublicKey = key.PublicKey
-	privKey.D = key.D
+	localKey = newStarKey(key)
+}
+
+// SetSigner makes the local star sign blocks and votes with the given signer,
+// e.g. a keystore account or an external signer, and cast BLS votes with the
+// given key, which is kept in the keystore. The public key is recovered from a
+// probe signature, the address of the signing account is returned so that the
+// caller can check it.
+func SetSigner(fn SignerFn, blsKey *bls.SecretKey) (common.Address, error) {
+	sig, err := fn(probeHash)
+	if err != nil {
+		return common.Address{}, err
+	}
+	pub, err := crypto.Ecrecover(probeHash, sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	privKeyMu.Lock()
+	defer privKeyMu.Unlock()
+
+	localKey = &starKey{sign: fn, pubKey: pub, blsKey: blsKey}
+	return common.BytesToAddress(crypto.Keccak256(pub[1:])[12:]), nil
+}
+
+// SetStarKey makes the star with the given coinbase sign with the given key,
+// instead of the key of the local star. It lets several stars run in the same
+// process, e.g. in simulations. A nil key drops the star's own key.
+func SetStarKey(coinbase common.Address, key *ecdsa.PrivateKey) {
+	privKeyMu.Lock()
+	defer privKeyMu.Unlock()
+
+	if key == nil {
+		delete(starKeys, coinbase)
+		return
+	}
+	starKeys[coinbase] = newStarKey(key)
+}
+
+// keyOf returns the key the star with the given coinbase signs with.
+func keyOf(coinbase common.Address) *starKey {
+	privKeyMu.RLock()
+	defer privKeyMu.RUnlock()
+
+	if key, ok := starKeys[coinbase]; ok {
+		return key
+	}
+	return localKey
+}
+
+// Sign signs the given hash with the key of the local star.
+func Sign(hash []byte) ([]byte, error) {
+	return SignAs(common.Address{}, hash)
+}
+
+// SignAs signs the given hash with the key of the star with the given coinbase.
+func SignAs(coinbase common.Address, hash []byte) ([]byte, error) {
+	key := keyOf(coinbase)
+	if key == nil {
+		return nil, errNoSigner
+	}
+	return key.sign(hash)
+}
+
+// GetPubkey returns the uncompressed (65 byte) public key the local star signs
+// with, or nil if no signer is set.
+func GetPubkey() []byte {
+	return PubkeyOf(common.Address{})
+}
+
+// PubkeyOf returns the uncompressed (65 byte) public key the star with the given
+// coinbase signs with, or nil if no signer is set.
+func PubkeyOf(coinbase common.Address) []byte {
+	if key := keyOf(coinbase); key != nil {
+		return key.pubKey
+	}
+	return nil
+}
 
-	// sman test
-	// testAddr := crypto.PubkeyToAddress(key.PublicKey)
-	// addr := common.ToHex(testAddr[:])
-	// log.Info(`sman: test Addr :%s`, addr)
+// GetBLSKey returns the BLS secret key the local star votes with. It is nil
+// until a signer is set.
+func GetBLSKey() *bls.SecretKey {
+	return BLSKeyOf(common.Address{})
 }
 
-// 获取私钥
-func GetPrivKey() ecdsa.PrivateKey {
-	return privKey
+// BLSKeyOf returns the BLS secret key the star with the given coinbase votes
+// with, or nil if no signer is set.
+func BLSKeyOf(coinbase common.Address) *bls.SecretKey {
+	if key := keyOf(coinbase); key != nil {
+		return key.blsKey
+	}
+	return nil
 }
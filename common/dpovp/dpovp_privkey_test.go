@@ -0,0 +1,63 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/crypto/bls"
+)
+
+// Tests that an external signer takes over block and vote signing, and votes
+// with the BLS key it is given.
+func TestSetSigner(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := func(hash []byte) ([]byte, error) {
+		return crypto.Sign(hash, key)
+	}
+	blsKey, _ := bls.GenerateKey(rand.Reader)
+	addr, err := SetSigner(signer, blsKey)
+	if err != nil {
+		t.Fatalf("failed to set signer: %v", err)
+	}
+	if want := crypto.PubkeyToAddress(key.PublicKey); addr != want {
+		t.Errorf("signer address mismatch: have %x, want %x", addr, want)
+	}
+	if have, want := GetPubkey(), crypto.FromECDSAPub(&key.PublicKey); !bytes.Equal(have, want) {
+		t.Errorf("public key mismatch: have %x, want %x", have, want)
+	}
+	hash := crypto.Keccak256([]byte("block"))
+	sig, err := Sign(hash)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if pub, _ := crypto.Ecrecover(hash, sig); !bytes.Equal(pub, GetPubkey()) {
+		t.Errorf("signature recovers to %x, want %x", pub, GetPubkey())
+	}
+	if GetBLSKey() != blsKey {
+		t.Errorf("BLS key not taken over")
+	}
+	// A node key signer takes over again
+	other, _ := crypto.GenerateKey()
+	SetPrivKey(other)
+	if have, want := GetPubkey(), crypto.FromECDSAPub(&other.PublicKey); !bytes.Equal(have, want) {
+		t.Errorf("public key mismatch: have %x, want %x", have, want)
+	}
+}
@@ -0,0 +1,228 @@
+package dpovp
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/rlp"
+)
+
+// RegistryAddress is the account whose storage holds the on-chain star node
+// registry. It has no code; the consensus engine reads and writes its storage
+// directly, so every node derives the same star list from the chain state.
+var RegistryAddress = common.HexToAddress("0x000000000000000000000000000000000000a001")
+
+// Registry commands accepted from the registry owner.
+const (
+	RegistryOpAdd      uint8 = iota + 1 // Append a star node to the end of the list
+	RegistryOpRemove                    // Remove a star node from the list
+	RegistryOpSetOwner                  // Hand the registry over to a new owner
+)
+
+// Storage layout of the registry account.
+var (
+	registryCountKey = common.BigToHash(big.NewInt(0)) // Number of registered stars
+	registryOwnerKey = common.BigToHash(big.NewInt(1)) // Address allowed to change the registry
+)
+
+// Fields of a single registry entry.
+const (
+	entryAddr    byte = iota // Star coinbase address
+	entryPubkey0             // First half of the star's signing public key
+	entryPubkey1             // Second half of the star's signing public key
+	entryNodeID0             // First half of the star's node key, if not the signing key
+	entryNodeID1             // Second half of the star's node key, if not the signing key
+	entryWeight              // Confirmation weight of the star, zero if not set
+)
+
+var (
+	errRegistryCommand = errors.New("invalid star registry command")
+	errStarKnown       = errors.New("star node already registered")
+	errStarUnknown     = errors.New("star node not registered")
+	errStarPubkey      = errors.New("star node pubkey must be 64 or 128 bytes")
+)
+
+// StateReader is the subset of the state database needed to read the registry.
+type StateReader interface {
+	GetState(addr common.Address, key common.Hash) common.Hash
+}
+
+// StateWriter is the subset of the state database needed to update the registry.
+type StateWriter interface {
+	StateReader
+	SetState(addr common.Address, key, value common.Hash)
+	GetNonce(addr common.Address) uint64
+	SetNonce(addr common.Address, nonce uint64)
+}
+
+// RegistryCommand is the payload of a transaction sent by the registry owner to
+// RegistryAddress in order to change the star node set. Pubkey is the 64 byte
+// signing key of the star, followed by its 64 byte node key if the star signs
+// with a key other than its node key.
+type RegistryCommand struct {
+	Op     uint8
+	Addr   common.Address
+	Pubkey []byte
+}
+
+// entryKey returns the storage key of a field of the index-th registry entry.
+func entryKey(index uint64, field byte) common.Hash {
+	return crypto.Keccak256Hash(common.BigToHash(new(big.Int).SetUint64(index)).Bytes(), []byte{field})
+}
+
+// ReadStarList returns the star nodes registered in the given state, in slot
+// order. An empty list means the registry was never seeded.
+func ReadStarList(db StateReader) StarList {
+	count := db.GetState(RegistryAddress, registryCountKey).Big().Uint64()
+
+	stars := make(StarList, 0, count)
+	for i := uint64(0); i < count; i++ {
+		addr := db.GetState(RegistryAddress, entryKey(i, entryAddr))
+		pub0 := db.GetState(RegistryAddress, entryKey(i, entryPubkey0))
+		pub1 := db.GetState(RegistryAddress, entryKey(i, entryPubkey1))
+
+		pubkey := make([]byte, 0, 64)
+		pubkey = append(append(pubkey, pub0[:]...), pub1[:]...)
+
+		var nodeID []byte
+		node0 := db.GetState(RegistryAddress, entryKey(i, entryNodeID0))
+		node1 := db.GetState(RegistryAddress, entryKey(i, entryNodeID1))
+		if node0 != (common.Hash{}) || node1 != (common.Hash{}) {
+			nodeID = append(append(make([]byte, 0, 64), node0[:]...), node1[:]...)
+		}
+		weight := db.GetState(RegistryAddress, entryKey(i, entryWeight)).Big().Uint64()
+
+		stars = append(stars, AddrNodeIDMapping{Addr: common.BytesToAddress(addr[:]), Pubkey: pubkey, NodeID: nodeID, Weight: weight})
+	}
+	return stars
+}
+
+// ReadRegistryOwner returns the address allowed to change the registry.
+func ReadRegistryOwner(db StateReader) common.Address {
+	owner := db.GetState(RegistryAddress, registryOwnerKey)
+	return common.BytesToAddress(owner[:])
+}
+
+// WriteStarList replaces the registered star nodes in the given state.
+func WriteStarList(db StateWriter, stars StarList) {
+	touchRegistry(db)
+
+	old := db.GetState(RegistryAddress, registryCountKey).Big().Uint64()
+	for i, star := range stars {
+		var pub0, pub1 common.Hash
+		copy(pub0[:], star.Pubkey)
+		if len(star.Pubkey) > common.HashLength {
+			copy(pub1[:], star.Pubkey[common.HashLength:])
+		}
+		var node0, node1 common.Hash
+		copy(node0[:], star.NodeID)
+		if len(star.NodeID) > common.HashLength {
+			copy(node1[:], star.NodeID[common.HashLength:])
+		}
+		db.SetState(RegistryAddress, entryKey(uint64(i), entryAddr), star.Addr.Hash())
+		db.SetState(RegistryAddress, entryKey(uint64(i), entryPubkey0), pub0)
+		db.SetState(RegistryAddress, entryKey(uint64(i), entryPubkey1), pub1)
+		db.SetState(RegistryAddress, entryKey(uint64(i), entryNodeID0), node0)
+		db.SetState(RegistryAddress, entryKey(uint64(i), entryNodeID1), node1)
+		db.SetState(RegistryAddress, entryKey(uint64(i), entryWeight), common.BigToHash(new(big.Int).SetUint64(star.Weight)))
+	}
+	for i := uint64(len(stars)); i < old; i++ {
+		db.SetState(RegistryAddress, entryKey(i, entryAddr), common.Hash{})
+		db.SetState(RegistryAddress, entryKey(i, entryPubkey0), common.Hash{})
+		db.SetState(RegistryAddress, entryKey(i, entryPubkey1), common.Hash{})
+		db.SetState(RegistryAddress, entryKey(i, entryNodeID0), common.Hash{})
+		db.SetState(RegistryAddress, entryKey(i, entryNodeID1), common.Hash{})
+		db.SetState(RegistryAddress, entryKey(i, entryWeight), common.Hash{})
+	}
+	db.SetState(RegistryAddress, registryCountKey, common.BigToHash(big.NewInt(int64(len(stars)))))
+}
+
+// WriteRegistryOwner sets the address allowed to change the registry.
+func WriteRegistryOwner(db StateWriter, owner common.Address) {
+	touchRegistry(db)
+	db.SetState(RegistryAddress, registryOwnerKey, owner.Hash())
+}
+
+// touchRegistry makes sure the registry account is never considered empty, as
+// empty accounts (and their storage) are deleted when touched by a transaction.
+func touchRegistry(db StateWriter) {
+	touchAccount(db, RegistryAddress)
+}
+
+// touchAccount makes sure a storage-only system account is never considered empty.
+func touchAccount(db StateWriter, addr common.Address) {
+	if db.GetNonce(addr) == 0 {
+		db.SetNonce(addr, 1)
+	}
+}
+
+// RegistryStorage returns the storage of a registry account seeded with the
+// given owner and star nodes, e.g. for inclusion in a genesis allocation. The
+// account must also be given a non-zero nonce.
+func RegistryStorage(owner common.Address, stars StarList) map[common.Hash]common.Hash {
+	storage := make(map[common.Hash]common.Hash)
+	db := &storageWriter{storage: storage}
+	WriteRegistryOwner(db, owner)
+	WriteStarList(db, stars)
+	return storage
+}
+
+// storageWriter is a StateWriter over the storage of the registry account only.
+type storageWriter struct {
+	storage map[common.Hash]common.Hash
+	nonce   uint64
+}
+
+func (w *storageWriter) GetState(addr common.Address, key common.Hash) common.Hash {
+	return w.storage[key]
+}
+func (w *storageWriter) SetState(addr common.Address, key, value common.Hash) {
+	if value == (common.Hash{}) {
+		delete(w.storage, key)
+		return
+	}
+	w.storage[key] = value
+}
+func (w *storageWriter) GetNonce(addr common.Address) uint64        { return w.nonce }
+func (w *storageWriter) SetNonce(addr common.Address, nonce uint64) { w.nonce = nonce }
+
+// ApplyRegistryCommand decodes a registry command from transaction data and
+// applies it to the given state.
+func ApplyRegistryCommand(db StateWriter, data []byte) error {
+	var cmd RegistryCommand
+	if err := rlp.DecodeBytes(data, &cmd); err != nil {
+		return errRegistryCommand
+	}
+	stars := ReadStarList(db)
+
+	switch cmd.Op {
+	case RegistryOpAdd:
+		if len(cmd.Pubkey) != 64 && len(cmd.Pubkey) != 128 {
+			return errStarPubkey
+		}
+		if stars.Index(&cmd.Addr) >= 0 {
+			return errStarKnown
+		}
+		star := AddrNodeIDMapping{Addr: cmd.Addr, Pubkey: common.CopyBytes(cmd.Pubkey[:64])}
+		if len(cmd.Pubkey) == 128 {
+			star.NodeID = common.CopyBytes(cmd.Pubkey[64:])
+		}
+		WriteStarList(db, append(stars, star))
+
+	case RegistryOpRemove:
+		index := stars.Index(&cmd.Addr)
+		if index < 0 {
+			return errStarUnknown
+		}
+		WriteStarList(db, append(stars[:index:index], stars[index+1:]...))
+
+	case RegistryOpSetOwner:
+		WriteRegistryOwner(db, cmd.Addr)
+
+	default:
+		return errRegistryCommand
+	}
+	return nil
+}
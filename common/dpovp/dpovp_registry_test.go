@@ -0,0 +1,304 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/rlp"
+)
+
+func testStar(b byte) AddrNodeIDMapping {
+	return AddrNodeIDMapping{
+		Addr:   common.BytesToAddress([]byte{b}),
+		Pubkey: bytes.Repeat([]byte{b}, 64),
+	}
+}
+
+func newTestRegistry(owner common.Address, stars StarList) *storageWriter {
+	return &storageWriter{storage: RegistryStorage(owner, stars), nonce: 1}
+}
+
+func encodeCommand(t *testing.T, op uint8, star AddrNodeIDMapping) []byte {
+	pubkey := append(common.CopyBytes(star.Pubkey), star.NodeID...)
+	data, err := rlp.EncodeToBytes(RegistryCommand{Op: op, Addr: star.Addr, Pubkey: pubkey})
+	if err != nil {
+		t.Fatalf("failed to encode command: %v", err)
+	}
+	return data
+}
+
+func checkStars(t *testing.T, have, want StarList) {
+	t.Helper()
+	if len(have) != len(want) {
+		t.Fatalf("star count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if have[i].Addr != want[i].Addr || !bytes.Equal(have[i].Pubkey, want[i].Pubkey) {
+			t.Errorf("star %d mismatch: have %x/%x, want %x/%x", i, have[i].Addr, have[i].Pubkey, want[i].Addr, want[i].Pubkey)
+		}
+		if !bytes.Equal(have[i].NodeID, want[i].NodeID) {
+			t.Errorf("star %d node key mismatch: have %x, want %x", i, have[i].NodeID, want[i].NodeID)
+		}
+		if have[i].Weight != want[i].Weight {
+			t.Errorf("star %d weight mismatch: have %d, want %d", i, have[i].Weight, want[i].Weight)
+		}
+	}
+}
+
+// Tests that star lists survive a round trip through the registry storage, and
+// that shrinking the list clears the stale entries.
+func TestRegistryReadWrite(t *testing.T) {
+	owner := common.HexToAddress("0x0123")
+	stars := StarList{testStar(1), testStar(2), testStar(3)}
+
+	db := newTestRegistry(owner, stars)
+	checkStars(t, ReadStarList(db), stars)
+	if have := ReadRegistryOwner(db); have != owner {
+		t.Errorf("owner mismatch: have %x, want %x", have, owner)
+	}
+	WriteStarList(db, stars[:1])
+	checkStars(t, ReadStarList(db), stars[:1])
+	if len(db.storage) != 5 { // count, owner and one entry
+		t.Errorf("stale registry entries left: have %d slots, want 5", len(db.storage))
+	}
+}
+
+// Tests that confirmation weights are stored in the registry and that unset
+// weights count as one.
+func TestRegistryWeights(t *testing.T) {
+	stars := StarList{testStar(1), testStar(2), testStar(3)}
+	stars[1].Weight = 5
+
+	db := newTestRegistry(common.HexToAddress("0x0123"), stars)
+	have := ReadStarList(db)
+	checkStars(t, have, stars)
+	if total := have.TotalWeight(); total != 7 {
+		t.Errorf("total weight mismatch: have %d, want 7", total)
+	}
+	WriteStarList(db, stars[:1])
+	if len(db.storage) != 5 { // count, owner and one entry without weight
+		t.Errorf("stale registry weights left: have %d slots, want 5", len(db.storage))
+	}
+}
+
+// Tests that a starlist file has to match the genesis stars, and that an empty
+// one adopts them.
+func TestCheckStarList(t *testing.T) {
+	genesis := StarList{testStar(1), testStar(2)}
+	genesis[0].Weight = 3
+	defer func(old []AddrNodeIDMapping, gen StarList) { starList, genesisStars = old, gen }(starList, genesisStars)
+
+	SetStarList(StarList{})
+	if err := CheckStarList(genesis); err != nil {
+		t.Fatalf("empty starlist rejected: %v", err)
+	}
+	checkStars(t, GetAllSortedCoreNodes(), genesis)
+
+	SetStarList(StarList{testStar(1), testStar(2)})
+	if err := CheckStarList(genesis); err != nil {
+		t.Fatalf("matching starlist rejected: %v", err)
+	}
+	checkStars(t, GetAllSortedCoreNodes(), genesis)
+
+	SetStarList(StarList{testStar(2), testStar(1)})
+	if err := CheckStarList(genesis); err == nil {
+		t.Fatal("reordered starlist accepted")
+	}
+	SetStarList(StarList{testStar(1)})
+	if err := CheckStarList(genesis); err == nil {
+		t.Fatal("shorter starlist accepted")
+	}
+}
+
+// Tests that reloading the starlist file schedules valid lists only, from the
+// given block on, posting an event for actual changes, that the schedule is kept
+// across restarts and that genesis stars can't be reloaded.
+func TestReloadStarList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string, old []AddrNodeIDMapping, gen StarList) {
+		dataDir, starList, reloadedStars, genesisStars = dir, old, nil, gen
+	}(dataDir, starList, genesisStars)
+
+	dataDir, genesisStars = dir, nil
+	SetStarList(StarList{testStar(1)})
+
+	events := make(chan StarListEvent, 3)
+	sub := SubscribeStarListEvent(events)
+	defer sub.Unsubscribe()
+
+	write := func(stars ...AddrNodeIDMapping) {
+		var buf bytes.Buffer
+		for _, star := range stars {
+			fmt.Fprintf(&buf, "%x %x\n", star.Addr, star.Pubkey)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "starlist"), buf.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A changed list is scheduled and announced
+	write(testStar(1), testStar(2))
+	stars, err := ReloadStarList(10)
+	if err != nil {
+		t.Fatalf("failed to reload starlist: %v", err)
+	}
+	checkStars(t, stars, StarList{testStar(1), testStar(2)})
+	checkStars(t, GetAllSortedCoreNodes(), stars)
+	checkStars(t, StarListAt(9), StarList{testStar(1)})
+	checkStars(t, StarListAt(10), stars)
+	select {
+	case ev := <-events:
+		checkStars(t, ev.Old, StarList{testStar(1)})
+		checkStars(t, ev.New, stars)
+		if ev.Number != 10 {
+			t.Errorf("activation block mismatch: have %d, want 10", ev.Number)
+		}
+	default:
+		t.Fatal("no event posted for changed starlist")
+	}
+	// An unchanged list is not announced
+	if _, err := ReloadStarList(20); err != nil {
+		t.Fatalf("failed to reload unchanged starlist: %v", err)
+	}
+	select {
+	case <-events:
+		t.Fatal("event posted for unchanged starlist")
+	default:
+	}
+	// Invalid lists are rejected and leave the scheduled ones in place
+	write()
+	if _, err := ReloadStarList(20); err != errStarListEmpty {
+		t.Errorf("empty starlist: have %v, want %v", err, errStarListEmpty)
+	}
+	write(testStar(1), testStar(1))
+	if _, err := ReloadStarList(20); err != errStarListRepeats {
+		t.Errorf("repeated star: have %v, want %v", err, errStarListRepeats)
+	}
+	checkStars(t, GetAllSortedCoreNodes(), stars)
+
+	// Later lists apply after the earlier ones, lists from an earlier block on
+	// replace them
+	write(testStar(3))
+	if _, err := ReloadStarList(20); err != nil {
+		t.Fatalf("failed to reload starlist: %v", err)
+	}
+	checkStars(t, StarListAt(15), stars)
+	checkStars(t, StarListAt(20), StarList{testStar(3)})
+
+	write(testStar(4))
+	if _, err := ReloadStarList(15); err != nil {
+		t.Fatalf("failed to reload starlist: %v", err)
+	}
+	checkStars(t, StarListAt(9), StarList{testStar(1)})
+	checkStars(t, StarListAt(10), stars)
+	checkStars(t, StarListAt(20), StarList{testStar(4)})
+
+	// The schedule survives restarts, even if the file changed meanwhile
+	write(testStar(5))
+	starList, reloadedStars = nil, nil
+	checkStars(t, StarListAt(9), StarList{testStar(1)})
+	checkStars(t, StarListAt(10), stars)
+	checkStars(t, StarListAt(20), StarList{testStar(4)})
+
+	// Genesis stars can't be reloaded, not even unchanged
+	genesisStars = StarList{testStar(1), testStar(2)}
+	write(testStar(1), testStar(2))
+	if _, err := ReloadStarList(30); err != errStarListGenesis {
+		t.Errorf("genesis stars: have %v, want %v", err, errStarListGenesis)
+	}
+	checkStars(t, StarListAt(30), StarList{testStar(4)})
+}
+
+// Tests that registry commands add and remove stars and hand over ownership.
+func TestRegistryCommands(t *testing.T) {
+	db := newTestRegistry(common.HexToAddress("0x0123"), StarList{testStar(1), testStar(2)})
+
+	if err := ApplyRegistryCommand(db, encodeCommand(t, RegistryOpAdd, testStar(3))); err != nil {
+		t.Fatalf("failed to add star: %v", err)
+	}
+	checkStars(t, ReadStarList(db), StarList{testStar(1), testStar(2), testStar(3)})
+
+	if err := ApplyRegistryCommand(db, encodeCommand(t, RegistryOpAdd, testStar(3))); err != errStarKnown {
+		t.Errorf("duplicate add error mismatch: have %v, want %v", err, errStarKnown)
+	}
+	if err := ApplyRegistryCommand(db, encodeCommand(t, RegistryOpRemove, testStar(1))); err != nil {
+		t.Fatalf("failed to remove star: %v", err)
+	}
+	checkStars(t, ReadStarList(db), StarList{testStar(2), testStar(3)})
+
+	if err := ApplyRegistryCommand(db, encodeCommand(t, RegistryOpRemove, testStar(1))); err != errStarUnknown {
+		t.Errorf("unknown remove error mismatch: have %v, want %v", err, errStarUnknown)
+	}
+	short := AddrNodeIDMapping{Addr: common.HexToAddress("0x04"), Pubkey: []byte{4}}
+	if err := ApplyRegistryCommand(db, encodeCommand(t, RegistryOpAdd, short)); err != errStarPubkey {
+		t.Errorf("short pubkey error mismatch: have %v, want %v", err, errStarPubkey)
+	}
+	if err := ApplyRegistryCommand(db, []byte{0xff}); err != errRegistryCommand {
+		t.Errorf("garbage command error mismatch: have %v, want %v", err, errRegistryCommand)
+	}
+	newOwner := common.HexToAddress("0x0456")
+	if err := ApplyRegistryCommand(db, encodeCommand(t, RegistryOpSetOwner, AddrNodeIDMapping{Addr: newOwner})); err != nil {
+		t.Fatalf("failed to set owner: %v", err)
+	}
+	if have := ReadRegistryOwner(db); have != newOwner {
+		t.Errorf("owner mismatch: have %x, want %x", have, newOwner)
+	}
+}
+
+// Tests that stars signing with a key other than their node key keep both keys
+// in the registry, and are found by either.
+func TestRegistryNodeID(t *testing.T) {
+	db := newTestRegistry(common.HexToAddress("0x0123"), StarList{testStar(1)})
+
+	star := testStar(2)
+	star.NodeID = bytes.Repeat([]byte{0x22}, 64)
+	if err := ApplyRegistryCommand(db, encodeCommand(t, RegistryOpAdd, star)); err != nil {
+		t.Fatalf("failed to add star: %v", err)
+	}
+	stars := ReadStarList(db)
+	checkStars(t, stars, StarList{testStar(1), star})
+
+	if index := stars.IndexByPubkey(append([]byte{0x04}, star.Pubkey...)); index != 1 {
+		t.Errorf("signing key index mismatch: have %d, want 1", index)
+	}
+	if index := stars.IndexByNodeKey(append([]byte{0x04}, star.NodeID...)); index != 1 {
+		t.Errorf("node key index mismatch: have %d, want 1", index)
+	}
+	if index := stars.IndexByNodeKey(append([]byte{0x04}, star.Pubkey...)); index != -1 {
+		t.Errorf("signing key accepted as node key: index %d", index)
+	}
+	if index := stars.IndexByNodeKey(append([]byte{0x04}, testStar(1).Pubkey...)); index != 0 {
+		t.Errorf("node key defaulting to signing key: have %d, want 0", index)
+	}
+	// Removing the star clears its node key as well
+	if err := ApplyRegistryCommand(db, encodeCommand(t, RegistryOpRemove, star)); err != nil {
+		t.Fatalf("failed to remove star: %v", err)
+	}
+	if len(db.storage) != 5 {
+		t.Errorf("stale registry entries left: have %d slots, want 5", len(db.storage))
+	}
+}
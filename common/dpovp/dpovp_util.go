@@ -3,26 +3,80 @@ package dpovp
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/event"
 	"github.com/LoveBlock/loveblock/log"
+	"github.com/LoveBlock/loveblock/rlp"
 	"io"
+	"io/ioutil"
 	"os"
 	"strings"
 	"sync"
 )
 
+// AddrNodeIDMapping maps a star address to the key it signs blocks and votes
+// with. NodeID is the p2p node key of the star, only set if it differs from the
+// signing key. Weight is the star's share in confirmation quorums.
 type AddrNodeIDMapping struct {
 	Addr   common.Address
-	Pubkey []byte
+	Pubkey []byte // 签名公钥 64字节
+	NodeID []byte // p2p节点公钥 64字节, 与签名公钥相同时为空
+	Weight uint64 // 确认权重, 0 视为 1
 }
 
+// NodeKey returns the p2p node key (64 bytes) of the star.
+func (m AddrNodeIDMapping) NodeKey() []byte {
+	if len(m.NodeID) > 0 {
+		return m.NodeID
+	}
+	return m.Pubkey
+}
+
+var (
+	errStarListMismatch = errors.New("starlist file contradicts genesis stars")
+	errStarListEmpty    = errors.New("starlist file lists no stars")
+	errStarListRepeats  = errors.New("starlist file lists a star more than once")
+	errStarListGenesis  = errors.New("star list is fixed by the genesis block")
+)
+
 var (
 	dataDir         string
 	starList        []AddrNodeIDMapping
+	reloadedStars   []scheduledStarList // Star lists reloaded since, ascending by first block
+	genesisStars    StarList            // Stars committed in the genesis block, if any
 	readStarListMux sync.Mutex
+	starListFeed    event.Feed
 )
 
+// scheduledStarList is a star list reloaded from the starlist file, taking effect
+// from the given block on.
+type scheduledStarList struct {
+	From  uint64
+	Stars StarList
+}
+
+// starListSchedule is the star list schedule kept in the data directory, so that
+// blocks keep the stars they were scheduled with across restarts.
+type starListSchedule struct {
+	Stars   StarList            // List loaded before the first reload
+	Reloads []scheduledStarList // Lists reloaded since, ascending by first block
+}
+
+// StarListEvent is posted when the star list is reloaded from the starlist file
+// and differs from the one loaded last.
+type StarListEvent struct {
+	Old    StarList
+	New    StarList
+	Number uint64 // First block the new list applies to
+}
+
+// SubscribeStarListEvent registers a subscription of StarListEvent.
+func SubscribeStarListEvent(ch chan<- StarListEvent) event.Subscription {
+	return starListFeed.Subscribe(ch)
+}
+
 // 设置 datadir路径
 func SetDataDir(path string) {
 	if dataDir == "" {
@@ -30,21 +84,89 @@ func SetDataDir(path string) {
 	}
 }
 
+// starListFile returns the path of the starlist file.
+func starListFile() string {
+	return dataDir + "/starlist"
+}
+
+// scheduleFile returns the path of the file keeping the star list schedule.
+func scheduleFile() string {
+	return dataDir + "/starlist.schedule"
+}
+
 // 读取主节点列表
+// Lists reloaded before a restart are scheduled again from the schedule file.
+// The caller must hold readStarListMux.
 func readStarList() {
-	readStarListMux.Lock()
-	defer readStarListMux.Unlock()
-
 	starList = make([]AddrNodeIDMapping, 0)
-	fileName := dataDir + "/starlist"
-	fd, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0)
+	fd, err := os.OpenFile(starListFile(), os.O_RDWR|os.O_CREATE, 0)
 	if err != nil {
 		log.Crit("Can't read file stalist")
 		return
 	}
 	defer fd.Close()
-	buff := bufio.NewReader(fd)
 	log.Info("Star node list")
+	starList = parseStarList(fd)
+
+	schedule, err := readSchedule()
+	if err != nil {
+		log.Crit("Can't read star list schedule", "err", err)
+	}
+	if schedule == nil {
+		return
+	}
+	local, latest := starList, schedule.Stars
+	if n := len(schedule.Reloads); n > 0 {
+		latest = schedule.Reloads[n-1].Stars
+	}
+	starList, reloadedStars = append(StarList{}, schedule.Stars...), schedule.Reloads
+	if matchStarList(local, latest) != nil {
+		log.Warn("Starlist file changed since the last reload, reload it to schedule the changes")
+	}
+}
+
+// readSchedule reads the star list schedule from the data directory, returning
+// nil if no list was ever reloaded.
+func readSchedule() (*starListSchedule, error) {
+	if dataDir == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(scheduleFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	schedule := new(starListSchedule)
+	if err := rlp.DecodeBytes(data, schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// writeSchedule stores the star list schedule in the data directory, replacing
+// the previous one atomically. The caller must hold readStarListMux.
+func writeSchedule() error {
+	if dataDir == "" {
+		return nil
+	}
+	data, err := rlp.EncodeToBytes(&starListSchedule{Stars: starList, Reloads: reloadedStars})
+	if err != nil {
+		return err
+	}
+	tmp := scheduleFile() + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, scheduleFile())
+}
+
+// parseStarList reads the stars listed in a starlist file, one per line: the
+// address, the signing public key and optionally the node public key. Lines not
+// made up of two or three fields are skipped.
+func parseStarList(r io.Reader) StarList {
+	stars := make(StarList, 0)
+	buff := bufio.NewReader(r)
 	for {
 		line, err := buff.ReadString('\n')
 		if err != nil {
@@ -55,8 +177,9 @@ func readStarList() {
 			}
 		}
 		line = strings.TrimSpace(line)
+		// 地址 签名公钥 [节点公钥]
 		tmp := strings.Split(line, " ")
-		if len(tmp) != 2 {
+		if len(tmp) != 2 && len(tmp) != 3 {
 			continue
 		}
 		addrStr := tmp[0]
@@ -65,93 +188,312 @@ func readStarList() {
 		}
 		var addr = common.HexToAddress(addrStr)
 		var pubKey = common.Hex2Bytes(tmp[1])
-		starList = append(starList, AddrNodeIDMapping{addr, pubKey})
+		var nodeID []byte
+		if len(tmp) == 3 {
+			nodeID = common.Hex2Bytes(tmp[2])
+		}
+		stars = append(stars, AddrNodeIDMapping{Addr: addr, Pubkey: pubKey, NodeID: nodeID})
 		log.Info(fmt.Sprintf("addr:%s pubkey:%s", tmp[0], tmp[1]))
 	}
+	return stars
+}
+
+// ReloadStarList reads the starlist file again and, if it lists a valid star list
+// different from the one loaded last, schedules it for the blocks from the given
+// number on and posts a StarListEvent. Blocks below keep the stars they were
+// produced with. Lists scheduled before for the same or a later block are
+// replaced. Nodes whose genesis block defines the stars can't reload them.
+func ReloadStarList(from uint64) (StarList, error) {
+	readStarListMux.Lock()
+	fixed := genesisStars != nil
+	readStarListMux.Unlock()
+	if fixed {
+		return nil, errStarListGenesis
+	}
+	fd, err := os.Open(starListFile())
+	if err != nil {
+		return nil, err
+	}
+	stars := parseStarList(fd)
+	fd.Close()
+
+	if err := verifyStarList(stars); err != nil {
+		return nil, err
+	}
+	readStarListMux.Lock()
+	old := latestStarList()
+	if old != nil && matchStarList(stars, old) == nil {
+		readStarListMux.Unlock()
+		return old, nil
+	}
+	for i, reloaded := range reloadedStars {
+		if reloaded.From >= from {
+			reloadedStars = reloadedStars[:i:i]
+			break
+		}
+	}
+	scheduled := reloadedStars
+	reloadedStars = append(reloadedStars, scheduledStarList{From: from, Stars: stars})
+	if err := writeSchedule(); err != nil {
+		reloadedStars = scheduled
+		readStarListMux.Unlock()
+		return nil, err
+	}
+	readStarListMux.Unlock()
+
+	log.Info("Reloaded star node list", "stars", len(stars), "from", from)
+	starListFeed.Send(StarListEvent{Old: old, New: stars, Number: from})
+	return stars, nil
+}
+
+// verifyStarList checks the keys of a star list read from the starlist file and
+// that no star is listed twice.
+func verifyStarList(stars StarList) error {
+	if len(stars) == 0 {
+		return errStarListEmpty
+	}
+	seen := make(map[common.Address]bool)
+	for _, star := range stars {
+		if len(star.Pubkey) != 64 {
+			return fmt.Errorf("star %x: pubkey must be 64 bytes", star.Addr)
+		}
+		if len(star.NodeID) != 0 && len(star.NodeID) != 64 {
+			return fmt.Errorf("star %x: node key must be 64 bytes", star.Addr)
+		}
+		if seen[star.Addr] {
+			return errStarListRepeats
+		}
+		seen[star.Addr] = true
+	}
+	return nil
+}
+
+// matchStarList checks that two star lists contain the same stars with the same
+// keys in the same order. Confirmation weights are not compared, as the starlist
+// file doesn't carry them.
+func matchStarList(local, want StarList) error {
+	if len(local) != len(want) {
+		return fmt.Errorf("%v: have %d stars, genesis has %d", errStarListMismatch, len(local), len(want))
+	}
+	for i := range local {
+		if local[i].Addr != want[i].Addr || !bytes.Equal(local[i].Pubkey, want[i].Pubkey) || !bytes.Equal(local[i].NodeKey(), want[i].NodeKey()) {
+			return fmt.Errorf("%v: star %d is %x, genesis has %x", errStarListMismatch, i, local[i].Addr, want[i].Addr)
+		}
+	}
+	return nil
+}
+
+// SetStarList replaces the star list otherwise read from the starlist file, for
+// processes running nodes without a data directory of their own. Genesis stars
+// adopted before are dropped.
+func SetStarList(list []AddrNodeIDMapping) {
+	readStarListMux.Lock()
+	defer readStarListMux.Unlock()
+
+	starList = append([]AddrNodeIDMapping{}, list...)
+	reloadedStars, genesisStars = nil, nil
+}
+
+// CheckStarList checks the local starlist file against the stars committed in
+// the genesis block. An empty starlist file is replaced by the genesis stars,
+// any other file has to list exactly the genesis stars in the same order.
+func CheckStarList(genesis StarList) error {
+	if local := StarList(GetAllSortedCoreNodes()); len(local) > 0 {
+		if err := matchStarList(local, genesis); err != nil {
+			return err
+		}
+	}
+	readStarListMux.Lock()
+	defer readStarListMux.Unlock()
+
+	genesisStars = append(StarList{}, genesis...)
+	starList, reloadedStars = genesisStars, nil
+	return nil
+}
+
+// GenesisStarList returns the stars committed in the genesis block, as adopted by
+// CheckStarList, or nil if the genesis block leaves the stars to the starlist file.
+func GenesisStarList() StarList {
+	readStarListMux.Lock()
+	defer readStarListMux.Unlock()
+
+	return genesisStars
 }
 
 // Get all sorted nodes that who can produce blocks
+// This is the star list loaded last, which may only apply to future blocks.
 func GetAllSortedCoreNodes() []AddrNodeIDMapping {
-	// TODO from合约
+	readStarListMux.Lock()
+	defer readStarListMux.Unlock()
+
+	return latestStarList()
+}
+
+// StarListAt returns the star list of the starlist file applying to the block
+// with the given number.
+func StarListAt(number uint64) StarList {
+	readStarListMux.Lock()
+	defer readStarListMux.Unlock()
+
+	if starList == nil {
+		readStarList()
+	}
+	stars := StarList(starList)
+	for _, reloaded := range reloadedStars {
+		if reloaded.From > number {
+			break
+		}
+		stars = reloaded.Stars
+	}
+	return stars
+}
+
+// latestStarList returns the star list loaded last, reading the starlist file
+// if nothing was loaded yet. The caller must hold readStarListMux.
+func latestStarList() StarList {
 	if starList == nil {
 		readStarList()
 	}
+	if n := len(reloadedStars); n > 0 {
+		return reloadedStars[n-1].Stars
+	}
 	return starList
 }
 
 // 获取主节点数量
 func GetCoreNodesCount() int {
-	nodes := GetAllSortedCoreNodes()
-	return len(nodes)
+	return len(GetAllSortedCoreNodes())
 }
 
 // 获取节点索引 后期可优化下
 func GetCoreNodeIndex(address *common.Address) int {
-	nodes := GetAllSortedCoreNodes()
-	for i := 0; i < len(nodes); i++ {
-		if bytes.Compare(nodes[i].Addr[:], address[:]) == 0 {
+	return StarList(GetAllSortedCoreNodes()).Index(address)
+}
+
+// 根据pubkey获取节点索引
+func GetCoreNodeIndexByPubkey(pubKey []byte) int {
+	return StarList(GetAllSortedCoreNodes()).IndexByPubkey(pubKey)
+}
+
+// 通过出块者地址获取节点公钥
+func GetPubkeyByAddress(address *common.Address) []byte {
+	nodes := StarList(GetAllSortedCoreNodes())
+	if len(nodes) == 0 {
+		log.Error("GetAllSortedCoreNodes count=0")
+	}
+	return nodes.PubkeyByAddress(address)
+}
+
+// 根据publick key 获取地址
+func GetAddressByPubkey(pubKey []byte) common.Address {
+	return StarList(GetAllSortedCoreNodes()).AddressByPubkey(pubKey)
+}
+
+// 获取最新块的出块者序号与本节点序号差
+func GetSlot(firstAddress, nextAddress *common.Address) int {
+	return StarList(GetAllSortedCoreNodes()).Slot(firstAddress, nextAddress)
+}
+
+// StarList is the ordered list of star nodes allowed to produce blocks. The
+// position of a star in the list determines its slot in the production round.
+type StarList []AddrNodeIDMapping
+
+// Index returns the position of the star with the given address, or -1.
+func (l StarList) Index(address *common.Address) int {
+	for i := 0; i < len(l); i++ {
+		if bytes.Equal(l[i].Addr[:], address[:]) {
 			return i
 		}
 	}
 	return -1
 }
 
-// 根据pubkey获取节点索引
-func GetCoreNodeIndexByPubkey(pubKey []byte) int {
-	nodes := GetAllSortedCoreNodes()
-	for i := 0; i < len(nodes); i++ {
-		if bytes.Compare(nodes[i].Pubkey, pubKey[1:]) == 0 {
+// Weight returns the confirmation weight of the i-th star.
+func (l StarList) Weight(i int) uint64 {
+	if l[i].Weight == 0 {
+		return 1
+	}
+	return l[i].Weight
+}
+
+// TotalWeight returns the sum of the confirmation weights of all stars.
+func (l StarList) TotalWeight() uint64 {
+	var total uint64
+	for i := range l {
+		total += l.Weight(i)
+	}
+	return total
+}
+
+// IndexByPubkey returns the position of the star with the given uncompressed
+// (65 byte) public key, or -1.
+func (l StarList) IndexByPubkey(pubKey []byte) int {
+	if len(pubKey) == 0 {
+		return -1
+	}
+	for i := 0; i < len(l); i++ {
+		if bytes.Equal(l[i].Pubkey, pubKey[1:]) {
 			return i
 		}
 	}
 	return -1
 }
 
-// 通过出块者地址获取节点公钥
-func GetPubkeyByAddress(address *common.Address) []byte {
-	nodes := GetAllSortedCoreNodes()
-	if len(nodes) == 0 {
-		log.Error("GetAllSortedCoreNodes count=0")
+// IndexByNodeKey returns the position of the star with the given uncompressed
+// (65 byte) p2p node key, or -1.
+func (l StarList) IndexByNodeKey(pubKey []byte) int {
+	if len(pubKey) == 0 {
+		return -1
 	}
-	for i := 0; i < len(nodes); i++ {
-		if bytes.Compare(nodes[i].Addr[:], address[:]) == 0 {
-			res := make([]byte, len(nodes[i].Pubkey))
-			copy(res, nodes[i].Pubkey)
-			return res
+	for i := 0; i < len(l); i++ {
+		if bytes.Equal(l[i].NodeKey(), pubKey[1:]) {
+			return i
 		}
 	}
+	return -1
+}
+
+// PubkeyByAddress returns a copy of the signing public key of the star with the
+// given address, or nil if it isn't a star.
+func (l StarList) PubkeyByAddress(address *common.Address) []byte {
+	if i := l.Index(address); i >= 0 {
+		res := make([]byte, len(l[i].Pubkey))
+		copy(res, l[i].Pubkey)
+		return res
+	}
 	log.Info("GetPubkeyByAddress is nil addr:", common.ToHex(address[:]))
-	for _, node := range nodes {
+	for _, node := range l {
 		log.Info(fmt.Sprintf("addr:%s pubkey:%s", common.ToHex(node.Addr[:]), common.ToHex(node.Pubkey[:])))
 	}
 	return nil
 }
 
-// 根据publick key 获取地址
-func GetAddressByPubkey(pubKey []byte) common.Address {
+// AddressByPubkey returns the address of the star with the given public key,
+// either uncompressed (65 bytes) or without the prefix byte (64 bytes).
+func (l StarList) AddressByPubkey(pubKey []byte) common.Address {
 	if len(pubKey) == 65 {
 		pubKey = pubKey[1:]
 	}
-	nodes := GetAllSortedCoreNodes()
-	for i := 0; i < len(nodes); i++ {
-		if bytes.Compare(nodes[i].Pubkey, pubKey) == 0 {
-			return nodes[i].Addr
+	for i := 0; i < len(l); i++ {
+		if bytes.Equal(l[i].Pubkey, pubKey) {
+			return l[i].Addr
 		}
 	}
 	return common.Address{}
 }
 
-// 获取最新块的出块者序号与本节点序号差
-func GetSlot(firstAddress, nextAddress *common.Address) int {
-	firstIndex := GetCoreNodeIndex(firstAddress)
-	nextIndex := GetCoreNodeIndex(nextAddress)
+// Slot returns the distance in the production round from the star that sealed
+// the previous block (firstAddress) to nextAddress.
+func (l StarList) Slot(firstAddress, nextAddress *common.Address) int {
+	firstIndex := l.Index(firstAddress)
+	nextIndex := l.Index(nextAddress)
 	// 与创世块比较
 	var emptyAddr [20]byte
-	if bytes.Compare((*firstAddress)[:], emptyAddr[:]) == 0 {
+	if bytes.Equal((*firstAddress)[:], emptyAddr[:]) {
 		log.Debug("getSlot: firstAddress is empty")
 		return nextIndex + 1
 	}
-	nodeCount := GetCoreNodesCount()
+	nodeCount := len(l)
 	// 只有一个主节点
 	if nodeCount == 1 {
 		log.Debug("getSlot: only one star node")
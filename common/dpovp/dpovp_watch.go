@@ -0,0 +1,108 @@
+// +build darwin,!ios freebsd linux,!arm64 netbsd solaris
+
+package dpovp
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/LoveBlock/loveblock/log"
+	"github.com/rjeczalik/notify"
+)
+
+// StarListWatcher reloads the star list whenever the starlist file changes.
+type StarListWatcher struct {
+	activation func() (uint64, error) // First block a reloaded list applies to
+	ev         chan notify.EventInfo
+	quit       chan struct{}
+
+	lock    sync.Mutex
+	running bool
+}
+
+// NewStarListWatcher creates a watcher of the starlist file in the data directory,
+// scheduling reloaded lists from the block returned by activation on.
+func NewStarListWatcher(activation func() (uint64, error)) *StarListWatcher {
+	return &StarListWatcher{
+		activation: activation,
+		ev:         make(chan notify.EventInfo, 10),
+		quit:       make(chan struct{}),
+	}
+}
+
+// Start starts watching the starlist file in the background, unless the node
+// has no data directory.
+func (w *StarListWatcher) Start() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.running || dataDir == "" {
+		return
+	}
+	w.running = true
+	go w.loop()
+}
+
+// Close stops watching the starlist file.
+func (w *StarListWatcher) Close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.running {
+		close(w.quit)
+		w.running = false
+	}
+}
+
+func (w *StarListWatcher) loop() {
+	file, _ := filepath.Abs(starListFile())
+	logger := log.New("path", file)
+
+	// Watch the folder, editors tend to replace files instead of writing them
+	if err := notify.Watch(filepath.Dir(file), w.ev, notify.All); err != nil {
+		logger.Warn("Failed to watch starlist file", "err", err)
+		return
+	}
+	defer notify.Stop(w.ev)
+	logger.Trace("Started watching starlist file")
+	defer logger.Trace("Stopped watching starlist file")
+
+	// Wait for file system events and reload.
+	// When an event occurs, the reload call is delayed a bit so that
+	// multiple events arriving quickly only cause a single reload.
+	var (
+		debounceDuration = 500 * time.Millisecond
+		reloadTriggered  = false
+		debounce         = time.NewTimer(0)
+	)
+	// Ignore initial trigger
+	if !debounce.Stop() {
+		<-debounce.C
+	}
+	defer debounce.Stop()
+	for {
+		select {
+		case <-w.quit:
+			return
+		case ev := <-w.ev:
+			if ev.Path() != file {
+				continue
+			}
+			// Trigger the reload (with delay), if not already triggered
+			if !reloadTriggered {
+				debounce.Reset(debounceDuration)
+				reloadTriggered = true
+			}
+		case <-debounce.C:
+			from, err := w.activation()
+			if err == nil {
+				_, err = ReloadStarList(from)
+			}
+			if err != nil {
+				logger.Warn("Rejected changed starlist file", "err", err)
+			}
+			reloadTriggered = false
+		}
+	}
+}
@@ -0,0 +1,13 @@
+// +build ios linux,arm64 windows !darwin,!freebsd,!linux,!netbsd,!solaris
+
+// This is the fallback implementation of starlist file watching.
+// It is used on unsupported platforms, where the star list is only
+// reloaded through the admin API.
+
+package dpovp
+
+type StarListWatcher struct{}
+
+func NewStarListWatcher(func() (uint64, error)) *StarListWatcher { return new(StarListWatcher) }
+func (*StarListWatcher) Start()                                  {}
+func (*StarListWatcher) Close()                                  {}
@@ -0,0 +1,181 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package mclock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a source of wall clock time and timers, making it possible to replace
+// the system clock with a simulated one in tests.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a cancellable event created by AfterFunc.
+type Timer interface {
+	// Stop cancels the timer. It returns false if the timer has already expired
+	// or been stopped.
+	Stop() bool
+}
+
+// System implements Clock using the system clock.
+type System struct{}
+
+// Now implements Clock.
+func (System) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep implements Clock.
+func (System) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// After implements Clock.
+func (System) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// AfterFunc implements Clock.
+func (System) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// Simulated implements a virtual Clock for reproducible time-sensitive tests. It
+// simulates a scheduler on a virtual timescale where actual processing takes zero
+// time.
+//
+// The virtual clock doesn't advance on its own, call Run to advance it and execute
+// timers. Since there is no way to influence the Go scheduler, testing timeout
+// behaviour involving goroutines needs special care. A good way to test such
+// timeouts is as follows: first perform the action that is supposed to time out.
+// Ensure that the timer you want to test is created. Then run the clock until
+// after the timeout. Finally observe the effect of the timeout using a channel or
+// semaphore.
+type Simulated struct {
+	now       time.Time
+	scheduled []*simTimer
+	mu        sync.Mutex
+	cond      *sync.Cond
+}
+
+// simTimer is a timer scheduled on the simulated clock.
+type simTimer struct {
+	at time.Time
+	s  *Simulated
+	do func()
+}
+
+// NewSimulated creates a simulated clock starting at the given time.
+func NewSimulated(start time.Time) *Simulated {
+	s := &Simulated{now: start}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Run moves the clock by the given duration, executing all timers before that
+// duration. Timers are executed in order of their expiry, with the clock set to
+// their expiry time, outside of the clock's lock.
+func (s *Simulated) Run(d time.Duration) {
+	s.mu.Lock()
+	end := s.now.Add(d)
+	for len(s.scheduled) > 0 && !s.scheduled[0].at.After(end) {
+		t := s.scheduled[0]
+		s.scheduled = s.scheduled[1:]
+		s.now = t.at
+
+		s.mu.Unlock()
+		t.do()
+		s.mu.Lock()
+	}
+	s.now = end
+	s.mu.Unlock()
+}
+
+// ActiveTimers returns the number of timers that haven't fired.
+func (s *Simulated) ActiveTimers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.scheduled)
+}
+
+// WaitForTimers waits until the clock has at least n scheduled timers.
+func (s *Simulated) WaitForTimers(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.scheduled) < n {
+		s.cond.Wait()
+	}
+}
+
+// Now implements Clock.
+func (s *Simulated) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.now
+}
+
+// Sleep implements Clock, blocking until the clock was moved past d.
+func (s *Simulated) Sleep(d time.Duration) {
+	<-s.After(d)
+}
+
+// After implements Clock.
+func (s *Simulated) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	s.AfterFunc(d, func() { ch <- s.Now() })
+	return ch
+}
+
+// AfterFunc implements Clock. The callback runs on the goroutine calling Run.
+func (s *Simulated) AfterFunc(d time.Duration, f func()) Timer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Keep timers expiring at the same time in creation order
+	t := &simTimer{at: s.now.Add(d), s: s, do: f}
+	s.scheduled = append(s.scheduled, t)
+	sort.SliceStable(s.scheduled, func(i, j int) bool {
+		return s.scheduled[i].at.Before(s.scheduled[j].at)
+	})
+	s.cond.Broadcast()
+	return t
+}
+
+// Stop implements Timer.
+func (t *simTimer) Stop() bool {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	for i, scheduled := range t.s.scheduled {
+		if scheduled == t {
+			t.s.scheduled = append(t.s.scheduled[:i], t.s.scheduled[i+1:]...)
+			t.s.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package mclock
+
+import (
+	"testing"
+	"time"
+)
+
+var _ Clock = System{}
+var _ Clock = new(Simulated)
+
+// Tests that simulated timers fire in order of expiry, with the clock set to the
+// expiry time, and that stopped timers don't fire at all.
+func TestSimulatedAfterFunc(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewSimulated(start)
+
+	var fired []time.Duration
+	record := func() { fired = append(fired, clock.Now().Sub(start)) }
+
+	clock.AfterFunc(3*time.Second, record)
+	clock.AfterFunc(1*time.Second, record)
+	stopped := clock.AfterFunc(2*time.Second, record)
+	if clock.ActiveTimers() != 3 {
+		t.Fatalf("active timer mismatch: have %d, want %d", clock.ActiveTimers(), 3)
+	}
+	if !stopped.Stop() {
+		t.Fatalf("failed to stop pending timer")
+	}
+	clock.Run(2 * time.Second)
+	if len(fired) != 1 || fired[0] != time.Second {
+		t.Fatalf("fired timers mismatch after 2s: have %v", fired)
+	}
+	if now := clock.Now(); now != start.Add(2*time.Second) {
+		t.Fatalf("clock time mismatch: have %v, want %v", now, start.Add(2*time.Second))
+	}
+	clock.Run(time.Second)
+	if len(fired) != 2 || fired[1] != 3*time.Second {
+		t.Fatalf("fired timers mismatch after 3s: have %v", fired)
+	}
+	if stopped.Stop() {
+		t.Errorf("stopped an already stopped timer")
+	}
+}
+
+// Tests that sleeping on the simulated clock blocks until the clock is advanced.
+func TestSimulatedSleep(t *testing.T) {
+	clock := NewSimulated(time.Unix(0, 0))
+
+	done := make(chan struct{})
+	go func() {
+		clock.Sleep(time.Minute)
+		close(done)
+	}()
+	clock.WaitForTimers(1)
+
+	clock.Run(time.Minute - 1)
+	select {
+	case <-done:
+		t.Fatalf("sleep returned before the clock advanced enough")
+	default:
+	}
+	clock.Run(1)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("sleep didn't return after the clock advanced")
+	}
+}
@@ -19,6 +19,7 @@ package consensus
 
 import (
 	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/common/dpovp"
 	"github.com/LoveBlock/loveblock/core/state"
 	"github.com/LoveBlock/loveblock/core/types"
 	"github.com/LoveBlock/loveblock/params"
@@ -96,3 +97,19 @@ type Engine interface {
 	// APIs returns the RPC APIs this consensus engine provides.
 	APIs(chain ChainReader) []rpc.API
 }
+
+// StarReader is implemented by consensus engines that schedule block production
+// over a set of star nodes.
+type StarReader interface {
+	// Stars returns the star nodes allowed to produce the child of the given
+	// parent header, in slot order.
+	Stars(chain ChainReader, parent *types.Header) dpovp.StarList
+}
+
+// EvidenceVerifier is implemented by consensus engines able to prove that a
+// block producer sealed conflicting blocks.
+type EvidenceVerifier interface {
+	// VerifyEvidence checks that both headers of the evidence were validly sealed
+	// by the same producer in the same slot.
+	VerifyEvidence(chain ChainReader, evidence *types.Evidence) error
+}
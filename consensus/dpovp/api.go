@@ -0,0 +1,376 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/common/hexutil"
+	"github.com/LoveBlock/loveblock/consensus"
+	"github.com/LoveBlock/loveblock/core/types"
+	"github.com/LoveBlock/loveblock/rlp"
+	"github.com/LoveBlock/loveblock/rpc"
+)
+
+var (
+	// errUnknownBlock is returned when the list of stars is requested for a block
+	// that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errNoConsensusInfo is returned if the chain the API was created for doesn't
+	// track block confirmations and stable blocks.
+	errNoConsensusInfo = errors.New("consensus bookkeeping not available")
+
+	// errNoStarKey is returned if the BLS registration of the local star is
+	// requested before its private key was set.
+	errNoStarKey = errors.New("star key not set")
+
+	// errNoState is returned if the chain the API was created for doesn't give
+	// access to the state database.
+	errNoState = errors.New("chain state not available")
+
+	// errInvalidWindow is returned if liveness statistics are requested over no
+	// blocks or more than maxLivenessWindow blocks.
+	errInvalidWindow = errors.New("invalid liveness window")
+)
+
+// consensusInfoReader is implemented by chains tracking star confirmations of
+// recent blocks (e.g. core.BlockChain).
+type consensusInfoReader interface {
+	StableBlock() *types.Block
+	ConsensusFlag(hash common.Hash) (*big.Int, bool)
+	GetFinalityCertificate(hash common.Hash) *types.FinalityCertificate
+}
+
+// API is a user facing RPC API to inspect the star schedule and the consensus
+// progress of the chain.
+type API struct {
+	chain consensus.ChainReader
+	dpovp *Dpovp
+}
+
+// StarInfo is a star node as exposed over RPC.
+type StarInfo struct {
+	Address common.Address `json:"address"`
+	Pubkey  hexutil.Bytes  `json:"pubkey"`
+	NodeID  hexutil.Bytes  `json:"nodeId,omitempty"`
+}
+
+// SlotInfo is the next production window of a star after the current head.
+type SlotInfo struct {
+	Address common.Address `json:"address"`
+	Slot    int            `json:"slot"`  // Distance from the author of the head block
+	Start   hexutil.Uint64 `json:"start"` // Window start, milliseconds since the unix epoch
+	End     hexutil.Uint64 `json:"end"`   // Window end (exclusive), 0 if the window never closes
+}
+
+// Schedule is the production schedule of the block following the current head.
+type Schedule struct {
+	Number  hexutil.Uint64  `json:"number"`  // Number of the current head block
+	Hash    common.Hash     `json:"hash"`    // Hash of the current head block
+	Current *common.Address `json:"current"` // Star whose slot it is now, nil if none
+	Slots   []SlotInfo      `json:"slots"`
+}
+
+// Confirmations is the set of stars that confirmed a block.
+type Confirmations struct {
+	Bitmap    *hexutil.Big     `json:"bitmap"` // Bit i set if the i-th star scheduled for the block confirmed
+	Confirmed []common.Address `json:"confirmed"`
+}
+
+// Finality is the certificate proving that a block was finalized, checkable by
+// recovering the stars from the votes.
+type Finality struct {
+	Hash   common.Hash     `json:"hash"`
+	Number hexutil.Uint64  `json:"number"`
+	Votes  []hexutil.Bytes `json:"votes"` // Signatures of the block hash by confirming stars, besides the block's author
+}
+
+// BLSRegistration is the transaction registering the BLS key of the local star,
+// to be sent from the star's coinbase.
+type BLSRegistration struct {
+	To     common.Address `json:"to"`
+	Pubkey hexutil.Bytes  `json:"pubkey"`
+	Data   hexutil.Bytes  `json:"data"`
+}
+
+// JailInfo is the jail record of a star as exposed over RPC.
+type JailInfo struct {
+	Address common.Address  `json:"address"`
+	Missed  uint64          `json:"missed"`          // Slots missed in a row
+	Jailed  bool            `json:"jailed"`          // Whether the star is out of the rotation
+	Since   *hexutil.Uint64 `json:"since,omitempty"` // Number of the block that jailed the star
+}
+
+// StableInfo identifies the current stable block.
+type StableInfo struct {
+	Number hexutil.Uint64 `json:"number"`
+	Hash   common.Hash    `json:"hash"`
+}
+
+// headerByNumber retrieves the header at the given number, defaulting to the
+// current head.
+func (api *API) headerByNumber(number *rpc.BlockNumber) (*types.Header, error) {
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber || *number == rpc.PendingBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else if *number == rpc.StableBlockNumber {
+		reader, ok := api.chain.(consensusInfoReader)
+		if !ok {
+			return nil, errNoConsensusInfo
+		}
+		header = reader.StableBlock().Header()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	return header, nil
+}
+
+// GetStars retrieves the star nodes allowed to produce the child of the block
+// with the given number, defaulting to the current head.
+func (api *API) GetStars(number *rpc.BlockNumber) ([]StarInfo, error) {
+	header, err := api.headerByNumber(number)
+	if err != nil {
+		return nil, err
+	}
+	return starInfos(api.dpovp.Stars(api.chain, header)), nil
+}
+
+// GetStarsAtHash retrieves the star nodes allowed to produce the child of the
+// block with the given hash.
+func (api *API) GetStarsAtHash(hash common.Hash) ([]StarInfo, error) {
+	header := api.chain.GetHeaderByHash(hash)
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	return starInfos(api.dpovp.Stars(api.chain, header)), nil
+}
+
+// GetSigner retrieves the star node that sealed the block with the given
+// number, defaulting to the current head.
+func (api *API) GetSigner(number *rpc.BlockNumber) (common.Address, error) {
+	header, err := api.headerByNumber(number)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if header.Number.Sign() == 0 {
+		return common.Address{}, nil
+	}
+	pubkey, err := ecrecover(api.dpovp.config, header, api.chain.Config().ChainId)
+	if err != nil {
+		return common.Address{}, errInvalidSignature
+	}
+	parent := api.chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	stars := api.dpovp.Stars(api.chain, parent)
+	if stars.IndexByPubkey(pubkey) < 0 {
+		return common.Address{}, errUnknownStar
+	}
+	return stars.AddressByPubkey(pubkey), nil
+}
+
+// GetSchedule returns, for every star, the next window in which it may produce
+// the child of the current head, along with the star whose slot it is now.
+func (api *API) GetSchedule() (*Schedule, error) {
+	head := api.chain.CurrentHeader()
+	if head == nil {
+		return nil, errUnknownBlock
+	}
+	var (
+		stars    = api.dpovp.Stars(api.chain, head)
+		now      = api.dpovp.clock.Now().UnixNano() / int64(time.Millisecond)
+		schedule = &Schedule{
+			Number: hexutil.Uint64(head.Number.Uint64()),
+			Hash:   head.Hash(),
+			Slots:  make([]SlotInfo, 0, len(stars)),
+		}
+	)
+	for _, star := range stars {
+		slot := stars.Slot(&head.Coinbase, &star.Addr)
+		start, end := SlotWindow(len(stars), slot, int64(head.MilliTime(api.dpovp.config)), now, api.dpovp.timeoutTime, api.dpovp.blockInternal)
+		if start <= now && (end == 0 || now < end) {
+			addr := star.Addr
+			schedule.Current = &addr
+		}
+		schedule.Slots = append(schedule.Slots, SlotInfo{
+			Address: star.Addr,
+			Slot:    slot,
+			Start:   hexutil.Uint64(start),
+			End:     hexutil.Uint64(end),
+		})
+	}
+	return schedule, nil
+}
+
+// GetConfirmations returns the stars that confirmed the block with the given
+// hash, as tracked by the local node. Blocks not imported yet are assumed to
+// extend the current head.
+func (api *API) GetConfirmations(hash common.Hash) (*Confirmations, error) {
+	reader, ok := api.chain.(consensusInfoReader)
+	if !ok {
+		return nil, errNoConsensusInfo
+	}
+	parent := api.chain.CurrentHeader()
+	if header := api.chain.GetHeaderByHash(hash); header != nil && header.Number.Sign() > 0 {
+		if prev := api.chain.GetHeader(header.ParentHash, header.Number.Uint64()-1); prev != nil {
+			parent = prev
+		}
+	}
+	flag, _ := reader.ConsensusFlag(hash)
+	info := &Confirmations{Bitmap: (*hexutil.Big)(flag), Confirmed: []common.Address{}}
+	for i, star := range api.dpovp.Stars(api.chain, parent) {
+		if flag.Bit(i) == 1 {
+			info.Confirmed = append(info.Confirmed, star.Addr)
+		}
+	}
+	return info, nil
+}
+
+// GetFinality returns the finality certificate of the block with the given hash,
+// or nil if the block isn't known to be finalized.
+func (api *API) GetFinality(hash common.Hash) (*Finality, error) {
+	reader, ok := api.chain.(consensusInfoReader)
+	if !ok {
+		return nil, errNoConsensusInfo
+	}
+	cert := reader.GetFinalityCertificate(hash)
+	if cert == nil {
+		return nil, nil
+	}
+	finality := &Finality{Hash: cert.Hash, Number: hexutil.Uint64(cert.Number), Votes: make([]hexutil.Bytes, 0, len(cert.Votes))}
+	for _, vote := range cert.Votes {
+		finality.Votes = append(finality.Votes, vote)
+	}
+	return finality, nil
+}
+
+// GetStableBlock returns the current stable block, below which the chain is
+// never reorganised.
+func (api *API) GetStableBlock() (*StableInfo, error) {
+	reader, ok := api.chain.(consensusInfoReader)
+	if !ok {
+		return nil, errNoConsensusInfo
+	}
+	stable := reader.StableBlock()
+	return &StableInfo{Number: hexutil.Uint64(stable.NumberU64()), Hash: stable.Hash()}, nil
+}
+
+// GetLiveness returns, for every star, the blocks produced, the slots missed, the
+// blocks sealed late and the confirmations given over the given number of blocks
+// up to the current head, defaulting to 1000.
+func (api *API) GetLiveness(window *hexutil.Uint64) (*Liveness, error) {
+	head := api.chain.CurrentHeader()
+	if head == nil {
+		return nil, errUnknownBlock
+	}
+	blocks := uint64(livenessWindow)
+	if window != nil {
+		blocks = uint64(*window)
+	}
+	if blocks == 0 || blocks > maxLivenessWindow {
+		return nil, errInvalidWindow
+	}
+	return api.dpovp.liveness(api.chain, head, blocks), nil
+}
+
+// GetJailStatus returns the jail records of all registered stars, including the
+// jailed ones, in the state of the block with the given number, defaulting to
+// the current head.
+func (api *API) GetJailStatus(number *rpc.BlockNumber) ([]JailInfo, error) {
+	header, err := api.headerByNumber(number)
+	if err != nil {
+		return nil, err
+	}
+	reader, ok := api.chain.(stateReader)
+	if !ok {
+		return nil, errNoState
+	}
+	statedb, err := reader.StateAt(header.Root)
+	if err != nil {
+		return nil, err
+	}
+	stars := commonDpovp.ReadStarList(statedb)
+	if len(stars) == 0 {
+		stars = commonDpovp.StarListAt(header.Number.Uint64() + 1)
+	}
+	infos := make([]JailInfo, 0, len(stars))
+	for _, star := range stars {
+		info := JailInfo{Address: star.Addr, Missed: commonDpovp.ReadMissedSlots(statedb, star.Addr)}
+		if since, jailed := commonDpovp.ReadJailed(statedb, star.Addr); jailed {
+			info.Jailed, info.Since = true, (*hexutil.Uint64)(&since)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// GetBLSRegistration returns the transaction registering the BLS key the local
+// star votes with. The key is derived from the star's signing key.
+func (api *API) GetBLSRegistration() (*BLSRegistration, error) {
+	key := commonDpovp.BLSKeyOf(api.dpovp.coinbase)
+	if key == nil {
+		return nil, errNoStarKey
+	}
+	pubkey := key.PublicKey().Marshal()
+	data, err := rlp.EncodeToBytes(&commonDpovp.BLSRegistration{Pubkey: pubkey, Proof: key.SignPossession().Marshal()})
+	if err != nil {
+		return nil, err
+	}
+	return &BLSRegistration{To: commonDpovp.BLSRegistryAddress, Pubkey: pubkey, Data: data}, nil
+}
+
+// SlotWindow returns the first window, in milliseconds since the unix epoch, in
+// which the star at the given slot distance from the parent's author may produce
+// a block on top of it and which doesn't end before now. The timeout and block
+// interval are the ones of the dpovp config, in milliseconds. The window is the
+// same one checked by verifyHeader; an end of 0 means the window never closes.
+func SlotWindow(nodeCount int, slot int, parentTime int64, now int64, timeout int64, interval int64) (int64, int64) {
+	if nodeCount <= 1 {
+		return parentTime + interval, 0
+	}
+	if slot == 0 { // 上一个块为自己出的块，排在一轮的最后
+		slot = nodeCount
+	}
+	oneLoopTime := int64(nodeCount) * timeout
+	offset := int64(slot-1) * timeout
+
+	// Skip the loops whose window for this slot has already passed
+	var loop int64
+	if elapsed := now - parentTime; elapsed >= offset+timeout {
+		loop = (elapsed-offset-timeout)/oneLoopTime + 1
+	}
+	start := parentTime + loop*oneLoopTime + offset
+	if slot == 1 && loop == 0 && interval > 0 {
+		start = parentTime + interval // 块间隔至少blockInternal
+	}
+	return start, parentTime + loop*oneLoopTime + offset + timeout
+}
+
+// starInfos converts a star list into its RPC representation.
+func starInfos(stars commonDpovp.StarList) []StarInfo {
+	infos := make([]StarInfo, 0, len(stars))
+	for _, star := range stars {
+		infos = append(infos, StarInfo{Address: star.Addr, Pubkey: common.CopyBytes(star.Pubkey), NodeID: common.CopyBytes(star.NodeID)})
+	}
+	return infos
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/LoveBlock/loveblock/params"
+	"github.com/LoveBlock/loveblock/rpc"
+)
+
+// Tests that slot windows match the production schedule enforced on headers.
+func TestSlotWindow(t *testing.T) {
+	tests := []struct {
+		nodes, slot int
+		now         int64
+		start, end  int64
+	}{
+		{1, 1, 0, 300, 0},          // Single star, waits for the block interval only
+		{4, 1, 0, 300, 1000},       // Next star, first loop starts after the block interval
+		{4, 1, 2500, 4000, 5000},   // Next star missed its window, waits a full loop
+		{4, 2, 0, 1000, 2000},      // Second star waits for the first one to time out
+		{4, 3, 2999, 2000, 3000},   // Third star, window still open
+		{4, 3, 3000, 6000, 7000},   // Third star, window just closed
+		{4, 0, 0, 3000, 4000},      // Author of the parent goes last
+		{4, 0, 9500, 11000, 12000}, // Author of the parent, third loop
+	}
+	for i, tt := range tests {
+		start, end := SlotWindow(tt.nodes, tt.slot, 0, tt.now, 1000, 300)
+		if start != tt.start || end != tt.end {
+			t.Errorf("test %d: window mismatch: have [%d, %d), want [%d, %d)", i, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+// Tests that the signer of a sealed block can be retrieved over the API.
+func TestAPIGetSigner(t *testing.T) {
+	setupStarList(t)
+
+	config := &params.DpovpConfig{SealHashBlock: big.NewInt(0)}
+	engine := New(config, nil, testStarAddr)
+	chain := &testChainReader{config: &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: config}}
+
+	sealed := sealTestHeader(t, engine, chain, newTestHeader(1))
+	chain.head = sealed
+
+	api := engine.APIs(chain)[0].Service.(*API)
+	latest := rpc.LatestBlockNumber
+	signer, err := api.GetSigner(&latest)
+	if err != nil {
+		t.Fatalf("failed to retrieve signer: %v", err)
+	}
+	if signer != testStarAddr {
+		t.Errorf("signer mismatch: have %x, want %x", signer, testStarAddr)
+	}
+	if _, err := api.GetStableBlock(); err != errNoConsensusInfo {
+		t.Errorf("stable block error mismatch: have %v, want %v", err, errNoConsensusInfo)
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/LoveBlock/loveblock/common"
 	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/common/mclock"
 	"github.com/LoveBlock/loveblock/consensus"
 	"github.com/LoveBlock/loveblock/core/state"
 	"github.com/LoveBlock/loveblock/core/types"
@@ -19,30 +20,93 @@ import (
 	"github.com/LoveBlock/loveblock/lovedb"
 	"github.com/LoveBlock/loveblock/params"
 	"github.com/LoveBlock/loveblock/rpc"
+	"github.com/hashicorp/golang-lru"
+)
+
+const (
+	inmemoryStarLists = 128 // Number of recent star lists to keep in memory
 )
 
 var (
-	BlockReward *big.Int = big.NewInt(5e+18) // Block reward in wei for successfully mining a block
+	BlockReward *big.Int = params.DefaultBlockReward // Block reward in wei for successfully mining a block, unless configured otherwise
+)
+
+var (
+	// errInvalidSignature is returned if the seal of a block can't be recovered.
+	errInvalidSignature = errors.New("invalid block signature")
+
+	// errUnknownStar is returned if the coinbase of a block is not in the star
+	// node list, so there is no public key to check the seal against.
+	errUnknownStar = errors.New("coinbase is not a star node")
+
+	// errNoEpochs is returned if the star list is reloaded on a chain without
+	// epochs.
+	errNoEpochs = errors.New("star list reloads need epochs")
+
+	// errInvalidActivation is returned if a reloaded star list is scheduled from
+	// a block that isn't a future epoch checkpoint.
+	errInvalidActivation = errors.New("star list activation must be a future epoch checkpoint")
+
+	// errUnauthorizedSigner is returned if the seal of a block was not produced
+	// by the star node owning the block's coinbase.
+	errUnauthorizedSigner = errors.New("block signer doesn't match the coinbase star node")
+
+	// errInvalidMilliTime is returned if the millisecond timestamp of a block past
+	// the millisecond timestamp fork doesn't match its time in seconds.
+	errInvalidMilliTime = errors.New("millisecond timestamp doesn't match the block time")
+)
+
+// stateReader is implemented by chain readers with access to the state database
+// (e.g. core.BlockChain), needed to read the star registry.
+type stateReader interface {
+	StateAt(root common.Hash) (*state.StateDB, error)
+}
+
+// Mode defines the type and amount of consensus verification a dpovp engine makes.
+type Mode uint
+
+const (
+	ModeNormal Mode = iota
+	ModeShared
+	ModeTest
+	ModeFake
+	ModeFullFake
 )
 
 type Dpovp struct {
-	config *params.DpovpConfig // Consensus engine configuration parameters
-	db     lovedb.Database     // Database to store and retrieve snapshot checkpoints
+	config      *params.DpovpConfig // Consensus engine configuration parameters
+	db          lovedb.Database     // Database to store and retrieve snapshot checkpoints
+	registry    *lru.ARCCache       // Star lists read from the registry, keyed by state root
+	checkpoints *lru.ARCCache       // Star lists valid for the children of a header, keyed by header hash
+	records     *lru.ARCCache       // Liveness records of finalized blocks, keyed by hash
 
 	coinbase      common.Address // LoveBlock address of the signing key
 	timeoutTime   int64          // 超时时间
 	blockInternal int64          // 出块间隔
+	clock         mclock.Clock   // Time source of the slot schedule, replaceable for tests
+
+	// The fields below are hooks for testing
+	mode      Mode          // Verification mode, fake modes skip the star checks
+	fakeFail  uint64        // Block number which fails verification even in fake mode
+	fakeDelay time.Duration // Time delay to sleep for before returning from verify
 }
 
 // 新增一个DPOVP共识机
 func New(config *params.DpovpConfig, db lovedb.Database, coinbase common.Address) *Dpovp {
 	// TODO
 	conf := *config
+	registry, _ := lru.NewARC(inmemoryStarLists)
+	checkpoints, _ := lru.NewARC(inmemoryStarLists)
+	records, _ := lru.NewARC(inmemoryLiveness)
 
 	return &Dpovp{
 		config:        &conf,
 		db:            db,
+		registry:      registry,
+		checkpoints:   checkpoints,
+		records:       records,
 		coinbase:      coinbase,
+		clock:         mclock.System{},
 		timeoutTime:   config.Timeout,
 		blockInternal: config.Sleeptime,
 	}
@@ -53,6 +117,17 @@ func (d *Dpovp) SetCoinbase(coinbase common.Address) {
 	d.coinbase = coinbase
 }
 
+// SetClock replaces the time source of the engine, e.g. with a simulated clock
+// in tests.
+func (d *Dpovp) SetClock(clock mclock.Clock) {
+	d.clock = clock
+}
+
+// Clock returns the time source the engine schedules blocks with.
+func (d *Dpovp) Clock() mclock.Clock {
+	return d.clock
+}
+
 // Author implements consensus.Engine, returning the LoveBlock address recovered
 // from the signature in the header's extra-data section.
 // Author implements consensus.Engine, returning the header's coinbase as the
@@ -65,6 +140,10 @@ func (d *Dpovp) Author(header *types.Header) (common.Address, error) {
 // given engine. Verifying the seal may be done optionally here, or explicitly
 // via the VerifySeal method.
 func (d *Dpovp) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	// If we're running a full engine faking, accept any input as valid
+	if d.mode == ModeFullFake {
+		return nil
+	}
 	return d.verifyHeader(chain, header, nil)
 }
 
@@ -75,6 +154,14 @@ func (d *Dpovp) VerifyHeader(chain consensus.ChainReader, header *types.Header,
 func (d *Dpovp) VerifyHeaders(chain consensus.ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
 	abort := make(chan struct{})
 	results := make(chan error, len(headers))
+
+	// If we're running a full engine faking, accept any input as valid
+	if d.mode == ModeFullFake {
+		for i := 0; i < len(headers); i++ {
+			results <- nil
+		}
+		return abort, results
+	}
 	log.Debug("start VerifyHeaders")
 	go func() {
 		for i, header := range headers {
@@ -120,22 +207,30 @@ func (d *Dpovp) verifyHeader(chain consensus.ChainReader, header *types.Header,
 		return consensus.ErrUnknownAncestor
 	}
 	// Don't waste time checking blocks from the future
-	if header.Time.Cmp(big.NewInt(time.Now().Unix())) > 0 {
+	if header.Time.Cmp(big.NewInt(d.clock.Now().Unix())) > 0 {
 		log.Debug("verifyHeader: header.Time > time.Now()")
 		return consensus.ErrFutureBlock
 	}
-	// 验证签名
-	tmpHash := crypto.Keccak256Hash(header.Coinbase[:])
-	pubKey, err := crypto.Ecrecover(tmpHash[:], header.SignInfo)
-	if err != nil {
-		return fmt.Errorf(`verifyHeader: Wrong signinfo`)
+	if d.config.IsMilliTime(header.Number) && header.Nonce.Uint64()/1000 != header.Time.Uint64() {
+		return errInvalidMilliTime
 	}
-	blkNodePubkey := commonDpovp.GetPubkeyByAddress(&(header.Coinbase)) // 获取出块者的node公钥
-	if blkNodePubkey == nil {
-		return fmt.Errorf("verifyHeader: Verify header failed. Cann't get pubkey of %s", common.ToHex(header.Coinbase[:]))
+	// Fake engines don't resolve the stars nor enforce their schedule, so chains
+	// can be built without a star list
+	if d.mode == ModeFake {
+		return d.verifySeal(chain, header, nil)
+	}
+	// 验证检查点区块中的主节点列表
+	if err := d.verifyCheckpoint(chain, header, parent); err != nil {
+		return err
+	}
+	// 验证签名
+	stars := d.stars(chain, parent, parents)
+	if len(stars) == 0 && commonDpovp.GenesisStarList() != nil {
+		// The stars of chains seeding the star registry need the parent state
+		return consensus.ErrPrunedAncestor
 	}
-	if bytes.Compare(blkNodePubkey, pubKey[1:]) != 0 {
-		return fmt.Errorf("verifyHeader: Cann't verify block's signer")
+	if err := d.verifySeal(chain, header, stars); err != nil {
+		return err
 	}
 
 	// 以下为确定是否该该节点出块
@@ -143,14 +238,14 @@ func (d *Dpovp) verifyHeader(chain consensus.ChainReader, header *types.Header,
 		log.Debug("verifyHeader: parent is genesis block")
 		return nil
 	}
-	timeSpan := int64(header.Time.Uint64()-parent.Time.Uint64()) * 1000 // 当前块与父块时间间隔 单位：ms
-	if timeSpan < d.blockInternal {                                     // 块与父块的时间间隔至少为 block internal
+	timeSpan := int64(header.MilliTime(d.config)) - int64(parent.MilliTime(d.config)) // 当前块与父块时间间隔 单位：ms
+	if timeSpan < d.blockInternal {                                                   // 块与父块的时间间隔至少为 block internal
 		log.Debug(fmt.Sprintf("verifyHeader: timeSpan:%d is smaller than blockInternal:%d", timeSpan, d.blockInternal))
 		return fmt.Errorf("verifyHeader: block is not enough newer than it's parent")
 	}
-	nodeCount := commonDpovp.GetCoreNodesCount() // 总节点数
-	slot := commonDpovp.GetSlot(&(parent.Coinbase), &(header.Coinbase))
-	oneLoopTime := int64(commonDpovp.GetCoreNodesCount()) * d.timeoutTime // 一轮全部超时时的时间
+	nodeCount := len(stars) // 总节点数
+	slot := stars.Slot(&(parent.Coinbase), &(header.Coinbase))
+	oneLoopTime := int64(nodeCount) * d.timeoutTime // 一轮全部超时时的时间
 	log.Debug(fmt.Sprintf("verifyHeader: timeSpan:%d nodeCount:%d slot:%d oneLoopTime:%d", timeSpan, nodeCount, slot, oneLoopTime))
 	// 只有一个出块节点
 	if nodeCount == 1 {
@@ -214,23 +309,144 @@ func (d *Dpovp) VerifyUncles(chain consensus.ChainReader, block *types.Block) er
 // VerifySeal checks whether the crypto seal on a header is valid according to
 // the consensus rules of the given engine.
 func (d *Dpovp) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
-	log.Debug("VerifySeal: start VerifySeal")
-	// 验证签名
-	tmpHash := crypto.Keccak256Hash(header.Coinbase[:])
-	pubkey, err := crypto.Ecrecover(tmpHash[:], header.SignInfo)
+	var parent *types.Header
+	if number := header.Number.Uint64(); number > 0 {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	return d.verifySeal(chain, header, d.Stars(chain, parent))
+}
+
+// verifySeal checks that the header was signed by the star node owning its
+// coinbase, over the seal hash valid at the header's height.
+func (d *Dpovp) verifySeal(chain consensus.ChainReader, header *types.Header, stars commonDpovp.StarList) error {
+	// If we're running a fake DPoVP, accept any seal as valid
+	if d.mode == ModeFake || d.mode == ModeFullFake {
+		time.Sleep(d.fakeDelay)
+		if d.fakeFail == header.Number.Uint64() {
+			return errInvalidSignature
+		}
+		return nil
+	}
+	pubkey, err := ecrecover(d.config, header, chain.Config().ChainId)
 	if err != nil {
-		hashTmp := header.Hash()
-		return fmt.Errorf("VerifySeal: Failed to verify Seal. hash:%s", common.ToHex(hashTmp[:]))
+		return errInvalidSignature
 	}
-	var signer common.Address
-	copy(signer[:], crypto.Keccak256(pubkey[1:])[12:])
-	if bytes.Compare(header.Coinbase[:], signer[:]) != 0 {
-		return fmt.Errorf(`VerifySeal: signer != coinbase`)
+	blkNodePubkey := stars.PubkeyByAddress(&(header.Coinbase)) // 获取出块者的node公钥
+	if blkNodePubkey == nil {
+		return errUnknownStar
+	}
+	if !bytes.Equal(blkNodePubkey, pubkey[1:]) {
+		return errUnauthorizedSigner
 	}
-	log.Debug("VerifySeal: end VerifySeal")
 	return nil
 }
 
+// Stars implements consensus.StarReader, returning the star nodes allowed to
+// produce the child of the given parent.
+func (d *Dpovp) Stars(chain consensus.ChainReader, parent *types.Header) commonDpovp.StarList {
+	return d.stars(chain, parent, nil)
+}
+
+// registryStars returns the star nodes registered in the star registry in the
+// parent's state. Chains whose registry was never seeded use the local starlist
+// file instead, as scheduled for the child of the parent. Stars jailed in the
+// parent state are left out. The second return value reports whether the chain
+// state was available at all. Readers without state get the starlist file, unless
+// the genesis block seeded the registry: the file then only mirrors the genesis
+// stars, which later registry changes may have replaced, so no stars are returned.
+func (d *Dpovp) registryStars(chain consensus.ChainReader, parent *types.Header) (commonDpovp.StarList, bool) {
+	if parent == nil {
+		return commonDpovp.GetAllSortedCoreNodes(), false
+	}
+	local := commonDpovp.StarListAt(parent.Number.Uint64() + 1)
+	if d.registry != nil {
+		if stars, ok := d.registry.Get(parent.Root); ok {
+			if len(stars.(commonDpovp.StarList)) == 0 {
+				return local, true
+			}
+			return stars.(commonDpovp.StarList), true
+		}
+	}
+	reader, ok := chain.(stateReader)
+	if !ok {
+		return d.withoutState(parent, local, nil), false
+	}
+	statedb, err := reader.StateAt(parent.Root)
+	if err != nil {
+		return d.withoutState(parent, local, err), false
+	}
+	stars := commonDpovp.ReadStarList(statedb)
+	fromFile := len(stars) == 0
+	if d.config.JailThreshold > 0 {
+		// 去除被暂停出块的主节点
+		if fromFile {
+			stars = local
+		}
+		stars = commonDpovp.ActiveStars(statedb, stars)
+	}
+	// Lists derived from the starlist file aren't cached, as it may be reloaded
+	if d.registry != nil && !(fromFile && d.config.JailThreshold > 0) {
+		d.registry.Add(parent.Root, stars)
+	}
+	if len(stars) == 0 {
+		return local, true
+	}
+	return stars, true
+}
+
+// withoutState returns the star list of the child of the given parent for chain
+// readers lacking the parent state: the local starlist file, or none on chains
+// whose genesis block seeded the star registry.
+func (d *Dpovp) withoutState(parent *types.Header, local commonDpovp.StarList, err error) commonDpovp.StarList {
+	if commonDpovp.GenesisStarList() != nil {
+		log.Debug("Star registry unavailable", "number", parent.Number, "err", err)
+		return nil
+	}
+	log.Debug("Star registry unavailable, using star list file", "number", parent.Number, "err", err)
+	return local
+}
+
+// StarListActivation returns the first block a star list reloaded from the
+// starlist file applies to, given the current head: the requested block, which
+// has to be a future epoch checkpoint, or the next checkpoint if none is given.
+// The checkpoint carries the new list for the following epoch. Chains without
+// epochs can't reload their stars, as the nodes couldn't agree on a block.
+func (d *Dpovp) StarListActivation(head *types.Header, from uint64) (uint64, error) {
+	if d.config.Epoch == 0 {
+		return 0, errNoEpochs
+	}
+	number := head.Number.Uint64() + 1
+	if from == 0 {
+		return (number + d.config.Epoch - 1) / d.config.Epoch * d.config.Epoch, nil
+	}
+	if from < number || !d.config.IsCheckpoint(from) {
+		return 0, errInvalidActivation
+	}
+	return from, nil
+}
+
+// SealHash returns the hash of a block that the producing star node signs.
+// Before the seal hash fork only the coinbase is signed, so one signature is
+// valid for every block of the same producer. From the fork on the hash covers
+// every header field except the seal itself, plus the chain id.
+func SealHash(config *params.DpovpConfig, header *types.Header, chainId *big.Int) common.Hash {
+	if config == nil || !config.IsSealHash(header.Number) {
+		return crypto.Keccak256Hash(header.Coinbase[:])
+	}
+	if chainId == nil {
+		chainId = new(big.Int)
+	}
+	hash := header.HashNoDpovp()
+	return crypto.Keccak256Hash(hash[:], chainId.Bytes())
+}
+
+// ecrecover extracts the uncompressed public key of the star node that sealed
+// the header.
+func ecrecover(config *params.DpovpConfig, header *types.Header, chainId *big.Int) ([]byte, error) {
+	hash := SealHash(config, header, chainId)
+	return crypto.Ecrecover(hash[:], header.SignInfo)
+}
+
 // Prepare initializes the consensus fields of a block header according to the
 // rules of a particular engine. The changes are executed inline.
 func (d *Dpovp) Prepare(chain consensus.ChainReader, header *types.Header) error {
@@ -239,13 +455,17 @@ func (d *Dpovp) Prepare(chain consensus.ChainReader, header *types.Header) error
 	if parent == nil {
 		return consensus.ErrUnknownAncestor
 	}
-	// Nonce is reserved for now, set to empty
+	// Nonce is reserved for the millisecond timestamp, set to empty before the fork
 	header.Nonce = types.BlockNonce{}
 	// Mix digest is reserved for now, set to empty
 	header.MixDigest = common.Hash{}
 	// Set the difficulty to 1
 	header.Difficulty = new(big.Int).SetInt64(1)
-	header.Time = new(big.Int).SetUint64(uint64(time.Now().Unix()))
+	header.SetMilliTime(d.config, uint64(d.clock.Now().UnixNano()/int64(time.Millisecond)))
+	// Embed the star list for the next epoch into checkpoint headers
+	if err := d.prepareCheckpoint(chain, header, parent); err != nil {
+		return err
+	}
 	log.Debug("mine-Prepare: end Prepare")
 	return nil
 }
@@ -257,8 +477,14 @@ func (d *Dpovp) Prepare(chain consensus.ChainReader, header *types.Header) error
 func (d *Dpovp) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction,
 	uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
 	log.Debug("mine-Finalize: start")
-	// No block rewards in PoA, so the state remains as is and uncles are dropped
-	accumulateRewards(state, header)
+	// Apply the system transactions, then pay the block reward and the fees
+	// according to the configured schedule. Uncles are dropped
+	d.applyRegistryCommands(chain, state, header, txs, receipts)
+	d.applyBLSRegistrations(chain, state, header, txs, receipts)
+	d.applyEvidence(chain, state, txs, receipts)
+	d.updateJail(chain, header, state)
+	d.applyUnjails(chain, state, header, txs, receipts)
+	d.accumulateRewards(state, header, txs, receipts)
 	header.Root = state.IntermediateRoot(true)
 	header.UncleHash = types.CalcUncleHash(nil)
 
@@ -266,11 +492,67 @@ func (d *Dpovp) Finalize(chain consensus.ChainReader, header *types.Header, stat
 	return types.NewBlock(header, txs, nil, receipts), nil
 }
 
+// applyRegistryCommands executes the star registry changes requested by the
+// registry owner in the given transactions. Commands take effect from the next
+// block on, as star lists are always read from the parent state.
+func (d *Dpovp) applyRegistryCommands(chain consensus.ChainReader, state *state.StateDB, header *types.Header, txs []*types.Transaction, receipts []*types.Receipt) {
+	owner := commonDpovp.ReadRegistryOwner(state)
+	if owner == (common.Address{}) {
+		return
+	}
+	signer := types.MakeSigner(chain.Config(), header.Number)
+	for i, tx := range txs {
+		if tx.To() == nil || *tx.To() != commonDpovp.RegistryAddress {
+			continue
+		}
+		if i < len(receipts) && receipts[i].Status != types.ReceiptStatusSuccessful {
+			continue
+		}
+		if from, err := types.Sender(signer, tx); err != nil || from != owner {
+			continue
+		}
+		if err := commonDpovp.ApplyRegistryCommand(state, tx.Data()); err != nil {
+			log.Warn("Rejected star registry command", "tx", tx.Hash(), "err", err)
+			continue
+		}
+		owner = commonDpovp.ReadRegistryOwner(state)
+	}
+}
+
+// applyBLSRegistrations registers the BLS keys sent by their owners in the given
+// transactions. Keys are registered for any sender, but only used for stars.
+func (d *Dpovp) applyBLSRegistrations(chain consensus.ChainReader, state *state.StateDB, header *types.Header, txs []*types.Transaction, receipts []*types.Receipt) {
+	signer := types.MakeSigner(chain.Config(), header.Number)
+	for i, tx := range txs {
+		if tx.To() == nil || *tx.To() != commonDpovp.BLSRegistryAddress {
+			continue
+		}
+		if i < len(receipts) && receipts[i].Status != types.ReceiptStatusSuccessful {
+			continue
+		}
+		from, err := types.Sender(signer, tx)
+		if err != nil {
+			continue
+		}
+		if err := commonDpovp.ApplyBLSRegistration(state, from, tx.Data()); err != nil {
+			log.Warn("Rejected BLS key registration", "tx", tx.Hash(), "err", err)
+		}
+	}
+}
+
 // Seal generates a new block for the given input block with the local miner's
 // seal place on top.
 func (d *Dpovp) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	// If we're running a fake DPoVP, return the block unsigned
+	if d.mode == ModeFake || d.mode == ModeFullFake {
+		return block.WithSeal(block.Header()), nil
+	}
 	// 判断本节点是否在主节点列表中
-	coinbaseIndex := commonDpovp.GetCoreNodeIndex(&(d.coinbase))
+	var parent *types.Header
+	if number := block.NumberU64(); number > 0 {
+		parent = chain.GetHeader(block.ParentHash(), number-1)
+	}
+	coinbaseIndex := d.Stars(chain, parent).Index(&(d.coinbase))
 	if coinbaseIndex == -1 {
 		log.Debug(fmt.Sprintf("mine-Seal: coinbaseIndex==-1 coinbase:%s", common.ToHex(d.coinbase[:])))
 		return nil, fmt.Errorf("Coinbase is not in star list.")
@@ -284,9 +566,8 @@ func (d *Dpovp) Seal(chain consensus.ChainReader, block *types.Block, stop <-cha
 		return nil, fmt.Errorf("mine-Seal: unknownblock, number:%d", number)
 	}
 	// 对区块进行签名
-	hash := crypto.Keccak256Hash(d.coinbase[:])
-	privKey := commonDpovp.GetPrivKey()
-	if signInfo, err := crypto.Sign(hash[:], &privKey); err != nil {
+	hash := SealHash(d.config, header, chain.Config().ChainId)
+	if signInfo, err := commonDpovp.SignAs(header.Coinbase, hash[:]); err != nil {
 		log.Warn("mine-Seal: sign failed")
 		return nil, err
 	} else {
@@ -306,21 +587,20 @@ func (d *Dpovp) CalcDifficulty(chain consensus.ChainReader, time uint64, parent
 
 // APIs returns the RPC APIs this consensus engine provides.
 func (d *Dpovp) APIs(chain consensus.ChainReader) []rpc.API {
-	return nil
-}
-
-// AccumulateRewards credits the coinbase of the given block with the mining
-// reward
-func accumulateRewards(state *state.StateDB, header *types.Header) {
-	blockReward := BlockReward
-	reward := new(big.Int).Set(blockReward)
-	state.AddBalance(header.Coinbase, reward)
+	return []rpc.API{{
+		Namespace: "dpovp",
+		Version:   "1.0",
+		Service:   &API{chain: chain, dpovp: d},
+		Public:    true,
+	}}
 }
 
 // NewTester creates a small sized DPoVP scheme useful only for testing purposes.
 func NewTester() *Dpovp {
 	return &Dpovp{
 		config: &params.DpovpConfig{},
+		clock:  mclock.System{},
+		mode:   ModeTest,
 	}
 }
 
@@ -330,6 +610,8 @@ func NewTester() *Dpovp {
 func NewFaker() *Dpovp {
 	return &Dpovp{
 		config: &params.DpovpConfig{},
+		clock:  mclock.System{},
+		mode:   ModeFake,
 	}
 }
 
@@ -338,7 +620,10 @@ func NewFaker() *Dpovp {
 // still have to conform to the LoveBlock consensus rules.
 func NewFakeFailer(fail uint64) *Dpovp {
 	return &Dpovp{
-		config: &params.DpovpConfig{},
+		config:   &params.DpovpConfig{},
+		clock:    mclock.System{},
+		mode:     ModeFake,
+		fakeFail: fail,
 	}
 }
 
@@ -347,7 +632,10 @@ func NewFakeFailer(fail uint64) *Dpovp {
 // they still have to conform to the LoveBlock consensus rules.
 func NewFakeDelayer(delay time.Duration) *Dpovp {
 	return &Dpovp{
-		config: &params.DpovpConfig{},
+		config:    &params.DpovpConfig{},
+		clock:     mclock.System{},
+		mode:      ModeFake,
+		fakeDelay: delay,
 	}
 }
 
@@ -356,6 +644,8 @@ func NewFakeDelayer(delay time.Duration) *Dpovp {
 func NewFullFaker() *Dpovp {
 	return &Dpovp{
 		config: &params.DpovpConfig{},
+		clock:  mclock.System{},
+		mode:   ModeFullFake,
 	}
 }
 
@@ -364,5 +654,7 @@ func NewFullFaker() *Dpovp {
 func NewShared() *Dpovp {
 	return &Dpovp{
 		config: &params.DpovpConfig{},
+		clock:  mclock.System{},
+		mode:   ModeShared,
 	}
 }
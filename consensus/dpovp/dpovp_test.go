@@ -0,0 +1,546 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/common/mclock"
+	"github.com/LoveBlock/loveblock/consensus"
+	"github.com/LoveBlock/loveblock/core/state"
+	"github.com/LoveBlock/loveblock/core/types"
+	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/lovedb"
+	"github.com/LoveBlock/loveblock/params"
+	"github.com/LoveBlock/loveblock/rlp"
+)
+
+var (
+	testStarKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testStarAddr   = crypto.PubkeyToAddress(testStarKey.PublicKey)
+)
+
+// testChainReader is a minimal consensus.ChainReader serving a fixed config.
+type testChainReader struct {
+	config  *params.ChainConfig
+	headers map[common.Hash]*types.Header
+	head    *types.Header
+}
+
+func (r *testChainReader) Config() *params.ChainConfig  { return r.config }
+func (r *testChainReader) CurrentHeader() *types.Header { return r.head }
+func (r *testChainReader) GetHeader(hash common.Hash, number uint64) *types.Header {
+	return r.headers[hash]
+}
+func (r *testChainReader) GetHeaderByNumber(number uint64) *types.Header { return nil }
+func (r *testChainReader) GetHeaderByHash(hash common.Hash) *types.Header {
+	return r.headers[hash]
+}
+func (r *testChainReader) GetBlock(hash common.Hash, number uint64) *types.Block { return nil }
+
+// testStateChain is a chain reader with access to the state database, which
+// lets the engine read the star registry.
+type testStateChain struct {
+	testChainReader
+	db state.Database
+}
+
+func (c *testStateChain) StateAt(root common.Hash) (*state.StateDB, error) {
+	return state.New(root, c.db)
+}
+
+// setupStarList loads a star list containing only the test star from a fresh
+// data directory and installs the test key as the local signing key.
+func setupStarList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dpovp-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pubkey := crypto.FromECDSAPub(&testStarKey.PublicKey)[1:]
+	line := fmt.Sprintf("%s %x\n", testStarAddr.Hex(), pubkey)
+	if err := ioutil.WriteFile(filepath.Join(dir, "starlist"), []byte(line), 0600); err != nil {
+		t.Fatalf("failed to write star list: %v", err)
+	}
+	commonDpovp.SetDataDir(dir)
+	commonDpovp.SetPrivKey(testStarKey)
+	if commonDpovp.GetCoreNodeIndex(&testStarAddr) != 0 {
+		t.Fatalf("test star not loaded from star list")
+	}
+}
+
+func newTestHeader(number int64) *types.Header {
+	return &types.Header{
+		Coinbase:   testStarAddr,
+		Number:     big.NewInt(number),
+		Difficulty: big.NewInt(1),
+		Time:       big.NewInt(1000 + number),
+		GasLimit:   params.GenesisGasLimit,
+	}
+}
+
+func sealTestHeader(t *testing.T, engine *Dpovp, chain *testChainReader, header *types.Header) *types.Header {
+	block, err := engine.Seal(chain, types.NewBlockWithHeader(header), nil)
+	if err != nil {
+		t.Fatalf("failed to seal block: %v", err)
+	}
+	return block.Header()
+}
+
+// Tests that the seal hash commits to the header contents and the chain id once
+// the seal hash fork is active, and to the coinbase only before it.
+func TestSealHash(t *testing.T) {
+	config := &params.DpovpConfig{SealHashBlock: big.NewInt(10)}
+
+	legacy := newTestHeader(9)
+	if have, want := SealHash(config, legacy, big.NewInt(1)), crypto.Keccak256Hash(testStarAddr[:]); have != want {
+		t.Errorf("pre-fork seal hash mismatch: have %x, want %x", have, want)
+	}
+	header := newTestHeader(10)
+	base := SealHash(config, header, big.NewInt(1))
+	if base == crypto.Keccak256Hash(testStarAddr[:]) {
+		t.Fatalf("post-fork seal hash only covers the coinbase")
+	}
+	if SealHash(config, header, big.NewInt(2)) == base {
+		t.Errorf("seal hash doesn't depend on the chain id")
+	}
+	modified := types.CopyHeader(header)
+	modified.Root = common.HexToHash("0x01")
+	if SealHash(config, modified, big.NewInt(1)) == base {
+		t.Errorf("seal hash doesn't depend on the state root")
+	}
+	signed := types.CopyHeader(header)
+	signed.SignInfo = []byte{0x01, 0x02}
+	if SealHash(config, signed, big.NewInt(1)) != base {
+		t.Errorf("seal hash depends on the seal itself")
+	}
+}
+
+// Tests that a block seal can't be replayed onto a different header once the
+// seal hash fork is active.
+func TestSealReplay(t *testing.T) {
+	setupStarList(t)
+
+	tests := []struct {
+		fork   *big.Int
+		replay bool
+	}{
+		{nil, true},            // Legacy seal, signature valid for any header of the star
+		{big.NewInt(0), false}, // Full header seal, signature bound to the header
+	}
+	for i, tt := range tests {
+		config := &params.DpovpConfig{SealHashBlock: tt.fork}
+		engine := New(config, nil, testStarAddr)
+		chain := &testChainReader{config: &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: config}}
+
+		sealed := sealTestHeader(t, engine, chain, newTestHeader(1))
+		if err := engine.VerifySeal(chain, sealed); err != nil {
+			t.Errorf("test %d: failed to verify sealed header: %v", i, err)
+		}
+		forged := newTestHeader(2)
+		forged.Root = common.HexToHash("0xdeadbeef")
+		forged.SignInfo = sealed.SignInfo
+
+		err := engine.VerifySeal(chain, forged)
+		if tt.replay && err != nil {
+			t.Errorf("test %d: legacy seal rejected on another header: %v", i, err)
+		}
+		if !tt.replay && err == nil {
+			t.Errorf("test %d: replayed seal accepted on a forged header", i)
+		}
+	}
+	// Seals must also be rejected on another chain
+	config := &params.DpovpConfig{SealHashBlock: big.NewInt(0)}
+	engine := New(config, nil, testStarAddr)
+	chain := &testChainReader{config: &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: config}}
+	other := &testChainReader{config: &params.ChainConfig{ChainId: big.NewInt(2), Dpovp: config}}
+
+	sealed := sealTestHeader(t, engine, chain, newTestHeader(1))
+	if err := engine.VerifySeal(other, sealed); err == nil {
+		t.Errorf("seal accepted on a chain with a different chain id")
+	}
+}
+
+// Tests that the star list is read from the registry in the parent state, and
+// that registry commands are only executed for the registry owner.
+func TestRegistryStars(t *testing.T) {
+	var (
+		db, _    = lovedb.NewMemDatabase()
+		config   = &params.DpovpConfig{SealHashBlock: big.NewInt(0)}
+		chain    = &testStateChain{testChainReader{config: &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: config}}, state.NewDatabase(db)}
+		engine   = New(config, nil, testStarAddr)
+		signer   = types.MakeSigner(chain.config, common.Big1)
+		other, _ = crypto.GenerateKey()
+		star     = commonDpovp.AddrNodeIDMapping{Addr: common.HexToAddress("0x0102"), Pubkey: make([]byte, 64)}
+		genesis  = commonDpovp.StarList{{Addr: testStarAddr, Pubkey: crypto.FromECDSAPub(&testStarKey.PublicKey)[1:]}}
+	)
+	commit := func(statedb *state.StateDB) *types.Header {
+		root, err := statedb.Commit(true)
+		if err != nil {
+			t.Fatalf("failed to commit state: %v", err)
+		}
+		if err := statedb.Database().TrieDB().Commit(root, false); err != nil {
+			t.Fatalf("failed to commit trie: %v", err)
+		}
+		return &types.Header{Number: big.NewInt(0), Root: root}
+	}
+	statedb, _ := state.New(common.Hash{}, chain.db)
+	statedb.AddBalance(crypto.PubkeyToAddress(other.PublicKey), big.NewInt(params.Love))
+	commonDpovp.WriteRegistryOwner(statedb, testStarAddr)
+	commonDpovp.WriteStarList(statedb, genesis)
+	parent := commit(statedb)
+
+	if stars := engine.Stars(chain, parent); len(stars) != 1 || stars[0].Addr != testStarAddr {
+		t.Fatalf("registry star list mismatch: have %v", stars)
+	}
+	// Readers without state must not fall back to the genesis stars
+	defer commonDpovp.SetStarList(commonDpovp.GetAllSortedCoreNodes())
+	if err := commonDpovp.CheckStarList(genesis); err != nil {
+		t.Fatalf("failed to adopt genesis stars: %v", err)
+	}
+	stateless := New(config, nil, testStarAddr)
+	if stars, ok := stateless.registryStars(&chain.testChainReader, parent); ok || stars != nil {
+		t.Errorf("star list without state: have %v, want none", stars)
+	}
+	child := newTestHeader(1)
+	child.ParentHash, child.Time = parent.Hash(), big.NewInt(0)
+	headerOnly := &testChainReader{config: chain.config, headers: map[common.Hash]*types.Header{parent.Hash(): parent}}
+	if err := stateless.verifyHeader(headerOnly, child, nil); err != consensus.ErrPrunedAncestor {
+		t.Errorf("header without parent state error mismatch: have %v, want %v", err, consensus.ErrPrunedAncestor)
+	}
+	// Add a star both from the owner and from an unauthorized account
+	data, _ := rlp.EncodeToBytes(commonDpovp.RegistryCommand{Op: commonDpovp.RegistryOpAdd, Addr: star.Addr, Pubkey: star.Pubkey})
+	authorized, _ := types.SignTx(types.NewTransaction(0, commonDpovp.RegistryAddress, new(big.Int), 100000, new(big.Int), data), signer, testStarKey)
+	unauthorized, _ := types.SignTx(types.NewTransaction(0, commonDpovp.RegistryAddress, new(big.Int), 100000, new(big.Int), data), signer, other)
+
+	for i, tt := range []struct {
+		tx    *types.Transaction
+		stars int
+	}{{unauthorized, 1}, {authorized, 2}} {
+		statedb, _ := state.New(parent.Root, chain.db)
+		header := &types.Header{Number: big.NewInt(1), ParentHash: parent.Hash(), Coinbase: testStarAddr, Difficulty: big.NewInt(1)}
+		receipts := []*types.Receipt{types.NewReceipt(nil, false, 0)}
+		if _, err := engine.Finalize(chain, header, statedb, []*types.Transaction{tt.tx}, nil, receipts); err != nil {
+			t.Fatalf("test %d: failed to finalize block: %v", i, err)
+		}
+		if stars := engine.Stars(chain, commit(statedb)); len(stars) != tt.stars {
+			t.Errorf("test %d: star count mismatch: have %d, want %d", i, len(stars), tt.stars)
+		}
+	}
+}
+
+// Tests that checkpoint headers carry the star list of their epoch and that the
+// schedule of later blocks uses the checkpointed list rather than the registry.
+func TestEpochCheckpoint(t *testing.T) {
+	var (
+		config = &params.DpovpConfig{Epoch: 4, SealHashBlock: big.NewInt(0)}
+		chain  = &testChainReader{config: &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: config}, headers: make(map[common.Hash]*types.Header)}
+		engine = New(config, nil, testStarAddr)
+		stars  = commonDpovp.StarList{
+			{Addr: common.HexToAddress("0x01"), Pubkey: bytes.Repeat([]byte{0x01}, 64)},
+			{Addr: common.HexToAddress("0x02"), Pubkey: bytes.Repeat([]byte{0x02}, 64)},
+		}
+	)
+	extra := encodeCheckpointExtra([]byte("vanity"), stars)
+	if len(extra) != extraVanity+2*starEntryLength {
+		t.Fatalf("checkpoint extra length mismatch: have %d, want %d", len(extra), extraVanity+2*starEntryLength)
+	}
+	decoded, err := decodeCheckpointStars(extra)
+	if err != nil {
+		t.Fatalf("failed to decode checkpoint stars: %v", err)
+	}
+	if !equalStars(decoded, stars) {
+		t.Fatalf("checkpoint star list mismatch: have %v, want %v", decoded, stars)
+	}
+	for _, bad := range [][]byte{nil, make([]byte, extraVanity), make([]byte, extraVanity+starEntryLength-1)} {
+		if _, err := decodeCheckpointStars(bad); err != errInvalidCheckpointStars {
+			t.Errorf("invalid checkpoint extra (%d bytes) error mismatch: have %v, want %v", len(bad), err, errInvalidCheckpointStars)
+		}
+	}
+	// Build a chain with a checkpoint at block 4 and resolve the stars of block 6
+	var (
+		headers []*types.Header
+		parent  common.Hash
+	)
+	for i := int64(0); i <= 5; i++ {
+		header := newTestHeader(i)
+		header.ParentHash = parent
+		if i == 4 {
+			header.Extra = extra
+		}
+		headers = append(headers, header)
+		parent = header.Hash()
+	}
+	for _, header := range headers[:4] {
+		chain.headers[header.Hash()] = header
+	}
+	// Block 4 and 5 are only passed in as a batch
+	if have := engine.stars(chain, headers[5], headers[4:]); !equalStars(have, stars) {
+		t.Errorf("epoch star list mismatch: have %v, want %v", have, stars)
+	}
+	if err := engine.verifyCheckpoint(chain, headers[4], headers[3]); err != nil {
+		t.Errorf("failed to verify checkpoint: %v", err)
+	}
+	headers[5].Extra = extra
+	if err := engine.verifyCheckpoint(chain, headers[5], headers[4]); err != errExtraStars {
+		t.Errorf("star list outside checkpoint error mismatch: have %v, want %v", err, errExtraStars)
+	}
+}
+
+// Tests that reloaded star lists are scheduled from the requested or the next
+// epoch checkpoint on, and that chains without epochs can't reload them.
+func TestStarListActivation(t *testing.T) {
+	tests := []struct {
+		epoch, head, from, want uint64
+		err                     error
+	}{
+		{0, 7, 0, 0, errNoEpochs},
+		{0, 7, 8, 0, errNoEpochs},
+		{4, 0, 0, 4, nil},
+		{4, 2, 0, 4, nil},
+		{4, 3, 0, 4, nil},
+		{4, 4, 0, 8, nil},
+		{4, 4, 12, 12, nil},
+		{4, 4, 10, 0, errInvalidActivation},
+		{4, 4, 4, 0, errInvalidActivation},
+	}
+	for i, tt := range tests {
+		engine := New(&params.DpovpConfig{Epoch: tt.epoch}, nil, testStarAddr)
+		have, err := engine.StarListActivation(newTestHeader(int64(tt.head)), tt.from)
+		if have != tt.want || err != tt.err {
+			t.Errorf("test %d: activation block mismatch: have %d (%v), want %d (%v)", i, have, err, tt.want, tt.err)
+		}
+	}
+}
+
+// Tests that the fake engines accept unsigned blocks without a star list, apart
+// from the block they were told to fail on.
+func TestFakers(t *testing.T) {
+	var (
+		config  = &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: &params.DpovpConfig{}}
+		chain   = &testChainReader{config: config, headers: make(map[common.Hash]*types.Header)}
+		genesis = newTestHeader(0)
+	)
+	chain.headers[genesis.Hash()] = genesis
+
+	header := newTestHeader(1)
+	header.Coinbase = common.HexToAddress("0xdeadbeef")
+	header.ParentHash = genesis.Hash()
+
+	tests := []struct {
+		engine *Dpovp
+		fail   bool
+	}{
+		{NewFaker(), false},
+		{NewFakeFailer(1), true},
+		{NewFakeFailer(2), false},
+		{NewFakeDelayer(time.Millisecond), false},
+		{NewFullFaker(), false},
+	}
+	for i, tt := range tests {
+		sealed, err := tt.engine.Seal(chain, types.NewBlockWithHeader(header), nil)
+		if err != nil {
+			t.Fatalf("test %d: failed to seal block: %v", i, err)
+		}
+		if err := tt.engine.VerifyHeader(chain, sealed.Header(), true); (err != nil) != tt.fail {
+			t.Errorf("test %d: verification failure mismatch: have %v, want failure %v", i, err, tt.fail)
+		}
+	}
+	// Full fakers don't even need a known parent
+	orphan := newTestHeader(5)
+	if err := NewFullFaker().VerifyHeader(chain, orphan, true); err != nil {
+		t.Errorf("full faker rejected orphan header: %v", err)
+	}
+	if err := NewFaker().VerifyHeader(chain, orphan, true); err != consensus.ErrUnknownAncestor {
+		t.Errorf("faker orphan error mismatch: have %v, want %v", err, consensus.ErrUnknownAncestor)
+	}
+}
+
+// Tests the slot rotation of multiple stars on a simulated clock, covering the
+// regular turn, missed slots timing out to the next star and multiple rounds.
+func TestSlotRotation(t *testing.T) {
+	setupStarList(t)
+
+	var (
+		db, _  = lovedb.NewMemDatabase()
+		config = &params.DpovpConfig{Timeout: 2000, Sleeptime: 1000, SealHashBlock: big.NewInt(0)}
+		chain  = &testStateChain{testChainReader{config: &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: config}, headers: make(map[common.Hash]*types.Header)}, state.NewDatabase(db)}
+		start  = time.Unix(1000000, 0)
+		clock  = mclock.NewSimulated(start)
+		engine = New(config, nil, common.Address{})
+		keys   = make([]*ecdsa.PrivateKey, 3)
+		stars  = make(commonDpovp.StarList, 3)
+	)
+	engine.SetClock(clock)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		stars[i] = commonDpovp.AddrNodeIDMapping{Addr: crypto.PubkeyToAddress(keys[i].PublicKey), Pubkey: crypto.FromECDSAPub(&keys[i].PublicKey)[1:]}
+	}
+	defer commonDpovp.SetPrivKey(testStarKey)
+
+	statedb, _ := state.New(common.Hash{}, chain.db)
+	commonDpovp.WriteStarList(statedb, stars)
+	root, _ := statedb.Commit(true)
+	statedb.Database().TrieDB().Commit(root, false)
+
+	genesis := &types.Header{Number: big.NewInt(0), Root: root, Difficulty: big.NewInt(1), Time: big.NewInt(start.Unix())}
+	chain.headers[genesis.Hash()] = genesis
+
+	// produce creates a block of the given star on top of parent at the current
+	// simulated time.
+	produce := func(parent *types.Header, star int) *types.Header {
+		header := &types.Header{ParentHash: parent.Hash(), Number: new(big.Int).Add(parent.Number, common.Big1), Root: root}
+		engine.SetCoinbase(stars[star].Addr)
+		header.Coinbase = stars[star].Addr
+		if err := engine.Prepare(chain, header); err != nil {
+			t.Fatalf("failed to prepare header: %v", err)
+		}
+		commonDpovp.SetPrivKey(keys[star])
+		block, err := engine.Seal(chain, types.NewBlockWithHeader(header), nil)
+		if err != nil {
+			t.Fatalf("failed to seal header: %v", err)
+		}
+		return block.Header()
+	}
+	clock.Run(time.Second)
+	first := produce(genesis, 0)
+	if err := engine.VerifyHeader(chain, first, true); err != nil {
+		t.Fatalf("failed to verify first block: %v", err)
+	}
+	chain.headers[first.Hash()] = first
+
+	tests := []struct {
+		elapsed time.Duration // Time since the first block
+		star    int
+		valid   bool
+	}{
+		{1 * time.Second, 1, true},   // Next star's turn right after the block interval
+		{1 * time.Second, 2, false},  // Star after the next one has to wait for a timeout
+		{3 * time.Second, 2, true},   // Next star missed its slot, star after it takes over
+		{3 * time.Second, 1, false},  // Missed slot can't be taken late
+		{5 * time.Second, 0, true},   // Nobody produced a block, the last author is up again
+		{7 * time.Second, 1, true},   // Second round, next star's turn again
+		{9 * time.Second, 1, false},  // Second round, next star missed its slot again
+		{13 * time.Second, 1, true},  // Third round
+		{13 * time.Second, 0, false}, // Third round, not the last author's turn
+	}
+	for i, tt := range tests {
+		clock.Run(time.Unix(first.Time.Int64(), 0).Add(tt.elapsed).Sub(clock.Now()))
+		header := produce(first, tt.star)
+
+		if err := engine.VerifyHeader(chain, header, true); (err == nil) != tt.valid {
+			t.Errorf("test %d: validity mismatch: have %v, want valid %v", i, err, tt.valid)
+		}
+	}
+	// Blocks from the future must be rejected until the clock catches up
+	future := produce(first, 1)
+	future.Time = new(big.Int).Add(future.Time, big.NewInt(6))
+	if err := engine.VerifyHeader(chain, future, true); err != consensus.ErrFutureBlock {
+		t.Errorf("future block error mismatch: have %v, want %v", err, consensus.ErrFutureBlock)
+	}
+}
+
+// Tests that from the millisecond timestamp fork on, slots are scheduled with a
+// millisecond precision, allowing block intervals below one second.
+func TestMilliTimeSlots(t *testing.T) {
+	setupStarList(t)
+
+	var (
+		db, _  = lovedb.NewMemDatabase()
+		config = &params.DpovpConfig{Timeout: 600, Sleeptime: 200, SealHashBlock: big.NewInt(0), MilliTimeBlock: big.NewInt(0)}
+		chain  = &testStateChain{testChainReader{config: &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: config}, headers: make(map[common.Hash]*types.Header)}, state.NewDatabase(db)}
+		start  = time.Unix(1000000, 0)
+		clock  = mclock.NewSimulated(start)
+		engine = New(config, nil, common.Address{})
+		keys   = make([]*ecdsa.PrivateKey, 3)
+		stars  = make(commonDpovp.StarList, 3)
+	)
+	engine.SetClock(clock)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		stars[i] = commonDpovp.AddrNodeIDMapping{Addr: crypto.PubkeyToAddress(keys[i].PublicKey), Pubkey: crypto.FromECDSAPub(&keys[i].PublicKey)[1:]}
+	}
+	defer commonDpovp.SetPrivKey(testStarKey)
+
+	statedb, _ := state.New(common.Hash{}, chain.db)
+	commonDpovp.WriteStarList(statedb, stars)
+	root, _ := statedb.Commit(true)
+	statedb.Database().TrieDB().Commit(root, false)
+
+	genesis := &types.Header{Number: big.NewInt(0), Root: root, Difficulty: big.NewInt(1), Time: big.NewInt(start.Unix())}
+	chain.headers[genesis.Hash()] = genesis
+
+	produce := func(parent *types.Header, star int) *types.Header {
+		header := &types.Header{ParentHash: parent.Hash(), Number: new(big.Int).Add(parent.Number, common.Big1), Root: root, Coinbase: stars[star].Addr}
+		if err := engine.Prepare(chain, header); err != nil {
+			t.Fatalf("failed to prepare header: %v", err)
+		}
+		engine.SetCoinbase(stars[star].Addr)
+		commonDpovp.SetPrivKey(keys[star])
+		block, err := engine.Seal(chain, types.NewBlockWithHeader(header), nil)
+		if err != nil {
+			t.Fatalf("failed to seal header: %v", err)
+		}
+		return block.Header()
+	}
+	clock.Run(1250 * time.Millisecond)
+	first := produce(genesis, 0)
+	if have, want := first.MilliTime(config), uint64(start.Unix()*1000+1250); have != want {
+		t.Fatalf("millisecond timestamp mismatch: have %d, want %d", have, want)
+	}
+	if have, want := first.Time.Int64(), start.Unix()+1; have != want {
+		t.Fatalf("timestamp mismatch: have %d, want %d", have, want)
+	}
+	chain.headers[first.Hash()] = first
+
+	tests := []struct {
+		elapsed time.Duration // Time since the first block
+		star    int
+		valid   bool
+	}{
+		{100 * time.Millisecond, 1, false}, // Within the block interval
+		{200 * time.Millisecond, 1, true},  // Next star's turn right after the block interval
+		{300 * time.Millisecond, 2, false}, // Star after the next one has to wait for a timeout
+		{700 * time.Millisecond, 2, true},  // Next star missed its slot, star after it takes over
+		{700 * time.Millisecond, 1, false}, // Missed slot can't be taken late
+	}
+	for i, tt := range tests {
+		clock.Run(time.Unix(0, int64(first.MilliTime(config))*int64(time.Millisecond)).Add(tt.elapsed).Sub(clock.Now()))
+		header := produce(first, tt.star)
+
+		if err := engine.VerifyHeader(chain, header, true); (err == nil) != tt.valid {
+			t.Errorf("test %d: validity mismatch: have %v, want valid %v", i, err, tt.valid)
+		}
+	}
+	// The millisecond timestamp must agree with the timestamp in seconds
+	header := produce(first, 2)
+	header.Nonce = types.EncodeNonce(header.Nonce.Uint64() - 1000)
+	if err := engine.VerifyHeader(chain, header, true); err != errInvalidMilliTime {
+		t.Errorf("inconsistent timestamp error mismatch: have %v, want %v", err, errInvalidMilliTime)
+	}
+}
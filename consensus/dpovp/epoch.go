@@ -0,0 +1,197 @@
+package dpovp
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/consensus"
+	"github.com/LoveBlock/loveblock/core/types"
+	"github.com/LoveBlock/loveblock/log"
+)
+
+const (
+	extraVanity     = 32                        // Fixed number of extra-data prefix bytes reserved for star vanity
+	starEntryLength = common.AddressLength + 64 // Length of a star list entry in a checkpoint: address and node pubkey
+)
+
+var (
+	// errExtraStars is returned if non-checkpoint block contains star list data
+	// in their extra-data field.
+	errExtraStars = errors.New("non-checkpoint block contains extra star list")
+
+	// errInvalidCheckpointStars is returned if a checkpoint block contains an
+	// invalid or empty star list.
+	errInvalidCheckpointStars = errors.New("invalid star list on checkpoint block")
+
+	// errMismatchingCheckpointStars is returned if a checkpoint block contains a
+	// star list different from the one in the star registry.
+	errMismatchingCheckpointStars = errors.New("mismatching star list on checkpoint block")
+)
+
+// stars returns the star nodes allowed to produce the child of the given parent.
+//
+// With epochs disabled, or if the checkpoint of the parent's epoch carries no
+// star list (e.g. the genesis block), this is the star registry in the parent
+// state. Otherwise it is the list embedded in the epoch's checkpoint header, so
+// the schedule can be derived from headers alone. The caller may pass in a batch
+// of headers (ascending order) not yet known to the chain.
+func (d *Dpovp) stars(chain consensus.ChainReader, parent *types.Header, parents []*types.Header) commonDpovp.StarList {
+	if parent == nil || d.config.Epoch == 0 {
+		stars, _ := d.registryStars(chain, parent)
+		return stars
+	}
+	checkpoint := parent.Number.Uint64() / d.config.Epoch * d.config.Epoch
+
+	// Walk back to the checkpoint, stopping early at any header already resolved
+	var (
+		walked []common.Hash
+		header = parent
+		stars  commonDpovp.StarList
+	)
+	for {
+		if d.checkpoints != nil {
+			if cached, ok := d.checkpoints.Get(header.Hash()); ok {
+				stars = cached.(commonDpovp.StarList)
+				break
+			}
+		}
+		walked = append(walked, header.Hash())
+		if header.Number.Uint64() == checkpoint {
+			var err error
+			if stars, err = decodeCheckpointStars(header.Extra); err != nil {
+				log.Debug("Checkpoint without star list, using star registry", "number", checkpoint, "err", err)
+				stars = nil
+			} else if checkpoint > 0 {
+				stars = d.withNodeKeys(chain, getHeader(chain, parents, header.ParentHash, checkpoint-1), stars)
+			}
+			break
+		}
+		number := header.Number.Uint64() - 1
+		if header = getHeader(chain, parents, header.ParentHash, number); header == nil {
+			log.Debug("Missing ancestor to resolve star list", "number", number)
+			stars = nil
+			break
+		}
+	}
+	if stars == nil {
+		stars, _ = d.registryStars(chain, parent)
+		return stars
+	}
+	if d.checkpoints != nil {
+		for _, hash := range walked {
+			d.checkpoints.Add(hash, stars)
+		}
+	}
+	return stars
+}
+
+// withNodeKeys copies the node keys of stars signing with a key of their own, and
+// the confirmation weights, into a star list decoded from a checkpoint, which only
+// carries the signing keys. They are taken from the registry the checkpoint was
+// built from.
+func (d *Dpovp) withNodeKeys(chain consensus.ChainReader, parent *types.Header, stars commonDpovp.StarList) commonDpovp.StarList {
+	registered, _ := d.registryStars(chain, parent)
+	for i := range stars {
+		if j := registered.Index(&stars[i].Addr); j >= 0 && bytes.Equal(registered[j].Pubkey, stars[i].Pubkey) {
+			stars[i].NodeID = registered[j].NodeID
+			stars[i].Weight = registered[j].Weight
+		}
+	}
+	return stars
+}
+
+// getHeader retrieves a header from the given batch of headers or, failing that,
+// from the chain.
+func getHeader(chain consensus.ChainReader, parents []*types.Header, hash common.Hash, number uint64) *types.Header {
+	for i := len(parents) - 1; i >= 0; i-- {
+		if parents[i].Hash() == hash {
+			return parents[i]
+		}
+	}
+	return chain.GetHeader(hash, number)
+}
+
+// prepareCheckpoint embeds the star list of the next epoch, as found in the star
+// registry of the parent state, into the extra-data of checkpoint headers.
+func (d *Dpovp) prepareCheckpoint(chain consensus.ChainReader, header, parent *types.Header) error {
+	if !d.config.IsCheckpoint(header.Number.Uint64()) {
+		if len(header.Extra) > extraVanity {
+			header.Extra = header.Extra[:extraVanity]
+		}
+		return nil
+	}
+	stars, _ := d.registryStars(chain, parent)
+	if len(stars) == 0 {
+		return errInvalidCheckpointStars
+	}
+	header.Extra = encodeCheckpointExtra(header.Extra, stars)
+	return nil
+}
+
+// verifyCheckpoint checks the star list data in the header's extra-data. Only
+// checkpoint headers may carry a star list, which has to match the registry in
+// the parent state if that state is available.
+func (d *Dpovp) verifyCheckpoint(chain consensus.ChainReader, header, parent *types.Header) error {
+	if d.config.Epoch == 0 {
+		return nil
+	}
+	if !d.config.IsCheckpoint(header.Number.Uint64()) {
+		if len(header.Extra) > extraVanity {
+			return errExtraStars
+		}
+		return nil
+	}
+	stars, err := decodeCheckpointStars(header.Extra)
+	if err != nil {
+		return err
+	}
+	if want, ok := d.registryStars(chain, parent); ok && !equalStars(stars, want) {
+		return errMismatchingCheckpointStars
+	}
+	return nil
+}
+
+// encodeCheckpointExtra appends the star list to the vanity part of extra.
+func encodeCheckpointExtra(extra []byte, stars commonDpovp.StarList) []byte {
+	if len(extra) < extraVanity {
+		extra = append(extra, bytes.Repeat([]byte{0x00}, extraVanity-len(extra))...)
+	}
+	res := make([]byte, extraVanity, extraVanity+len(stars)*starEntryLength)
+	copy(res, extra[:extraVanity])
+	for _, star := range stars {
+		res = append(res, star.Addr[:]...)
+		res = append(res, star.Pubkey...)
+	}
+	return res
+}
+
+// decodeCheckpointStars extracts the star list from a checkpoint's extra-data.
+func decodeCheckpointStars(extra []byte) (commonDpovp.StarList, error) {
+	if len(extra) <= extraVanity || (len(extra)-extraVanity)%starEntryLength != 0 {
+		return nil, errInvalidCheckpointStars
+	}
+	data := extra[extraVanity:]
+	stars := make(commonDpovp.StarList, 0, len(data)/starEntryLength)
+	for i := 0; i < len(data); i += starEntryLength {
+		stars = append(stars, commonDpovp.AddrNodeIDMapping{
+			Addr:   common.BytesToAddress(data[i : i+common.AddressLength]),
+			Pubkey: common.CopyBytes(data[i+common.AddressLength : i+starEntryLength]),
+		})
+	}
+	return stars, nil
+}
+
+// equalStars reports whether two star lists contain the same stars in the same order.
+func equalStars(a, b commonDpovp.StarList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Addr != b[i].Addr || !bytes.Equal(a[i].Pubkey, b[i].Pubkey) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,179 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+
+	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/consensus"
+	"github.com/LoveBlock/loveblock/core/state"
+	"github.com/LoveBlock/loveblock/core/types"
+	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/log"
+	"github.com/LoveBlock/loveblock/params"
+	"github.com/LoveBlock/loveblock/rlp"
+)
+
+var (
+	// errIncompleteEvidence is returned if evidence is missing one of its headers.
+	errIncompleteEvidence = errors.New("incomplete evidence")
+
+	// errIdenticalEvidence is returned if both headers of evidence have the same
+	// seal hash, i.e. are the same block, possibly with a re-encoded seal.
+	errIdenticalEvidence = errors.New("evidence headers are identical")
+
+	// errEvidenceOrder is returned if the headers of evidence are not ordered by
+	// hash, as types.NewEvidence does.
+	errEvidenceOrder = errors.New("evidence headers are not in canonical order")
+
+	// errEvidenceFork is returned for evidence below the seal hash fork, where
+	// seals only cover the coinbase and two blocks can't be told apart by them.
+	errEvidenceFork = errors.New("evidence predates the seal hash fork")
+
+	// errMalleableSeal is returned if a header of evidence carries a seal with a
+	// high s value, which honest stars never produce.
+	errMalleableSeal = errors.New("evidence seal is not in canonical form")
+
+	// errEvidenceSlot is returned if the headers of evidence don't share the same
+	// coinbase and parent, i.e. weren't sealed by the same star in the same slot.
+	errEvidenceSlot = errors.New("evidence headers are not from the same star and slot")
+)
+
+// VerifyEvidence implements consensus.EvidenceVerifier, checking that both
+// headers were validly sealed by the same star on top of the same parent, over
+// different seal hashes. Only evidence from the seal hash fork on is accepted.
+func (d *Dpovp) VerifyEvidence(chain consensus.ChainReader, evidence *types.Evidence) error {
+	first, second := evidence.First, evidence.Second
+	if first == nil || second == nil || first.Number == nil || second.Number == nil {
+		return errIncompleteEvidence
+	}
+	if first.Coinbase != second.Coinbase || first.ParentHash != second.ParentHash || first.Number.Cmp(second.Number) != 0 {
+		return errEvidenceSlot
+	}
+	number := first.Number.Uint64()
+	if number == 0 {
+		return errEvidenceSlot
+	}
+	config := chain.Config()
+	if !config.IsSealHash(first.Number) {
+		return errEvidenceFork
+	}
+	// Headers differing in their seals only are the same block
+	if SealHash(config.Dpovp, first, config.ChainId) == SealHash(config.Dpovp, second, config.ChainId) {
+		return errIdenticalEvidence
+	}
+	if firstHash, secondHash := first.Hash(), second.Hash(); bytes.Compare(firstHash[:], secondHash[:]) > 0 {
+		return errEvidenceOrder
+	}
+	// Fake engines don't seal, so there is no seal encoding to check
+	if d.mode != ModeFake && d.mode != ModeFullFake && (!canonicalSeal(first) || !canonicalSeal(second)) {
+		return errMalleableSeal
+	}
+	parent := chain.GetHeader(first.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	stars := d.Stars(chain, parent)
+	if err := d.verifySeal(chain, first, stars); err != nil {
+		return err
+	}
+	return d.verifySeal(chain, second, stars)
+}
+
+// canonicalSeal reports whether the seal of the header is a signature with a low
+// s value, the only encoding crypto.Sign produces. Otherwise anyone could derive
+// a second valid seal of the same block.
+func canonicalSeal(header *types.Header) bool {
+	sig := header.SignInfo
+	if len(sig) != 65 {
+		return false
+	}
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:64])
+	return crypto.ValidateSignatureValues(sig[64], r, s)
+}
+
+// offenceKey identifies the slot evidence proves an equivocation in. Any number
+// of blocks a star seals in the same slot is punished once.
+func offenceKey(evidence *types.Evidence) common.Hash {
+	offender := evidence.Offender()
+	return crypto.Keccak256Hash(evidence.First.ParentHash[:], offender[:])
+}
+
+// applyEvidence punishes the stars proven to have equivocated by the evidence in
+// the given transactions, according to the configured slashing policy. Every
+// offence is only punished once, whatever evidence proves it.
+func (d *Dpovp) applyEvidence(chain consensus.ChainReader, state *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) {
+	for i, tx := range txs {
+		if tx.To() == nil || *tx.To() != commonDpovp.EvidenceAddress {
+			continue
+		}
+		if i < len(receipts) && receipts[i].Status != types.ReceiptStatusSuccessful {
+			continue
+		}
+		evidence := new(types.Evidence)
+		if err := rlp.DecodeBytes(tx.Data(), evidence); err != nil {
+			log.Debug("Rejected undecodable evidence", "tx", tx.Hash(), "err", err)
+			continue
+		}
+		if err := d.VerifyEvidence(chain, evidence); err != nil {
+			log.Debug("Rejected invalid evidence", "tx", tx.Hash(), "err", err)
+			continue
+		}
+		key := offenceKey(evidence)
+		if commonDpovp.HasEvidence(state, key) {
+			continue
+		}
+		commonDpovp.WriteEvidence(state, key)
+		d.slash(state, evidence.Offender())
+
+		log.Info("Punished equivocating star", "star", evidence.Offender(), "number", evidence.First.Number, "policy", d.config.SlashPolicy)
+	}
+}
+
+// slash punishes the given star according to the configured slashing policy.
+func (d *Dpovp) slash(state *state.StateDB, offender common.Address) {
+	switch d.config.SlashPolicy {
+	case params.SlashPolicyPenalty:
+		slashPenalty(state, offender, d.config.SlashPenalty)
+
+	case params.SlashPolicyRemove:
+		slashPenalty(state, offender, d.config.SlashPenalty)
+
+		// Only chains with a seeded registry can drop stars on chain
+		stars := commonDpovp.ReadStarList(state)
+		if index := stars.Index(&offender); index >= 0 {
+			commonDpovp.WriteStarList(state, append(stars[:index:index], stars[index+1:]...))
+		}
+	}
+}
+
+// slashPenalty burns the given penalty from the offender's balance, or all of it
+// if the balance doesn't cover the penalty.
+func slashPenalty(state *state.StateDB, offender common.Address, penalty *big.Int) {
+	if penalty == nil || penalty.Sign() <= 0 {
+		return
+	}
+	amount := new(big.Int).Set(penalty)
+	if balance := state.GetBalance(offender); balance.Cmp(amount) < 0 {
+		amount.Set(balance)
+	}
+	state.SubBalance(offender, amount)
+}
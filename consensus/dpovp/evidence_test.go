@@ -0,0 +1,173 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/core/state"
+	"github.com/LoveBlock/loveblock/core/types"
+	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/lovedb"
+	"github.com/LoveBlock/loveblock/params"
+	"github.com/LoveBlock/loveblock/rlp"
+)
+
+// Tests that evidence is only accepted for two different headers validly sealed
+// by the same star on top of the same parent, in canonical order and form.
+func TestVerifyEvidence(t *testing.T) {
+	setupStarList(t)
+
+	config := &params.DpovpConfig{SealHashBlock: big.NewInt(0)}
+	engine := New(config, nil, testStarAddr)
+	chain := &testChainReader{config: &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: config}, headers: make(map[common.Hash]*types.Header)}
+
+	parent := newTestHeader(0)
+	chain.headers[parent.Hash()] = parent
+
+	seal := func(root common.Hash, number int64) *types.Header {
+		header := newTestHeader(number)
+		header.ParentHash = parent.Hash()
+		header.Root = root
+		return sealTestHeader(t, engine, chain, header)
+	}
+	first, second := seal(common.HexToHash("0x01"), 1), seal(common.HexToHash("0x02"), 1)
+
+	forged := types.CopyHeader(second)
+	forged.Root = common.HexToHash("0x03")
+
+	other := types.CopyHeader(second)
+	other.ParentHash = common.HexToHash("0xdead")
+
+	// Re-encode the seal of the second header with the high s value, which is
+	// just as valid a signature of the same block
+	malleated := types.CopyHeader(second)
+	malleated.SignInfo = common.CopyBytes(second.SignInfo)
+	highS := new(big.Int).Sub(crypto.S256().Params().N, new(big.Int).SetBytes(second.SignInfo[32:64]))
+	copy(malleated.SignInfo[32:64], common.LeftPadBytes(highS.Bytes(), 32))
+	malleated.SignInfo[64] ^= 1
+	if err := engine.verifySeal(chain, malleated, engine.Stars(chain, parent)); err != nil {
+		t.Fatalf("re-encoded seal rejected: %v", err)
+	}
+	canonical := types.NewEvidence(first, second)
+
+	tests := []struct {
+		evidence *types.Evidence
+		err      error
+	}{
+		{types.NewEvidence(first, second), nil},
+		{&types.Evidence{First: first}, errIncompleteEvidence},
+		{types.NewEvidence(first, first), errIdenticalEvidence},
+		{types.NewEvidence(second, malleated), errIdenticalEvidence},
+		{types.NewEvidence(first, malleated), errMalleableSeal},
+		{&types.Evidence{First: canonical.Second, Second: canonical.First}, errEvidenceOrder},
+		{types.NewEvidence(first, seal(common.HexToHash("0x02"), 2)), errEvidenceSlot},
+		{types.NewEvidence(first, other), errEvidenceSlot},
+		{types.NewEvidence(first, forged), errUnauthorizedSigner},
+	}
+	for i, tt := range tests {
+		if err := engine.VerifyEvidence(chain, tt.evidence); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+	// The same pair of headers must always produce the same evidence
+	if types.NewEvidence(first, second).Hash() != types.NewEvidence(second, first).Hash() {
+		t.Errorf("evidence hash depends on the header order")
+	}
+	// Seals before the seal hash fork only cover the coinbase and prove nothing
+	chain.config = &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: &params.DpovpConfig{SealHashBlock: big.NewInt(2)}}
+	if err := engine.VerifyEvidence(chain, canonical); err != errEvidenceFork {
+		t.Errorf("evidence before fork: have %v, want %v", err, errEvidenceFork)
+	}
+}
+
+// Tests that evidence submitted in transactions punishes the offender according
+// to the slashing policy, exactly once per slot.
+func TestApplyEvidence(t *testing.T) {
+	setupStarList(t)
+
+	var (
+		penalty = big.NewInt(params.Love)
+		balance = new(big.Int).Mul(big.NewInt(3), penalty)
+		star    = commonDpovp.AddrNodeIDMapping{Addr: common.HexToAddress("0x0102"), Pubkey: make([]byte, 64)}
+		self    = commonDpovp.AddrNodeIDMapping{Addr: testStarAddr, Pubkey: crypto.FromECDSAPub(&testStarKey.PublicKey)[1:]}
+	)
+	tests := []struct {
+		policy  string
+		balance *big.Int
+		stars   int
+	}{
+		{params.SlashPolicyNone, balance, 2},
+		{params.SlashPolicyPenalty, new(big.Int).Sub(balance, penalty), 2},
+		{params.SlashPolicyRemove, new(big.Int).Sub(balance, penalty), 1},
+	}
+	for i, tt := range tests {
+		var (
+			db, _  = lovedb.NewMemDatabase()
+			config = &params.DpovpConfig{SealHashBlock: big.NewInt(0), SlashPolicy: tt.policy, SlashPenalty: penalty}
+			chain  = &testStateChain{testChainReader{config: &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: config}, headers: make(map[common.Hash]*types.Header)}, state.NewDatabase(db)}
+			engine = New(config, nil, testStarAddr)
+			signer = types.MakeSigner(chain.config, common.Big1)
+		)
+		statedb, _ := state.New(common.Hash{}, chain.db)
+		statedb.AddBalance(testStarAddr, balance)
+		commonDpovp.WriteStarList(statedb, commonDpovp.StarList{self, star})
+		root, _ := statedb.Commit(true)
+		statedb.Database().TrieDB().Commit(root, false)
+
+		parent := &types.Header{Number: big.NewInt(0), Root: root}
+		chain.headers[parent.Hash()] = parent
+
+		var headers []*types.Header
+		for j := 0; j < 3; j++ {
+			header := newTestHeader(1)
+			header.ParentHash = parent.Hash()
+			header.Extra = []byte{byte(j)}
+			headers = append(headers, sealTestHeader(t, engine, &chain.testChainReader, header))
+		}
+		// Submit the same evidence twice and other evidence of the same slot, only
+		// the first must be punished
+		var (
+			txs      []*types.Transaction
+			receipts []*types.Receipt
+		)
+		for nonce, pair := range [][2]int{{0, 1}, {0, 1}, {0, 2}} {
+			data, _ := rlp.EncodeToBytes(types.NewEvidence(headers[pair[0]], headers[pair[1]]))
+			tx, _ := types.SignTx(types.NewTransaction(uint64(nonce), commonDpovp.EvidenceAddress, new(big.Int), 100000, new(big.Int), data), signer, testStarKey)
+			txs = append(txs, tx)
+			receipts = append(receipts, types.NewReceipt(nil, false, 0))
+		}
+
+		statedb, _ = state.New(root, chain.db)
+		header := &types.Header{Number: big.NewInt(1), ParentHash: parent.Hash(), Coinbase: common.HexToAddress("0x0102"), Difficulty: big.NewInt(1)}
+		if _, err := engine.Finalize(chain, header, statedb, txs, nil, receipts); err != nil {
+			t.Fatalf("test %d: failed to finalize block: %v", i, err)
+		}
+		if have := statedb.GetBalance(testStarAddr); have.Cmp(tt.balance) != 0 {
+			t.Errorf("test %d: offender balance mismatch: have %v, want %v", i, have, tt.balance)
+		}
+		if have := len(commonDpovp.ReadStarList(statedb)); have != tt.stars {
+			t.Errorf("test %d: star count mismatch: have %d, want %d", i, have, tt.stars)
+		}
+		if !commonDpovp.HasEvidence(statedb, offenceKey(types.NewEvidence(headers[0], headers[1]))) {
+			t.Errorf("test %d: evidence not marked as processed", i)
+		}
+	}
+}
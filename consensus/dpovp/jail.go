@@ -0,0 +1,88 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/consensus"
+	"github.com/LoveBlock/loveblock/core/state"
+	"github.com/LoveBlock/loveblock/core/types"
+	"github.com/LoveBlock/loveblock/log"
+)
+
+// updateJail counts the slots missed in a row by the stars whose turn passed
+// before the given block, and jails those reaching the configured threshold.
+// The author of the block proved to be online, so its count starts over, even
+// if its own turn passed before during a gap of a full round or more. Jailed
+// stars leave the rotation from the next block on, or from the next checkpoint
+// on chains with epochs. The last active star is never jailed.
+func (d *Dpovp) updateJail(chain consensus.ChainReader, header *types.Header, state *state.StateDB) {
+	if d.config.JailThreshold == 0 || header.Number.Sign() == 0 {
+		return
+	}
+	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	if parent == nil {
+		return
+	}
+	stars := d.stars(chain, parent, nil)
+	record := d.slotLiveness(stars, header, parent)
+
+	for _, addr := range record.missed {
+		if addr == record.author {
+			continue
+		}
+		if _, jailed := commonDpovp.ReadJailed(state, addr); jailed {
+			continue
+		}
+		missed := commonDpovp.ReadMissedSlots(state, addr) + 1
+		commonDpovp.WriteMissedSlots(state, addr, missed)
+		if missed < d.config.JailThreshold || len(commonDpovp.ActiveStars(state, stars)) <= 1 {
+			continue
+		}
+		commonDpovp.WriteJailed(state, addr, header.Number.Uint64())
+		log.Info("Jailed offline star", "star", addr, "number", header.Number, "missed", missed)
+	}
+	if commonDpovp.ReadMissedSlots(state, record.author) != 0 {
+		commonDpovp.WriteMissedSlots(state, record.author, 0)
+	}
+}
+
+// applyUnjails returns the jailed stars sending a transaction to the jail account
+// from their coinbase to the rotation, once their jail period is over.
+func (d *Dpovp) applyUnjails(chain consensus.ChainReader, state *state.StateDB, header *types.Header, txs []*types.Transaction, receipts []*types.Receipt) {
+	if d.config.JailThreshold == 0 {
+		return
+	}
+	signer := types.MakeSigner(chain.Config(), header.Number)
+	for i, tx := range txs {
+		if tx.To() == nil || *tx.To() != commonDpovp.JailAddress {
+			continue
+		}
+		if i < len(receipts) && receipts[i].Status != types.ReceiptStatusSuccessful {
+			continue
+		}
+		from, err := types.Sender(signer, tx)
+		if err != nil {
+			continue
+		}
+		if err := commonDpovp.ApplyUnjail(state, from, header.Number.Uint64(), d.config.JailPeriod); err != nil {
+			log.Warn("Rejected star unjail", "tx", tx.Hash(), "err", err)
+			continue
+		}
+		log.Info("Unjailed star", "star", from, "number", header.Number)
+	}
+}
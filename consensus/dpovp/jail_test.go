@@ -0,0 +1,168 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/core/state"
+	"github.com/LoveBlock/loveblock/core/types"
+	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/lovedb"
+	"github.com/LoveBlock/loveblock/params"
+)
+
+// Tests that stars missing too many slots in a row leave the rotation, and come
+// back once they unjail themselves after the jail period.
+func TestJailOfflineStar(t *testing.T) {
+	var (
+		db, _  = lovedb.NewMemDatabase()
+		config = &params.DpovpConfig{Timeout: 10000, Sleeptime: 3000, JailThreshold: 2, JailPeriod: 3}
+		chain  = &testStateChain{testChainReader{config: &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: config}, headers: make(map[common.Hash]*types.Header)}, state.NewDatabase(db)}
+		engine = New(config, nil, testStarAddr)
+		signer = types.MakeSigner(chain.config, common.Big1)
+		keys   = make([]*ecdsa.PrivateKey, 3)
+		stars  = make(commonDpovp.StarList, len(keys))
+	)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		stars[i] = commonDpovp.AddrNodeIDMapping{Addr: crypto.PubkeyToAddress(keys[i].PublicKey), Pubkey: crypto.FromECDSAPub(&keys[i].PublicKey)[1:]}
+	}
+	commit := func(statedb *state.StateDB, header *types.Header) *types.Header {
+		root, err := statedb.Commit(true)
+		if err != nil {
+			t.Fatalf("failed to commit state: %v", err)
+		}
+		if err := statedb.Database().TrieDB().Commit(root, false); err != nil {
+			t.Fatalf("failed to commit trie: %v", err)
+		}
+		header.Root = root
+		chain.headers[header.Hash()] = header
+		return header
+	}
+	statedb, _ := state.New(common.Hash{}, chain.db)
+	commonDpovp.WriteStarList(statedb, stars)
+	parent := commit(statedb, &types.Header{Number: big.NewInt(0), Time: big.NewInt(0)})
+
+	unjail, _ := types.SignTx(types.NewTransaction(0, commonDpovp.JailAddress, new(big.Int), 100000, new(big.Int), nil), signer, keys[2])
+	blocks := []struct {
+		author int
+		span   int64 // Seconds since the parent
+		unjail bool
+		active int // Number of stars in the rotation after the block
+	}{
+		{0, 1000, false, 3}, // On top of genesis, not accounted
+		{1, 3, false, 3},
+		{0, 13, false, 3}, // Star 2 misses its first slot
+		{1, 3, false, 3},
+		{0, 13, false, 2}, // Star 2 misses its second slot in a row and is jailed
+		{1, 3, true, 2},   // Unjailing before the period is over fails
+		{0, 3, false, 2},
+		{1, 3, true, 3}, // Star 2 returns to the rotation
+	}
+	for i, b := range blocks {
+		statedb, _ := state.New(parent.Root, chain.db)
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Coinbase:   stars[b.author].Addr,
+			Number:     new(big.Int).Add(parent.Number, common.Big1),
+			Time:       new(big.Int).Add(parent.Time, big.NewInt(b.span)),
+			Difficulty: big.NewInt(1),
+		}
+		var (
+			txs      []*types.Transaction
+			receipts []*types.Receipt
+		)
+		if b.unjail {
+			txs, receipts = []*types.Transaction{unjail}, []*types.Receipt{types.NewReceipt(nil, false, 0)}
+		}
+		if _, err := engine.Finalize(chain, header, statedb, txs, nil, receipts); err != nil {
+			t.Fatalf("block %d: failed to finalize: %v", i+1, err)
+		}
+		parent = commit(statedb, header)
+
+		if active := engine.Stars(chain, parent); len(active) != b.active {
+			t.Errorf("block %d: active star count mismatch: have %d, want %d", i+1, len(active), b.active)
+		}
+	}
+	statedb, _ = state.New(parent.Root, chain.db)
+	if _, jailed := commonDpovp.ReadJailed(statedb, stars[2].Addr); jailed {
+		t.Errorf("star still jailed after unjailing")
+	}
+	if missed := commonDpovp.ReadMissedSlots(statedb, stars[2].Addr); missed != 0 {
+		t.Errorf("missed slots not reset on unjail: have %d", missed)
+	}
+}
+
+// Tests that the author of a block following a gap of several rounds isn't jailed
+// for the slots it missed during the gap, as its block proves it is online.
+func TestJailAfterStall(t *testing.T) {
+	var (
+		db, _  = lovedb.NewMemDatabase()
+		config = &params.DpovpConfig{Timeout: 10000, Sleeptime: 3000, JailThreshold: 2, JailPeriod: 3}
+		chain  = &testStateChain{testChainReader{config: &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: config}, headers: make(map[common.Hash]*types.Header)}, state.NewDatabase(db)}
+		engine = New(config, nil, testStarAddr)
+		stars  = make(commonDpovp.StarList, 3)
+	)
+	for i := range stars {
+		key, _ := crypto.GenerateKey()
+		stars[i] = commonDpovp.AddrNodeIDMapping{Addr: crypto.PubkeyToAddress(key.PublicKey), Pubkey: crypto.FromECDSAPub(&key.PublicKey)[1:]}
+	}
+	commit := func(statedb *state.StateDB, header *types.Header) *types.Header {
+		root, _ := statedb.Commit(true)
+		statedb.Database().TrieDB().Commit(root, false)
+		header.Root = root
+		chain.headers[header.Hash()] = header
+		return header
+	}
+	statedb, _ := state.New(common.Hash{}, chain.db)
+	commonDpovp.WriteStarList(statedb, stars)
+	parent := commit(statedb, &types.Header{Number: big.NewInt(0), Time: big.NewInt(0)})
+
+	// Star 0 produces on top of genesis, star 1 two rounds (six slots) later
+	for i, b := range []struct {
+		author int
+		span   int64
+	}{{0, 1000}, {1, 63}} {
+		statedb, _ := state.New(parent.Root, chain.db)
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Coinbase:   stars[b.author].Addr,
+			Number:     new(big.Int).Add(parent.Number, common.Big1),
+			Time:       new(big.Int).Add(parent.Time, big.NewInt(b.span)),
+			Difficulty: big.NewInt(1),
+		}
+		if _, err := engine.Finalize(chain, header, statedb, nil, nil, nil); err != nil {
+			t.Fatalf("block %d: failed to finalize: %v", i+1, err)
+		}
+		parent = commit(statedb, header)
+	}
+	statedb, _ = state.New(parent.Root, chain.db)
+	if _, jailed := commonDpovp.ReadJailed(statedb, stars[1].Addr); jailed {
+		t.Errorf("author jailed by its own block")
+	}
+	if missed := commonDpovp.ReadMissedSlots(statedb, stars[1].Addr); missed != 0 {
+		t.Errorf("author missed slots not reset: have %d", missed)
+	}
+	if active := engine.Stars(chain, parent); active.Index(&stars[1].Addr) < 0 {
+		t.Errorf("author left the rotation: have %v", active)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/common/hexutil"
+	"github.com/LoveBlock/loveblock/consensus"
+	"github.com/LoveBlock/loveblock/core/types"
+	"github.com/LoveBlock/loveblock/metrics"
+)
+
+const (
+	livenessWindow        = 1000  // Default number of blocks liveness statistics are collected over
+	maxLivenessWindow     = 10000 // Maximum number of blocks liveness statistics are collected over
+	livenessMetricsWindow = 256   // Number of blocks the liveness gauges are collected over
+	inmemoryLiveness      = 4096  // Number of liveness records of finalized blocks to keep in memory
+)
+
+// StarLiveness is the production and confirmation record of a star over a window
+// of recent blocks.
+type StarLiveness struct {
+	Address   common.Address `json:"address"`
+	Produced  uint64         `json:"produced"`  // Blocks sealed by the star
+	Missed    uint64         `json:"missed"`    // Slots of the star that timed out without a block
+	Late      uint64         `json:"late"`      // Blocks sealed more than a block interval into the star's window
+	Confirmed uint64         `json:"confirmed"` // Blocks of other stars the star confirmed
+	Expected  uint64         `json:"expected"`  // Blocks of other stars with known confirmations the star could confirm
+}
+
+// Liveness is the liveness record of the stars over the blocks From to To.
+type Liveness struct {
+	From  hexutil.Uint64  `json:"from"`
+	To    hexutil.Uint64  `json:"to"`
+	Stars []*StarLiveness `json:"stars"`
+}
+
+// blockLiveness is the outcome of the production round leading to a block, and
+// the confirmations it got from the other stars.
+type blockLiveness struct {
+	author    common.Address
+	missed    []common.Address // Stars whose slot timed out before the block
+	late      bool             // Whether the block was sealed late in the author's window
+	stars     []common.Address // Stars allowed to confirm the block
+	confirmed []common.Address // Stars that confirmed the block, nil if unknown
+}
+
+// slotLiveness derives the stars that missed their slots before the given block
+// from the time since its parent: every timeout that passed gave the turn to the
+// next star in the production round. Blocks on top of the genesis block aren't
+// accounted, as the genesis time isn't part of the schedule.
+func (d *Dpovp) slotLiveness(stars commonDpovp.StarList, header, parent *types.Header) *blockLiveness {
+	record := &blockLiveness{author: header.Coinbase, stars: make([]common.Address, 0, len(stars))}
+	for _, star := range stars {
+		record.stars = append(record.stars, star.Addr)
+	}
+	if parent.Number.Sign() == 0 || len(stars) == 0 || d.timeoutTime <= 0 {
+		return record
+	}
+	timeSpan := int64(header.MilliTime(d.config)) - int64(parent.MilliTime(d.config))
+
+	var timeouts int64
+	if len(stars) > 1 {
+		timeouts = timeSpan / d.timeoutTime
+		first := stars.Index(&parent.Coinbase) + 1
+		for i := int64(0); i < timeouts; i++ {
+			record.missed = append(record.missed, stars[(first+int(i%int64(len(stars))))%len(stars)].Addr)
+		}
+	}
+	// 在自己的时间窗口内超过一个出块间隔才出块
+	earliest := timeouts * d.timeoutTime
+	if timeouts == 0 {
+		earliest = d.blockInternal
+	}
+	record.late = d.blockInternal > 0 && timeSpan-earliest >= d.blockInternal
+	return record
+}
+
+// blockLiveness returns the liveness record of the given block. Records of
+// finalized blocks are final and cached, those of recent blocks are recomputed
+// as confirmations arrive.
+func (d *Dpovp) blockLiveness(chain consensus.ChainReader, header *types.Header) *blockLiveness {
+	hash := header.Hash()
+	if d.records != nil {
+		if record, ok := d.records.Get(hash); ok {
+			return record.(*blockLiveness)
+		}
+	}
+	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	if parent == nil {
+		return nil
+	}
+	stars := d.stars(chain, parent, nil)
+	record := d.slotLiveness(stars, header, parent)
+
+	reader, ok := chain.(consensusInfoReader)
+	if !ok {
+		return record
+	}
+	if cert := reader.GetFinalityCertificate(hash); cert != nil {
+		confirmed := make(map[common.Address]bool)
+		if cert.Signers != nil {
+			for i, star := range stars {
+				if cert.Signers.Bit(i) == 1 {
+					confirmed[star.Addr] = true
+				}
+			}
+		}
+		if voters, err := cert.Voters(); err == nil {
+			for _, pubkey := range voters {
+				if index := stars.IndexByPubkey(pubkey); index >= 0 {
+					confirmed[stars[index].Addr] = true
+				}
+			}
+		}
+		record.confirmed = make([]common.Address, 0, len(confirmed))
+		for _, star := range stars {
+			if confirmed[star.Addr] {
+				record.confirmed = append(record.confirmed, star.Addr)
+			}
+		}
+		if d.records != nil {
+			d.records.Add(hash, record)
+		}
+		return record
+	}
+	if flag, ok := reader.ConsensusFlag(hash); ok {
+		record.confirmed = confirmedStars(stars, flag)
+	}
+	return record
+}
+
+// confirmedStars returns the stars whose bits are set in a confirmation bitmap.
+func confirmedStars(stars commonDpovp.StarList, flag *big.Int) []common.Address {
+	confirmed := make([]common.Address, 0, len(stars))
+	for i, star := range stars {
+		if flag.Bit(i) == 1 {
+			confirmed = append(confirmed, star.Addr)
+		}
+	}
+	return confirmed
+}
+
+// liveness collects the liveness statistics of the stars over the given number
+// of blocks up to head. Stars of the head's list come first, in slot order,
+// followed by the stars that left the list within the window.
+func (d *Dpovp) liveness(chain consensus.ChainReader, head *types.Header, window uint64) *Liveness {
+	var (
+		stats = make(map[common.Address]*StarLiveness)
+		order []common.Address
+	)
+	get := func(addr common.Address) *StarLiveness {
+		if stat, ok := stats[addr]; ok {
+			return stat
+		}
+		stats[addr] = &StarLiveness{Address: addr}
+		order = append(order, addr)
+		return stats[addr]
+	}
+	for _, star := range d.Stars(chain, head) {
+		get(star.Addr)
+	}
+	result := &Liveness{From: hexutil.Uint64(head.Number.Uint64()), To: hexutil.Uint64(head.Number.Uint64())}
+	for header := head; header != nil && header.Number.Sign() > 0 && window > 0; window-- {
+		record := d.blockLiveness(chain, header)
+		if record == nil {
+			break
+		}
+		result.From = hexutil.Uint64(header.Number.Uint64())
+
+		get(record.author).Produced++
+		if record.late {
+			get(record.author).Late++
+		}
+		for _, addr := range record.missed {
+			get(addr).Missed++
+		}
+		if record.confirmed != nil {
+			confirmed := make(map[common.Address]bool)
+			for _, addr := range record.confirmed {
+				confirmed[addr] = true
+			}
+			for _, addr := range record.stars {
+				if addr == record.author {
+					continue
+				}
+				stat := get(addr)
+				stat.Expected++
+				if confirmed[addr] {
+					stat.Confirmed++
+				}
+			}
+		}
+		header = chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	}
+	result.Stars = make([]*StarLiveness, 0, len(order))
+	for _, addr := range order {
+		result.Stars = append(result.Stars, stats[addr])
+	}
+	return result
+}
+
+// UpdateLivenessMetrics refreshes the per-star liveness gauges from the recent
+// blocks up to the current head of the chain.
+func (d *Dpovp) UpdateLivenessMetrics(chain consensus.ChainReader) {
+	if !metrics.Enabled {
+		return
+	}
+	head := chain.CurrentHeader()
+	if head == nil {
+		return
+	}
+	for _, stat := range d.liveness(chain, head, livenessMetricsWindow).Stars {
+		prefix := fmt.Sprintf("dpovp/stars/%x/", stat.Address)
+		metrics.GetOrRegisterGauge(prefix+"produced", nil).Update(int64(stat.Produced))
+		metrics.GetOrRegisterGauge(prefix+"missed", nil).Update(int64(stat.Missed))
+		metrics.GetOrRegisterGauge(prefix+"late", nil).Update(int64(stat.Late))
+		metrics.GetOrRegisterGauge(prefix+"confirmed", nil).Update(int64(stat.Confirmed))
+		metrics.GetOrRegisterGauge(prefix+"expected", nil).Update(int64(stat.Expected))
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/common/hexutil"
+	"github.com/LoveBlock/loveblock/core/types"
+	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/params"
+)
+
+// livenessChain is a chain reader tracking the confirmations of its blocks.
+type livenessChain struct {
+	testChainReader
+	flags map[common.Hash]*big.Int
+	certs map[common.Hash]*types.FinalityCertificate
+}
+
+func (c *livenessChain) StableBlock() *types.Block { return nil }
+func (c *livenessChain) ConsensusFlag(hash common.Hash) (*big.Int, bool) {
+	flag, ok := c.flags[hash]
+	return flag, ok
+}
+func (c *livenessChain) GetFinalityCertificate(hash common.Hash) *types.FinalityCertificate {
+	return c.certs[hash]
+}
+
+// Tests that produced blocks, missed slots, late blocks and confirmations are
+// accounted to the right stars over the requested window.
+func TestLiveness(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	stars := make(commonDpovp.StarList, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		stars[i] = commonDpovp.AddrNodeIDMapping{Addr: crypto.PubkeyToAddress(keys[i].PublicKey), Pubkey: crypto.FromECDSAPub(&keys[i].PublicKey)[1:]}
+	}
+	engine := New(&params.DpovpConfig{Timeout: 10000, Sleeptime: 3000}, nil, stars[0].Addr)
+	engine.registry.Add(common.Hash{}, stars)
+
+	chain := &livenessChain{
+		testChainReader: testChainReader{headers: make(map[common.Hash]*types.Header)},
+		flags:           make(map[common.Hash]*big.Int),
+		certs:           make(map[common.Hash]*types.FinalityCertificate),
+	}
+	parent := &types.Header{Number: big.NewInt(0), Time: big.NewInt(0)}
+	chain.headers[parent.Hash()] = parent
+
+	blocks := []struct {
+		author int
+		span   int64 // Seconds since the parent
+	}{
+		{0, 1000}, // On top of genesis, not accounted
+		{1, 3},    // In time
+		{0, 15},   // Star 2 missed its slot, star 0 sealed late
+		{1, 3},    // In time
+	}
+	var headers []*types.Header
+	for _, b := range blocks {
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Coinbase:   stars[b.author].Addr,
+			Number:     new(big.Int).Add(parent.Number, common.Big1),
+			Time:       new(big.Int).Add(parent.Time, big.NewInt(b.span)),
+		}
+		chain.headers[header.Hash()] = header
+		headers = append(headers, header)
+		parent = header
+	}
+	chain.head = parent
+
+	// Block 2 is finalized with the vote of star 0, block 4 confirmed by star 2
+	vote, _ := crypto.Sign(headers[1].Hash().Bytes(), keys[0])
+	chain.certs[headers[1].Hash()] = &types.FinalityCertificate{Hash: headers[1].Hash(), Number: 2, Votes: [][]byte{vote}}
+	chain.flags[headers[3].Hash()] = big.NewInt(1 << 2)
+
+	api := engine.APIs(chain)[0].Service.(*API)
+	liveness, err := api.GetLiveness(nil)
+	if err != nil {
+		t.Fatalf("failed to retrieve liveness: %v", err)
+	}
+	if liveness.From != 1 || liveness.To != 4 {
+		t.Errorf("window mismatch: have [%d, %d], want [1, 4]", liveness.From, liveness.To)
+	}
+	want := []StarLiveness{
+		{Address: stars[0].Addr, Produced: 2, Late: 1, Confirmed: 1, Expected: 2},
+		{Address: stars[1].Addr, Produced: 2},
+		{Address: stars[2].Addr, Missed: 1, Confirmed: 1, Expected: 2},
+	}
+	if len(liveness.Stars) != len(want) {
+		t.Fatalf("star count mismatch: have %d, want %d", len(liveness.Stars), len(want))
+	}
+	for i := range want {
+		if *liveness.Stars[i] != want[i] {
+			t.Errorf("star %d: liveness mismatch: have %+v, want %+v", i, *liveness.Stars[i], want[i])
+		}
+	}
+	// Narrower windows only account their own blocks
+	window := hexutil.Uint64(2)
+	if liveness, _ = api.GetLiveness(&window); liveness.From != 3 {
+		t.Errorf("window start mismatch: have %d, want 3", liveness.From)
+	}
+	if stat := liveness.Stars[0]; stat.Produced != 1 || stat.Late != 1 || stat.Confirmed != 0 || stat.Expected != 1 {
+		t.Errorf("narrow window mismatch: have %+v", *stat)
+	}
+	window = 0
+	if _, err := api.GetLiveness(&window); err != errInvalidWindow {
+		t.Errorf("empty window error mismatch: have %v, want %v", err, errInvalidWindow)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"math/big"
+
+	"github.com/LoveBlock/loveblock/core/state"
+	"github.com/LoveBlock/loveblock/core/types"
+	"github.com/LoveBlock/loveblock/params"
+)
+
+var big100 = big.NewInt(100)
+
+// accumulateRewards credits the block reward and, if the chain splits them, the
+// transaction fees of the given block to the coinbase and the treasury. The burnt
+// shares are simply never credited.
+func (d *Dpovp) accumulateRewards(state *state.StateDB, header *types.Header, txs []*types.Transaction, receipts []*types.Receipt) {
+	producer, treasury := splitShares(blockReward(d.config, header.Number.Uint64()), d.config.RewardTreasury, d.config.RewardBurn)
+
+	if d.config.SplitsFees() {
+		fees := new(big.Int)
+		for i, tx := range txs {
+			if i < len(receipts) {
+				fees.Add(fees, new(big.Int).Mul(new(big.Int).SetUint64(receipts[i].GasUsed), tx.GasPrice()))
+			}
+		}
+		feeProducer, feeTreasury := splitShares(fees, d.config.FeeTreasury, d.config.FeeBurn)
+		producer.Add(producer, feeProducer)
+		treasury.Add(treasury, feeTreasury)
+	}
+	state.AddBalance(header.Coinbase, producer)
+	if treasury.Sign() > 0 {
+		state.AddBalance(d.config.Treasury, treasury)
+	}
+}
+
+// splitShares splits amount into the producer and treasury shares, the rest is
+// burnt. Percentages are capped so that the shares never exceed the amount.
+func splitShares(amount *big.Int, treasuryPercent, burnPercent uint64) (*big.Int, *big.Int) {
+	if treasuryPercent > 100 {
+		treasuryPercent = 100
+	}
+	if burnPercent > 100-treasuryPercent {
+		burnPercent = 100 - treasuryPercent
+	}
+	treasury := new(big.Int).Mul(amount, new(big.Int).SetUint64(treasuryPercent))
+	treasury.Div(treasury, big100)
+
+	producer := new(big.Int).Mul(amount, new(big.Int).SetUint64(100-treasuryPercent-burnPercent))
+	producer.Div(producer, big100)
+
+	return producer, treasury
+}
+
+// blockReward returns the reward of the block with the given number, following
+// the reduction schedule of the config and capped by the remaining issuance.
+func blockReward(config *params.DpovpConfig, number uint64) *big.Int {
+	if number == 0 {
+		return new(big.Int)
+	}
+	reward := scheduledReward(config, number)
+	if config.MaxIssuance == nil {
+		return reward
+	}
+	remaining := new(big.Int).Sub(config.MaxIssuance, issuance(config, number-1))
+	if remaining.Sign() <= 0 {
+		return new(big.Int)
+	}
+	if reward.Cmp(remaining) > 0 {
+		return remaining
+	}
+	return reward
+}
+
+// scheduledReward returns the uncapped reward of the block with the given number.
+// Blocks 1 to ReductionInterval earn the initial reward, every following run of
+// ReductionInterval blocks earns ReductionPercent less than the previous one.
+func scheduledReward(config *params.DpovpConfig, number uint64) *big.Int {
+	reward := initialReward(config)
+	if config.ReductionInterval == 0 || config.ReductionPercent == 0 {
+		return reward
+	}
+	for i := (number - 1) / config.ReductionInterval; i > 0 && reward.Sign() > 0; i-- {
+		reduce(reward, config.ReductionPercent)
+	}
+	return reward
+}
+
+// issuance returns the sum of the uncapped rewards of blocks 1 to number, which
+// is the amount issued before block number+1 as long as it's below the cap.
+func issuance(config *params.DpovpConfig, number uint64) *big.Int {
+	reward := initialReward(config)
+	if config.ReductionInterval == 0 || config.ReductionPercent == 0 {
+		return reward.Mul(reward, new(big.Int).SetUint64(number))
+	}
+	total := new(big.Int)
+	for first := uint64(1); first <= number && reward.Sign() > 0; first += config.ReductionInterval {
+		blocks := config.ReductionInterval
+		if number-first+1 < blocks {
+			blocks = number - first + 1
+		}
+		total.Add(total, new(big.Int).Mul(reward, new(big.Int).SetUint64(blocks)))
+		reduce(reward, config.ReductionPercent)
+	}
+	return total
+}
+
+// initialReward returns a copy of the reward of the first blocks.
+func initialReward(config *params.DpovpConfig) *big.Int {
+	if config.BlockReward != nil {
+		return new(big.Int).Set(config.BlockReward)
+	}
+	return new(big.Int).Set(BlockReward)
+}
+
+// reduce cuts the given percentage from the reward in place.
+func reduce(reward *big.Int, percent uint64) {
+	if percent >= 100 {
+		reward.SetUint64(0)
+		return
+	}
+	reward.Mul(reward, new(big.Int).SetUint64(100-percent))
+	reward.Div(reward, big100)
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/core/state"
+	"github.com/LoveBlock/loveblock/core/types"
+	"github.com/LoveBlock/loveblock/lovedb"
+	"github.com/LoveBlock/loveblock/params"
+)
+
+// Tests that block rewards follow the reduction schedule and stop at the cap.
+func TestBlockReward(t *testing.T) {
+	halving := &params.DpovpConfig{BlockReward: big.NewInt(100), ReductionInterval: 10, ReductionPercent: 50}
+	capped := &params.DpovpConfig{BlockReward: big.NewInt(100), ReductionInterval: 10, ReductionPercent: 50, MaxIssuance: big.NewInt(1250)}
+
+	tests := []struct {
+		config *params.DpovpConfig
+		number uint64
+		reward int64
+	}{
+		{&params.DpovpConfig{}, 0, 0},
+		{&params.DpovpConfig{}, 1, 5e18},
+		{&params.DpovpConfig{}, 1000000, 5e18},
+		{halving, 1, 100},
+		{halving, 10, 100},
+		{halving, 11, 50},
+		{halving, 21, 25},
+		{halving, 71, 0},
+		{&params.DpovpConfig{BlockReward: big.NewInt(100), ReductionInterval: 10, ReductionPercent: 10}, 21, 81},
+		{&params.DpovpConfig{BlockReward: big.NewInt(100), MaxIssuance: big.NewInt(250)}, 2, 100},
+		{&params.DpovpConfig{BlockReward: big.NewInt(100), MaxIssuance: big.NewInt(250)}, 3, 50},
+		{&params.DpovpConfig{BlockReward: big.NewInt(100), MaxIssuance: big.NewInt(250)}, 4, 0},
+		{capped, 12, 50}, // 1050 issued before
+		{capped, 15, 50}, // 1200 issued before, last reward up to the cap
+		{capped, 16, 0},  // cap reached
+		{capped, 100, 0},
+	}
+	for i, tt := range tests {
+		if have := blockReward(tt.config, tt.number); have.Cmp(big.NewInt(tt.reward)) != 0 {
+			t.Errorf("test %d: reward mismatch: have %v, want %v", i, have, tt.reward)
+		}
+	}
+	// The sum of all rewards must never exceed the cap
+	total := new(big.Int)
+	for number := uint64(1); number <= 100; number++ {
+		total.Add(total, blockReward(capped, number))
+	}
+	if total.Cmp(capped.MaxIssuance) > 0 {
+		t.Errorf("issuance exceeds cap: have %v, cap %v", total, capped.MaxIssuance)
+	}
+}
+
+// Tests that block rewards and fees are split between the producer, the
+// treasury and the burn as configured.
+func TestRewardDistribution(t *testing.T) {
+	var (
+		coinbase = common.HexToAddress("0x01")
+		treasury = common.HexToAddress("0x02")
+	)
+	tests := []struct {
+		config   *params.DpovpConfig
+		producer int64
+		treasury int64
+	}{
+		// Rewards only, fees were paid to the coinbase by the state transition
+		{&params.DpovpConfig{BlockReward: big.NewInt(1000)}, 1000, 0},
+		{&params.DpovpConfig{BlockReward: big.NewInt(1000), Treasury: treasury, RewardTreasury: 20, RewardBurn: 30}, 500, 200},
+		// Fees of 21000 * 10 split as well
+		{&params.DpovpConfig{BlockReward: big.NewInt(1000), Treasury: treasury, FeeTreasury: 10, FeeBurn: 50}, 1000 + 84000, 21000},
+		{&params.DpovpConfig{BlockReward: big.NewInt(1000), Treasury: treasury, FeeBurn: 100}, 1000, 0},
+		// Oversized percentages never create money
+		{&params.DpovpConfig{BlockReward: big.NewInt(1000), Treasury: treasury, RewardTreasury: 80, RewardBurn: 80}, 0, 800},
+	}
+	tx := types.NewTransaction(0, common.Address{}, new(big.Int), 21000, big.NewInt(10), nil)
+	receipt := types.NewReceipt(nil, false, 21000)
+	receipt.GasUsed = 21000
+
+	for i, tt := range tests {
+		db, _ := lovedb.NewMemDatabase()
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+
+		engine := New(tt.config, nil, coinbase)
+		engine.accumulateRewards(statedb, &types.Header{Number: big.NewInt(1), Coinbase: coinbase}, []*types.Transaction{tx}, []*types.Receipt{receipt})
+
+		if have := statedb.GetBalance(coinbase); have.Cmp(big.NewInt(tt.producer)) != 0 {
+			t.Errorf("test %d: producer balance mismatch: have %v, want %v", i, have, tt.producer)
+		}
+		if have := statedb.GetBalance(treasury); have.Cmp(big.NewInt(tt.treasury)) != 0 {
+			t.Errorf("test %d: treasury balance mismatch: have %v, want %v", i, have, tt.treasury)
+		}
+	}
+}
@@ -35,6 +35,7 @@ import (
 	"github.com/LoveBlock/loveblock/core/types"
 	"github.com/LoveBlock/loveblock/core/vm"
 	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/crypto/bls"
 	"github.com/LoveBlock/loveblock/event"
 	"github.com/LoveBlock/loveblock/lovedb"
 	"github.com/LoveBlock/loveblock/log"
@@ -58,6 +59,9 @@ const (
 	maxFutureBlocks     = 256
 	maxTimeFutureBlocks = 30
 	badBlockLimit       = 10
+	recentSealsLimit    = 1024
+	maxFutureFinality   = 256
+	maxFutureVotes      = 1024
 	triesInMemory       = 128
 
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
@@ -100,9 +104,15 @@ type BlockChain struct {
 	chainSideFeed event.Feed
 	chainHeadFeed event.Feed
 	logsFeed      event.Feed
+	evidenceFeed  event.Feed
+	stableFeed    event.Feed
 	scope         event.SubscriptionScope
 	genesisBlock  *types.Block
 
+	stableQueue   []*types.Block // Stable blocks not announced to subscribers yet
+	stableSending bool           // Whether a sender is delivering the queued announcements
+	stableQueueMu sync.Mutex     // Lock protecting the stable block announcement queue
+
 	mu      sync.RWMutex // global mutex for locking chain operations
 	chainmu sync.RWMutex // blockchain insertion lock
 	procmu  sync.RWMutex // block processor lock
@@ -117,6 +127,9 @@ type BlockChain struct {
 	blockCache   *lru.Cache     // Cache for the most recent entire blocks
 	futureBlocks *lru.Cache     // future blocks are blocks added for later processing
 
+	futureFinality *lru.Cache // Finality certificates of blocks not imported yet
+	futureVotes    *lru.Cache // Star votes of blocks not imported yet
+
 	quit    chan struct{} // blockchain quit channel
 	running int32         // running must be called atomically
 	// procInterrupt must be atomically called
@@ -128,24 +141,29 @@ type BlockChain struct {
 	validator Validator // block and state validator interface
 	vmConfig  vm.Config
 
-	badBlocks *lru.Cache // Bad block cache
+	badBlocks   *lru.Cache // Bad block cache
+	recentSeals *lru.Cache // Recent blocks by parent and coinbase, to catch stars sealing twice in a slot
 
 	isStarNode               bool                                             // sman 是否为主节点
 	stableBlock              atomic.Value                                     // sman 当前稳定块指针
-	blocksConsensus          map[common.Hash]uint64                           // sman 区块经过确认的标识 按位计算 最多64个主节点
+	blocksConsensus          map[common.Hash]*ConsensusEntry                  // sman 稳定块之上区块的确认标识(按位计算)及子块
 	blocksConsensusMux       sync.Mutex                                       // sman 锁
 	coinbase                 common.Address                                   // sman 节点coinbase
 	BroadcastConFn           func(hash common.Hash, num uint64, hasFlag bool) // sman 广播确认标识回调函数
 	BroadcastBlock2Satellite func(hash common.Hash, number uint64)            // sman 广播区块到普通节点
 }
 
-var childrenMap = make(map[common.Hash][]common.Hash) // sman for iteratal of block children block
 
 // sman 设置coinbase
 func (bc *BlockChain) SetCoinbase(coinbase common.Address) {
 	bc.coinbase = coinbase
 }
 
+// Coinbase returns the address of the local star, whose key signs its votes.
+func (bc *BlockChain) Coinbase() common.Address {
+	return bc.coinbase
+}
+
 // NewBlockChain returns a fully initialised block chain using information
 // available in the database. It initialises the default LoveBlock Validator and
 // Processor.
@@ -160,7 +178,10 @@ func NewBlockChain(db lovedb.Database, cacheConfig *CacheConfig, chainConfig *pa
 	bodyRLPCache, _ := lru.New(bodyCacheLimit)
 	blockCache, _ := lru.New(blockCacheLimit)
 	futureBlocks, _ := lru.New(maxFutureBlocks)
+	futureFinality, _ := lru.New(maxFutureFinality)
+	futureVotes, _ := lru.New(maxFutureVotes)
 	badBlocks, _ := lru.New(badBlockLimit)
+	recentSeals, _ := lru.New(recentSealsLimit)
 
 	bc := &BlockChain{
 		chainConfig:     chainConfig,
@@ -173,10 +194,13 @@ func NewBlockChain(db lovedb.Database, cacheConfig *CacheConfig, chainConfig *pa
 		bodyRLPCache:    bodyRLPCache,
 		blockCache:      blockCache,
 		futureBlocks:    futureBlocks,
+		futureFinality:  futureFinality,
+		futureVotes:     futureVotes,
 		engine:          engine,
 		vmConfig:        vmConfig,
 		badBlocks:       badBlocks,
-		blocksConsensus: make(map[common.Hash]uint64, 0),
+		recentSeals:     recentSeals,
+		blocksConsensus: make(map[common.Hash]*ConsensusEntry, 0),
 	}
 	bc.SetValidator(NewBlockValidator(chainConfig, bc, engine))
 	bc.SetProcessor(NewStateProcessor(chainConfig, bc, engine))
@@ -242,7 +266,18 @@ func (bc *BlockChain) loadLastState() error {
 	}
 	// Everything seems to be fine, set as the head block
 	bc.currentBlock.Store(currentBlock)
-	bc.stableBlock.Store(bc.genesisBlock) // sman set stableblock with genesis block
+
+	// sman Restore the last stable block and the consensus bookkeeping above it
+	bc.stableBlock.Store(bc.genesisBlock)
+	if stable := bc.GetBlockByHash(GetHeadStableBlockHash(bc.db)); stable != nil && stable.NumberU64() <= currentBlock.NumberU64() {
+		bc.stableBlock.Store(stable)
+	}
+	bc.blocksConsensusMux.Lock()
+	bc.blocksConsensus = make(map[common.Hash]*ConsensusEntry)
+	for _, entry := range GetConsensusEntries(bc.db) {
+		bc.blocksConsensus[entry.Hash] = entry
+	}
+	bc.blocksConsensusMux.Unlock()
 
 	// Restore the last known head header
 	currentHeader := currentBlock.Header()
@@ -330,6 +365,11 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	if err := WriteHeadFastBlockHash(bc.db, currentFastBlock.Hash()); err != nil {
 		log.Crit("Failed to reset head fast block", "err", err)
 	}
+	// sman 回退到稳定块之下时, 新的头部块即为稳定块(其为原稳定块的祖先)
+	if stable := bc.StableBlock(); stable.NumberU64() > currentBlock.NumberU64() {
+		log.Warn("Rewound below stable block", "stable", stable.Number(), "head", currentBlock.Number())
+		WriteHeadStableBlockHash(bc.db, currentBlock.Hash())
+	}
 	return bc.loadLastState()
 }
 
@@ -370,8 +410,55 @@ func (bc *BlockChain) StableBlock() *types.Block {
 }
 
 // sman 设置当前稳定区块指针
+// The stable block is persisted and the consensus bookkeeping of the blocks below
+// it is dropped, as they can no longer be reorganised.
 func (bc *BlockChain) SetStableBlock(block *types.Block) {
 	bc.stableBlock.Store(block)
+	WriteHeadStableBlockHash(bc.db, block.Hash())
+	bc.postStableEvent(block)
+
+	bc.blocksConsensusMux.Lock()
+	defer bc.blocksConsensusMux.Unlock()
+
+	var pruned []common.Hash
+	for hash, entry := range bc.blocksConsensus {
+		if entry.Number < block.NumberU64() {
+			pruned = append(pruned, hash)
+		}
+	}
+	bc.deleteConsensusEntries(pruned...)
+}
+
+// postStableEvent queues the announcement of a new stable block. The events are
+// delivered in order by a background sender, as the stable block advances on the
+// import path and slow subscribers must not stall it.
+func (bc *BlockChain) postStableEvent(block *types.Block) {
+	bc.stableQueueMu.Lock()
+	defer bc.stableQueueMu.Unlock()
+
+	bc.stableQueue = append(bc.stableQueue, block)
+	if !bc.stableSending {
+		bc.stableSending = true
+		go bc.sendStableEvents()
+	}
+}
+
+// sendStableEvents delivers the queued stable block announcements until the queue
+// runs empty.
+func (bc *BlockChain) sendStableEvents() {
+	for {
+		bc.stableQueueMu.Lock()
+		if len(bc.stableQueue) == 0 {
+			bc.stableSending = false
+			bc.stableQueueMu.Unlock()
+			return
+		}
+		block := bc.stableQueue[0]
+		bc.stableQueue = bc.stableQueue[1:]
+		bc.stableQueueMu.Unlock()
+
+		bc.stableFeed.Send(ChainStableEvent{Block: block})
+	}
 }
 
 // 设置是否为主节点标记
@@ -379,47 +466,115 @@ func (bc *BlockChain) SetIsStarNode(flag bool) {
 	bc.isStarNode = flag
 }
 
+// CurrentStars returns the star nodes allowed to produce the block following the
+// current head, as scheduled by the consensus engine.
+func (bc *BlockChain) CurrentStars() commonDpovp.StarList {
+	if reader, ok := bc.engine.(consensus.StarReader); ok {
+		return reader.Stars(bc, bc.CurrentBlock().Header())
+	}
+	return commonDpovp.GetAllSortedCoreNodes()
+}
+
+// starsOf returns the stars scheduled for the given block, resolved from its
+// parent, whose positions index the block's confirmation bits and whose weights
+// make up its quorum. Blocks not imported yet are assumed to extend the head.
+func (bc *BlockChain) starsOf(hash common.Hash, number uint64) commonDpovp.StarList {
+	reader, ok := bc.engine.(consensus.StarReader)
+	if !ok {
+		return commonDpovp.GetAllSortedCoreNodes()
+	}
+	if header := bc.GetHeader(hash, number); header != nil && number > 0 {
+		if parent := bc.GetHeader(header.ParentHash, number-1); parent != nil {
+			return reader.Stars(bc, parent)
+		}
+	}
+	return reader.Stars(bc, bc.CurrentBlock().Header())
+}
+
 // sman 设置确认标识
 // hash: block hash
+// number: block number
 // address: consensus node address
-func (bc *BlockChain) SetConsensusFlag(hash common.Hash, address common.Address) {
+func (bc *BlockChain) SetConsensusFlag(hash common.Hash, number uint64, address common.Address) {
 	bc.blocksConsensusMux.Lock()
 	defer bc.blocksConsensusMux.Unlock()
 
-	index := commonDpovp.GetCoreNodeIndex(&address)
+	index := bc.starsOf(hash, number).Index(&address)
 	if index < 0 {
 		log.Error(fmt.Sprintf("Failed to set consensus flag, address:%s", common.ToHex(address[:])))
 		return
 	}
-	v := uint64(1) << uint32(index)
-	if flag, ok := bc.blocksConsensus[hash]; ok == true {
-		v = flag | v
-	}
-	bc.blocksConsensus[hash] = v
+	entry := bc.consensusEntry(hash, number)
+	entry.Flag.SetBit(entry.Flag, index, 1)
+	bc.writeConsensusEntry(entry)
 	log.Debug(fmt.Sprintf("SetConsensusFlag: hash:%s, addr:%s", common.ToHex(hash[:]), common.ToHex(address[:])))
 }
 
-// sman 验证区块是否得到超多2/3的确认
+// confirm sets the confirmation flag of the local star on the given block, unless
+// the star already confirmed another block of the same height. Stars confirm a
+// single block per height, so two competing blocks can never both gather a quorum
+// and become stable on different nodes.
+func (bc *BlockChain) confirm(hash common.Hash, number uint64) bool {
+	index := bc.starsOf(hash, number).Index(&bc.coinbase)
+	if index < 0 {
+		return false
+	}
+	bc.blocksConsensusMux.Lock()
+	defer bc.blocksConsensusMux.Unlock()
+
+	for other, entry := range bc.blocksConsensus {
+		if entry.Number == number && other != hash && entry.Flag.Bit(index) == 1 {
+			log.Debug("Already confirmed another block of the height", "number", number, "hash", hash, "confirmed", other)
+			return false
+		}
+	}
+	entry := bc.consensusEntry(hash, number)
+	entry.Flag.SetBit(entry.Flag, index, 1)
+	bc.writeConsensusEntry(entry)
+	return true
+}
+
+// ConfirmMined sets the confirmation flag of the local star on a block it sealed,
+// its seal confirming the block to the other stars. It reports false if the star
+// confirmed a competing block of the same height meanwhile, in which case the
+// sealed block must be dropped.
+func (bc *BlockChain) ConfirmMined(hash common.Hash, number uint64) bool {
+	if !bc.isStarNode {
+		return true
+	}
+	return bc.confirm(hash, number)
+}
+
+// sman 验证区块是否得到超过法定比例(默认2/3)的确认
 func (bc *BlockChain) VerifyConsensusOK(hash common.Hash) bool {
 	bc.blocksConsensusMux.Lock()
 	defer bc.blocksConsensusMux.Unlock()
 
-	flag, ok := bc.blocksConsensus[hash]
+	entry, ok := bc.blocksConsensus[hash]
 	if ok != true {
 		return false
 	}
-	nodeCount := commonDpovp.GetCoreNodesCount()
-	count := 0
-	for i := 0; i < nodeCount; i++ {
-		tmp := uint64(1 << uint32(i))
-		if tmp&flag == tmp {
-			count++
+	stars := bc.starsOf(hash, entry.Number)
+	var weight uint64
+	for i := range stars {
+		if entry.Flag.Bit(i) == 1 {
+			weight += stars.Weight(i)
 		}
 	}
-	if count >= nodeCount*2/3 {
-		return true
+	return weight >= uint64(bc.chainConfig.Dpovp.Quorum(int(stars.TotalWeight())))
+}
+
+// ConsensusFlag returns the confirmation bitmap of the given block, bit i being
+// set if the i-th star scheduled for the block confirmed it.
+func (bc *BlockChain) ConsensusFlag(hash common.Hash) (*big.Int, bool) {
+	bc.blocksConsensusMux.Lock()
+	defer bc.blocksConsensusMux.Unlock()
+
+	entry, ok := bc.blocksConsensus[hash]
+	if !ok {
+		return new(big.Int), false
 	}
-	return false
+	return new(big.Int).Set(entry.Flag), true
 }
 
 // sman 删除已达成共识的块标识
@@ -427,7 +582,485 @@ func (bc *BlockChain) RemoveConsensusFlag(hash common.Hash) {
 	bc.blocksConsensusMux.Lock()
 	defer bc.blocksConsensusMux.Unlock()
 
-	delete(bc.blocksConsensus, hash)
+	if _, ok := bc.blocksConsensus[hash]; ok {
+		bc.deleteConsensusEntries(hash)
+	}
+}
+
+// addVote records the signature of the block hash by a confirming star, to be
+// collected into the block's finality certificate.
+func (bc *BlockChain) addVote(hash common.Hash, number uint64, vote []byte) {
+	bc.blocksConsensusMux.Lock()
+	defer bc.blocksConsensusMux.Unlock()
+
+	entry := bc.consensusEntry(hash, number)
+	for _, known := range entry.Votes {
+		if bytes.Equal(known, vote) {
+			return
+		}
+	}
+	entry.Votes = append(entry.Votes, common.CopyBytes(vote))
+	bc.writeConsensusEntry(entry)
+}
+
+// castVote records the vote of the local star for a block it confirmed, with its
+// BLS key if the chain votes with aggregated signatures.
+func (bc *BlockChain) castVote(hash common.Hash, number uint64) {
+	if !bc.blsVotes() {
+		if vote := bc.signVote(hash); vote != nil {
+			bc.addVote(hash, number, vote)
+		}
+		return
+	}
+	key := commonDpovp.BLSKeyOf(bc.coinbase)
+	index := bc.starsOf(hash, number).Index(&bc.coinbase)
+	if key == nil || index < 0 {
+		return
+	}
+	bc.mergeBLSVotes(hash, number, new(big.Int).SetBit(new(big.Int), index, 1), key.Sign(hash[:]))
+}
+
+// blsVotes reports whether the stars of the chain vote with BLS keys.
+func (bc *BlockChain) blsVotes() bool {
+	return bc.chainConfig.Dpovp != nil && bc.chainConfig.Dpovp.BLSVotes
+}
+
+// blsPublicKey aggregates the BLS keys of the stars whose bits are set in signers,
+// as registered in the state of the block they voted for.
+func (bc *BlockChain) blsPublicKey(header *types.Header, stars commonDpovp.StarList, signers *big.Int) (*bls.PublicKey, error) {
+	if signers.BitLen() > len(stars) {
+		return nil, ErrUnknownVoter
+	}
+	statedb, err := bc.StateAt(header.Root)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*bls.PublicKey
+	for i := range stars {
+		if signers.Bit(i) == 0 {
+			continue
+		}
+		data := commonDpovp.ReadBLSKey(statedb, stars[i].Addr)
+		if data == nil {
+			return nil, ErrMissingBLSKey
+		}
+		key, err := bls.UnmarshalPublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return bls.AggregatePublicKeys(keys...), nil
+}
+
+// mergeBLSVotes merges verified, aggregated BLS votes for a block into the ones
+// collected so far, returning whether any new star confirmed the block. Disjoint
+// aggregates are combined; overlapping ones can't be, so the larger one is kept.
+func (bc *BlockChain) mergeBLSVotes(hash common.Hash, number uint64, signers *big.Int, sig *bls.Signature) bool {
+	bc.blocksConsensusMux.Lock()
+	defer bc.blocksConsensusMux.Unlock()
+
+	entry := bc.consensusEntry(hash, number)
+	if entry.Signers == nil {
+		entry.Signers = new(big.Int)
+	}
+	if new(big.Int).AndNot(signers, entry.Signers).Sign() == 0 {
+		return false
+	}
+	switch {
+	case new(big.Int).And(signers, entry.Signers).Sign() == 0:
+		if len(entry.Aggregate) > 0 {
+			known, err := bls.UnmarshalSignature(entry.Aggregate)
+			if err != nil {
+				return false
+			}
+			sig = bls.AggregateSignatures(known, sig)
+		}
+		entry.Signers.Or(entry.Signers, signers)
+
+	case bitCount(signers) > bitCount(entry.Signers):
+		entry.Signers.Set(signers)
+
+	default:
+		return false
+	}
+	entry.Aggregate = sig.Marshal()
+	entry.Flag.Or(entry.Flag, signers)
+	bc.writeConsensusEntry(entry)
+	return true
+}
+
+// ConsensusAggregate returns the BLS votes collected for a block: the stars that
+// voted and their aggregated signature.
+func (bc *BlockChain) ConsensusAggregate(hash common.Hash) (*big.Int, []byte, bool) {
+	bc.blocksConsensusMux.Lock()
+	defer bc.blocksConsensusMux.Unlock()
+
+	entry, ok := bc.blocksConsensus[hash]
+	if !ok || entry.Signers == nil || len(entry.Aggregate) == 0 {
+		return nil, nil, false
+	}
+	return new(big.Int).Set(entry.Signers), common.CopyBytes(entry.Aggregate), true
+}
+
+// bitCount returns the number of set bits of a non-negative integer.
+func bitCount(x *big.Int) int {
+	count := 0
+	for i := 0; i < x.BitLen(); i++ {
+		count += int(x.Bit(i))
+	}
+	return count
+}
+
+// signVote signs the hash of a block confirmed by the local star, returning nil
+// if the node has no star key.
+func (bc *BlockChain) signVote(hash common.Hash) []byte {
+	if commonDpovp.PubkeyOf(bc.coinbase) == nil {
+		return nil
+	}
+	vote, err := commonDpovp.SignAs(bc.coinbase, hash[:])
+	if err != nil {
+		log.Warn("Failed to sign finality vote", "hash", hash, "err", err)
+		return nil
+	}
+	return vote
+}
+
+// writeFinalityCertificate stores the votes collected for a block that reached
+// the quorum as its finality certificate.
+func (bc *BlockChain) writeFinalityCertificate(hash common.Hash, number uint64) {
+	bc.blocksConsensusMux.Lock()
+	entry, ok := bc.blocksConsensus[hash]
+	if !ok {
+		bc.blocksConsensusMux.Unlock()
+		return
+	}
+	cert := &types.FinalityCertificate{
+		Hash:      hash,
+		Number:    number,
+		Votes:     append([][]byte(nil), entry.Votes...),
+		Signers:   new(big.Int),
+		Aggregate: common.CopyBytes(entry.Aggregate),
+	}
+	if entry.Signers != nil {
+		cert.Signers.Set(entry.Signers)
+	}
+	bc.blocksConsensusMux.Unlock()
+
+	if err := WriteFinalityCertificate(bc.db, cert); err != nil {
+		log.Error("Failed to store finality certificate", "hash", hash, "err", err)
+	}
+}
+
+// GetFinalityCertificate retrieves the finality certificate of the block with the
+// given hash, or nil if the block isn't known to be finalized.
+func (bc *BlockChain) GetFinalityCertificate(hash common.Hash) *types.FinalityCertificate {
+	return GetFinalityCertificate(bc.db, hash)
+}
+
+// VerifyFinalityCertificate checks that a certificate holds the votes of a quorum
+// of the stars scheduled for the block it certifies. The block must be part of
+// the local chain; its author is counted as confirming it by its verified seal.
+func (bc *BlockChain) VerifyFinalityCertificate(cert *types.FinalityCertificate) error {
+	return verifyFinality(bc, bc.engine, bc.CurrentStars(), cert, bc.verifyAggregate)
+}
+
+// VerifyLightFinalityCertificate checks a finality certificate like
+// BlockChain.VerifyFinalityCertificate against a chain of headers without state,
+// e.g. the one of a light client. Aggregated BLS votes are checked against keys
+// registered in the state, so certificates carrying them are rejected.
+func VerifyLightFinalityCertificate(chain consensus.ChainReader, engine consensus.Engine, cert *types.FinalityCertificate) error {
+	return verifyFinality(chain, engine, commonDpovp.GetAllSortedCoreNodes(), cert, nil)
+}
+
+// verifyFinality checks a finality certificate against the stars resolved by the
+// engine, or the given ones if the engine doesn't schedule stars. Aggregated votes
+// are checked by aggregate, if they can be checked at all.
+func verifyFinality(chain consensus.ChainReader, engine consensus.Engine, stars commonDpovp.StarList, cert *types.FinalityCertificate,
+	aggregate func(*types.Header, commonDpovp.StarList, *big.Int, []byte) error) error {
+	header := chain.GetHeader(cert.Hash, cert.Number)
+	if header == nil || cert.Number == 0 {
+		return ErrUnknownFinalityBlock
+	}
+	if reader, ok := engine.(consensus.StarReader); ok {
+		parent := chain.GetHeader(header.ParentHash, cert.Number-1)
+		if parent == nil {
+			return ErrUnknownFinalityBlock
+		}
+		stars = reader.Stars(chain, parent)
+	}
+	voters, err := cert.Voters()
+	if err != nil {
+		return err
+	}
+	confirmed := make(map[int]bool)
+	if len(cert.Aggregate) > 0 && cert.Signers != nil {
+		if aggregate == nil {
+			return ErrAggregateWithoutState
+		}
+		if err := aggregate(header, stars, cert.Signers, cert.Aggregate); err != nil {
+			return err
+		}
+		for i := range stars {
+			if cert.Signers.Bit(i) == 1 {
+				confirmed[i] = true
+			}
+		}
+	}
+	if index := stars.Index(&header.Coinbase); index >= 0 {
+		confirmed[index] = true
+	}
+	for _, pubkey := range voters {
+		index := stars.IndexByPubkey(pubkey)
+		if index < 0 {
+			return ErrUnknownVoter
+		}
+		confirmed[index] = true
+	}
+	var weight uint64
+	for index := range confirmed {
+		weight += stars.Weight(index)
+	}
+	if weight < uint64(chain.Config().Dpovp.Quorum(int(stars.TotalWeight()))) {
+		return ErrInsufficientVotes
+	}
+	return nil
+}
+
+// verifyAggregate checks aggregated BLS votes for a block against the registered
+// keys of the stars that voted.
+func (bc *BlockChain) verifyAggregate(header *types.Header, stars commonDpovp.StarList, signers *big.Int, aggregate []byte) error {
+	hash := header.Hash()
+	key, err := bc.blsPublicKey(header, stars, signers)
+	if err != nil {
+		return err
+	}
+	sig, err := bls.UnmarshalSignature(aggregate)
+	if err != nil {
+		return err
+	}
+	if !bls.Verify(key, hash[:], sig) {
+		return ErrInvalidAggregate
+	}
+	return nil
+}
+
+// AddFinalityCertificate verifies and stores a finality certificate received from
+// the network, returning whether it was new. A verified certificate advances the
+// stable block, so nodes that don't vote themselves still learn which blocks are
+// final. Certificates of blocks not imported yet are kept until the block arrives.
+func (bc *BlockChain) AddFinalityCertificate(cert *types.FinalityCertificate) (bool, error) {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	return bc.addFinalityCertificate(cert)
+}
+
+// addFinalityCertificate is the internal version of AddFinalityCertificate, the
+// caller must hold chainmu.
+func (bc *BlockChain) addFinalityCertificate(cert *types.FinalityCertificate) (bool, error) {
+	if GetFinalityCertificate(bc.db, cert.Hash) != nil {
+		return false, nil
+	}
+	if err := bc.VerifyFinalityCertificate(cert); err != nil {
+		if err == ErrUnknownFinalityBlock && cert.Number > bc.StableBlock().NumberU64() {
+			bc.futureFinality.Add(cert.Hash, cert)
+		}
+		return false, err
+	}
+	if err := WriteFinalityCertificate(bc.db, cert); err != nil {
+		return false, err
+	}
+	bc.futureFinality.Remove(cert.Hash)
+
+	return true, bc.advanceStable(bc.GetBlock(cert.Hash, cert.Number))
+}
+
+// advanceStable moves the stable block to a block proven final. A final block on
+// a side chain is made canonical first, dropping the blocks competing with it.
+// The caller must hold chainmu.
+func (bc *BlockChain) advanceStable(block *types.Block) error {
+	if block.NumberU64() <= bc.StableBlock().NumberU64() {
+		return nil
+	}
+	if GetCanonicalHash(bc.db, block.NumberU64()) != block.Hash() {
+		bc.mu.Lock()
+		err := bc.reorg(bc.CurrentBlock(), block)
+		if err == nil {
+			bc.insert(block)
+		}
+		bc.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+		log.Info("Switched to finalized side chain", "number", block.Number(), "hash", block.Hash())
+		go bc.chainHeadFeed.Send(ChainHeadEvent{Block: block})
+	}
+	bc.SetStableBlock(block)
+	return nil
+}
+
+// descendsFromStable reports whether the given header is the stable block or one
+// of its descendants, i.e. whether making it canonical keeps the stable block.
+func (bc *BlockChain) descendsFromStable(header *types.Header) bool {
+	stable := bc.StableBlock()
+	for header != nil && header.Number.Uint64() > stable.NumberU64() {
+		// The canonical chain always contains the stable block
+		if GetCanonicalHash(bc.db, header.Number.Uint64()) == header.Hash() {
+			return true
+		}
+		header = bc.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	}
+	return header != nil && header.Hash() == stable.Hash()
+}
+
+// ConflictsWithStable reports whether the given header is on a chain that doesn't
+// contain the stable block. Such chains are never imported, as they would remove
+// final blocks. Headers of unknown ancestry above the stable block don't conflict.
+func (bc *BlockChain) ConflictsWithStable(header *types.Header) bool {
+	stable := bc.StableBlock()
+	if number := header.Number.Uint64(); number <= stable.NumberU64() {
+		return GetCanonicalHash(bc.db, number) != header.Hash()
+	}
+	for header.Number.Uint64() > stable.NumberU64()+1 {
+		if GetCanonicalHash(bc.db, header.Number.Uint64()) == header.Hash() {
+			return false
+		}
+		parent := bc.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+		if parent == nil {
+			return false
+		}
+		header = parent
+	}
+	return header.ParentHash != stable.Hash()
+}
+
+// consensusEntry returns the consensus bookkeeping of the given block, creating
+// it if it's not tracked yet. The caller must hold blocksConsensusMux.
+func (bc *BlockChain) consensusEntry(hash common.Hash, number uint64) *ConsensusEntry {
+	entry, ok := bc.blocksConsensus[hash]
+	if !ok {
+		entry = &ConsensusEntry{Hash: hash, Number: number, Flag: new(big.Int)}
+		bc.blocksConsensus[hash] = entry
+		bc.writeConsensusIndex()
+	}
+	return entry
+}
+
+// writeConsensusEntry persists the consensus bookkeeping of a block so that it
+// survives restarts. The caller must hold blocksConsensusMux.
+func (bc *BlockChain) writeConsensusEntry(entry *ConsensusEntry) {
+	if err := WriteConsensusEntry(bc.db, entry); err != nil {
+		log.Error("Failed to persist consensus bookkeeping", "hash", entry.Hash, "err", err)
+	}
+}
+
+// writeConsensusIndex persists the hashes of the tracked blocks, from which the
+// bookkeeping is reloaded on restart. The caller must hold blocksConsensusMux.
+func (bc *BlockChain) writeConsensusIndex() {
+	hashes := make([]common.Hash, 0, len(bc.blocksConsensus))
+	for hash := range bc.blocksConsensus {
+		hashes = append(hashes, hash)
+	}
+	if err := WriteConsensusIndex(bc.db, hashes); err != nil {
+		log.Error("Failed to persist consensus index", "err", err)
+	}
+}
+
+// deleteConsensusEntries drops the consensus bookkeeping of the given blocks from
+// memory and from the database. The caller must hold blocksConsensusMux.
+func (bc *BlockChain) deleteConsensusEntries(hashes ...common.Hash) {
+	if len(hashes) == 0 {
+		return
+	}
+	for _, hash := range hashes {
+		delete(bc.blocksConsensus, hash)
+		DeleteConsensusEntry(bc.db, hash)
+	}
+	bc.writeConsensusIndex()
+}
+
+// sman 记录区块的子块
+func (bc *BlockChain) addChild(parent *types.Header, child common.Hash) {
+	bc.blocksConsensusMux.Lock()
+	defer bc.blocksConsensusMux.Unlock()
+
+	entry := bc.consensusEntry(parent.Hash(), parent.Number.Uint64())
+	for _, hash := range entry.Children {
+		if hash == child {
+			return
+		}
+	}
+	entry.Children = append(entry.Children, child)
+	bc.writeConsensusEntry(entry)
+}
+
+// sman 获取区块的子块
+func (bc *BlockChain) children(hash common.Hash) []common.Hash {
+	bc.blocksConsensusMux.Lock()
+	defer bc.blocksConsensusMux.Unlock()
+
+	if entry, ok := bc.blocksConsensus[hash]; ok {
+		return append([]common.Hash(nil), entry.Children...)
+	}
+	return nil
+}
+
+// checkEquivocation records the block the header's coinbase sealed on top of the
+// header's parent, and reports evidence if the star already sealed a different
+// block in that slot.
+func (bc *BlockChain) checkEquivocation(header *types.Header) {
+	// Seals before the seal hash fork only cover the coinbase, so they can't prove
+	// two blocks were sealed
+	if !bc.chainConfig.IsSealHash(header.Number) {
+		return
+	}
+	key := string(append(header.ParentHash.Bytes(), header.Coinbase.Bytes()...))
+	hash := header.Hash()
+
+	if prev, ok := bc.recentSeals.Get(key); ok && prev.(common.Hash) != hash {
+		first := bc.GetHeaderByHash(prev.(common.Hash))
+		// Headers differing in their seals only are the same block
+		if first == nil || first.HashNoDpovp() == header.HashNoDpovp() {
+			return
+		}
+		if _, err := bc.AddEvidence(types.NewEvidence(first, header)); err != nil {
+			log.Debug("Failed to record equivocation", "number", header.Number, "coinbase", header.Coinbase, "err", err)
+		}
+		return
+	}
+	bc.recentSeals.Add(key, hash)
+}
+
+// AddEvidence verifies and stores evidence of a star sealing two blocks in the
+// same slot, notifying subscribers about evidence not seen before. It returns
+// whether the evidence was new.
+func (bc *BlockChain) AddEvidence(evidence *types.Evidence) (bool, error) {
+	hash := evidence.Hash()
+	if GetEvidence(bc.db, hash) != nil {
+		return false, nil
+	}
+	verifier, ok := bc.engine.(consensus.EvidenceVerifier)
+	if !ok {
+		return false, ErrNoEvidenceSupport
+	}
+	if err := verifier.VerifyEvidence(bc, evidence); err != nil {
+		return false, err
+	}
+	if err := WriteEvidence(bc.db, evidence); err != nil {
+		return false, err
+	}
+	log.Warn("Star sealed conflicting blocks", "star", evidence.Offender(), "number", evidence.First.Number,
+		"first", evidence.First.Hash(), "second", evidence.Second.Hash())
+
+	bc.evidenceFeed.Send(EvidenceEvent{Evidence: evidence})
+	return true, nil
+}
+
+// GetEvidence retrieves stored equivocation evidence by hash.
+func (bc *BlockChain) GetEvidence(hash common.Hash) *types.Evidence {
+	return GetEvidence(bc.db, hash)
 }
 
 // CurrentFastBlock retrieves the current fast-sync head block of the canonical
@@ -503,7 +1136,17 @@ func (bc *BlockChain) ResetWithGenesisBlock(genesis *types.Block) error {
 	bc.hc.SetCurrentHeader(bc.genesisBlock.Header())
 	bc.currentFastBlock.Store(bc.genesisBlock)
 
-	bc.stableBlock.Store(bc.genesisBlock) // sman set stableBlock with genesisBlock
+	// sman set stableBlock with genesisBlock, forgetting all confirmations
+	bc.stableBlock.Store(bc.genesisBlock)
+	WriteHeadStableBlockHash(bc.db, bc.genesisBlock.Hash())
+
+	bc.blocksConsensusMux.Lock()
+	for hash := range bc.blocksConsensus {
+		DeleteConsensusEntry(bc.db, hash)
+	}
+	bc.blocksConsensus = make(map[common.Hash]*ConsensusEntry)
+	bc.writeConsensusIndex()
+	bc.blocksConsensusMux.Unlock()
 	return nil
 }
 
@@ -812,7 +1455,7 @@ func (bc *BlockChain) Rollback(chain []common.Hash) {
 
 // SetReceiptsData computes all the non-consensus fields of the receipts
 func SetReceiptsData(config *params.ChainConfig, block *types.Block, receipts types.Receipts) error {
-	signer := types.MakeSigner(config)
+	signer := types.MakeSigner(config, block.Number())
 
 	transactions, logIndex := block.Transactions(), uint(0)
 	if len(transactions) != len(receipts) {
@@ -1058,6 +1701,11 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 		//reorg = block.NumberU64() < currentBlock.NumberU64() || (block.NumberU64() == currentBlock.NumberU64() && mrand.Float64() < 0.5)
 		reorg = block.NumberU64() < currentBlock.NumberU64() || (block.NumberU64() == currentBlock.NumberU64())
 	}
+	if reorg && block.ParentHash() != currentBlock.Hash() && !bc.descendsFromStable(block.Header()) {
+		// Finalized blocks are never reorganised away, keep the fork on the side
+		log.Warn("Ignored fork below the stable block", "number", block.Number(), "hash", block.Hash(), "stable", bc.StableBlock().Number())
+		reorg = false
+	}
 	if reorg {
 		// Reorganise the chain if the parent is not the head block
 		if block.ParentHash() != currentBlock.Hash() {
@@ -1155,10 +1803,23 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 			bc.reportBlock(block, nil, ErrBlacklistedHash)
 			return i, events, coalescedLogs, ErrBlacklistedHash
 		}
+		// Never import chains that would reorganise away final blocks
+		if bc.ConflictsWithStable(block.Header()) {
+			if bc.isStarNode {
+				bc.BroadcastConFn(block.Hash(), block.NumberU64(), false) // 广播不带确认标识
+			}
+			log.Debug("Rejected block conflicting with stable block", "number", block.Number(), "hash", block.Hash())
+			return i, events, coalescedLogs, ErrStableConflict
+		}
 		// Wait for the block's verification to complete
 		bstart := time.Now()
 
 		err := <-results
+		if err == consensus.ErrPrunedAncestor && i > 0 {
+			// The engine may need the state of a parent imported in this batch,
+			// which is available by now
+			err = bc.engine.VerifyHeader(bc, block.Header(), true)
+		}
 		if err == nil {
 			err = bc.Validator().ValidateBody(block)
 		}
@@ -1219,6 +1880,11 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 			if err != nil {
 				return i, events, coalescedLogs, err
 			}
+			// Repeat the header checks the engine couldn't do without the parent state
+			if err := bc.engine.VerifyHeader(bc, block.Header(), true); err != nil {
+				bc.reportBlock(block, nil, err)
+				return i, events, coalescedLogs, err
+			}
 
 		case err != nil:
 			bc.reportBlock(block, nil, err)
@@ -1248,6 +1914,9 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 			bc.reportBlock(block, receipts, err)
 			return i, events, coalescedLogs, err
 		}
+		// Catch stars sealing two different blocks in the same slot
+		bc.checkEquivocation(block.Header())
+
 		// sman
 		if bc.isStarNode {
 			// sman 高度是否大于当前stable block head的高度
@@ -1258,7 +1927,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 				return i, events, coalescedLogs, nil // todo 是否需要返回错误？ 错误将导致断开连接
 			}
 			// sman 置出块者确认标识
-			bc.SetConsensusFlag(block.Header().Hash(), block.Coinbase())
+			bc.SetConsensusFlag(block.Header().Hash(), block.NumberU64(), block.Coinbase())
 			log.Debug("blockchain-insertChain: SetConsensusFlag:coinbase")
 		}
 		// Write the block to the chain and get the status.
@@ -1269,15 +1938,21 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 		}
 		// sman
 		if bc.isStarNode {
-			// sman 置自己节点对应的确认标识位
+			// sman 置自己节点对应的确认标识位, 同一高度只确认一个块
 			blockHash := block.Hash()
-			bc.SetConsensusFlag(blockHash, bc.coinbase)
-			log.Debug("blockchain-insertChain: SetConsensusFlag: local node")
+			confirm := bc.confirm(blockHash, block.NumberU64())
+			if confirm {
+				bc.castVote(blockHash, block.NumberU64())
+				log.Debug("blockchain-insertChain: SetConsensusFlag: local node")
+			}
+			// Votes received before the block arrived count now
+			bc.procFutureVotes(block)
 			// sman 判断是否有2/3以上的确认
 			if bc.VerifyConsensusOK(blockHash) {
 				log.Info(fmt.Sprintf("blockchain-insertChain: block has consensus. Number:%d hash:%s", block.Header().Number.Uint64(), common.ToHex(blockHash[:])))
+				bc.writeFinalityCertificate(blockHash, block.NumberU64())
 				if bc.StableBlock().Header().Number.Uint64() < block.Header().Number.Uint64() { // Stable_block是否已指向该块或该块的子块
-					bc.stableBlock.Store(block) // 将stable_block指向该块
+					bc.SetStableBlock(block) // 将stable_block指向该块
 					log.Debug(fmt.Sprintf("blockchain-insertChain: stableBlock refer to:%s", common.ToHex(blockHash[:])))
 				}
 				if !bc.isCurAndStableBlockInSameChain() { // current block与stable block不在一条链上
@@ -1292,13 +1967,18 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 			}
 			// sman 获取父节点header 并设置父header的children
 			parentHeader := bc.GetHeader(block.ParentHash(), block.Number().Uint64()-1)
-			parHash := parentHeader.Hash()
-			childrenMap[parHash] = append(childrenMap[parHash], blockHash)
+			bc.addChild(parentHeader, blockHash)
 
 			// sman  广播该块的hash，附带上确认标识
-			bc.BroadcastConFn(block.Header().Hash(), block.Header().Number.Uint64(), true)
+			bc.BroadcastConFn(block.Header().Hash(), block.Header().Number.Uint64(), confirm)
 			log.Debug("blockchain-insertChain: BroadcastConFn")
 		}
+		// Finality proven before the block arrived advances the stable block now
+		if cert, ok := bc.futureFinality.Get(block.Hash()); ok {
+			if _, err := bc.addFinalityCertificate(cert.(*types.FinalityCertificate)); err != nil {
+				log.Debug("Discarded queued finality certificate", "hash", block.Hash(), "err", err)
+			}
+		}
 		proctime := time.Since(bstart)
 		switch status {
 		case CanonStatTy:
@@ -1332,6 +2012,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 }
 
 // sman 收到hash广播后的处理 主要处理确认标识
+// Votes of blocks not imported yet are queued until the block arrives.
 func (bc *BlockChain) ProcConsensusMsg(info struct {
 	Hash         common.Hash // 区块hash
 	Number       uint64      // 区块高度
@@ -1346,47 +2027,124 @@ func (bc *BlockChain) ProcConsensusMsg(info struct {
 		log.Warn("blockchain-ProcConsensusMsg: recv not have consuensus flag's block")
 		return
 	}
+	// 区块尚未导入 暂存确认 导入后再处理
+	if !bc.HasBlock(info.Hash, info.Number) {
+		if info.Number > bc.StableBlock().NumberU64() {
+			bc.futureVotes.Add(futureVoteKey{info.Hash, string(peerPubKey)}, &futureVote{info.Number, info.SignInfo, peerPubKey})
+		}
+		return
+	}
+	if bc.procVote(info.Hash, info.Number, info.SignInfo, peerPubKey) {
+		bc.checkConsensus(info.Hash, info.Number)
+	}
+}
+
+// futureVoteKey identifies a star vote queued until its block is imported. Every
+// peer has one vote per block.
+type futureVoteKey struct {
+	hash common.Hash
+	peer string
+}
+
+// futureVote is a star vote received before the block it confirms.
+type futureVote struct {
+	number     uint64
+	signInfo   []byte
+	peerPubKey []byte
+}
+
+// procFutureVotes counts the votes queued for a block that was just imported.
+// Votes claiming a different number than the block's are dropped.
+func (bc *BlockChain) procFutureVotes(block *types.Block) {
+	for _, key := range bc.futureVotes.Keys() {
+		if key.(futureVoteKey).hash != block.Hash() {
+			continue
+		}
+		vote, ok := bc.futureVotes.Peek(key)
+		bc.futureVotes.Remove(key)
+		if ok && vote.(*futureVote).number == block.NumberU64() {
+			bc.procVote(block.Hash(), block.NumberU64(), vote.(*futureVote).signInfo, vote.(*futureVote).peerPubKey)
+		}
+	}
+}
+
+// procVote sets the confirmation bit of the star that signed the known block with
+// the given hash and records its vote. The vote must come from the star's own
+// peer connection. It returns whether the vote was counted.
+func (bc *BlockChain) procVote(hash common.Hash, number uint64, signInfo []byte, peerPubKey []byte) bool {
 	// 置签名者对应的确认标识位
 	// Recover the public key and the LoveBlock address
-	pubkey, err := crypto.Ecrecover(info.Hash.Bytes(), info.SignInfo)
+	pubkey, err := crypto.Ecrecover(hash.Bytes(), signInfo)
 	if err != nil {
 		log.Warn("blockchain-ProcConsensusMsg: cann't recover pubkey")
+		return false
+	}
+	stars := bc.starsOf(hash, number)
+	index := stars.IndexByPubkey(pubkey)
+	if index < 0 {
+		log.Warn("blockchain-ProcConsensusMsg: cann't get remote address from star nodes list")
+		return false
+	}
+	// 禁止处理转发的确认标识 签名者须为发送确认的节点
+	if len(peerPubKey) == 0 || !bytes.Equal(stars[index].NodeKey(), peerPubKey[1:]) {
+		log.Warn(fmt.Sprintf("blockchain-ProcConsensusMsg: Recv scam consensus flag. Remote node pubkey:%x", peerPubKey))
+		return false
+	}
+	remoteAddr := stars[index].Addr
+	bc.SetConsensusFlag(hash, number, remoteAddr)
+	bc.addVote(hash, number, signInfo)
+	return true
+}
+
+// sman 收到聚合BLS确认后的处理
+// ProcAggregateVote handles the aggregated BLS votes of a block received from a
+// star peer. Aggregates are self-authenticating, so relayed ones are accepted.
+// The voters' keys are read from the state of the block, so votes for blocks not
+// imported yet are dropped.
+func (bc *BlockChain) ProcAggregateVote(hash common.Hash, number uint64, signers *big.Int, aggregate []byte) {
+	if bc.isStarNode == false || !bc.blsVotes() {
+		log.Warn("blockchain-ProcAggregateVote: not voting with BLS keys, but recv an aggregated vote")
 		return
 	}
-	// 禁止处理转发的确认标识
-	if bytes.Compare(pubkey, peerPubKey) != 0 {
-		log.Warn(fmt.Sprintf("blockchain-ProcConsensusMsg: Recv scam consensus flag. Remote node pubkey:%s", string(peerPubKey)))
+	header := bc.GetHeader(hash, number)
+	if header == nil {
+		log.Debug("blockchain-ProcAggregateVote: chain doesn't have the block", "hash", hash, "number", number)
 		return
 	}
-	remoteAddr := commonDpovp.GetAddressByPubkey(pubkey)
-	epAddr := common.Address{}
-	if remoteAddr == epAddr {
-		log.Warn("blockchain-ProcConsensusMsg: cann't get remote address from star nodes list")
+	if err := bc.verifyAggregate(header, bc.starsOf(hash, number), signers, aggregate); err != nil {
+		log.Warn("blockchain-ProcAggregateVote: invalid aggregated vote", "hash", hash, "err", err)
 		return
 	}
-	bc.SetConsensusFlag(info.Hash, remoteAddr)
-	hashTmp := info.Hash
+	sig, _ := bls.UnmarshalSignature(aggregate)
+	if bc.mergeBLSVotes(hash, number, signers, sig) {
+		bc.checkConsensus(hash, number)
+	}
+}
+
+// sman 判断区块是否得到足够确认, 是则更新稳定块
+func (bc *BlockChain) checkConsensus(hashTmp common.Hash, number uint64) {
 	// 是否有该块 没有则返回
-	if !bc.HasBlock(hashTmp, info.Number) {
-		log.Debug(fmt.Sprintf("blockchain-ProcConsensusMsg: chain doesn't have the block. hash:%s num:%d", common.ToHex(hashTmp[:]), info.Number))
+	if !bc.HasBlock(hashTmp, number) {
+		log.Debug(fmt.Sprintf("blockchain-ProcConsensusMsg: chain doesn't have the block. hash:%s num:%d", common.ToHex(hashTmp[:]), number))
 		return
 	}
 	// 判断是否有2/3以上的确认
 	if bc.VerifyConsensusOK(hashTmp) {
-		log.Info(fmt.Sprintf("blockchain-ProcConsensusMsg: block has consensus. hash:%s num:%d", common.ToHex(hashTmp[:]), info.Number))
-		block := bc.GetBlock(hashTmp, info.Number)
-		if bc.stableBlock.Load().(*types.Block).Header().Number.Int64() < int64(info.Number) { // Stable_block是否已指向该块或该块的子块
-			bc.stableBlock.Store(block) // 将stable_block指向该块
+		log.Info(fmt.Sprintf("blockchain-ProcConsensusMsg: block has consensus. hash:%s num:%d", common.ToHex(hashTmp[:]), number))
+		bc.writeFinalityCertificate(hashTmp, number)
+		block := bc.GetBlock(hashTmp, number)
+		if bc.stableBlock.Load().(*types.Block).Header().Number.Int64() < int64(number) { // Stable_block是否已指向该块或该块的子块
+			bc.SetStableBlock(block) // 将stable_block指向该块
 			log.Debug(fmt.Sprintf("blockchain-ProcConsensusMsg: stableBlock refer to  hash:%s", common.ToHex(hashTmp[:])))
 		}
 		if !bc.isCurAndStableBlockInSameChain() { // current block与stable block不在一条链上
 			newCurBlock := bc.getNewestBlockInStableChain()
 			bc.currentBlock.Store(newCurBlock)
-			log.Debug(fmt.Sprintf("blockchain-ProcConsensusMsg: currentBlock refer to hash:%s num:%d", common.ToHex(hashTmp[:]), info.Number))
+			log.Debug(fmt.Sprintf("blockchain-ProcConsensusMsg: currentBlock refer to hash:%s num:%d", common.ToHex(hashTmp[:]), number))
 		}
 		// 广播给普通节点
-		bc.BroadcastBlock2Satellite(hashTmp, info.Number)
-		log.Debug(fmt.Sprintf("blockchain-ProcConsensusMsg: BroadcastBlock2Satellite hash:%s num:%d", common.ToHex(hashTmp[:]), info.Number))
+		bc.BroadcastBlock2Satellite(hashTmp, number)
+		log.Debug(fmt.Sprintf("blockchain-ProcConsensusMsg: BroadcastBlock2Satellite hash:%s num:%d", common.ToHex(hashTmp[:]), number))
 	}
 }
 
@@ -1452,10 +2210,11 @@ func (bc *BlockChain) getNewestBlockInStableChain() *types.Block {
 
 // sman get a block's leaf Descendants
 func (bc *BlockChain) getLeavesOfBlock(block *types.Block) (blocks []*types.Block) {
-	if len(childrenMap[block.Hash()]) == 0 {
+	children := bc.children(block.Hash())
+	if len(children) == 0 {
 		blocks = append(blocks, block)
 	} else {
-		for _, hash := range childrenMap[block.Hash()] {
+		for _, hash := range children {
 			childBlock := bc.GetBlock(hash, block.Header().Number.Uint64()+1)
 			childLeaves := bc.getLeavesOfBlock(childBlock)
 			for _, grandchildren := range childLeaves {
@@ -1583,6 +2342,12 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 			return fmt.Errorf("Invalid new chain")
 		}
 	}
+	// Final blocks are never reorganised away
+	if len(oldChain) > 0 && commonBlock.NumberU64() < bc.StableBlock().NumberU64() {
+		log.Warn("Refused reorg below stable block", "number", commonBlock.Number(), "hash", commonBlock.Hash(),
+			"drop", len(oldChain), "stable", bc.StableBlock().Number())
+		return ErrStableConflict
+	}
 	// Ensure the user sees large reorgs
 	if len(oldChain) > 0 && len(newChain) > 0 {
 		logFn := log.Debug
@@ -1726,6 +2491,11 @@ func (bc *BlockChain) InsertHeaderChain(chain []*types.Header, checkFreq int) (i
 	bc.wg.Add(1)
 	defer bc.wg.Done()
 
+	for i, header := range chain {
+		if bc.ConflictsWithStable(header) {
+			return i, ErrStableConflict
+		}
+	}
 	whFunc := func(header *types.Header) error {
 		bc.mu.Lock()
 		defer bc.mu.Unlock()
@@ -1831,6 +2601,16 @@ func (bc *BlockChain) SubscribeChainSideEvent(ch chan<- ChainSideEvent) event.Su
 	return bc.scope.Track(bc.chainSideFeed.Subscribe(ch))
 }
 
+// SubscribeEvidenceEvent registers a subscription of EvidenceEvent.
+func (bc *BlockChain) SubscribeEvidenceEvent(ch chan<- EvidenceEvent) event.Subscription {
+	return bc.scope.Track(bc.evidenceFeed.Subscribe(ch))
+}
+
+// SubscribeChainStableEvent registers a subscription of ChainStableEvent.
+func (bc *BlockChain) SubscribeChainStableEvent(ch chan<- ChainStableEvent) event.Subscription {
+	return bc.scope.Track(bc.stableFeed.Subscribe(ch))
+}
+
 // SubscribeLogsEvent registers a subscription of []*types.Log.
 func (bc *BlockChain) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription {
 	return bc.scope.Track(bc.logsFeed.Subscribe(ch))
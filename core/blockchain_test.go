@@ -17,6 +17,7 @@
 package core
 
 import (
+	"crypto/ecdsa"
 	"fmt"
 	"math/big"
 	"math/rand"
@@ -25,13 +26,17 @@ import (
 	"time"
 
 	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/consensus"
 	"github.com/LoveBlock/loveblock/consensus/dpovp"
 	"github.com/LoveBlock/loveblock/core/state"
 	"github.com/LoveBlock/loveblock/core/types"
 	"github.com/LoveBlock/loveblock/core/vm"
 	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/crypto/bls"
 	"github.com/LoveBlock/loveblock/lovedb"
 	"github.com/LoveBlock/loveblock/params"
+	"github.com/LoveBlock/loveblock/rlp"
 )
 
 // Test fork of length N starting from block i
@@ -1335,3 +1340,844 @@ func TestLargeReorgTrieGC(t *testing.T) {
 		}
 	}
 }
+
+// Tests that a star sealing two different blocks on top of the same parent is
+// caught on import, with the evidence stored and announced exactly once.
+func TestEquivocationEvidence(t *testing.T) {
+	var (
+		db, _   = lovedb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig}
+		genesis = gspec.MustCommit(db)
+		engine  = dpovp.NewFaker()
+		star    = common.Address{0x01}
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+
+	events := make(chan EvidenceEvent, 2)
+	sub := blockchain.SubscribeEvidenceEvent(events)
+	defer sub.Unsubscribe()
+
+	makeBlock := func(coinbase common.Address, extra string) *types.Block {
+		blocks, _ := GenerateChain(gspec.Config, genesis, engine, db, 1, func(i int, b *BlockGen) {
+			b.SetCoinbase(coinbase)
+			b.SetExtra([]byte(extra))
+		})
+		return blocks[0]
+	}
+	// Blocks of different stars on the same parent are regular forks
+	for _, block := range []*types.Block{makeBlock(star, "first"), makeBlock(common.Address{0x02}, "other")} {
+		if _, err := blockchain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("failed to insert block: %v", err)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected evidence against %x", ev.Evidence.Offender())
+	default:
+	}
+	// A second block of the same star on the same parent is an equivocation
+	second := makeBlock(star, "second")
+	if _, err := blockchain.InsertChain(types.Blocks{second}); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	select {
+	case ev := <-events:
+		if ev.Evidence.Offender() != star {
+			t.Errorf("offender mismatch: have %x, want %x", ev.Evidence.Offender(), star)
+		}
+		if blockchain.GetEvidence(ev.Evidence.Hash()) == nil {
+			t.Errorf("evidence not stored")
+		}
+		if added, err := blockchain.AddEvidence(ev.Evidence); added || err != nil {
+			t.Errorf("known evidence re-added: added %v, err %v", added, err)
+		}
+	default:
+		t.Fatalf("no evidence reported for equivocating star")
+	}
+}
+
+// starsEngine is a fake dpovp engine scheduling over a fixed star list.
+type starsEngine struct {
+	consensus.Engine
+	stars commonDpovp.StarList
+}
+
+func (e *starsEngine) Stars(chain consensus.ChainReader, parent *types.Header) commonDpovp.StarList {
+	return e.stars
+}
+
+// epochStarsEngine is a fake dpovp engine scheduling the stars of the first list
+// for the first block and those of the second list afterwards.
+type epochStarsEngine struct {
+	consensus.Engine
+	first, second commonDpovp.StarList
+}
+
+func (e *epochStarsEngine) Stars(chain consensus.ChainReader, parent *types.Header) commonDpovp.StarList {
+	if parent.Number.Sign() == 0 {
+		return e.first
+	}
+	return e.second
+}
+
+// Tests that confirmations are assigned to and counted against the stars that
+// were scheduled for the block, not the ones scheduled after the current head.
+func TestConsensusFlagStars(t *testing.T) {
+	first := make(commonDpovp.StarList, 3)
+	for i := range first {
+		first[i].Addr = common.BigToAddress(big.NewInt(int64(i + 1)))
+	}
+	second := commonDpovp.StarList{first[1], first[2], {Addr: common.Address{0x04}}, {Addr: common.Address{0x05}}}
+
+	db, _ := lovedb.NewMemDatabase()
+	genesis := (&Genesis{Config: params.TestChainConfig}).MustCommit(db)
+	blockchain, _ := NewBlockChain(db, nil, params.TestChainConfig, &epochStarsEngine{dpovp.NewFaker(), first, second}, vm.Config{})
+	defer blockchain.Stop()
+
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, dpovp.NewFaker(), db, 2, nil)
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	hash := blocks[0].Hash()
+	blockchain.SetConsensusFlag(hash, 1, first[0].Addr)
+	blockchain.SetConsensusFlag(hash, 1, first[1].Addr)
+	if blockchain.VerifyConsensusOK(hash) {
+		t.Errorf("block confirmed by 2 of 3 stars")
+	}
+	blockchain.SetConsensusFlag(hash, 1, first[2].Addr)
+	if flag, _ := blockchain.ConsensusFlag(hash); flag.Cmp(big.NewInt(7)) != 0 {
+		t.Errorf("confirmation flag mismatch: have %b, want 111", flag)
+	}
+	// The first star left the head's list, which the other two don't make a quorum of
+	if !blockchain.VerifyConsensusOK(hash) {
+		t.Errorf("block not confirmed by all of its stars")
+	}
+}
+
+// Tests that blocks only become confirmed by a strict supermajority of the stars,
+// including star sets too large for a 64 bit confirmation mask.
+func TestConsensusQuorum(t *testing.T) {
+	halfConfig := &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: &params.DpovpConfig{QuorumNumerator: 1, QuorumDenominator: 2}}
+
+	tests := []struct {
+		config   *params.ChainConfig
+		stars    int
+		confirms int
+		ok       bool
+	}{
+		{params.TestChainConfig, 1, 1, true},
+		{params.TestChainConfig, 2, 1, false},
+		{params.TestChainConfig, 2, 2, true},
+		{params.TestChainConfig, 3, 2, false},
+		{params.TestChainConfig, 3, 3, true},
+		{params.TestChainConfig, 4, 2, false},
+		{params.TestChainConfig, 4, 3, true},
+		{params.TestChainConfig, 6, 4, false},
+		{params.TestChainConfig, 6, 5, true},
+		{params.TestChainConfig, 7, 4, false},
+		{params.TestChainConfig, 7, 5, true},
+		{params.TestChainConfig, 64, 42, false},
+		{params.TestChainConfig, 64, 43, true},
+		{params.TestChainConfig, 65, 43, false},
+		{params.TestChainConfig, 65, 44, true},
+		{params.TestChainConfig, 100, 66, false},
+		{params.TestChainConfig, 100, 67, true},
+		{halfConfig, 4, 2, false},
+		{halfConfig, 4, 3, true},
+		{halfConfig, 5, 3, true},
+	}
+	for i, tt := range tests {
+		stars := make(commonDpovp.StarList, tt.stars)
+		for j := range stars {
+			stars[j].Addr = common.BigToAddress(big.NewInt(int64(j + 1)))
+		}
+		db, _ := lovedb.NewMemDatabase()
+		(&Genesis{Config: tt.config}).MustCommit(db)
+		blockchain, _ := NewBlockChain(db, nil, tt.config, &starsEngine{dpovp.NewFaker(), stars}, vm.Config{})
+
+		// Confirm by the last stars, so large sets hit the high indices
+		hash := common.HexToHash("0x01")
+		for _, star := range stars[tt.stars-tt.confirms:] {
+			blockchain.SetConsensusFlag(hash, 1, star.Addr)
+		}
+		if ok := blockchain.VerifyConsensusOK(hash); ok != tt.ok {
+			t.Errorf("test %d: %d/%d confirmations: have %v, want %v", i, tt.confirms, tt.stars, ok, tt.ok)
+		}
+		if flag, _ := blockchain.ConsensusFlag(hash); flag.Bit(tt.stars-1) != 1 {
+			t.Errorf("test %d: confirmation of last star lost", i)
+		}
+		blockchain.Stop()
+	}
+}
+
+// Tests that a star confirms a single block per height, so that competing blocks
+// of the same height can never both gather a quorum.
+func TestConfirmOncePerHeight(t *testing.T) {
+	stars := commonDpovp.StarList{{Addr: common.Address{0x01}}, {Addr: common.Address{0x02}}, {Addr: common.Address{0x03}}}
+	var (
+		db, _   = lovedb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig}
+		genesis = gspec.MustCommit(db)
+		engine  = &starsEngine{dpovp.NewFaker(), stars}
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+
+	flags := make(map[common.Hash]bool)
+	blockchain.SetIsStarNode(true)
+	blockchain.SetCoinbase(stars[0].Addr)
+	blockchain.BroadcastConFn = func(hash common.Hash, number uint64, hasFlag bool) { flags[hash] = hasFlag }
+	blockchain.BroadcastBlock2Satellite = func(common.Hash, uint64) {}
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, engine, db, 1, func(i int, b *BlockGen) { b.SetCoinbase(stars[1].Addr) })
+	sides, _ := GenerateChain(gspec.Config, genesis, engine, db, 1, func(i int, b *BlockGen) { b.SetCoinbase(stars[2].Addr) })
+	for _, chain := range []types.Blocks{blocks, sides} {
+		if _, err := blockchain.InsertChain(chain); err != nil {
+			t.Fatalf("failed to insert block: %v", err)
+		}
+	}
+	if flag, _ := blockchain.ConsensusFlag(blocks[0].Hash()); flag.Bit(0) != 1 {
+		t.Errorf("first block of the height not confirmed")
+	}
+	if flag, _ := blockchain.ConsensusFlag(sides[0].Hash()); flag.Bit(0) != 0 {
+		t.Errorf("competing block of the same height confirmed")
+	}
+	if !flags[blocks[0].Hash()] || flags[sides[0].Hash()] {
+		t.Errorf("confirmation broadcast mismatch: have %v for the first block, %v for the competing one", flags[blocks[0].Hash()], flags[sides[0].Hash()])
+	}
+}
+
+// Tests that a star drops a block it sealed if it confirmed a competing block of
+// the same height meanwhile.
+func TestConfirmMined(t *testing.T) {
+	stars := commonDpovp.StarList{{Addr: common.Address{0x01}}, {Addr: common.Address{0x02}}, {Addr: common.Address{0x03}}}
+	var (
+		db, _   = lovedb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig}
+		genesis = gspec.MustCommit(db)
+		engine  = &starsEngine{dpovp.NewFaker(), stars}
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+
+	blockchain.SetIsStarNode(true)
+	blockchain.SetCoinbase(stars[0].Addr)
+	blockchain.BroadcastConFn = func(common.Hash, uint64, bool) {}
+	blockchain.BroadcastBlock2Satellite = func(common.Hash, uint64) {}
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, engine, db, 1, func(i int, b *BlockGen) { b.SetCoinbase(stars[1].Addr) })
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	sealed, _ := GenerateChain(gspec.Config, genesis, engine, db, 1, func(i int, b *BlockGen) { b.SetCoinbase(stars[0].Addr) })
+	if blockchain.ConfirmMined(sealed[0].Hash(), 1) {
+		t.Errorf("sealed block confirmed next to a competing block of the height")
+	}
+	next, _ := GenerateChain(gspec.Config, blocks[0], engine, db, 1, func(i int, b *BlockGen) { b.SetCoinbase(stars[0].Addr) })
+	if !blockchain.ConfirmMined(next[0].Hash(), 2) {
+		t.Errorf("sealed block of a new height not confirmed")
+	}
+	if flag, _ := blockchain.ConsensusFlag(next[0].Hash()); flag.Bit(0) != 1 {
+		t.Errorf("sealed block not flagged as confirmed by its author")
+	}
+	// Nodes that aren't stars don't confirm, so they never drop their blocks
+	blockchain.SetIsStarNode(false)
+	if !blockchain.ConfirmMined(sealed[0].Hash(), 1) {
+		t.Errorf("sealed block dropped by a node that isn't a star")
+	}
+}
+
+// Tests that the stable block and the confirmations above it survive a restart,
+// while the bookkeeping of blocks below the stable block is dropped.
+func TestStableBlockPersistence(t *testing.T) {
+	var (
+		db, _   = lovedb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig}
+		genesis = gspec.MustCommit(db)
+		star    = common.Address{0x01}
+		engine  = &starsEngine{dpovp.NewFaker(), commonDpovp.StarList{{Addr: star}}}
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, engine, vm.Config{})
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, engine, db, 3, nil)
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	blockchain.SetConsensusFlag(blocks[0].Hash(), 1, star)
+	blockchain.SetConsensusFlag(blocks[2].Hash(), 3, star)
+	blockchain.SetStableBlock(blocks[1])
+	blockchain.Stop()
+
+	// Reopen the chain and check that finality wasn't forgotten
+	blockchain, _ = NewBlockChain(db, nil, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+
+	if stable := blockchain.StableBlock(); stable.Hash() != blocks[1].Hash() {
+		t.Errorf("stable block mismatch: have #%d [%x…], want #%d [%x…]", stable.NumberU64(), stable.Hash().Bytes()[:4], blocks[1].NumberU64(), blocks[1].Hash().Bytes()[:4])
+	}
+	if _, ok := blockchain.ConsensusFlag(blocks[0].Hash()); ok {
+		t.Errorf("confirmations below the stable block not pruned")
+	}
+	if entry := GetConsensusEntry(db, blocks[0].Hash()); entry != nil {
+		t.Errorf("pruned confirmations not deleted from the database")
+	}
+	if flag, ok := blockchain.ConsensusFlag(blocks[2].Hash()); !ok || flag.Bit(0) != 1 {
+		t.Errorf("confirmations above the stable block lost: have %v, %v", flag, ok)
+	}
+	// Resetting the chain forgets finality
+	if err := blockchain.Reset(); err != nil {
+		t.Fatalf("failed to reset chain: %v", err)
+	}
+	if stable := blockchain.StableBlock(); stable.Hash() != genesis.Hash() {
+		t.Errorf("stable block not reset to genesis: have #%d", stable.NumberU64())
+	}
+	if hash := GetHeadStableBlockHash(db); hash != genesis.Hash() {
+		t.Errorf("persisted stable block not reset: have %x", hash)
+	}
+	if entries := GetConsensusEntries(db); len(entries) != 0 {
+		t.Errorf("persisted confirmations not reset: have %d entries", len(entries))
+	}
+	if entry := GetConsensusEntry(db, blocks[2].Hash()); entry != nil {
+		t.Errorf("reset confirmations not deleted from the database")
+	}
+}
+
+// Tests that the votes of confirming stars are collected into a finality
+// certificate once a block reaches the quorum, and that certificates are only
+// accepted with the votes of a quorum of the block's stars.
+func TestFinalityCertificate(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	stars := make(commonDpovp.StarList, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		stars[i] = commonDpovp.AddrNodeIDMapping{Addr: crypto.PubkeyToAddress(keys[i].PublicKey), Pubkey: crypto.FromECDSAPub(&keys[i].PublicKey)[1:]}
+	}
+	var (
+		db, _   = lovedb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig}
+		genesis = gspec.MustCommit(db)
+		engine  = &starsEngine{dpovp.NewFaker(), stars}
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+
+	// Run the chain as the second star, importing a block of the first one
+	blockchain.SetIsStarNode(true)
+	blockchain.SetCoinbase(stars[1].Addr)
+	blockchain.BroadcastConFn = func(common.Hash, uint64, bool) {}
+	blockchain.BroadcastBlock2Satellite = func(common.Hash, uint64) {}
+	commonDpovp.SetPrivKey(keys[1])
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, engine, db, 1, func(i int, b *BlockGen) {
+		b.SetCoinbase(stars[0].Addr)
+	})
+	block := blocks[0]
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	if cert := blockchain.GetFinalityCertificate(block.Hash()); cert != nil {
+		t.Fatalf("certificate created below quorum")
+	}
+	// Deliver the vote of the third star, finalizing the block
+	vote := func(key *ecdsa.PrivateKey) []byte {
+		sig, err := crypto.Sign(block.Hash().Bytes(), key)
+		if err != nil {
+			t.Fatalf("failed to sign vote: %v", err)
+		}
+		return sig
+	}
+	blockchain.ProcConsensusMsg(struct {
+		Hash         common.Hash
+		Number       uint64
+		HasConsensus uint8
+		SignInfo     []byte
+	}{block.Hash(), block.NumberU64(), 1, vote(keys[2])}, crypto.FromECDSAPub(&keys[2].PublicKey))
+
+	cert := blockchain.GetFinalityCertificate(block.Hash())
+	if cert == nil {
+		t.Fatalf("no certificate for finalized block")
+	}
+	if len(cert.Votes) != 2 {
+		t.Errorf("vote count mismatch: have %d, want 2", len(cert.Votes))
+	}
+	if err := blockchain.VerifyFinalityCertificate(cert); err != nil {
+		t.Errorf("failed to verify collected certificate: %v", err)
+	}
+	// Check certificates assembled by others
+	stranger, _ := crypto.GenerateKey()
+	tests := []struct {
+		cert *types.FinalityCertificate
+		err  error
+	}{
+		{&types.FinalityCertificate{Hash: block.Hash(), Number: 1, Votes: [][]byte{vote(keys[1]), vote(keys[2])}}, nil},
+		{&types.FinalityCertificate{Hash: block.Hash(), Number: 1, Votes: [][]byte{vote(keys[2])}}, ErrInsufficientVotes},
+		{&types.FinalityCertificate{Hash: block.Hash(), Number: 1, Votes: [][]byte{vote(keys[2]), vote(keys[2])}}, ErrInsufficientVotes},
+		{&types.FinalityCertificate{Hash: block.Hash(), Number: 1, Votes: [][]byte{vote(keys[1]), vote(stranger)}}, ErrUnknownVoter},
+		{&types.FinalityCertificate{Hash: common.Hash{0x01}, Number: 1, Votes: [][]byte{vote(keys[1]), vote(keys[2])}}, ErrUnknownFinalityBlock},
+	}
+	for i, tt := range tests {
+		if err := blockchain.VerifyFinalityCertificate(tt.cert); err != tt.err {
+			t.Errorf("test %d: verification error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+	if added, err := blockchain.AddFinalityCertificate(tests[0].cert); added || err != nil {
+		t.Errorf("known certificate re-added: added %v, err %v", added, err)
+	}
+}
+
+// Tests that the votes of stars signing with a key other than their node key are
+// accepted from the star's own peer connection only.
+func TestSeparateSigningKey(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	stars := make(commonDpovp.StarList, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		stars[i] = commonDpovp.AddrNodeIDMapping{Addr: crypto.PubkeyToAddress(keys[i].PublicKey), Pubkey: crypto.FromECDSAPub(&keys[i].PublicKey)[1:]}
+	}
+	nodeKey, _ := crypto.GenerateKey()
+	stars[2].NodeID = crypto.FromECDSAPub(&nodeKey.PublicKey)[1:]
+
+	var (
+		db, _   = lovedb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig}
+		genesis = gspec.MustCommit(db)
+		engine  = &starsEngine{dpovp.NewFaker(), stars}
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+
+	blockchain.SetIsStarNode(true)
+	blockchain.SetCoinbase(stars[1].Addr)
+	blockchain.BroadcastConFn = func(common.Hash, uint64, bool) {}
+	blockchain.BroadcastBlock2Satellite = func(common.Hash, uint64) {}
+	commonDpovp.SetPrivKey(keys[1])
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, engine, db, 1, func(i int, b *BlockGen) {
+		b.SetCoinbase(stars[0].Addr)
+	})
+	block := blocks[0]
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	sig, _ := crypto.Sign(block.Hash().Bytes(), keys[2])
+	deliver := func(peer *ecdsa.PrivateKey) {
+		blockchain.ProcConsensusMsg(struct {
+			Hash         common.Hash
+			Number       uint64
+			HasConsensus uint8
+			SignInfo     []byte
+		}{block.Hash(), block.NumberU64(), 1, sig}, crypto.FromECDSAPub(&peer.PublicKey))
+	}
+	// Neither relayed votes nor votes from a peer using the signing key count
+	deliver(keys[0])
+	deliver(keys[2])
+	if cert := blockchain.GetFinalityCertificate(block.Hash()); cert != nil {
+		t.Fatalf("vote accepted from a peer other than the star")
+	}
+	deliver(nodeKey)
+	if cert := blockchain.GetFinalityCertificate(block.Hash()); cert == nil {
+		t.Fatalf("vote of the star's node rejected")
+	}
+}
+
+// Tests that votes of blocks not imported yet are queued without creating any
+// bookkeeping, and counted once the block arrives under the number voted for.
+func TestFutureVotes(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	stars := make(commonDpovp.StarList, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		stars[i] = commonDpovp.AddrNodeIDMapping{Addr: crypto.PubkeyToAddress(keys[i].PublicKey), Pubkey: crypto.FromECDSAPub(&keys[i].PublicKey)[1:]}
+	}
+	var (
+		db, _   = lovedb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig}
+		genesis = gspec.MustCommit(db)
+		engine  = &starsEngine{dpovp.NewFaker(), stars}
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+
+	blockchain.SetIsStarNode(true)
+	blockchain.SetCoinbase(stars[1].Addr)
+	blockchain.BroadcastConFn = func(common.Hash, uint64, bool) {}
+	blockchain.BroadcastBlock2Satellite = func(common.Hash, uint64) {}
+	commonDpovp.SetPrivKey(keys[1])
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, engine, db, 2, func(i int, b *BlockGen) {
+		b.SetCoinbase(stars[0].Addr)
+	})
+	deliver := func(block *types.Block, number uint64) {
+		sig, _ := crypto.Sign(block.Hash().Bytes(), keys[2])
+		blockchain.ProcConsensusMsg(struct {
+			Hash         common.Hash
+			Number       uint64
+			HasConsensus uint8
+			SignInfo     []byte
+		}{block.Hash(), number, 1, sig}, crypto.FromECDSAPub(&keys[2].PublicKey))
+	}
+	// Votes ahead of their blocks, one of them with an inflated number
+	deliver(blocks[0], 1)
+	deliver(blocks[1], 1000)
+	for _, block := range blocks {
+		if _, ok := blockchain.ConsensusFlag(block.Hash()); ok {
+			t.Fatalf("confirmation recorded for unknown block #%d", block.NumberU64())
+		}
+		if entry := GetConsensusEntry(db, block.Hash()); entry != nil {
+			t.Fatalf("confirmation of unknown block #%d persisted", block.NumberU64())
+		}
+	}
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert blocks: %v", err)
+	}
+	if cert := blockchain.GetFinalityCertificate(blocks[0].Hash()); cert == nil {
+		t.Errorf("queued vote not counted on import")
+	}
+	if cert := blockchain.GetFinalityCertificate(blocks[1].Hash()); cert != nil {
+		t.Errorf("vote for a mismatching number counted")
+	}
+}
+
+// Tests that subscribers not reading stable block events don't stall imports.
+func TestSlowStableSubscriber(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	stars := make(commonDpovp.StarList, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		stars[i] = commonDpovp.AddrNodeIDMapping{Addr: crypto.PubkeyToAddress(keys[i].PublicKey), Pubkey: crypto.FromECDSAPub(&keys[i].PublicKey)[1:]}
+	}
+	var (
+		db, _   = lovedb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig}
+		genesis = gspec.MustCommit(db)
+		engine  = &starsEngine{dpovp.NewFaker(), stars}
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+
+	stableCh := make(chan ChainStableEvent)
+	stableSub := blockchain.SubscribeChainStableEvent(stableCh)
+	defer stableSub.Unsubscribe()
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, engine, db, 3, func(i int, b *BlockGen) {
+		b.SetCoinbase(stars[0].Addr)
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, block := range blocks {
+			cert := &types.FinalityCertificate{Hash: block.Hash(), Number: block.NumberU64()}
+			for _, key := range keys[1:] {
+				sig, _ := crypto.Sign(block.Hash().Bytes(), key)
+				cert.Votes = append(cert.Votes, sig)
+			}
+			blockchain.AddFinalityCertificate(cert)
+			blockchain.InsertChain(types.Blocks{block})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("import stalled by stable block subscriber")
+	}
+	if stable := blockchain.StableBlock(); stable.Hash() != blocks[2].Hash() {
+		t.Errorf("stable block mismatch: have #%d, want #3", stable.NumberU64())
+	}
+	// The events are still delivered in order
+	for _, want := range blocks {
+		select {
+		case ev := <-stableCh:
+			if ev.Block.Hash() != want.Hash() {
+				t.Errorf("stable event mismatch: have #%d, want #%d", ev.Block.NumberU64(), want.NumberU64())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("missing stable event for #%d", want.NumberU64())
+		}
+	}
+}
+
+// Tests that BLS votes of the stars are aggregated into a single signature of the
+// finality certificate, verifiable against the registered keys of the signers.
+func TestAggregatedFinality(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	blsKeys := make([]*bls.SecretKey, len(keys))
+	stars := make(commonDpovp.StarList, len(keys))
+	registered := make(map[common.Address][]byte)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		blsKeys[i] = bls.DeriveKey(crypto.FromECDSA(keys[i]))
+		stars[i] = commonDpovp.AddrNodeIDMapping{Addr: crypto.PubkeyToAddress(keys[i].PublicKey), Pubkey: crypto.FromECDSAPub(&keys[i].PublicKey)[1:]}
+		if i > 0 { // The block author doesn't need a key, its seal confirms the block
+			registered[stars[i].Addr] = blsKeys[i].PublicKey().Marshal()
+		}
+	}
+	var (
+		db, _  = lovedb.NewMemDatabase()
+		config = &params.ChainConfig{ChainId: big.NewInt(1), Dpovp: &params.DpovpConfig{SealHashBlock: big.NewInt(0), BLSVotes: true}}
+		gspec  = &Genesis{
+			Config: config,
+			Alloc: GenesisAlloc{commonDpovp.BLSRegistryAddress: {
+				Balance: new(big.Int),
+				Nonce:   1,
+				Storage: commonDpovp.BLSRegistryStorage(registered),
+			}},
+		}
+		genesis = gspec.MustCommit(db)
+		engine  = &starsEngine{dpovp.NewFaker(), stars}
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+
+	blockchain.SetIsStarNode(true)
+	blockchain.SetCoinbase(stars[1].Addr)
+	blockchain.BroadcastConFn = func(common.Hash, uint64, bool) {}
+	blockchain.BroadcastBlock2Satellite = func(common.Hash, uint64) {}
+	commonDpovp.SetPrivKey(keys[1])
+
+	// The block author registers its key only after the certified block, which
+	// mustn't make its votes for the block count
+	blocks, _ := GenerateChain(gspec.Config, genesis, engine, db, 2, func(i int, b *BlockGen) {
+		b.SetCoinbase(stars[0].Addr)
+		if i == 1 {
+			data, _ := rlp.EncodeToBytes(&commonDpovp.BLSRegistration{Pubkey: blsKeys[0].PublicKey().Marshal(), Proof: blsKeys[0].SignPossession().Marshal()})
+			tx, _ := types.SignTx(types.NewTransaction(0, commonDpovp.BLSRegistryAddress, new(big.Int), 100000, new(big.Int), data), types.MakeSigner(config, b.Number()), keys[0])
+			b.AddTx(tx)
+		}
+	})
+	block := blocks[0]
+	if _, err := blockchain.InsertChain(blocks[:1]); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	signers, _, ok := blockchain.ConsensusAggregate(block.Hash())
+	if !ok || signers.Cmp(big.NewInt(1<<1)) != 0 {
+		t.Fatalf("local vote not aggregated: have %v", signers)
+	}
+	// Invalid aggregates must be ignored
+	sign := func(i int) *bls.Signature { return blsKeys[i].Sign(block.Hash().Bytes()) }
+	blockchain.ProcAggregateVote(block.Hash(), 1, big.NewInt(1<<2), sign(1).Marshal())
+	if cert := blockchain.GetFinalityCertificate(block.Hash()); cert != nil {
+		t.Fatalf("block finalized by forged vote")
+	}
+	// A relayed aggregate of the last star finalizes the block
+	blockchain.ProcAggregateVote(block.Hash(), 1, big.NewInt(1<<2), sign(2).Marshal())
+
+	cert := blockchain.GetFinalityCertificate(block.Hash())
+	if cert == nil {
+		t.Fatalf("no certificate for finalized block")
+	}
+	if len(cert.Votes) != 0 || cert.Signers.Cmp(big.NewInt(1<<1|1<<2)) != 0 {
+		t.Errorf("certificate votes mismatch: have %d votes, signers %v", len(cert.Votes), cert.Signers)
+	}
+	if err := blockchain.VerifyFinalityCertificate(cert); err != nil {
+		t.Errorf("failed to verify aggregated certificate: %v", err)
+	}
+	if _, err := blockchain.InsertChain(blocks[1:]); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	aggregate := bls.AggregateSignatures(sign(1), sign(2)).Marshal()
+	tests := []struct {
+		signers   int64
+		aggregate []byte
+		err       error
+	}{
+		{1<<1 | 1<<2, aggregate, nil},
+		{1 << 1, aggregate, ErrInvalidAggregate},
+		{1 << 1, sign(1).Marshal(), ErrInsufficientVotes},
+		{1<<0 | 1<<1, bls.AggregateSignatures(sign(0), sign(1)).Marshal(), ErrMissingBLSKey},
+		{1<<1 | 1<<3, aggregate, ErrUnknownVoter},
+	}
+	for i, tt := range tests {
+		cert := &types.FinalityCertificate{Hash: block.Hash(), Number: 1, Signers: big.NewInt(tt.signers), Aggregate: tt.aggregate}
+		if err := blockchain.VerifyFinalityCertificate(cert); err != tt.err {
+			t.Errorf("test %d: verification error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// Tests that nodes not voting themselves advance the stable block on verified
+// finality certificates, and never reorganise it away.
+func TestSatelliteFinality(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	stars := make(commonDpovp.StarList, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		stars[i] = commonDpovp.AddrNodeIDMapping{Addr: crypto.PubkeyToAddress(keys[i].PublicKey), Pubkey: crypto.FromECDSAPub(&keys[i].PublicKey)[1:]}
+	}
+	var (
+		db, _   = lovedb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig}
+		genesis = gspec.MustCommit(db)
+		engine  = &starsEngine{dpovp.NewFaker(), stars}
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+
+	stableCh := make(chan ChainStableEvent, 10)
+	stableSub := blockchain.SubscribeChainStableEvent(stableCh)
+	defer stableSub.Unsubscribe()
+
+	certify := func(block *types.Block, voters ...int) *types.FinalityCertificate {
+		cert := &types.FinalityCertificate{Hash: block.Hash(), Number: block.NumberU64()}
+		for _, i := range voters {
+			sig, err := crypto.Sign(block.Hash().Bytes(), keys[i])
+			if err != nil {
+				t.Fatalf("failed to sign vote: %v", err)
+			}
+			cert.Votes = append(cert.Votes, sig)
+		}
+		return cert
+	}
+	blocks, _ := GenerateChain(gspec.Config, genesis, engine, db, 4, func(i int, b *BlockGen) {
+		b.SetCoinbase(stars[0].Addr)
+	})
+	if _, err := blockchain.InsertChain(blocks[:2]); err != nil {
+		t.Fatalf("failed to insert blocks: %v", err)
+	}
+	// Certificates below the quorum are rejected
+	if _, err := blockchain.AddFinalityCertificate(certify(blocks[1], 1)); err != ErrInsufficientVotes {
+		t.Fatalf("insufficient certificate error mismatch: have %v, want %v", err, ErrInsufficientVotes)
+	}
+	if stable := blockchain.StableBlock(); stable.Hash() != genesis.Hash() {
+		t.Fatalf("stable block advanced without quorum: have #%d", stable.NumberU64())
+	}
+	if added, err := blockchain.AddFinalityCertificate(certify(blocks[1], 1, 2)); !added || err != nil {
+		t.Fatalf("failed to add certificate: added %v, err %v", added, err)
+	}
+	if stable := blockchain.StableBlock(); stable.Hash() != blocks[1].Hash() {
+		t.Fatalf("stable block mismatch: have #%d, want #2", stable.NumberU64())
+	}
+	// Certificates arriving ahead of their block are applied on import
+	if _, err := blockchain.AddFinalityCertificate(certify(blocks[2], 1, 2)); err != ErrUnknownFinalityBlock {
+		t.Fatalf("early certificate error mismatch: have %v, want %v", err, ErrUnknownFinalityBlock)
+	}
+	if _, err := blockchain.InsertChain(blocks[2:3]); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	if stable := blockchain.StableBlock(); stable.Hash() != blocks[2].Hash() {
+		t.Fatalf("stable block mismatch: have #%d, want #3", stable.NumberU64())
+	}
+	// A longer fork below the stable block must not become canonical
+	forks, _ := GenerateChain(gspec.Config, genesis, engine, db, 6, func(i int, b *BlockGen) {
+		b.SetCoinbase(stars[1].Addr)
+	})
+	if _, err := blockchain.InsertChain(forks); err != ErrStableConflict {
+		t.Fatalf("fork insertion error mismatch: have %v, want %v", err, ErrStableConflict)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != blocks[2].Hash() {
+		t.Fatalf("head reorganised below stable block: have #%d [%x…]", head.NumberU64(), head.Hash().Bytes()[:4])
+	}
+	// A fork above the stable block is made canonical once proven final
+	sides, _ := GenerateChain(gspec.Config, blocks[2], engine, db, 1, func(i int, b *BlockGen) {
+		b.SetCoinbase(stars[1].Addr)
+	})
+	if _, err := blockchain.InsertChain(blocks[3:]); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	if _, err := blockchain.InsertChain(sides); err != nil {
+		t.Fatalf("failed to insert side block: %v", err)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != sides[0].Hash() {
+		t.Fatalf("head mismatch: have [%x…], want side block [%x…]", head.Hash().Bytes()[:4], sides[0].Hash().Bytes()[:4])
+	}
+	if _, err := blockchain.AddFinalityCertificate(certify(blocks[3], 1, 2)); err != nil {
+		t.Fatalf("failed to add certificate: %v", err)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != blocks[3].Hash() {
+		t.Errorf("head mismatch: have [%x…], want finalized block [%x…]", head.Hash().Bytes()[:4], blocks[3].Hash().Bytes()[:4])
+	}
+	if hash := GetCanonicalHash(db, 4); hash != blocks[3].Hash() {
+		t.Errorf("canonical hash mismatch: have [%x…], want [%x…]", hash.Bytes()[:4], blocks[3].Hash().Bytes()[:4])
+	}
+	if stable := blockchain.StableBlock(); stable.Hash() != blocks[3].Hash() {
+		t.Errorf("stable block mismatch: have #%d, want #4", stable.NumberU64())
+	}
+	// Every advance of the stable block must have been announced
+	for _, want := range []*types.Block{blocks[1], blocks[2], blocks[3]} {
+		select {
+		case ev := <-stableCh:
+			if ev.Block.Hash() != want.Hash() {
+				t.Errorf("stable event mismatch: have #%d, want #%d", ev.Block.NumberU64(), want.NumberU64())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("missing stable event for #%d", want.NumberU64())
+		}
+	}
+}
+
+// Tests that no import path reorganises away the stable block, while forks above
+// it are still handled as usual.
+func TestStableReorgProtection(t *testing.T) {
+	var (
+		db, _   = lovedb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig}
+		genesis = gspec.MustCommit(db)
+		engine  = dpovp.NewFaker()
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, engine, db, 6, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{0x01})
+	})
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert blocks: %v", err)
+	}
+	blockchain.SetStableBlock(blocks[2])
+
+	// Longer forks from below the stable block are rejected, blocks and headers alike
+	forks, _ := GenerateChain(gspec.Config, blocks[0], engine, db, 8, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{0x02})
+	})
+	if n, err := blockchain.InsertChain(forks); err != ErrStableConflict || n != 0 {
+		t.Errorf("fork insertion mismatch: have %d/%v, want 0/%v", n, err, ErrStableConflict)
+	}
+	headers := make([]*types.Header, len(forks))
+	for i, block := range forks {
+		headers[i] = block.Header()
+	}
+	if _, err := blockchain.InsertHeaderChain(headers, 1); err != ErrStableConflict {
+		t.Errorf("fork header insertion error mismatch: have %v, want %v", err, ErrStableConflict)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != blocks[5].Hash() {
+		t.Fatalf("head mismatch: have #%d [%x…], want #6", head.NumberU64(), head.Hash().Bytes()[:4])
+	}
+	// Known canonical blocks below the stable block don't conflict
+	for _, block := range blocks {
+		if blockchain.ConflictsWithStable(block.Header()) {
+			t.Errorf("canonical block #%d conflicts with stable block", block.NumberU64())
+		}
+	}
+	// Reorganisations dropping the stable block fail even if requested directly
+	for _, block := range forks {
+		if err := WriteBlock(db, block); err != nil {
+			t.Fatalf("failed to write fork block: %v", err)
+		}
+	}
+	blockchain.mu.Lock()
+	err := blockchain.reorg(blocks[5], forks[len(forks)-1])
+	blockchain.mu.Unlock()
+	if err != ErrStableConflict {
+		t.Errorf("reorg error mismatch: have %v, want %v", err, ErrStableConflict)
+	}
+	// Forks above the stable block are still imported
+	sides, _ := GenerateChain(gspec.Config, blocks[2], engine, db, 5, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{0x02})
+	})
+	if _, err := blockchain.InsertChain(sides); err != nil {
+		t.Fatalf("failed to insert fork above stable block: %v", err)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != sides[4].Hash() {
+		t.Errorf("head mismatch: have #%d, want fork head #%d", head.NumberU64(), sides[4].NumberU64())
+	}
+	// Rewinding below the stable block moves it down to the new head
+	if err := blockchain.SetHead(1); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if stable := blockchain.StableBlock(); stable.Hash() != blocks[0].Hash() {
+		t.Errorf("stable block mismatch after rewind: have #%d, want #1", stable.NumberU64())
+	}
+	if hash := GetHeadStableBlockHash(db); hash != blocks[0].Hash() {
+		t.Errorf("persisted stable block mismatch: have %x, want %x", hash, blocks[0].Hash())
+	}
+}
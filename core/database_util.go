@@ -26,8 +26,8 @@ import (
 
 	"github.com/LoveBlock/loveblock/common"
 	"github.com/LoveBlock/loveblock/core/types"
-	"github.com/LoveBlock/loveblock/lovedb"
 	"github.com/LoveBlock/loveblock/log"
+	"github.com/LoveBlock/loveblock/lovedb"
 	"github.com/LoveBlock/loveblock/metrics"
 	"github.com/LoveBlock/loveblock/params"
 	"github.com/LoveBlock/loveblock/rlp"
@@ -49,6 +49,9 @@ var (
 	headFastKey   = []byte("LastFast")
 	trieSyncKey   = []byte("TrieSync")
 
+	headStableKey     = []byte("LastStable")     // hash of the current stable block
+	consensusIndexKey = []byte("ConsensusIndex") // hashes of the blocks above the stable block with consensus bookkeeping
+
 	// Data item prefixes (use single byte to avoid mixing data types, avoid `i`).
 	headerPrefix        = []byte("h") // headerPrefix + num (uint64 big endian) + hash -> header
 	tdSuffix            = []byte("t") // headerPrefix + num (uint64 big endian) + hash + tdSuffix -> td
@@ -59,6 +62,10 @@ var (
 	lookupPrefix        = []byte("l") // lookupPrefix + hash -> transaction/receipt lookup metadata
 	bloomBitsPrefix     = []byte("B") // bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash -> bloom bits
 
+	consensusPrefix = []byte("consensus-") // consensusPrefix + hash -> confirmations, votes and children of a block
+	evidencePrefix  = []byte("evidence-")  // evidencePrefix + hash -> equivocation evidence
+	finalityPrefix  = []byte("finality-")  // finalityPrefix + hash -> finality certificate
+
 	preimagePrefix = "secure-key-"               // preimagePrefix + hash -> preimage
 	configPrefix   = []byte("loveblock-config-") // config prefix for the db
 
@@ -414,6 +421,158 @@ func WriteHeader(db lovedb.Putter, header *types.Header) error {
 	return nil
 }
 
+// ConsensusEntry is the consensus bookkeeping of a block not below the stable
+// block: the star confirmations and votes it received and its known child blocks.
+type ConsensusEntry struct {
+	Hash      common.Hash
+	Number    uint64
+	Flag      *big.Int
+	Votes     [][]byte
+	Signers   *big.Int // Stars whose BLS votes are aggregated in Aggregate
+	Aggregate []byte
+	Children  []common.Hash
+}
+
+// GetHeadStableBlockHash retrieves the hash of the current stable block, below
+// which the chain is never reorganised.
+func GetHeadStableBlockHash(db DatabaseReader) common.Hash {
+	data, _ := db.Get(headStableKey)
+	if len(data) == 0 {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
+// WriteHeadStableBlockHash stores the stable block's hash.
+func WriteHeadStableBlockHash(db lovedb.Putter, hash common.Hash) error {
+	if err := db.Put(headStableKey, hash.Bytes()); err != nil {
+		log.Crit("Failed to store last stable block's hash", "err", err)
+	}
+	return nil
+}
+
+// GetConsensusIndex retrieves the hashes of the blocks above the stable block
+// whose consensus bookkeeping is stored.
+func GetConsensusIndex(db DatabaseReader) []common.Hash {
+	data, _ := db.Get(consensusIndexKey)
+	if len(data) == 0 {
+		return nil
+	}
+	var hashes []common.Hash
+	if err := rlp.Decode(bytes.NewReader(data), &hashes); err != nil {
+		log.Error("Invalid consensus index RLP", "err", err)
+		return nil
+	}
+	return hashes
+}
+
+// WriteConsensusIndex stores the hashes of the blocks above the stable block
+// whose consensus bookkeeping is stored, replacing the previous index.
+func WriteConsensusIndex(db lovedb.Putter, hashes []common.Hash) error {
+	data, err := rlp.EncodeToBytes(hashes)
+	if err != nil {
+		return err
+	}
+	if err := db.Put(consensusIndexKey, data); err != nil {
+		log.Crit("Failed to store consensus index", "err", err)
+	}
+	return nil
+}
+
+// GetConsensusEntry retrieves the consensus bookkeeping of the block with the
+// given hash, or nil if none is stored.
+func GetConsensusEntry(db DatabaseReader, hash common.Hash) *ConsensusEntry {
+	data, _ := db.Get(append(consensusPrefix, hash.Bytes()...))
+	if len(data) == 0 {
+		return nil
+	}
+	entry := new(ConsensusEntry)
+	if err := rlp.Decode(bytes.NewReader(data), entry); err != nil {
+		log.Error("Invalid consensus bookkeeping RLP", "hash", hash, "err", err)
+		return nil
+	}
+	return entry
+}
+
+// WriteConsensusEntry stores the consensus bookkeeping of a block.
+func WriteConsensusEntry(db lovedb.Putter, entry *ConsensusEntry) error {
+	data, err := rlp.EncodeToBytes(entry)
+	if err != nil {
+		return err
+	}
+	if err := db.Put(append(consensusPrefix, entry.Hash.Bytes()...), data); err != nil {
+		log.Crit("Failed to store consensus bookkeeping", "err", err)
+	}
+	return nil
+}
+
+// GetConsensusEntries retrieves the consensus bookkeeping of all the blocks in
+// the consensus index.
+func GetConsensusEntries(db DatabaseReader) []*ConsensusEntry {
+	var entries []*ConsensusEntry
+	for _, hash := range GetConsensusIndex(db) {
+		if entry := GetConsensusEntry(db, hash); entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// GetFinalityCertificate retrieves the finality certificate of the block with the
+// given hash, or nil if the block isn't known to be finalized.
+func GetFinalityCertificate(db DatabaseReader, hash common.Hash) *types.FinalityCertificate {
+	data, _ := db.Get(append(finalityPrefix, hash.Bytes()...))
+	if len(data) == 0 {
+		return nil
+	}
+	cert := new(types.FinalityCertificate)
+	if err := rlp.Decode(bytes.NewReader(data), cert); err != nil {
+		log.Error("Invalid finality certificate RLP", "hash", hash, "err", err)
+		return nil
+	}
+	return cert
+}
+
+// WriteFinalityCertificate stores the finality certificate of a block into the
+// database.
+func WriteFinalityCertificate(db lovedb.Putter, cert *types.FinalityCertificate) error {
+	data, err := rlp.EncodeToBytes(cert)
+	if err != nil {
+		return err
+	}
+	if err := db.Put(append(finalityPrefix, cert.Hash.Bytes()...), data); err != nil {
+		log.Crit("Failed to store finality certificate", "err", err)
+	}
+	return nil
+}
+
+// GetEvidence retrieves the equivocation evidence with the given hash, or nil if
+// it's not stored in the database.
+func GetEvidence(db DatabaseReader, hash common.Hash) *types.Evidence {
+	data, _ := db.Get(append(evidencePrefix, hash.Bytes()...))
+	if len(data) == 0 {
+		return nil
+	}
+	evidence := new(types.Evidence)
+	if err := rlp.Decode(bytes.NewReader(data), evidence); err != nil {
+		log.Error("Invalid evidence RLP", "hash", hash, "err", err)
+		return nil
+	}
+	return evidence
+}
+
+// WriteEvidence stores a piece of equivocation evidence into the database.
+func WriteEvidence(db lovedb.Putter, evidence *types.Evidence) error {
+	data, err := rlp.EncodeToBytes(evidence)
+	if err != nil {
+		return err
+	}
+	if err := db.Put(append(evidencePrefix, evidence.Hash().Bytes()...), data); err != nil {
+		log.Crit("Failed to store evidence", "err", err)
+	}
+	return nil
+}
+
 // WriteBody serializes the body of a block into the database.
 func WriteBody(db lovedb.Putter, hash common.Hash, number uint64, body *types.Body) error {
 	data, err := rlp.EncodeToBytes(body)
@@ -547,6 +706,11 @@ func DeleteBlockReceipts(db DatabaseDeleter, hash common.Hash, number uint64) {
 	db.Delete(append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...))
 }
 
+// DeleteConsensusEntry removes the consensus bookkeeping of a block.
+func DeleteConsensusEntry(db DatabaseDeleter, hash common.Hash) {
+	db.Delete(append(consensusPrefix, hash.Bytes()...))
+}
+
 // DeleteTxLookupEntry removes all transaction data associated with a hash.
 func DeleteTxLookupEntry(db DatabaseDeleter, hash common.Hash) {
 	db.Delete(append(lookupPrefix, hash.Bytes()...))
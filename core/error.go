@@ -32,4 +32,36 @@ var (
 	// ErrNonceTooHigh is returned if the nonce of a transaction is higher than the
 	// next one expected based on the local chain.
 	ErrNonceTooHigh = errors.New("nonce too high")
+
+	// ErrNoEvidenceSupport is returned if equivocation evidence is submitted to a
+	// chain whose consensus engine can't verify it.
+	ErrNoEvidenceSupport = errors.New("consensus engine doesn't support evidence")
+
+	// ErrUnknownFinalityBlock is returned if a finality certificate is checked for
+	// a block that is not part of the local chain.
+	ErrUnknownFinalityBlock = errors.New("finality certificate of unknown block")
+
+	// ErrUnknownVoter is returned if a finality certificate holds a vote of a node
+	// that isn't a star of the certified block.
+	ErrUnknownVoter = errors.New("finality vote of unknown star")
+
+	// ErrInsufficientVotes is returned if a finality certificate doesn't hold the
+	// votes of a quorum of stars.
+	ErrInsufficientVotes = errors.New("finality certificate below quorum")
+
+	// ErrMissingBLSKey is returned if aggregated votes include a star that never
+	// registered a BLS key.
+	ErrMissingBLSKey = errors.New("star has no registered BLS key")
+
+	// ErrInvalidAggregate is returned if an aggregated BLS vote doesn't verify
+	// against the keys of its signers.
+	ErrInvalidAggregate = errors.New("invalid aggregated vote")
+
+	// ErrAggregateWithoutState is returned if a finality certificate with an
+	// aggregated BLS vote is checked by a chain without state, lacking the keys.
+	ErrAggregateWithoutState = errors.New("aggregated vote can't be verified without state")
+
+	// ErrStableConflict is returned if a block or header to import is on a chain
+	// not containing the stable block, i.e. would reorganise away final blocks.
+	ErrStableConflict = errors.New("block conflicts with stable block")
 )
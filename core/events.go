@@ -52,3 +52,11 @@ type ChainSideEvent struct {
 }
 
 type ChainHeadEvent struct{ Block *types.Block }
+
+// ChainStableEvent is posted when the stable block advances, i.e. a new block is
+// proven final.
+type ChainStableEvent struct{ Block *types.Block }
+
+// EvidenceEvent is posted when a star node is caught sealing two different
+// blocks in the same slot.
+type EvidenceEvent struct{ Evidence *types.Evidence }
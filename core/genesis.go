@@ -26,6 +26,7 @@ import (
 	"strings"
 
 	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/common/dpovp"
 	"github.com/LoveBlock/loveblock/common/hexutil"
 	"github.com/LoveBlock/loveblock/common/math"
 	"github.com/LoveBlock/loveblock/core/state"
@@ -38,8 +39,14 @@ import (
 
 //go:generate gencodec -type Genesis -field-override genesisSpecMarshaling -out gen_genesis.go
 //go:generate gencodec -type GenesisAccount -field-override genesisAccountMarshaling -out gen_genesis_account.go
+//go:generate gencodec -type GenesisStar -field-override genesisStarMarshaling -out gen_genesis_star.go
 
-var errGenesisNoConfig = errors.New("genesis has no chain configuration")
+var (
+	errGenesisNoConfig    = errors.New("genesis has no chain configuration")
+	errGenesisStarPubkey  = errors.New("genesis star pubkey must be 64 bytes")
+	errGenesisStarNodeID  = errors.New("genesis star node id must be empty or 64 bytes")
+	errGenesisStarRepeats = errors.New("genesis star listed more than once")
+)
 
 // Genesis specifies the header fields, state of a genesis block. It also defines hard
 // fork switch-over blocks through the chain configuration.
@@ -54,6 +61,11 @@ type Genesis struct {
 	Coinbase   common.Address      `json:"coinbase"`
 	Alloc      GenesisAlloc        `json:"alloc"      gencodec:"required"`
 
+	// Stars are the initial star nodes, committed into the star registry of the
+	// genesis state. StarOwner is the address allowed to change the registry.
+	Stars     []GenesisStar  `json:"stars,omitempty"`
+	StarOwner common.Address `json:"starOwner,omitempty"`
+
 	// These fields are used for consensus tests. Please don't use them
 	// in actual genesis blocks.
 	Number     uint64      `json:"number"`
@@ -85,6 +97,14 @@ type GenesisAccount struct {
 	PrivateKey []byte                      `json:"secretKey,omitempty"` // for tests
 }
 
+// GenesisStar is a star node allowed to produce blocks from the genesis block on.
+type GenesisStar struct {
+	Address common.Address `json:"address" gencodec:"required"`
+	Pubkey  []byte         `json:"pubkey"  gencodec:"required"` // 64 byte signing key
+	NodeID  []byte         `json:"nodeId,omitempty"`            // 64 byte p2p node key, if not the signing key
+	Weight  uint64         `json:"weight,omitempty"`            // Confirmation weight, 0 counts as 1
+}
+
 // field type overrides for gencodec
 type genesisSpecMarshaling struct {
 	Nonce      math.HexOrDecimal64
@@ -105,6 +125,12 @@ type genesisAccountMarshaling struct {
 	PrivateKey hexutil.Bytes
 }
 
+type genesisStarMarshaling struct {
+	Pubkey hexutil.Bytes
+	NodeID hexutil.Bytes
+	Weight math.HexOrDecimal64
+}
+
 // storageJSON represents a 256 bit byte array, but allows less than 256 bits when
 // unmarshaling from hex.
 type storageJSON common.Hash
@@ -147,12 +173,19 @@ func (e *GenesisMismatchError) Error() string {
 // The stored chain configuration will be updated if it is compatible (i.e. does not
 // specify a fork block below the local head block). In case of a conflict, the
 // error is a *params.ConfigCompatError and the new, unwritten config is returned.
+// Changes to parameters without a fork block, such as the chain id, can't be
+// resolved by rewinding and yield a *params.ConsensusParamError instead.
 //
 // The returned chain configuration is never nil.
 func SetupGenesisBlock(db lovedb.Database, genesis *Genesis) (*params.ChainConfig, common.Hash, error) {
 	if genesis != nil && genesis.Config == nil {
 		return params.AllLovehashProtocolChanges, common.Hash{}, errGenesisNoConfig
 	}
+	if genesis != nil {
+		if err := genesis.verifyStars(); err != nil {
+			return genesis.Config, common.Hash{}, err
+		}
+	}
 
 	// Just commit the new block if there is no stored genesis block.
 	stored := GetCanonicalHash(db, 0)
@@ -177,7 +210,7 @@ func SetupGenesisBlock(db lovedb.Database, genesis *Genesis) (*params.ChainConfi
 
 	// Get the existing chain configuration.
 	newcfg := genesis.configOrDefault(stored)
-	_, err := GetChainConfig(db, stored)
+	storedcfg, err := GetChainConfig(db, stored)
 	if err != nil {
 		if err == ErrChainConfigNotFound {
 			// This case happens if a genesis write was interrupted.
@@ -189,9 +222,9 @@ func SetupGenesisBlock(db lovedb.Database, genesis *Genesis) (*params.ChainConfi
 	// Special case: don't change the existing config of a non-mainnet chain if no new
 	// config is supplied. These chains would get AllProtocolChanges (and a compat error)
 	// if we just continued here.
-	// if genesis == nil && stored != params.MainnetGenesisHash {
-	// 	return storedcfg, stored, nil
-	// }
+	if genesis == nil && stored != params.MainnetGenesisHash {
+		return storedcfg, stored, nil
+	}
 
 	// Check config compatibility and write the config. Compatibility errors
 	// are returned to the caller unless we're already at block zero.
@@ -199,6 +232,13 @@ func SetupGenesisBlock(db lovedb.Database, genesis *Genesis) (*params.ChainConfi
 	if height == missingNumber {
 		return newcfg, stored, fmt.Errorf("missing block number for head header hash")
 	}
+	if err := storedcfg.CheckConsensusParams(newcfg, height); err != nil {
+		return newcfg, stored, err
+	}
+	compatErr := storedcfg.CheckCompatible(newcfg, height)
+	if compatErr != nil && height != 0 {
+		return newcfg, stored, compatErr
+	}
 	return newcfg, stored, WriteChainConfig(db, stored, newcfg)
 }
 
@@ -230,6 +270,10 @@ func (g *Genesis) ToBlock(db lovedb.Database) *types.Block {
 			statedb.SetState(addr, key, value)
 		}
 	}
+	if len(g.Stars) > 0 {
+		dpovp.WriteRegistryOwner(statedb, g.StarOwner)
+		dpovp.WriteStarList(statedb, g.StarList())
+	}
 	root := statedb.IntermediateRoot(false)
 	head := &types.Header{
 		Number:     new(big.Int).SetUint64(g.Number),
@@ -259,6 +303,9 @@ func (g *Genesis) ToBlock(db lovedb.Database) *types.Block {
 // Commit writes the block and state of a genesis specification to the database.
 // The block is committed as the canonical head block.
 func (g *Genesis) Commit(db lovedb.Database) (*types.Block, error) {
+	if err := g.verifyStars(); err != nil {
+		return nil, err
+	}
 	block := g.ToBlock(db)
 	if block.Number().Sign() != 0 {
 		return nil, fmt.Errorf("can't commit genesis block with number > 0")
@@ -304,6 +351,78 @@ func GenesisBlockForTesting(db lovedb.Database, addr common.Address, balance *bi
 	return g.MustCommit(db)
 }
 
+// StarList returns the initial star nodes of the genesis specification.
+func (g *Genesis) StarList() dpovp.StarList {
+	stars := make(dpovp.StarList, 0, len(g.Stars))
+	for _, star := range g.Stars {
+		stars = append(stars, dpovp.AddrNodeIDMapping{
+			Addr:   star.Address,
+			Pubkey: common.CopyBytes(star.Pubkey),
+			NodeID: common.CopyBytes(star.NodeID),
+			Weight: star.Weight,
+		})
+	}
+	return stars
+}
+
+// verifyStars checks the keys of the initial star nodes and that no star is
+// listed twice.
+func (g *Genesis) verifyStars() error {
+	seen := make(map[common.Address]bool)
+	for _, star := range g.Stars {
+		if len(star.Pubkey) != 64 {
+			return errGenesisStarPubkey
+		}
+		if len(star.NodeID) != 0 && len(star.NodeID) != 64 {
+			return errGenesisStarNodeID
+		}
+		if seen[star.Address] {
+			return errGenesisStarRepeats
+		}
+		seen[star.Address] = true
+	}
+	return nil
+}
+
+// GenesisStars returns the star nodes committed into the star registry of the
+// canonical genesis block in db. The list is empty for genesis blocks without
+// stars, whose star nodes come from the starlist file only.
+func GenesisStars(db lovedb.Database) (dpovp.StarList, error) {
+	header := GetHeader(db, GetCanonicalHash(db, 0), 0)
+	if header == nil {
+		return nil, errors.New("genesis block not found")
+	}
+	statedb, err := state.New(header.Root, state.NewDatabase(db))
+	if err != nil {
+		return nil, err
+	}
+	return dpovp.ReadStarList(statedb), nil
+}
+
+// CheckGenesisStars checks the local starlist file against the star nodes of the
+// genesis block in db, adopting the genesis stars if the file is empty.
+func CheckGenesisStars(db lovedb.Database) error {
+	stars, err := GenesisStars(db)
+	if err != nil {
+		return err
+	}
+	if len(stars) == 0 {
+		return nil
+	}
+	return dpovp.CheckStarList(stars)
+}
+
+// StarRegistryAccount returns a genesis account seeding the on-chain star
+// registry with the given owner and star nodes. Add it to the genesis allocation
+// at dpovp.RegistryAddress.
+func StarRegistryAccount(owner common.Address, stars dpovp.StarList) GenesisAccount {
+	return GenesisAccount{
+		Balance: new(big.Int),
+		Nonce:   1,
+		Storage: dpovp.RegistryStorage(owner, stars),
+	}
+}
+
 // DefaultGenesisBlock returns the LoveBlock main net genesis block.
 func DefaultGenesisBlock() *Genesis {
 	return &Genesis{
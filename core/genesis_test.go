@@ -17,6 +17,8 @@
 package core
 
 import (
+	"bytes"
+	"encoding/json"
 	"math/big"
 	"reflect"
 	"testing"
@@ -42,7 +44,7 @@ func TestDefaultGenesisBlock(t *testing.T) {
 
 func TestSetupGenesis(t *testing.T) {
 	var (
-		customghash = common.HexToHash("0xa1cd36b0c6db6940b1184a28cea11f4f74d823589c6a7e7f1398a5c176835598")
+		customghash = common.HexToHash("0x804fd75a0293d82315e740064718b5bb1251f38b1257a0e300e2c2aaa2043354")
 		customg     = Genesis{
 			Config: params.MainnetChainConfig,
 			Alloc: GenesisAlloc{
@@ -51,7 +53,10 @@ func TestSetupGenesis(t *testing.T) {
 		}
 		oldcustomg = customg
 	)
-	oldcustomg.Config = &params.ChainConfig{}
+	oldcustomg.Config = &params.ChainConfig{
+		ChainId: params.MainnetChainConfig.ChainId,
+		Dpovp:   &params.DpovpConfig{Timeout: 10 * 1000, Sleeptime: 3 * 1000, SealHashBlock: big.NewInt(2)},
+	}
 	tests := []struct {
 		name       string
 		fn         func(lovedb.Database) (*params.ChainConfig, common.Hash, error)
@@ -115,8 +120,8 @@ func TestSetupGenesis(t *testing.T) {
 		{
 			name: "incompatible config in DB",
 			fn: func(db lovedb.Database) (*params.ChainConfig, common.Hash, error) {
-				// Commit the 'old' genesis block with Homestead transition at #2.
-				// Advance to block #4, past the homestead transition block of customg.
+				// Commit the 'old' genesis block with the seal hash transition at #2.
+				// Advance to block #4, past the seal hash transition customg schedules later.
 				genesis := oldcustomg.MustCommit(db)
 
 				bc, _ := NewBlockChain(db, nil, oldcustomg.Config, dpovp.NewFullFaker(), vm.Config{})
@@ -131,12 +136,36 @@ func TestSetupGenesis(t *testing.T) {
 			wantHash:   customghash,
 			wantConfig: customg.Config,
 			wantErr: &params.ConfigCompatError{
-				What:         "Homestead fork block",
-				StoredConfig: big.NewInt(4),
-				NewConfig:    big.NewInt(2),
+				What:         "seal hash fork block",
+				StoredConfig: big.NewInt(2),
+				NewConfig:    params.MainnetChainConfig.Dpovp.SealHashBlock,
 				RewindTo:     1,
 			},
 		},
+		{
+			name: "changed consensus parameter in DB",
+			fn: func(db lovedb.Database) (*params.ChainConfig, common.Hash, error) {
+				// Advance to block #1, then change a parameter that has no fork block.
+				genesis := oldcustomg.MustCommit(db)
+
+				bc, _ := NewBlockChain(db, nil, oldcustomg.Config, dpovp.NewFullFaker(), vm.Config{})
+				defer bc.Stop()
+
+				blocks, _ := GenerateChain(oldcustomg.Config, genesis, dpovp.NewFaker(), db, 1, nil)
+				bc.InsertChain(blocks)
+
+				changed := oldcustomg
+				changed.Config = &params.ChainConfig{ChainId: oldcustomg.Config.ChainId, Dpovp: &params.DpovpConfig{SealHashBlock: big.NewInt(2), Epoch: 100}}
+				return SetupGenesisBlock(db, &changed)
+			},
+			wantHash:   customghash,
+			wantConfig: &params.ChainConfig{ChainId: oldcustomg.Config.ChainId, Dpovp: &params.DpovpConfig{SealHashBlock: big.NewInt(2), Epoch: 100}},
+			wantErr: &params.ConsensusParamError{
+				What:         "dpovp epoch",
+				StoredConfig: big.NewInt(0),
+				NewConfig:    big.NewInt(100),
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -150,6 +179,12 @@ func TestSetupGenesis(t *testing.T) {
 		if !reflect.DeepEqual(config, test.wantConfig) {
 			t.Errorf("%s:\nreturned %v\nwant     %v", test.name, config, test.wantConfig)
 		}
+		// Rejected configs must not replace the stored one.
+		if _, ok := err.(*params.ConsensusParamError); ok {
+			if stored, _ := GetChainConfig(db, hash); stored.Dpovp.Epoch != 0 {
+				t.Errorf("%s: rejected config stored", test.name)
+			}
+		}
 		if hash != test.wantHash {
 			t.Errorf("%s: returned hash %s, want %s", test.name, hash.Hex(), test.wantHash.Hex())
 		} else if err == nil {
@@ -161,3 +196,55 @@ func TestSetupGenesis(t *testing.T) {
 		}
 	}
 }
+
+// Tests that the genesis stars are committed into the star registry, and so
+// into the genesis hash, and survive a round trip through genesis.json.
+func TestGenesisStars(t *testing.T) {
+	star := func(b byte) GenesisStar {
+		return GenesisStar{Address: common.BytesToAddress([]byte{b}), Pubkey: bytes.Repeat([]byte{b}, 64)}
+	}
+	genesis := &Genesis{
+		Config:     params.TestChainConfig,
+		GasLimit:   params.GenesisGasLimit,
+		Difficulty: big.NewInt(1),
+		Alloc:      GenesisAlloc{},
+		Stars:      []GenesisStar{star(1), star(2)},
+		StarOwner:  common.HexToAddress("0x0123"),
+	}
+	genesis.Stars[1].NodeID = bytes.Repeat([]byte{0xff}, 64)
+	genesis.Stars[1].Weight = 2
+
+	blob, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatalf("failed to encode genesis: %v", err)
+	}
+	decoded := new(Genesis)
+	if err := json.Unmarshal(blob, decoded); err != nil {
+		t.Fatalf("failed to decode genesis: %v", err)
+	}
+	if !reflect.DeepEqual(decoded.Stars, genesis.Stars) || decoded.StarOwner != genesis.StarOwner {
+		t.Errorf("genesis stars mismatch after json round trip: have %v, want %v", decoded.Stars, genesis.Stars)
+	}
+
+	db, _ := lovedb.NewMemDatabase()
+	block := genesis.MustCommit(db)
+
+	other := *genesis
+	other.Stars = genesis.Stars[:1]
+	if other.ToBlock(nil).Hash() == block.Hash() {
+		t.Error("genesis hash doesn't depend on the stars")
+	}
+	stars, err := GenesisStars(db)
+	if err != nil {
+		t.Fatalf("failed to read genesis stars: %v", err)
+	}
+	if !reflect.DeepEqual(stars, genesis.StarList()) {
+		t.Errorf("registry stars mismatch: have %v, want %v", stars, genesis.StarList())
+	}
+
+	invalid := *genesis
+	invalid.Stars = []GenesisStar{star(1), star(1)}
+	if _, _, err := SetupGenesisBlock(db, &invalid); err != errGenesisStarRepeats {
+		t.Errorf("duplicate star error mismatch: have %v, want %v", err, errGenesisStarRepeats)
+	}
+}
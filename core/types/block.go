@@ -30,6 +30,7 @@ import (
 	"github.com/LoveBlock/loveblock/common"
 	"github.com/LoveBlock/loveblock/common/hexutil"
 	"github.com/LoveBlock/loveblock/crypto/sha3"
+	"github.com/LoveBlock/loveblock/params"
 	"github.com/LoveBlock/loveblock/rlp"
 )
 
@@ -104,6 +105,26 @@ func (h *Header) Hash() common.Hash {
 	return rlpHash(h)
 }
 
+// MilliTime returns the block time in milliseconds since the unix epoch. From the
+// millisecond timestamp fork on it is carried by the nonce, before it the header
+// time only has a precision of one second.
+func (h *Header) MilliTime(config *params.DpovpConfig) uint64 {
+	if config.IsMilliTime(h.Number) {
+		return h.Nonce.Uint64()
+	}
+	return h.Time.Uint64() * 1000
+}
+
+// SetMilliTime sets the block time to the given number of milliseconds since the
+// unix epoch. The header time is set to the whole seconds, which is what the
+// TIMESTAMP opcode returns.
+func (h *Header) SetMilliTime(config *params.DpovpConfig, ms uint64) {
+	h.Time = new(big.Int).SetUint64(ms / 1000)
+	if config.IsMilliTime(h.Number) {
+		h.Nonce = EncodeNonce(ms)
+	}
+}
+
 // HashNoNonce returns the hash which is used as input for the proof-of-work search.
 func (h *Header) HashNoNonce() common.Hash {
 	return rlpHash([]interface{}{
@@ -0,0 +1,50 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"bytes"
+
+	"github.com/LoveBlock/loveblock/common"
+)
+
+// Evidence proves that a star node sealed two different blocks in the same slot,
+// i.e. on top of the same parent.
+type Evidence struct {
+	First  *Header
+	Second *Header
+}
+
+// NewEvidence creates the evidence of two conflicting headers. The headers are
+// ordered by hash, so the same pair always yields the same evidence.
+func NewEvidence(a, b *Header) *Evidence {
+	ha, hb := a.Hash(), b.Hash()
+	if bytes.Compare(ha[:], hb[:]) > 0 {
+		a, b = b, a
+	}
+	return &Evidence{First: CopyHeader(a), Second: CopyHeader(b)}
+}
+
+// Hash returns the keccak256 hash of the evidence's RLP encoding.
+func (e *Evidence) Hash() common.Hash {
+	return rlpHash(e)
+}
+
+// Offender returns the coinbase of the star node that sealed both headers.
+func (e *Evidence) Offender() common.Address {
+	return e.First.Coinbase
+}
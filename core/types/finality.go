@@ -0,0 +1,52 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+
+	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/crypto"
+)
+
+// FinalityCertificate proves that a block was finalized: it collects the votes,
+// i.e. the signatures of the block hash, of the stars that confirmed the block.
+// The author of the block confirms it by its seal and doesn't need to vote.
+//
+// Votes are either individual secp256k1 signatures, or, on chains voting with BLS
+// keys, a single signature aggregated from the votes of the stars whose bits are
+// set in Signers.
+type FinalityCertificate struct {
+	Hash      common.Hash
+	Number    uint64
+	Votes     [][]byte
+	Signers   *big.Int // Bit i set if the i-th star of the block is part of Aggregate
+	Aggregate []byte   // Aggregated BLS signature of the block hash
+}
+
+// Voters recovers the public keys of the stars that voted for the block.
+func (c *FinalityCertificate) Voters() ([][]byte, error) {
+	voters := make([][]byte, 0, len(c.Votes))
+	for _, vote := range c.Votes {
+		pubkey, err := crypto.Ecrecover(c.Hash.Bytes(), vote)
+		if err != nil {
+			return nil, err
+		}
+		voters = append(voters, pubkey)
+	}
+	return voters, nil
+}
@@ -39,7 +39,9 @@ type sigCache struct {
 }
 
 // MakeSigner returns a Signer based on the given chain config and block number.
-func MakeSigner(config *params.ChainConfig) Signer {
+// All fork blocks scheduled so far share the replay protected default signer, a
+// fork changing the transaction signature selects its signer here.
+func MakeSigner(config *params.ChainConfig, blockNumber *big.Int) Signer {
 	return NewDefaultSigner(config.ChainId)
 }
 
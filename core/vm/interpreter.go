@@ -59,15 +59,22 @@ type Interpreter struct {
 func NewInterpreter(evm *EVM, cfg Config) *Interpreter {
 	// We use the STOP instruction whether to see
 	// the jump table was initialised. If it was not
-	// we'll set the default jump table.
+	// we'll set the jump table of the current phase.
 	if !cfg.JumpTable[STOP].valid {
-		cfg.JumpTable = NewInstructionSet()
+		switch {
+		case evm.chainRules.IsIstanbul:
+			cfg.JumpTable = istanbulInstructionSet
+		case evm.chainRules.IsConstantinople:
+			cfg.JumpTable = constantinopleInstructionSet
+		default:
+			cfg.JumpTable = defaultInstructionSet
+		}
 	}
 
 	return &Interpreter{
 		evm:      evm,
 		cfg:      cfg,
-		gasTable: params.DefaultGasTable,
+		gasTable: evm.ChainConfig().GasTable(evm.BlockNumber),
 		intPool:  newIntPool(),
 	}
 }
@@ -50,7 +50,54 @@ type operation struct {
 	returns bool // determines whether the operations sets the return data content
 }
 
-// NewInstructionSet instructions.
+var (
+	defaultInstructionSet        = NewInstructionSet()
+	constantinopleInstructionSet = NewConstantinopleInstructionSet()
+	istanbulInstructionSet       = NewIstanbulInstructionSet()
+)
+
+// NewIstanbulInstructionSet returns the instructions of the Istanbul phase, the
+// Constantinople instructions along with CHAINID and SELFBALANCE.
+func NewIstanbulInstructionSet() [256]operation {
+	instructionSet := NewConstantinopleInstructionSet()
+	instructionSet[CHAINID] = operation{
+		execute:       opChainID,
+		gasCost:       constGasFunc(GasQuickStep),
+		validateStack: makeStackFunc(0, 1),
+		valid:         true,
+	}
+	instructionSet[SELFBALANCE] = operation{
+		execute:       opSelfBalance,
+		gasCost:       constGasFunc(GasFastStep),
+		validateStack: makeStackFunc(0, 1),
+		valid:         true,
+	}
+	return instructionSet
+}
+
+// NewConstantinopleInstructionSet returns the instructions of the Constantinople
+// phase, the default instructions along with CREATE2 and EXTCODEHASH.
+func NewConstantinopleInstructionSet() [256]operation {
+	instructionSet := NewInstructionSet()
+	instructionSet[EXTCODEHASH] = operation{
+		execute:       opExtCodeHash,
+		gasCost:       gasExtCodeHash,
+		validateStack: makeStackFunc(1, 1),
+		valid:         true,
+	}
+	instructionSet[CREATE2] = operation{
+		execute:       opCreate2,
+		gasCost:       gasCreate2,
+		validateStack: makeStackFunc(4, 1),
+		memorySize:    memoryCreate2,
+		valid:         true,
+		writes:        true,
+		returns:       true,
+	}
+	return instructionSet
+}
+
+// NewInstructionSet returns the instructions of the chain before any fork.
 func NewInstructionSet() [256]operation {
 	return [256]operation{
 		STOP: {
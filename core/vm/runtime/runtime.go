@@ -52,7 +52,9 @@ type Config struct {
 func setDefaults(cfg *Config) {
 	if cfg.ChainConfig == nil {
 		cfg.ChainConfig = &params.ChainConfig{
-			ChainId: big.NewInt(1),
+			ChainId:             big.NewInt(1),
+			ConstantinopleBlock: new(big.Int),
+			IstanbulBlock:       new(big.Int),
 		}
 	}
 
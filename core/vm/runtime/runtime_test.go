@@ -25,7 +25,9 @@ import (
 	"github.com/LoveBlock/loveblock/common"
 	"github.com/LoveBlock/loveblock/core/state"
 	"github.com/LoveBlock/loveblock/core/vm"
+	"github.com/LoveBlock/loveblock/crypto"
 	"github.com/LoveBlock/loveblock/lovedb"
+	"github.com/LoveBlock/loveblock/params"
 )
 
 func TestDefaults(t *testing.T) {
@@ -117,6 +119,79 @@ func TestCall(t *testing.T) {
 	}
 }
 
+// Tests that CREATE2 and EXTCODEHASH are only available from the Constantinople
+// fork block on.
+func TestConstantinopleInstructions(t *testing.T) {
+	code := []byte{
+		byte(vm.PUSH1), 0, // salt
+		byte(vm.PUSH1), 0, // size
+		byte(vm.PUSH1), 0, // offset
+		byte(vm.PUSH1), 0, // value
+		byte(vm.CREATE2),
+		byte(vm.DUP1),
+		byte(vm.PUSH1), 0,
+		byte(vm.MSTORE),
+		byte(vm.EXTCODEHASH),
+		byte(vm.PUSH1), 32,
+		byte(vm.MSTORE),
+		byte(vm.PUSH1), 64,
+		byte(vm.PUSH1), 0,
+		byte(vm.RETURN),
+	}
+	config := &params.ChainConfig{ChainId: big.NewInt(1), ConstantinopleBlock: big.NewInt(10)}
+
+	if _, _, err := Execute(code, nil, &Config{ChainConfig: config, BlockNumber: big.NewInt(9)}); err == nil {
+		t.Fatal("expected invalid opcode before the fork block")
+	}
+	ret, _, err := Execute(code, nil, &Config{ChainConfig: config, BlockNumber: big.NewInt(10)})
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	emptyHash := crypto.Keccak256(nil)
+	want := crypto.CreateAddress2(common.StringToAddress("contract"), common.Hash{}, emptyHash)
+	if addr := common.BytesToAddress(ret[:32]); addr != want {
+		t.Errorf("created contract address mismatch: have %x, want %x", addr, want)
+	}
+	if hash := common.BytesToHash(ret[32:]); hash != common.BytesToHash(emptyHash) {
+		t.Errorf("code hash mismatch: have %x, want %x", hash, emptyHash)
+	}
+}
+
+// Tests that CHAINID and SELFBALANCE are only available from the Istanbul fork
+// block on.
+func TestIstanbulInstructions(t *testing.T) {
+	code := []byte{
+		byte(vm.CHAINID),
+		byte(vm.PUSH1), 0,
+		byte(vm.MSTORE),
+		byte(vm.SELFBALANCE),
+		byte(vm.PUSH1), 32,
+		byte(vm.MSTORE),
+		byte(vm.PUSH1), 64,
+		byte(vm.PUSH1), 0,
+		byte(vm.RETURN),
+	}
+	config := &params.ChainConfig{ChainId: big.NewInt(1337), ConstantinopleBlock: big.NewInt(0), IstanbulBlock: big.NewInt(10)}
+
+	if _, _, err := Execute(code, nil, &Config{ChainConfig: config, BlockNumber: big.NewInt(9)}); err == nil {
+		t.Fatal("expected invalid opcode before the fork block")
+	}
+	db, _ := lovedb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	statedb.AddBalance(common.StringToAddress("contract"), big.NewInt(42))
+
+	ret, _, err := Execute(code, nil, &Config{ChainConfig: config, BlockNumber: big.NewInt(10), State: statedb})
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	if id := new(big.Int).SetBytes(ret[:32]); id.Cmp(config.ChainId) != 0 {
+		t.Errorf("chain id mismatch: have %v, want %v", id, config.ChainId)
+	}
+	if balance := new(big.Int).SetBytes(ret[32:]); balance.Cmp(big.NewInt(42)) != 0 {
+		t.Errorf("balance mismatch: have %v, want 42", balance)
+	}
+}
+
 func BenchmarkCall(b *testing.B) {
 	var definition = `[{"constant":true,"inputs":[],"name":"seller","outputs":[{"name":"","type":"address"}],"type":"function"},{"constant":false,"inputs":[],"name":"abort","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"value","outputs":[{"name":"","type":"uint256"}],"type":"function"},{"constant":false,"inputs":[],"name":"refund","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"buyer","outputs":[{"name":"","type":"address"}],"type":"function"},{"constant":false,"inputs":[],"name":"confirmReceived","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"state","outputs":[{"name":"","type":"uint8"}],"type":"function"},{"constant":false,"inputs":[],"name":"confirmPurchase","outputs":[],"type":"function"},{"inputs":[],"type":"constructor"},{"anonymous":false,"inputs":[],"name":"Aborted","type":"event"},{"anonymous":false,"inputs":[],"name":"PurchaseConfirmed","type":"event"},{"anonymous":false,"inputs":[],"name":"ItemReceived","type":"event"},{"anonymous":false,"inputs":[],"name":"Refunded","type":"event"}]`
 
@@ -0,0 +1,235 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package bls implements BLS signatures over the bn256 curve, with signatures in
+// G1 and public keys in G2. Signatures of the same message by different keys can
+// be aggregated into a single signature, verified against the sum of the keys.
+//
+// Aggregation is only safe for keys whose owners proved possession of the secret
+// key (see SignPossession), otherwise a rogue key can cancel out honest ones.
+package bls
+
+import (
+	"errors"
+	"io"
+	"math/big"
+
+	"github.com/LoveBlock/loveblock/common/math"
+	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/crypto/bn256"
+)
+
+const (
+	SecretKeyLength = 32  // Length of a marshalled secret key (scalar)
+	PublicKeyLength = 128 // Length of a marshalled public key (G2 point)
+	SignatureLength = 64  // Length of a marshalled signature (G1 point)
+)
+
+var (
+	// order is the order of the bn256 groups.
+	order, _ = new(big.Int).SetString("21888242871839275222246405745257275088548364400416034343698204186575808495617", 10)
+
+	// fieldP is the prime of the base field of the bn256 curve.
+	fieldP, _ = new(big.Int).SetString("21888242871839275222246405745257275088696311157297823662689037894645226208583", 10)
+
+	// sqrtExp is (p+1)/4, square roots in the base field are powers of it as p = 3 mod 4.
+	sqrtExp = new(big.Int).Rsh(new(big.Int).Add(fieldP, big.NewInt(1)), 2)
+
+	// g2 is the generator of G2.
+	g2 = new(bn256.G2).ScalarBaseMult(big.NewInt(1))
+)
+
+// Domains separating the messages hashed onto the curve for the different uses
+// of a key.
+var (
+	signatureDomain  = []byte("loveblock-bls-signature")
+	possessionDomain = []byte("loveblock-bls-possession")
+)
+
+var (
+	errInvalidSecretKey = errors.New("bls: invalid secret key")
+	errInvalidPublicKey = errors.New("bls: invalid public key")
+	errInvalidSignature = errors.New("bls: invalid signature")
+)
+
+// SecretKey is a BLS secret key.
+type SecretKey struct {
+	x *big.Int
+}
+
+// PublicKey is a BLS public key.
+type PublicKey struct {
+	p *bn256.G2
+}
+
+// Signature is a BLS signature, possibly aggregated from multiple signatures of
+// the same message.
+type Signature struct {
+	s *bn256.G1
+}
+
+// GenerateKey creates a random secret key.
+func GenerateKey(rand io.Reader) (*SecretKey, error) {
+	for {
+		buf := make([]byte, 32)
+		if _, err := io.ReadFull(rand, buf); err != nil {
+			return nil, err
+		}
+		if x := new(big.Int).Mod(new(big.Int).SetBytes(buf), order); x.Sign() > 0 {
+			return &SecretKey{x: x}, nil
+		}
+	}
+}
+
+// DeriveKey deterministically derives a secret key from the given seed, e.g. an
+// existing secp256k1 private key.
+func DeriveKey(seed []byte) *SecretKey {
+	for i := byte(0); ; i++ {
+		digest := crypto.Keccak256([]byte("loveblock-bls-key"), seed, []byte{i})
+		if x := new(big.Int).Mod(new(big.Int).SetBytes(digest), order); x.Sign() > 0 {
+			return &SecretKey{x: x}
+		}
+	}
+}
+
+// Marshal encodes the secret key into its 32 byte big endian representation.
+func (sk *SecretKey) Marshal() []byte {
+	return math.PaddedBigBytes(sk.x, SecretKeyLength)
+}
+
+// UnmarshalSecretKey decodes a secret key from its 32 byte representation.
+func UnmarshalSecretKey(data []byte) (*SecretKey, error) {
+	if len(data) != SecretKeyLength {
+		return nil, errInvalidSecretKey
+	}
+	x := new(big.Int).SetBytes(data)
+	if x.Sign() == 0 || x.Cmp(order) >= 0 {
+		return nil, errInvalidSecretKey
+	}
+	return &SecretKey{x: x}, nil
+}
+
+// PublicKey returns the public key of the secret key.
+func (sk *SecretKey) PublicKey() *PublicKey {
+	return &PublicKey{p: new(bn256.G2).ScalarBaseMult(sk.x)}
+}
+
+// Sign signs the given message.
+func (sk *SecretKey) Sign(msg []byte) *Signature {
+	return &Signature{s: new(bn256.G1).ScalarMult(hashToG1(signatureDomain, msg), sk.x)}
+}
+
+// SignPossession proves the possession of the secret key by signing the public
+// key under a domain of its own.
+func (sk *SecretKey) SignPossession() *Signature {
+	return &Signature{s: new(bn256.G1).ScalarMult(hashToG1(possessionDomain, sk.PublicKey().Marshal()), sk.x)}
+}
+
+// Marshal encodes the public key into its 128 byte representation.
+func (pk *PublicKey) Marshal() []byte {
+	return pk.p.Marshal()
+}
+
+// UnmarshalPublicKey decodes a public key from its 128 byte representation.
+func UnmarshalPublicKey(data []byte) (*PublicKey, error) {
+	if len(data) != PublicKeyLength {
+		return nil, errInvalidPublicKey
+	}
+	p := new(bn256.G2)
+	if _, err := p.Unmarshal(data); err != nil {
+		return nil, errInvalidPublicKey
+	}
+	return &PublicKey{p: p}, nil
+}
+
+// Marshal encodes the signature into its 64 byte representation.
+func (sig *Signature) Marshal() []byte {
+	return sig.s.Marshal()
+}
+
+// UnmarshalSignature decodes a signature from its 64 byte representation.
+func UnmarshalSignature(data []byte) (*Signature, error) {
+	if len(data) != SignatureLength {
+		return nil, errInvalidSignature
+	}
+	s := new(bn256.G1)
+	if _, err := s.Unmarshal(data); err != nil {
+		return nil, errInvalidSignature
+	}
+	return &Signature{s: s}, nil
+}
+
+// Verify checks that sig is a signature of msg by the given public key, or by
+// the keys aggregated into it.
+func Verify(pk *PublicKey, msg []byte, sig *Signature) bool {
+	return verify(pk, hashToG1(signatureDomain, msg), sig)
+}
+
+// VerifyPossession checks a proof of possession created by SignPossession.
+func VerifyPossession(pk *PublicKey, proof *Signature) bool {
+	return verify(pk, hashToG1(possessionDomain, pk.Marshal()), proof)
+}
+
+// verify checks e(sig, g2) == e(h, pk).
+func verify(pk *PublicKey, h *bn256.G1, sig *Signature) bool {
+	return bn256.PairingCheck([]*bn256.G1{new(bn256.G1).Neg(sig.s), h}, []*bn256.G2{g2, pk.p})
+}
+
+// AggregateSignatures combines signatures of the same message into one.
+func AggregateSignatures(sigs ...*Signature) *Signature {
+	agg := new(bn256.G1).ScalarBaseMult(new(big.Int))
+	for _, sig := range sigs {
+		agg = new(bn256.G1).Add(agg, sig.s)
+	}
+	return &Signature{s: agg}
+}
+
+// AggregatePublicKeys combines public keys into the key verifying the aggregate
+// of their signatures.
+func AggregatePublicKeys(pks ...*PublicKey) *PublicKey {
+	agg := new(bn256.G2).ScalarBaseMult(new(big.Int))
+	for _, pk := range pks {
+		agg = new(bn256.G2).Add(agg, pk.p)
+	}
+	return &PublicKey{p: agg}
+}
+
+// hashToG1 maps a message onto a point of G1 by try-and-increment: the first
+// hash that is the x coordinate of a curve point y^2 = x^3 + 3 yields the point.
+// G1 has a cofactor of 1, so every curve point is in the group.
+func hashToG1(domain, msg []byte) *bn256.G1 {
+	for i := uint32(0); ; i++ {
+		digest := crypto.Keccak256(domain, msg, []byte{byte(i >> 24), byte(i >> 16), byte(i >> 8), byte(i)})
+		x := new(big.Int).Mod(new(big.Int).SetBytes(digest), fieldP)
+
+		y2 := new(big.Int).Exp(x, big.NewInt(3), fieldP)
+		y2.Add(y2, big.NewInt(3)).Mod(y2, fieldP)
+
+		y := new(big.Int).Exp(y2, sqrtExp, fieldP)
+		if new(big.Int).Exp(y, big.NewInt(2), fieldP).Cmp(y2) != 0 {
+			continue
+		}
+		buf := make([]byte, 64)
+		xb, yb := x.Bytes(), y.Bytes()
+		copy(buf[32-len(xb):], xb)
+		copy(buf[64-len(yb):], yb)
+
+		point := new(bn256.G1)
+		if _, err := point.Unmarshal(buf); err == nil {
+			return point
+		}
+	}
+}
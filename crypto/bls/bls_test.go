@@ -0,0 +1,119 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package bls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	sk, err := GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	msg := []byte("block hash")
+	sig := sk.Sign(msg)
+
+	if !Verify(sk.PublicKey(), msg, sig) {
+		t.Errorf("valid signature rejected")
+	}
+	if Verify(sk.PublicKey(), []byte("other hash"), sig) {
+		t.Errorf("signature of other message accepted")
+	}
+	other, _ := GenerateKey(rand.Reader)
+	if Verify(other.PublicKey(), msg, sig) {
+		t.Errorf("signature accepted with wrong key")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	sk := DeriveKey([]byte("seed"))
+	if !bytes.Equal(sk.PublicKey().Marshal(), DeriveKey([]byte("seed")).PublicKey().Marshal()) {
+		t.Fatalf("key derivation not deterministic")
+	}
+	dec, err := UnmarshalSecretKey(sk.Marshal())
+	if err != nil {
+		t.Fatalf("failed to unmarshal secret key: %v", err)
+	}
+	if !bytes.Equal(dec.PublicKey().Marshal(), sk.PublicKey().Marshal()) {
+		t.Errorf("decoded secret key mismatch")
+	}
+	if _, err := UnmarshalSecretKey(make([]byte, SecretKeyLength)); err == nil {
+		t.Errorf("zero secret key accepted")
+	}
+	pk, err := UnmarshalPublicKey(sk.PublicKey().Marshal())
+	if err != nil {
+		t.Fatalf("failed to unmarshal public key: %v", err)
+	}
+	sig, err := UnmarshalSignature(sk.Sign([]byte("msg")).Marshal())
+	if err != nil {
+		t.Fatalf("failed to unmarshal signature: %v", err)
+	}
+	if !Verify(pk, []byte("msg"), sig) {
+		t.Errorf("decoded signature rejected")
+	}
+	if _, err := UnmarshalPublicKey(make([]byte, PublicKeyLength-1)); err == nil {
+		t.Errorf("short public key accepted")
+	}
+	garbage := bytes.Repeat([]byte{0x01}, SignatureLength)
+	if _, err := UnmarshalSignature(garbage); err == nil {
+		t.Errorf("point off the curve accepted")
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	msg := []byte("block hash")
+
+	var (
+		pks  []*PublicKey
+		sigs []*Signature
+	)
+	for i := 0; i < 4; i++ {
+		sk, _ := GenerateKey(rand.Reader)
+		pks = append(pks, sk.PublicKey())
+		sigs = append(sigs, sk.Sign(msg))
+	}
+	agg := AggregateSignatures(sigs...)
+	if !Verify(AggregatePublicKeys(pks...), msg, agg) {
+		t.Errorf("aggregate signature rejected")
+	}
+	if Verify(AggregatePublicKeys(pks[:3]...), msg, agg) {
+		t.Errorf("aggregate accepted with missing signer")
+	}
+	// Aggregates can be combined further
+	partial := AggregateSignatures(AggregateSignatures(sigs[:2]...), AggregateSignatures(sigs[2:]...))
+	if !bytes.Equal(partial.Marshal(), agg.Marshal()) {
+		t.Errorf("aggregation not associative")
+	}
+}
+
+func TestPossession(t *testing.T) {
+	sk, _ := GenerateKey(rand.Reader)
+	if !VerifyPossession(sk.PublicKey(), sk.SignPossession()) {
+		t.Errorf("valid proof of possession rejected")
+	}
+	// A plain signature of the public key must not pass as proof
+	if VerifyPossession(sk.PublicKey(), sk.Sign(sk.PublicKey().Marshal())) {
+		t.Errorf("signature accepted as proof of possession")
+	}
+	other, _ := GenerateKey(rand.Reader)
+	if VerifyPossession(other.PublicKey(), sk.SignPossession()) {
+		t.Errorf("proof accepted for other key")
+	}
+}
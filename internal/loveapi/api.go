@@ -486,8 +486,8 @@ func (s *PublicBlockChainAPI) BlockNumber() *big.Int {
 }
 
 // GetBalance returns the amount of wei for the given address in the state of the
-// given block number. The rpc.LatestBlockNumber and rpc.PendingBlockNumber meta
-// block numbers are also allowed.
+// given block number. The rpc.LatestBlockNumber, rpc.PendingBlockNumber and
+// rpc.StableBlockNumber meta block numbers are also allowed.
 func (s *PublicBlockChainAPI) GetBalance(ctx context.Context, address common.Address, blockNr rpc.BlockNumber) (*big.Int, error) {
 	state, _, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
 	if state == nil || err != nil {
@@ -497,7 +497,8 @@ func (s *PublicBlockChainAPI) GetBalance(ctx context.Context, address common.Add
 	return b, state.Error()
 }
 
-// GetBlockByNumber returns the requested block. When blockNr is -1 the chain head is returned. When fullTx is true all
+// GetBlockByNumber returns the requested block. When blockNr is -1 the chain head is returned, when it is -3 the stable
+// block, the latest one proven final by the stars. When fullTx is true all
 // transactions in the block are returned in full detail, otherwise only the transaction hash is returned.
 func (s *PublicBlockChainAPI) GetBlockByNumber(ctx context.Context, blockNr rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
 	block, err := s.b.BlockByNumber(ctx, blockNr)
@@ -585,8 +586,8 @@ func (s *PublicBlockChainAPI) GetCode(ctx context.Context, address common.Addres
 }
 
 // GetStorageAt returns the storage from the state at the given address, key and
-// block number. The rpc.LatestBlockNumber and rpc.PendingBlockNumber meta block
-// numbers are also allowed.
+// block number. The rpc.LatestBlockNumber, rpc.PendingBlockNumber and
+// rpc.StableBlockNumber meta block numbers are also allowed.
 func (s *PublicBlockChainAPI) GetStorageAt(ctx context.Context, address common.Address, key string, blockNr rpc.BlockNumber) (hexutil.Bytes, error) {
 	state, _, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
 	if state == nil || err != nil {
@@ -1160,7 +1161,7 @@ func submitTransaction(ctx context.Context, b Backend, tx *types.Transaction) (c
 		return common.Hash{}, err
 	}
 	if tx.To() == nil {
-		signer := types.MakeSigner(b.ChainConfig())
+		signer := types.MakeSigner(b.ChainConfig(), b.CurrentBlock().Number())
 		from, err := types.Sender(signer, tx)
 		if err != nil {
 			return common.Hash{}, err
@@ -1415,8 +1416,20 @@ func (api *PrivateDebugAPI) ChaindbCompact() error {
 	return nil
 }
 
-// SetHead rewinds the head of the blockchain to a previous block.
-func (api *PrivateDebugAPI) SetHead(number hexutil.Uint64) {
+// SetHead rewinds the head of the blockchain to a previous block. Rewinding below
+// the stable block drops final blocks and is refused, use ForceSetHead for that.
+func (api *PrivateDebugAPI) SetHead(number hexutil.Uint64) error {
+	stable, _ := api.b.HeaderByNumber(context.Background(), rpc.StableBlockNumber)
+	if stable != nil && uint64(number) < stable.Number.Uint64() {
+		return fmt.Errorf("head %d below stable block %d, use forceSetHead to rewind final blocks", number, stable.Number)
+	}
+	api.b.SetHead(uint64(number))
+	return nil
+}
+
+// ForceSetHead rewinds the head of the blockchain to a previous block, even below
+// the stable block, in which case the new head becomes the stable block.
+func (api *PrivateDebugAPI) ForceSetHead(number hexutil.Uint64) {
 	api.b.SetHead(uint64(number))
 }
 
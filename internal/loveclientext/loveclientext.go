@@ -20,6 +20,7 @@ package loveclientext
 var Modules = map[string]string{
 	"admin":    Admin_JS,
 	"debug":    Debug_JS,
+	"dpovp":    Dpovp_JS,
 	"network":  Love_JS,
 	"miner":    Miner_JS,
 	"net":      Net_JS,
@@ -53,6 +54,15 @@ networkClient._extend({
 			call: 'admin_importChain',
 			params: 1
 		}),
+		new networkClient._extend.Method({
+			name: 'reloadStarList',
+			call: 'admin_reloadStarList'
+		}),
+		new networkClient._extend.Method({
+			name: 'scheduleStarList',
+			call: 'admin_scheduleStarList',
+			params: 1
+		}),
 		new networkClient._extend.Method({
 			name: 'sleepBlocks',
 			call: 'admin_sleepBlocks',
@@ -115,6 +125,11 @@ networkClient._extend({
 			call: 'debug_setHead',
 			params: 1
 		}),
+		new networkClient._extend.Method({
+			name: 'forceSetHead',
+			call: 'debug_forceSetHead',
+			params: 1
+		}),
 		new networkClient._extend.Method({
 			name: 'seedHash',
 			call: 'debug_seedHash',
@@ -309,6 +324,67 @@ networkClient._extend({
 });
 `
 
+const Dpovp_JS = `
+networkClient._extend({
+	property: 'dpovp',
+	methods: [
+		new networkClient._extend.Method({
+			name: 'getStars',
+			call: 'dpovp_getStars',
+			params: 1,
+			inputFormatter: [null]
+		}),
+		new networkClient._extend.Method({
+			name: 'getStarsAtHash',
+			call: 'dpovp_getStarsAtHash',
+			params: 1
+		}),
+		new networkClient._extend.Method({
+			name: 'getSigner',
+			call: 'dpovp_getSigner',
+			params: 1,
+			inputFormatter: [null]
+		}),
+		new networkClient._extend.Method({
+			name: 'getConfirmations',
+			call: 'dpovp_getConfirmations',
+			params: 1
+		}),
+		new networkClient._extend.Method({
+			name: 'getFinality',
+			call: 'dpovp_getFinality',
+			params: 1
+		}),
+		new networkClient._extend.Method({
+			name: 'getLiveness',
+			call: 'dpovp_getLiveness',
+			params: 1,
+			inputFormatter: [null]
+		}),
+		new networkClient._extend.Method({
+			name: 'getJailStatus',
+			call: 'dpovp_getJailStatus',
+			params: 1,
+			inputFormatter: [null]
+		}),
+	],
+	properties: [
+		new networkClient._extend.Property({
+			name: 'schedule',
+			getter: 'dpovp_getSchedule'
+		}),
+		new networkClient._extend.Property({
+			name: 'stableBlock',
+			getter: 'dpovp_getStableBlock'
+		}),
+		new networkClient._extend.Property({
+			name: 'blsRegistration',
+			getter: 'dpovp_getBLSRegistration'
+		}),
+	]
+});
+`
+
 const Love_JS = `
 networkClient._extend({
 	property: 'network',
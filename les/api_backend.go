@@ -18,6 +18,7 @@ package les
 
 import (
 	"context"
+	"errors"
 	"math/big"
 
 	"github.com/LoveBlock/loveblock/accounts"
@@ -37,6 +38,10 @@ import (
 	"github.com/LoveBlock/loveblock/rpc"
 )
 
+// errStableUnknown is returned for the stable block until a server announced a
+// finality certificate of a block known to the light client.
+var errStableUnknown = errors.New("stable block not known yet")
+
 type LesApiBackend struct {
 	network *LightLoveblock
 	gpo     *gasprice.Oracle
@@ -59,6 +64,12 @@ func (b *LesApiBackend) HeaderByNumber(ctx context.Context, blockNr rpc.BlockNum
 	if blockNr == rpc.LatestBlockNumber || blockNr == rpc.PendingBlockNumber {
 		return b.network.blockchain.CurrentHeader(), nil
 	}
+	if blockNr == rpc.StableBlockNumber {
+		if header := b.network.blockchain.StableHeader(); header != nil {
+			return header, nil
+		}
+		return nil, errStableUnknown
+	}
 
 	return b.network.blockchain.GetHeaderByNumberOdr(ctx, uint64(blockNr))
 }
@@ -141,6 +152,10 @@ func (b *LesApiBackend) SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) e
 	return b.network.blockchain.SubscribeChainHeadEvent(ch)
 }
 
+func (b *LesApiBackend) SubscribeChainStableEvent(ch chan<- core.ChainStableEvent) event.Subscription {
+	return b.network.blockchain.SubscribeChainStableEvent(ch)
+}
+
 func (b *LesApiBackend) SubscribeChainSideEvent(ch chan<- core.ChainSideEvent) event.Subscription {
 	return b.network.blockchain.SubscribeChainSideEvent(ch)
 }
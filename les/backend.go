@@ -18,12 +18,14 @@
 package les
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/LoveBlock/loveblock/accounts"
 	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
 	"github.com/LoveBlock/loveblock/common/hexutil"
 	"github.com/LoveBlock/loveblock/consensus"
 	"github.com/LoveBlock/loveblock/core"
@@ -45,6 +47,11 @@ import (
 	rpc "github.com/LoveBlock/loveblock/rpc"
 )
 
+// errStarRegistry is returned when starting a light client on a chain whose
+// genesis block seeds the star registry, as the stars can't be resolved from
+// headers without the chain state.
+var errStarRegistry = errors.New("light clients don't support chains with a star registry")
+
 type LightLoveblock struct {
 	config *network.Config
 
@@ -88,6 +95,12 @@ func New(ctx *node.ServiceContext, config *network.Config) (*LightLoveblock, err
 	if _, isCompat := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !isCompat {
 		return nil, genesisErr
 	}
+	if err := core.CheckGenesisStars(chainDb); err != nil {
+		return nil, err
+	}
+	if commonDpovp.GenesisStarList() != nil {
+		return nil, errStarRegistry
+	}
 	log.Info("Initialised chain configuration", "config", chainConfig)
 
 	peers := newPeerSet()
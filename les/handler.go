@@ -86,6 +86,19 @@ type BlockChain interface {
 	SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription
 }
 
+// finalityChain is implemented by light chains tracking the stable block from the
+// finality certificates announced by servers.
+type finalityChain interface {
+	AddFinalityCertificate(cert *types.FinalityCertificate) (bool, error)
+}
+
+// stableChain is implemented by server chains knowing the finality certificate of
+// their stable block.
+type stableChain interface {
+	StableBlock() *types.Block
+	GetFinalityCertificate(hash common.Hash) *types.FinalityCertificate
+}
+
 type txPool interface {
 	AddRemotes(txs []*types.Transaction) []error
 	Status(hashes []common.Hash) []core.TxStatus
@@ -398,6 +411,15 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if pm.fetcher != nil {
 			pm.fetcher.announce(p, &req)
 		}
+		// Advance the stable block if the server proves a newer block final
+		if chain, ok := pm.blockchain.(finalityChain); ok {
+			var cert types.FinalityCertificate
+			if err := req.Update.decode().get("finality", &cert); err == nil {
+				if _, err := chain.AddFinalityCertificate(&cert); err != nil {
+					p.Log().Debug("Rejected announced finality certificate", "number", cert.Number, "hash", cert.Hash, "err", err)
+				}
+			}
+		}
 
 	case GetBlockHeadersMsg:
 		p.Log().Trace("Received block header request")
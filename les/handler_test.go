@@ -516,7 +516,7 @@ func TestTransactionStatusLes2(t *testing.T) {
 		}
 	}
 
-	signer := types.NewDefaultSigner(params.AllLovehashProtocolChanges.ChainId)
+	signer := types.NewDefaultSigner(params.TestChainConfig.ChainId)
 
 	// test error status by sending an underpriced transaction
 	tx0, _ := types.SignTx(types.NewTransaction(0, acc1Addr, big.NewInt(10000), params.TxGas, nil, nil), signer, testBankKey)
@@ -78,7 +78,7 @@ contract test {
 */
 
 func testChainGen(i int, block *core.BlockGen) {
-	signer := types.NewDefaultSigner(params.AllLovehashProtocolChanges.ChainId)
+	signer := types.NewDefaultSigner(params.TestChainConfig.ChainId)
 
 	switch i {
 	case 0:
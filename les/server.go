@@ -314,6 +314,21 @@ func (s *requestCostStats) update(msgCode, reqCnt, cost uint64) {
 	c.add(float64(reqCnt), float64(cost))
 }
 
+// stableCertificate returns the finality certificate of the server's stable
+// block, announced along with new heads to let light clients track the stable
+// block, or nil if there is none.
+func (pm *ProtocolManager) stableCertificate() *types.FinalityCertificate {
+	chain, ok := pm.blockchain.(stableChain)
+	if !ok {
+		return nil
+	}
+	stable := chain.StableBlock()
+	if stable == nil || stable.NumberU64() == 0 {
+		return nil
+	}
+	return chain.GetFinalityCertificate(stable.Hash())
+}
+
 func (pm *ProtocolManager) blockLoop() {
 	pm.wg.Add(1)
 	headCh := make(chan core.ChainHeadEvent, 10)
@@ -341,6 +356,9 @@ func (pm *ProtocolManager) blockLoop() {
 						log.Debug("Announcing block to peers", "number", number, "hash", hash, "td", td, "reorg", reorg)
 
 						announce := announceData{Hash: hash, Number: number, Td: td, ReorgDepth: reorg}
+						if cert := pm.stableCertificate(); cert != nil {
+							announce.Update = announce.Update.add("finality", cert)
+						}
 						var (
 							signed         bool
 							signedAnnounce announceData
@@ -52,8 +52,10 @@ type LightChain struct {
 	chainFeed     event.Feed
 	chainSideFeed event.Feed
 	chainHeadFeed event.Feed
+	stableFeed    event.Feed
 	scope         event.SubscriptionScope
 	genesisBlock  *types.Block
+	stableHeader  atomic.Value // Header of the latest block proven final, nil if none is known yet
 
 	mu      sync.RWMutex
 	chainmu sync.RWMutex
@@ -151,8 +153,18 @@ func (self *LightChain) loadLastState() error {
 		}
 	}
 
-	// Issue a status log and return
+	// Restore the stable header, unless the chain was rewound below it
 	header := self.hc.CurrentHeader()
+
+	var stable *types.Header
+	if hash := core.GetHeadStableBlockHash(self.chainDb); hash != (common.Hash{}) {
+		if stable = self.GetHeaderByHash(hash); stable != nil && stable.Number.Cmp(header.Number) > 0 {
+			stable = nil
+		}
+	}
+	self.stableHeader.Store(stable)
+
+	// Issue a status log and return
 	headerTd := self.GetTd(header.Hash(), header.Number.Uint64())
 	log.Info("Loaded most recent local header", "number", header.Number, "hash", header.Hash(), "td", headerTd)
 
@@ -441,6 +453,50 @@ func (self *LightChain) GetHeaderByNumberOdr(ctx context.Context, number uint64)
 // Config retrieves the header chain's chain configuration.
 func (self *LightChain) Config() *params.ChainConfig { return self.hc.Config() }
 
+// StableHeader returns the header of the latest block proven final by a finality
+// certificate, or nil if none was received yet.
+func (self *LightChain) StableHeader() *types.Header {
+	return self.stableHeader.Load().(*types.Header)
+}
+
+// AddFinalityCertificate verifies a finality certificate announced by a server
+// and, if it certifies a canonical block above the stable one, makes that block
+// the stable one. It returns whether the stable header advanced.
+func (self *LightChain) AddFinalityCertificate(cert *types.FinalityCertificate) (bool, error) {
+	self.mu.Lock()
+	header, err := self.addFinalityCertificate(cert)
+	self.mu.Unlock()
+
+	if header == nil {
+		return false, err
+	}
+	self.stableFeed.Send(core.ChainStableEvent{Block: types.NewBlockWithHeader(header)})
+	return true, nil
+}
+
+// addFinalityCertificate is the internal version of AddFinalityCertificate,
+// returning the new stable header if it advanced. The caller must hold mu.
+func (self *LightChain) addFinalityCertificate(cert *types.FinalityCertificate) (*types.Header, error) {
+	if stable := self.StableHeader(); stable != nil && cert.Number <= stable.Number.Uint64() {
+		return nil, nil
+	}
+	if core.GetCanonicalHash(self.chainDb, cert.Number) != cert.Hash {
+		return nil, core.ErrUnknownFinalityBlock
+	}
+	if err := core.VerifyLightFinalityCertificate(self.hc, self.engine, cert); err != nil {
+		return nil, err
+	}
+	if err := core.WriteFinalityCertificate(self.chainDb, cert); err != nil {
+		return nil, err
+	}
+	if err := core.WriteHeadStableBlockHash(self.chainDb, cert.Hash); err != nil {
+		return nil, err
+	}
+	header := self.GetHeader(cert.Hash, cert.Number)
+	self.stableHeader.Store(header)
+	return header, nil
+}
+
 func (self *LightChain) SyncCht(ctx context.Context) bool {
 	if self.odr.ChtIndexer() == nil {
 		return false
@@ -483,6 +539,11 @@ func (self *LightChain) SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) e
 	return self.scope.Track(self.chainHeadFeed.Subscribe(ch))
 }
 
+// SubscribeChainStableEvent registers a subscription of ChainStableEvent.
+func (self *LightChain) SubscribeChainStableEvent(ch chan<- core.ChainStableEvent) event.Subscription {
+	return self.scope.Track(self.stableFeed.Subscribe(ch))
+}
+
 // SubscribeChainSideEvent registers a subscription of ChainSideEvent.
 func (self *LightChain) SubscribeChainSideEvent(ch chan<- core.ChainSideEvent) event.Subscription {
 	return self.scope.Track(self.chainSideFeed.Subscribe(ch))
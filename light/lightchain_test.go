@@ -18,13 +18,16 @@ package light
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"math/big"
 	"testing"
 
 	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
 	"github.com/LoveBlock/loveblock/consensus/dpovp"
 	"github.com/LoveBlock/loveblock/core"
 	"github.com/LoveBlock/loveblock/core/types"
+	"github.com/LoveBlock/loveblock/crypto"
 	"github.com/LoveBlock/loveblock/lovedb"
 	"github.com/LoveBlock/loveblock/params"
 )
@@ -346,3 +349,78 @@ func TestReorgBadHeaderHashes(t *testing.T) {
 		t.Errorf("last header hash mismatch: have: %x, want %x", ncm.CurrentHeader().Hash(), headers[2].Hash())
 	}
 }
+
+// Tests that light chains verify announced finality certificates against their
+// headers, advance the stable header only to canonical blocks above it, and keep
+// it across restarts.
+func TestFinalityCertificates(t *testing.T) {
+	var keys []*ecdsa.PrivateKey
+	var stars commonDpovp.StarList
+	for i := 0; i < 2; i++ {
+		key, _ := crypto.GenerateKey()
+		keys = append(keys, key)
+		stars = append(stars, commonDpovp.AddrNodeIDMapping{Addr: crypto.PubkeyToAddress(key.PublicKey), Pubkey: crypto.FromECDSAPub(&key.PublicKey)[1:]})
+	}
+	commonDpovp.SetStarList(stars)
+
+	db, _ := lovedb.NewMemDatabase()
+	gspec := core.Genesis{Config: params.TestChainConfig}
+	genesis := gspec.MustCommit(db)
+	blocks, _ := core.GenerateChain(params.TestChainConfig, genesis, dpovp.NewFaker(), db, 4, func(i int, b *core.BlockGen) {
+		b.SetCoinbase(stars[0].Addr)
+	})
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	bc, _ := NewLightChain(&dummyOdr{db: db}, gspec.Config, dpovp.NewFaker())
+	if _, err := bc.InsertHeaderChain(headers, 1); err != nil {
+		t.Fatalf("failed to import headers: %v", err)
+	}
+	stableCh := make(chan core.ChainStableEvent, 10)
+	sub := bc.SubscribeChainStableEvent(stableCh)
+	defer sub.Unsubscribe()
+
+	vote := func(header *types.Header) [][]byte {
+		sig, _ := crypto.Sign(header.Hash().Bytes(), keys[1])
+		return [][]byte{sig}
+	}
+	tests := []struct {
+		cert    *types.FinalityCertificate
+		err     error
+		stable  *types.Header
+		advance bool
+	}{
+		// The author alone is no quorum of two stars
+		{&types.FinalityCertificate{Hash: headers[1].Hash(), Number: 2}, core.ErrInsufficientVotes, nil, false},
+		{&types.FinalityCertificate{Hash: headers[1].Hash(), Number: 2, Votes: vote(headers[1])}, nil, headers[1], true},
+		{&types.FinalityCertificate{Hash: headers[0].Hash(), Number: 1, Votes: vote(headers[0])}, nil, headers[1], false},
+		{&types.FinalityCertificate{Hash: common.Hash{0x01}, Number: 3, Votes: vote(headers[2])}, core.ErrUnknownFinalityBlock, headers[1], false},
+		{&types.FinalityCertificate{Hash: headers[2].Hash(), Number: 3, Signers: big.NewInt(2), Aggregate: []byte{0x01}}, core.ErrAggregateWithoutState, headers[1], false},
+		{&types.FinalityCertificate{Hash: headers[3].Hash(), Number: 4, Votes: vote(headers[3])}, nil, headers[3], true},
+	}
+	for i, tt := range tests {
+		advanced, err := bc.AddFinalityCertificate(tt.cert)
+		if err != tt.err || advanced != tt.advance {
+			t.Errorf("test %d: result mismatch: have %v (%v), want %v (%v)", i, advanced, err, tt.advance, tt.err)
+		}
+		if stable := bc.StableHeader(); stable != tt.stable && (stable == nil || tt.stable == nil || stable.Hash() != tt.stable.Hash()) {
+			t.Errorf("test %d: stable header mismatch: have %v, want %v", i, stable, tt.stable)
+		}
+	}
+	for _, want := range []*types.Header{headers[1], headers[3]} {
+		select {
+		case ev := <-stableCh:
+			if ev.Block.Hash() != want.Hash() {
+				t.Errorf("stable event mismatch: have %d, want %d", ev.Block.NumberU64(), want.Number)
+			}
+		default:
+			t.Fatalf("missing stable event for block %d", want.Number)
+		}
+	}
+	// The stable header is restored on restart
+	restarted, _ := NewLightChain(&dummyOdr{db: db}, gspec.Config, dpovp.NewFaker())
+	if stable := restarted.StableHeader(); stable == nil || stable.Hash() != headers[3].Hash() {
+		t.Errorf("restored stable header mismatch: have %v, want %d", stable, headers[3].Number)
+	}
+}
@@ -190,7 +190,7 @@ func odrContractCall(ctx context.Context, db lovedb.Database, bc *core.BlockChai
 }
 
 func testChainGen(i int, block *core.BlockGen) {
-	signer := types.NewDefaultSigner(params.AllLovehashProtocolChanges.ChainId)
+	signer := types.NewDefaultSigner(params.TestChainConfig.ChainId)
 	switch i {
 	case 0:
 		// In block 1, the test bank sends account #1 some network.
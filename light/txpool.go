@@ -305,7 +305,7 @@ func (pool *TxPool) setNewHead(head *types.Header) {
 	txc, _ := pool.reorgOnNewHead(ctx, head)
 	m, r := txc.getLists()
 	pool.relay.NewHead(pool.head, m, r)
-	pool.signer = types.MakeSigner(pool.config)
+	pool.signer = types.MakeSigner(pool.config, head.Number)
 }
 
 // Stop stops the light transaction pool
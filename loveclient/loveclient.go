@@ -63,7 +63,7 @@ func (ec *Client) BlockByHash(ctx context.Context, hash common.Hash) (*types.Blo
 }
 
 // BlockByNumber returns a block from the current canonical chain. If number is nil, the
-// latest known block is returned.
+// latest known block is returned, if it is rpc.StableBlockNumber the stable one.
 //
 // Note that loading full blocks requires two requests. Use HeaderByNumber
 // if you don't need all transactions or uncle headers.
@@ -150,6 +150,22 @@ func (ec *Client) HeaderByHash(ctx context.Context, hash common.Hash) (*types.He
 	return head, err
 }
 
+// StableBlock returns the stable block, the latest block proven final by the stars.
+// Unlike the head of the chain, it is never reorganised away.
+func (ec *Client) StableBlock(ctx context.Context) (*types.Block, error) {
+	return ec.getBlock(ctx, "network_getBlockByNumber", "stable", true)
+}
+
+// StableHeader returns the header of the stable block.
+func (ec *Client) StableHeader(ctx context.Context) (*types.Header, error) {
+	var head *types.Header
+	err := ec.c.CallContext(ctx, &head, "network_getBlockByNumber", "stable", false)
+	if err == nil && head == nil {
+		err = loveblock.NotFound
+	}
+	return head, err
+}
+
 // HeaderByNumber returns a block header from the current canonical chain. If number is
 // nil, the latest known header is returned.
 func (ec *Client) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
@@ -258,6 +274,9 @@ func toBlockNumArg(number *big.Int) string {
 	if number == nil {
 		return "latest"
 	}
+	if number.Cmp(big.NewInt(rpc.StableBlockNumber.Int64())) == 0 {
+		return "stable"
+	}
 	return hexutil.EncodeBig(number)
 }
 
@@ -294,6 +313,12 @@ func (ec *Client) SyncProgress(ctx context.Context) (*loveblock.SyncProgress, er
 	}, nil
 }
 
+// SubscribeNewStableHead subscribes to notifications about the stable block of the
+// chain on the given channel, fired each time a new block is proven final.
+func (ec *Client) SubscribeNewStableHead(ctx context.Context, ch chan<- *types.Header) (loveblock.Subscription, error) {
+	return ec.c.LoveSubscribe(ctx, ch, "newStableHeads", map[string]struct{}{})
+}
+
 // SubscribeNewHead subscribes to notifications about the current blockchain head
 // on the given channel.
 func (ec *Client) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (loveblock.Subscription, error) {
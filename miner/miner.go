@@ -23,7 +23,6 @@ import (
 
 	"github.com/LoveBlock/loveblock/accounts"
 	"github.com/LoveBlock/loveblock/common"
-	dpovpCommon "github.com/LoveBlock/loveblock/common/dpovp"
 	"github.com/LoveBlock/loveblock/consensus"
 	"github.com/LoveBlock/loveblock/consensus/dpovp"
 	"github.com/LoveBlock/loveblock/core"
@@ -118,7 +117,7 @@ func (self *Miner) Start(coinbase common.Address) {
 		log.Warn("Not a Star Node!")
 		return
 	}
-	if dpovpCommon.GetCoreNodesCount() == 0 {
+	if len(self.network.BlockChain().CurrentStars()) == 0 {
 		log.Warn("At least one star node!")
 		return
 	}
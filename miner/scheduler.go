@@ -0,0 +1,140 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"sync"
+	"time"
+
+	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/common/mclock"
+	"github.com/LoveBlock/loveblock/consensus/dpovp"
+	"github.com/LoveBlock/loveblock/log"
+)
+
+// slotScheduler wakes the worker when the slot of the local star on top of the
+// current head opens. It arms a single timer per slot window: a new head cancels
+// it and schedules the slot on top of the new head, and a window passing without
+// a new head schedules the star's window of the next round.
+type slotScheduler struct {
+	clock    mclock.Clock
+	timeout  int64 // 超时时间 单位：ms
+	interval int64 // 出块间隔 单位：ms
+
+	sealCh chan struct{} // Signals that the local star's slot opened
+
+	mu    sync.Mutex
+	timer mclock.Timer // Timer of the next slot, nil if none is scheduled
+}
+
+// slotHead is the part of the chain head the slot schedule is derived from.
+type slotHead struct {
+	coinbase common.Address       // Author of the head
+	time     int64                // Time of the head in milliseconds
+	stars    commonDpovp.StarList // Stars allowed to produce on top of the head
+}
+
+func newSlotScheduler(clock mclock.Clock, timeout, interval int64) *slotScheduler {
+	return &slotScheduler{
+		clock:    clock,
+		timeout:  timeout,
+		interval: interval,
+		sealCh:   make(chan struct{}, 1),
+	}
+}
+
+// now returns the current time of the scheduler's clock in milliseconds.
+func (s *slotScheduler) now() int64 {
+	return s.clock.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// window returns the first window, in milliseconds since the unix epoch, in which
+// the given star may produce a block on top of head and which doesn't end before
+// from. An end of 0 means the window never closes. The windows are the ones the
+// consensus engine accepts blocks in.
+func (s *slotScheduler) window(head *slotHead, star common.Address, from int64) (int64, int64) {
+	slot := head.stars.Slot(&head.coinbase, &star)
+	return dpovp.SlotWindow(len(head.stars), slot, head.time, from, s.timeout, s.interval)
+}
+
+// schedule cancels the pending wake-up and arms the one of the next slot of the
+// given star on top of head.
+func (s *slotScheduler) schedule(head *slotHead, star common.Address) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.arm(head, star, s.now())
+}
+
+// arm schedules the wake-up of the first window of the star not ending before
+// from. The caller must hold the lock.
+func (s *slotScheduler) arm(head *slotHead, star common.Address, from int64) {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if head.stars.Index(&star) < 0 {
+		log.Debug("Not in the active star list, slot scheduling paused", "star", star)
+		return
+	}
+	start, end := s.window(head, star, from)
+
+	wait := start - s.now()
+	if wait < 0 {
+		wait = 0
+	}
+	log.Debug("Scheduled next slot", "star", star, "wait", common.PrettyDuration(time.Duration(wait)*time.Millisecond))
+
+	var timer mclock.Timer
+	timer = s.clock.AfterFunc(time.Duration(wait)*time.Millisecond, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.timer != timer {
+			return // Rescheduled or stopped meanwhile
+		}
+		select {
+		case s.sealCh <- struct{}{}:
+		default:
+		}
+		// Should no block make it into the chain, produce in the next round
+		if end != 0 {
+			s.arm(head, star, end)
+		} else {
+			retry := *head
+			retry.time = s.now()
+			s.arm(&retry, star, retry.time)
+		}
+	})
+	s.timer = timer
+}
+
+// stop cancels the pending wake-up and drops a wake-up not yet handled.
+func (s *slotScheduler) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	select {
+	case <-s.sealCh:
+	default:
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/common/mclock"
+)
+
+var testStars = commonDpovp.StarList{
+	{Addr: common.Address{1}},
+	{Addr: common.Address{2}},
+	{Addr: common.Address{3}},
+}
+
+// Tests that the slot windows of the stars on top of a head match the schedule
+// enforced by the consensus engine.
+func TestSlotWindow(t *testing.T) {
+	scheduler := newSlotScheduler(mclock.NewSimulated(time.Unix(0, 0)), 2000, 1000)
+	head := &slotHead{coinbase: testStars[0].Addr, time: 10000, stars: testStars}
+
+	tests := []struct {
+		star       int
+		from       int64
+		start, end int64
+	}{
+		{1, 10000, 11000, 12000}, // Next star, after the block interval
+		{2, 10000, 12000, 14000}, // Star after the next one, once the next star timed out
+		{0, 10000, 14000, 16000}, // Author of the head, last in the round
+		{1, 12000, 16000, 18000}, // Next star missed its window, next round
+		{2, 13999, 12000, 14000}, // Window still open
+		{2, 14000, 18000, 20000}, // Window just closed
+	}
+	for i, tt := range tests {
+		start, end := scheduler.window(head, testStars[tt.star].Addr, tt.from)
+		if start != tt.start || end != tt.end {
+			t.Errorf("test %d: window mismatch: have [%d, %d), want [%d, %d)", i, start, end, tt.start, tt.end)
+		}
+	}
+	single := &slotHead{coinbase: testStars[0].Addr, time: 10000, stars: testStars[:1]}
+	if start, end := scheduler.window(single, testStars[0].Addr, 50000); start != 11000 || end != 0 {
+		t.Errorf("single star window mismatch: have [%d, %d), want [11000, 0)", start, end)
+	}
+}
+
+// Tests that the scheduler wakes up exactly once when the local star's slot
+// opens, moves on to the next round if no block arrives, and reschedules on a
+// new head.
+func TestSlotScheduler(t *testing.T) {
+	clock := mclock.NewSimulated(time.Unix(10, 0))
+	scheduler := newSlotScheduler(clock, 2000, 1000)
+
+	woken := func() bool {
+		select {
+		case <-scheduler.sealCh:
+			return true
+		default:
+			return false
+		}
+	}
+	local := testStars[1].Addr
+	scheduler.schedule(&slotHead{coinbase: testStars[0].Addr, time: 10000, stars: testStars}, local)
+
+	clock.Run(999 * time.Millisecond)
+	if woken() {
+		t.Fatalf("woken up before the slot opened")
+	}
+	clock.Run(time.Millisecond)
+	if !woken() {
+		t.Fatalf("not woken up when the slot opened")
+	}
+	clock.Run(4999 * time.Millisecond)
+	if woken() {
+		t.Fatalf("woken up twice in the same window")
+	}
+	if clock.ActiveTimers() != 1 {
+		t.Fatalf("next round timer count mismatch: have %d, want 1", clock.ActiveTimers())
+	}
+	// No block in the window, the star is up again in the next round
+	clock.Run(time.Millisecond)
+	if !woken() {
+		t.Fatalf("not woken up in the next round")
+	}
+	// A new head by another star reschedules the slot
+	scheduler.schedule(&slotHead{coinbase: testStars[2].Addr, time: 16000, stars: testStars}, local)
+	if clock.ActiveTimers() != 1 {
+		t.Fatalf("timer count mismatch after new head: have %d, want 1", clock.ActiveTimers())
+	}
+	clock.Run(1999 * time.Millisecond)
+	if woken() {
+		t.Fatalf("woken up before the rescheduled slot opened")
+	}
+	clock.Run(time.Millisecond)
+	if !woken() {
+		t.Fatalf("not woken up when the rescheduled slot opened")
+	}
+	// Stars out of the active list aren't scheduled
+	scheduler.schedule(&slotHead{coinbase: testStars[2].Addr, time: 18000, stars: testStars[:1]}, local)
+	if clock.ActiveTimers() != 0 {
+		t.Errorf("inactive star scheduled: %d timers", clock.ActiveTimers())
+	}
+	scheduler.schedule(&slotHead{coinbase: testStars[2].Addr, time: 18000, stars: testStars}, local)
+	scheduler.stop()
+	if clock.ActiveTimers() != 0 {
+		t.Errorf("timers still pending after stop: %d", clock.ActiveTimers())
+	}
+}
@@ -18,13 +18,13 @@ package miner
 
 import (
 	"fmt"
-	"math/big"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/LoveBlock/loveblock/common"
 	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/common/mclock"
 	"github.com/LoveBlock/loveblock/consensus"
 	"github.com/LoveBlock/loveblock/core"
 	"github.com/LoveBlock/loveblock/core/state"
@@ -48,6 +48,8 @@ const (
 	chainHeadChanSize = 10
 	// chainSideChanSize is the size of channel listening to ChainSideEvent.
 	chainSideChanSize = 10
+	// starListChanSize is the size of channel listening to StarListEvent.
+	starListChanSize = 1
 )
 
 // Agent can register themself with the worker
@@ -99,6 +101,8 @@ type worker struct {
 	chainHeadSub event.Subscription
 	chainSideCh  chan core.ChainSideEvent
 	chainSideSub event.Subscription
+	starListCh   chan commonDpovp.StarListEvent
+	starListSub  event.Subscription
 	wg           sync.WaitGroup
 
 	agents map[Agent]struct{}
@@ -125,12 +129,16 @@ type worker struct {
 	atWork int32
 
 	// sman for dpovp
-	timeoutTime     int64               // 超时时间
-	blockInternal   int64               // 出块间隔
-	blockMinerTimer *time.Timer         // 出块timer
-	time2SealCh     chan struct{}       // time2SealCh
-	sealStopCh      chan struct{}       // miner.stop()时
-	currentBlock    func() *types.Block // 获取当前block的回调
+	scheduler    *slotScheduler      // 出块时机调度器
+	clock        mclock.Clock        // 出块时钟，测试时可替换为模拟时钟
+	sealStopCh   chan struct{}       // miner.stop()时
+	currentBlock func() *types.Block // 获取当前block的回调
+}
+
+// clockedEngine is implemented by consensus engines scheduling blocks with a
+// replaceable clock, which the worker then shares.
+type clockedEngine interface {
+	Clock() mclock.Clock
 }
 
 func newWorker(config *params.ChainConfig, engine consensus.Engine, coinbase common.Address, network Backend, mux *event.TypeMux) *worker {
@@ -142,6 +150,7 @@ func newWorker(config *params.ChainConfig, engine consensus.Engine, coinbase com
 		txCh:           make(chan core.TxPreEvent, txChanSize),
 		chainHeadCh:    make(chan core.ChainHeadEvent, chainHeadChanSize),
 		chainSideCh:    make(chan core.ChainSideEvent, chainSideChanSize),
+		starListCh:     make(chan commonDpovp.StarListEvent, starListChanSize),
 		chainDb:        network.ChainDb(),
 		recv:           make(chan *Result, resultQueueSize),
 		chain:          network.BlockChain(),
@@ -156,12 +165,16 @@ func newWorker(config *params.ChainConfig, engine consensus.Engine, coinbase com
 	// Subscribe events for blockchain
 	worker.chainHeadSub = network.BlockChain().SubscribeChainHeadEvent(worker.chainHeadCh)
 	worker.chainSideSub = network.BlockChain().SubscribeChainSideEvent(worker.chainSideCh)
+	// Subscribe star list reloads
+	worker.starListSub = commonDpovp.SubscribeStarListEvent(worker.starListCh)
 
 	// sman for dpovp
-	worker.time2SealCh = make(chan struct{}, 1)
 	worker.sealStopCh = make(chan struct{}, 1)
-	worker.blockInternal = config.Dpovp.Sleeptime
-	worker.timeoutTime = config.Dpovp.Timeout
+	worker.clock = mclock.System{}
+	if engine, ok := engine.(clockedEngine); ok && engine.Clock() != nil {
+		worker.clock = engine.Clock() // 与共识引擎使用同一个时钟
+	}
+	worker.scheduler = newSlotScheduler(worker.clock, config.Dpovp.Timeout, config.Dpovp.Sleeptime)
 	worker.currentBlock = func() *types.Block {
 		return network.BlockChain().CurrentBlock()
 	}
@@ -173,128 +186,33 @@ func newWorker(config *params.ChainConfig, engine consensus.Engine, coinbase com
 	return worker
 }
 
-// 提交出块任务前先重置下定时器 防止出块过程出错阻塞定时器
-func (self *worker) beforeCommitNewWork() {
-	// 出块之后需要重置定时器
-	nodeCount := commonDpovp.GetCoreNodesCount()
-	var timeDur int64
-	if nodeCount > 1 {
-		timeDur = int64(nodeCount-1) * self.timeoutTime
-	} else {
-		timeDur = self.blockInternal
-	}
-	log.Debug(fmt.Sprintf("worker-beforeCommitNewWork: timeDur:%d", timeDur))
-	self.resetMinerTimer(timeDur)
-}
-
-// 修改定时器
-func (self *worker) modifyTimer() {
-	nodeCount := commonDpovp.GetCoreNodesCount()
-	// 只有一个主节点
-	if nodeCount == 1 {
-		waitTime := self.blockInternal
-		self.resetMinerTimer(waitTime)
-		log.Debug(fmt.Sprintf("modifyTimer: waitTime:%d", waitTime))
-		return
+// 根据最新区块和主节点列表调度本节点的下一个出块时机
+func (self *worker) reschedule() {
+	header := self.currentBlock().Header()
+	head := &slotHead{
+		coinbase: header.Coinbase,
+		time:     int64(header.MilliTime(self.config.Dpovp)),
+		stars:    self.chain.CurrentStars(),
 	}
-	timeDur := self.getTimespan()                                                           // 获取当前时间与最新块的时间差
-	slot := commonDpovp.GetSlot(&(self.currentBlock().Header().Coinbase), &(self.coinbase)) // 获取新块离本节点索引的距离
-	oneLoopTime := int64(nodeCount) * self.timeoutTime
-	log.Debug(fmt.Sprintf("modifyTimer: timeDur:%d slot:%d oneLoopTime:%d", timeDur, slot, oneLoopTime))
-	if slot == 0 { // 上一个块为自己出的块
-		if timeDur > oneLoopTime { // 间隔大于一轮
-			timeDur = timeDur % oneLoopTime // 求余
-			waitTime := int64(nodeCount-1)*self.timeoutTime - timeDur
-			if waitTime <= 0 {
-				log.Debug(fmt.Sprintf("modifyTimer: slot:0 oneLoopTime:%d", oneLoopTime))
-				self.time2SealCh <- struct{}{}
-			} else {
-				self.resetMinerTimer(waitTime)
-				log.Debug(fmt.Sprintf("modifyTimer: waitTime:%d", waitTime))
-			}
-			log.Debug(fmt.Sprintf("modifyTimer: slot:0 resetMinerTimer(waitTime:%d)", waitTime))
-		}
-	} else if slot == 1 { // 说明下一个区块就该本节点产生了
-		if timeDur > oneLoopTime { // 间隔大于一轮
-			timeDur = timeDur % oneLoopTime // 求余
-			log.Debug(fmt.Sprintf("modifyTimer: slot:1 timeDur:%d>oneLoopTime:%d ", timeDur, oneLoopTime))
-			if timeDur < self.timeoutTime { //
-				log.Debug("modifyTimer: start seal")
-				self.time2SealCh <- struct{}{}
-			} else {
-				waitTime := oneLoopTime - timeDur
-				self.resetMinerTimer(waitTime)
-				log.Debug(fmt.Sprintf("ModifyTimer: slot:1 timeDur:%d>=self.timeoutTime:%d resetMinerTimer(waitTime:%d)", timeDur, self.timeoutTime, waitTime))
-			}
-		} else { // 间隔不到一轮
-			if timeDur > self.timeoutTime { // 过了本节点该出块的时机
-				waitTime := oneLoopTime - timeDur
-				self.resetMinerTimer(waitTime)
-				log.Debug(fmt.Sprintf("modifyTimer: slot:1 timeDur<oneLoopTime, timeDur>self.timeoutTime, resetMinerTimer(waitTime:%d)", waitTime))
-			} else if timeDur >= self.blockInternal { // 如果上一个区块的时间与当前时间差大或等于3s（区块间的最小间隔为3s），则直接出块无需休眠
-				log.Debug(fmt.Sprintf("modifyTimer: slot:1 timeDur<oneLoopTime, timeDur>=self.blockInternal isTurn=true"))
-				self.time2SealCh <- struct{}{}
-			} else {
-				waitTime := self.blockInternal - timeDur // 如果上一个块时间与当前时间非常近（小于3s），则设置休眠
-				self.resetMinerTimer(waitTime)
-				log.Debug(fmt.Sprintf("modifyTimer: slot:1, else, resetMinerTimer(waitTime:%d)", waitTime))
-			}
-		}
-	} else { // 说明还不该自己出块，但是需要修改超时时间了
-		timeDur = timeDur % oneLoopTime
-		if timeDur >= int64(slot-1)*self.timeoutTime && timeDur < int64(slot)*self.timeoutTime {
-			self.time2SealCh <- struct{}{}
-			log.Debug(fmt.Sprintf("modifyTimer: start seal,slot>1,timeDur:%d", timeDur))
-		} else {
-			waitTime := (int64(slot-1)*self.timeoutTime - timeDur + oneLoopTime) % oneLoopTime
-			self.resetMinerTimer(waitTime)
-			log.Debug(fmt.Sprintf("modifyTimer: slot:>1, timeDur:%d, resetMinerTimer(waitTime:%d)", timeDur, waitTime))
-		}
+	if header.Time.Sign() == 0 { // 创世块时间为0时直接出块
+		log.Debug("worker-reschedule: current block's time is 0")
+		head.time = self.scheduler.now() - self.scheduler.interval
 	}
+	self.scheduler.schedule(head, self.coinbase)
 }
 
-// 重置出块定时器
-func (self *worker) resetMinerTimer(timeDur int64) {
-	// 停掉之前的定时器
-	if self.blockMinerTimer != nil {
-		self.blockMinerTimer.Stop()
-	}
-	// 重开新的定时器
-	self.blockMinerTimer = time.AfterFunc(time.Duration(timeDur*int64(time.Millisecond)), func() {
-		log.Debug("resetMinerTimer: isTurn=true")
-		if atomic.LoadInt32(&self.mining) == 1 {
-			self.time2SealCh <- struct{}{}
-		}
-	})
-}
-
-// 准备出块
+// 等待出块时机 出块
 func (self *worker) waitToSeal() {
-done:
 	for {
 		select {
-		case <-self.time2SealCh:
-			self.beforeCommitNewWork()
+		case <-self.scheduler.sealCh:
 			self.commitNewWork()
 		case <-self.sealStopCh:
-			break done
-		default:
-
+			return
 		}
 	}
 }
 
-// 获取最新区块的时间戳离当前时间的距离 单位：ms
-func (self *worker) getTimespan() int64 {
-	lstSpan := self.currentBlock().Header().Time.Int64()
-	if lstSpan == int64(0) {
-		log.Debug("worker-getTimespan: current block's time is 0")
-		return int64(self.blockInternal)
-	}
-	now := time.Now().Unix()
-	return (now - lstSpan) * 1000
-}
-
 func (self *worker) setLovebase(addr common.Address) {
 	self.mu.Lock()
 	defer self.mu.Unlock()
@@ -350,8 +268,8 @@ func (self *worker) start() {
 	}
 
 	go self.waitToSeal()
-	// 启动挖矿时 重置定时器
-	go self.modifyTimer()
+	// 启动挖矿时 调度出块时机
+	self.reschedule()
 
 	log.Debug("worker-start: start worker")
 }
@@ -367,11 +285,8 @@ func (self *worker) stop() {
 			agent.Stop()
 		}
 	}
-	select {
-	case <-self.time2SealCh:
-	default:
-	}
 	atomic.StoreInt32(&self.mining, 0)
+	self.scheduler.stop()
 	atomic.StoreInt32(&self.atWork, 0)
 	log.Debug("worker-stop: stop worker")
 }
@@ -394,15 +309,23 @@ func (self *worker) update() {
 	defer self.txSub.Unsubscribe()
 	defer self.chainHeadSub.Unsubscribe()
 	defer self.chainSideSub.Unsubscribe()
+	defer self.starListSub.Unsubscribe()
 
 	for {
 		// A real event arrived, process interesting content
 		select {
 		// Handle ChainHeadEvent
 		case <-self.chainHeadCh:
-			// 收到新块广播 修改定时器
+			// 收到新块 重新调度出块时机
+			if atomic.LoadInt32(&self.mining) == 1 {
+				self.reschedule()
+			}
+
+		// Handle StarListEvent
+		case <-self.starListCh:
+			// 主节点列表变化 重新调度出块时机
 			if atomic.LoadInt32(&self.mining) == 1 {
-				self.modifyTimer()
+				self.reschedule()
 			}
 
 		// Handle ChainSideEvent
@@ -436,6 +359,8 @@ func (self *worker) update() {
 			return
 		case <-self.chainSideSub.Err():
 			return
+		case <-self.starListSub.Err():
+			return
 		}
 	}
 }
@@ -457,6 +382,11 @@ func (self *worker) wait() {
 				for _, log := range work.state.Logs() {
 					log.BlockHash = block.Hash()
 				}
+				// 同一高度已确认了其他块, 不再发布自己的块
+				if !self.chain.ConfirmMined(block.Hash(), block.NumberU64()) {
+					log.Debug("worker-wait: another block of the height was confirmed, dropping sealed block", "number", block.Number(), "hash", block.Hash())
+					continue
+				}
 				stat, err := self.chain.WriteBlockWithState(block, work.receipts, work.state)
 				if err != nil {
 					log.Error("worker-wait: Failed writing block to chain", "err", err)
@@ -511,7 +441,7 @@ func (self *worker) makeCurrent(parent *types.Block, header *types.Header) error
 	}
 	work := &Work{
 		config:    self.config,
-		signer:    types.NewDefaultSigner(self.config.ChainId),
+		signer:    types.MakeSigner(self.config, header.Number),
 		state:     state,
 		ancestors: set.New(),
 		family:    set.New(),
@@ -543,17 +473,17 @@ func (self *worker) commitNewWork() {
 	self.currentMu.Lock()
 	defer self.currentMu.Unlock()
 
-	tstart := time.Now()
+	tstart := self.clock.Now()
 	parent := self.chain.CurrentBlock()
-	tstamp := tstart.Unix()
-	if parent.Time().Cmp(new(big.Int).SetInt64(tstamp)) >= 0 {
-		tstamp = parent.Time().Int64() + 1
+	tstamp := tstart.UnixNano() / int64(time.Millisecond) // 单位：ms
+	if last := int64(parent.Header().MilliTime(self.config.Dpovp)); last >= tstamp {
+		tstamp = last + 1
 	}
 	// this will ensure we're not going off too far in the future
-	if now := time.Now().Unix(); tstamp > now+1 {
-		wait := time.Duration(tstamp-now) * time.Second
+	if now := self.clock.Now().UnixNano() / int64(time.Millisecond); tstamp > now+1000 {
+		wait := time.Duration(tstamp-now) * time.Millisecond
 		log.Info("Mining too far in the future", "wait", common.PrettyDuration(wait))
-		time.Sleep(wait)
+		self.clock.Sleep(wait)
 	}
 
 	num := parent.Number()
@@ -562,8 +492,8 @@ func (self *worker) commitNewWork() {
 		Number:     num.Add(num, common.Big1),
 		GasLimit:   core.CalcGasLimit(parent),
 		Extra:      self.extra,
-		Time:       big.NewInt(tstamp),
 	}
+	header.SetMilliTime(self.config.Dpovp, uint64(tstamp))
 	// Only set the coinbase if we are mining (avoid spurious block rewards)
 	if atomic.LoadInt32(&self.mining) == 1 {
 		header.Coinbase = self.coinbase
@@ -26,6 +26,7 @@ import (
 	"strings"
 
 	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
 	"github.com/LoveBlock/loveblock/common/hexutil"
 	"github.com/LoveBlock/loveblock/core"
 	"github.com/LoveBlock/loveblock/core/state"
@@ -214,6 +215,38 @@ func hasAllBlocks(chain *core.BlockChain, bs []*types.Block) bool {
 	return true
 }
 
+// ReloadStarList reloads the star list from the starlist file in the data
+// directory, returning the addresses of the stars in slot order. The new list
+// takes effect from the next epoch checkpoint on. Chains without epochs, or whose
+// genesis block defines the stars, reject the reload.
+func (api *PrivateAdminAPI) ReloadStarList() ([]common.Address, error) {
+	return api.reloadStarList(0)
+}
+
+// ScheduleStarList reloads the star list like ReloadStarList, but schedules it
+// from the given epoch checkpoint on, so all nodes can agree on the block.
+func (api *PrivateAdminAPI) ScheduleStarList(from hexutil.Uint64) ([]common.Address, error) {
+	return api.reloadStarList(uint64(from))
+}
+
+// reloadStarList reloads the star list from the starlist file, scheduling it
+// from the requested block or, if 0, from the engine's default one on.
+func (api *PrivateAdminAPI) reloadStarList(from uint64) ([]common.Address, error) {
+	activation, err := api.network.starListActivation(from)
+	if err != nil {
+		return nil, err
+	}
+	stars, err := commonDpovp.ReloadStarList(activation)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]common.Address, len(stars))
+	for i, star := range stars {
+		addrs[i] = star.Addr
+	}
+	return addrs, nil
+}
+
 // ImportChain imports a blockchain from a local file.
 func (api *PrivateAdminAPI) ImportChain(file string) (bool, error) {
 	// Make sure the can access the file to import
@@ -287,6 +320,8 @@ func (api *PublicDebugAPI) DumpBlock(blockNr rpc.BlockNumber) (state.Dump, error
 	var block *types.Block
 	if blockNr == rpc.LatestBlockNumber {
 		block = api.network.blockchain.CurrentBlock()
+	} else if blockNr == rpc.StableBlockNumber {
+		block = api.network.blockchain.StableBlock()
 	} else {
 		block = api.network.blockchain.GetBlockByNumber(uint64(blockNr))
 	}
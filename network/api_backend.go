@@ -69,6 +69,9 @@ func (b *LoveApiBackend) HeaderByNumber(ctx context.Context, blockNr rpc.BlockNu
 	if blockNr == rpc.LatestBlockNumber {
 		return b.network.blockchain.CurrentBlock().Header(), nil
 	}
+	if blockNr == rpc.StableBlockNumber {
+		return b.network.blockchain.StableBlock().Header(), nil
+	}
 	return b.network.blockchain.GetHeaderByNumber(uint64(blockNr)), nil
 }
 
@@ -85,6 +88,9 @@ func (b *LoveApiBackend) BlockByNumber(ctx context.Context, blockNr rpc.BlockNum
 	if blockNr == rpc.LatestBlockNumber {
 		return b.network.blockchain.CurrentBlock(), nil
 	}
+	if blockNr == rpc.StableBlockNumber {
+		return b.network.blockchain.StableBlock(), nil
+	}
 	return b.network.blockchain.GetBlockByNumber(uint64(blockNr)), nil
 }
 
@@ -147,6 +153,10 @@ func (b *LoveApiBackend) SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent)
 	return b.network.BlockChain().SubscribeChainHeadEvent(ch)
 }
 
+func (b *LoveApiBackend) SubscribeChainStableEvent(ch chan<- core.ChainStableEvent) event.Subscription {
+	return b.network.BlockChain().SubscribeChainStableEvent(ch)
+}
+
 func (b *LoveApiBackend) SubscribeChainSideEvent(ch chan<- core.ChainSideEvent) event.Subscription {
 	return b.network.BlockChain().SubscribeChainSideEvent(ch)
 }
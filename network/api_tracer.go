@@ -100,6 +100,8 @@ func (api *PrivateDebugAPI) TraceChain(ctx context.Context, start, end rpc.Block
 		from = api.network.miner.PendingBlock()
 	case rpc.LatestBlockNumber:
 		from = api.network.blockchain.CurrentBlock()
+	case rpc.StableBlockNumber:
+		from = api.network.blockchain.StableBlock()
 	default:
 		from = api.network.blockchain.GetBlockByNumber(uint64(start))
 	}
@@ -108,6 +110,8 @@ func (api *PrivateDebugAPI) TraceChain(ctx context.Context, start, end rpc.Block
 		to = api.network.miner.PendingBlock()
 	case rpc.LatestBlockNumber:
 		to = api.network.blockchain.CurrentBlock()
+	case rpc.StableBlockNumber:
+		to = api.network.blockchain.StableBlock()
 	default:
 		to = api.network.blockchain.GetBlockByNumber(uint64(end))
 	}
@@ -188,7 +192,7 @@ func (api *PrivateDebugAPI) traceChain(ctx context.Context, start, end *types.Bl
 
 			// Fetch and execute the next block trace tasks
 			for task := range tasks {
-				signer := types.MakeSigner(api.config)
+				signer := types.MakeSigner(api.config, task.block.Number())
 
 				// Trace all the transactions contained within
 				for i, tx := range task.block.Transactions() {
@@ -342,6 +346,8 @@ func (api *PrivateDebugAPI) TraceBlockByNumber(ctx context.Context, number rpc.B
 		block = api.network.miner.PendingBlock()
 	case rpc.LatestBlockNumber:
 		block = api.network.blockchain.CurrentBlock()
+	case rpc.StableBlockNumber:
+		block = api.network.blockchain.StableBlock()
 	default:
 		block = api.network.blockchain.GetBlockByNumber(uint64(number))
 	}
@@ -404,7 +410,7 @@ func (api *PrivateDebugAPI) traceBlock(ctx context.Context, block *types.Block,
 	}
 	// Execute all the transaction contained within the block concurrently
 	var (
-		signer = types.MakeSigner(api.config)
+		signer = types.MakeSigner(api.config, block.Number())
 
 		txs     = block.Transactions()
 		results = make([]*txTraceResult, len(txs))
@@ -626,7 +632,7 @@ func (api *PrivateDebugAPI) computeTxEnv(blockHash common.Hash, txIndex int, ree
 		return nil, vm.Context{}, nil, err
 	}
 	// Recompute transactions up to the target index.
-	signer := types.MakeSigner(api.config)
+	signer := types.MakeSigner(api.config, block.Number())
 
 	for idx, tx := range block.Transactions() {
 		// Assemble the transaction call message and return if the requested offset
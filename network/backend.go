@@ -27,6 +27,7 @@ import (
 
 	"github.com/LoveBlock/loveblock/accounts"
 	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
 	"github.com/LoveBlock/loveblock/common/hexutil"
 	"github.com/LoveBlock/loveblock/consensus"
 	"github.com/LoveBlock/loveblock/consensus/dpovp"
@@ -38,6 +39,7 @@ import (
 	"github.com/LoveBlock/loveblock/internal/loveapi"
 	"github.com/LoveBlock/loveblock/log"
 	"github.com/LoveBlock/loveblock/lovedb"
+	"github.com/LoveBlock/loveblock/metrics"
 	"github.com/LoveBlock/loveblock/miner"
 	"github.com/LoveBlock/loveblock/network/downloader"
 	"github.com/LoveBlock/loveblock/network/filters"
@@ -84,6 +86,7 @@ type Loveblock struct {
 	ApiBackend *LoveApiBackend
 
 	miner       *miner.Miner
+	starWatcher *commonDpovp.StarListWatcher // Reloads the star list on changes of the starlist file
 	gasPrice    *big.Int
 	networkbase common.Address
 
@@ -116,6 +119,9 @@ func New(ctx *node.ServiceContext, config *Config) (*Loveblock, error) {
 	if _, ok := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !ok {
 		return nil, genesisErr
 	}
+	if err := core.CheckGenesisStars(chainDb); err != nil {
+		return nil, err
+	}
 	log.Info("Initialised chain configuration", "config", chainConfig)
 
 	network := &Loveblock{
@@ -134,6 +140,9 @@ func New(ctx *node.ServiceContext, config *Config) (*Loveblock, error) {
 	}
 	// sman modify
 	network.engine = CreateConsensusEngine(ctx, chainConfig, chainDb, config.Lovebase)
+	if engine, ok := network.engine.(*dpovp.Dpovp); ok && config.Clock != nil {
+		engine.SetClock(config.Clock)
+	}
 
 	log.Info("Initialising Loveblock protocol", "versions", ProtocolVersions, "network", config.NetworkId)
 
@@ -160,6 +169,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Loveblock, error) {
 		core.WriteChainConfig(chainDb, genesisHash, chainConfig)
 	}
 	network.bloomIndexer.Start(network.blockchain)
+	network.starWatcher = commonDpovp.NewStarListWatcher(func() (uint64, error) { return network.starListActivation(0) })
 
 	if config.TxPool.Journal != "" {
 		config.TxPool.Journal = ctx.ResolvePath(config.TxPool.Journal)
@@ -388,15 +398,52 @@ func (s *Loveblock) Start(srvr *p2p.Server) error {
 	if s.lesServer != nil {
 		s.lesServer.Start(srvr)
 	}
+	if engine, ok := s.engine.(*dpovp.Dpovp); ok && metrics.Enabled {
+		go s.livenessLoop(engine)
+	}
+	s.starWatcher.Start()
 	return nil
 }
 
+// starListActivation returns the first block a star list reloaded from the
+// starlist file applies to, as scheduled by the consensus engine from the
+// requested block, or the engine's default if 0.
+func (s *Loveblock) starListActivation(from uint64) (uint64, error) {
+	head := s.blockchain.CurrentBlock().Header()
+	if engine, ok := s.engine.(*dpovp.Dpovp); ok {
+		return engine.StarListActivation(head, from)
+	}
+	if from == 0 {
+		from = head.Number.Uint64() + 1
+	}
+	return from, nil
+}
+
+// livenessLoop refreshes the star liveness gauges whenever the chain head changes.
+func (s *Loveblock) livenessLoop(engine *dpovp.Dpovp) {
+	heads := make(chan core.ChainHeadEvent, 16)
+	sub := s.blockchain.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-heads:
+			engine.UpdateLivenessMetrics(s.blockchain)
+		case <-sub.Err():
+			return
+		case <-s.shutdownChan:
+			return
+		}
+	}
+}
+
 // Stop implements node.Service, terminating all internal goroutines used by the
 // LoveBlock protocol.
 func (s *Loveblock) Stop() error {
 	if s.stopDbUpgrade != nil {
 		s.stopDbUpgrade()
 	}
+	s.starWatcher.Close()
 	s.bloomIndexer.Close()
 	s.blockchain.Stop()
 	s.protocolManager.Stop()
@@ -24,6 +24,7 @@ import (
 
 	"github.com/LoveBlock/loveblock/common"
 	"github.com/LoveBlock/loveblock/common/hexutil"
+	"github.com/LoveBlock/loveblock/common/mclock"
 	"github.com/LoveBlock/loveblock/core"
 	"github.com/LoveBlock/loveblock/network/downloader"
 	"github.com/LoveBlock/loveblock/network/gasprice"
@@ -107,6 +108,10 @@ type Config struct {
 
 	// sman
 	NodeMode NodeMode
+
+	// Time source of the slot schedule, the system clock if nil. Simulations
+	// replace it to skew the clocks of the nodes.
+	Clock mclock.Clock `toml:"-"`
 }
 
 type configMarshaling struct {
@@ -186,6 +186,9 @@ type BlockChain interface {
 	// CurrentFastBlock retrieves the head fast block from the local chain.
 	CurrentFastBlock() *types.Block
 
+	// StableBlock retrieves the latest final block of the local chain.
+	StableBlock() *types.Block
+
 	// FastSyncCommitHead directly commits the head block to a certain entity.
 	FastSyncCommitHead(common.Hash) error
 
@@ -599,6 +602,12 @@ func (d *Downloader) findAncestor(p *peerConnection, height uint64) (uint64, err
 	if ceil >= MaxForkAncestry {
 		floor = int64(ceil - MaxForkAncestry)
 	}
+	// Final blocks are never rewritten, the ancestor must be the stable block or above
+	if d.mode != LightSync {
+		if stable := int64(d.blockchain.StableBlock().NumberU64()) - 1; stable > floor {
+			floor = stable
+		}
+	}
 	p.log.Debug("Looking for common ancestor", "local", ceil, "remote", height)
 
 	// Request the topmost blocks to short circuit binary ancestor lookup
@@ -678,16 +687,14 @@ func (d *Downloader) findAncestor(p *peerConnection, height uint64) (uint64, err
 		}
 	}
 	// If the head fetch already found an ancestor, return
-	if !common.EmptyHash(hash) {
-		if int64(number) <= floor {
-			p.log.Warn("Ancestor below allowance", "number", number, "hash", hash, "allowance", floor)
-			return 0, errInvalidAncestor
-		}
+	if !common.EmptyHash(hash) && int64(number) > floor {
 		p.log.Debug("Found common ancestor", "number", number, "hash", hash)
 		return number, nil
 	}
-	// Ancestor not found, we need to binary search over our chain
-	start, end := uint64(0), head
+	// Ancestor not found, we need to binary search over our chain. The sparse head
+	// fetch may skip every block above the stable one, so the head is searched too.
+	hash = common.Hash{}
+	start, end := uint64(0), head+1
 	if floor > 0 {
 		start = uint64(floor)
 	}
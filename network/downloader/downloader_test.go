@@ -70,6 +70,8 @@ type downloadTester struct {
 
 	peerMissingStates map[string]map[common.Hash]bool // State entries that fast sync should not return
 
+	stable *types.Block // Final block of the tester's chain, genesis if nil
+
 	lock sync.RWMutex
 }
 
@@ -116,7 +118,7 @@ func (dl *downloadTester) makeChain(n int, seed byte, parent *types.Block, paren
 		}
 		// If the block number is multiple of 3, send a bonus transaction to the miner
 		if parent == dl.genesis && i%3 == 0 {
-			signer := types.MakeSigner(params.TestChainConfig)
+			signer := types.MakeSigner(params.TestChainConfig, block.Number())
 			tx, err := types.SignTx(types.NewTransaction(block.TxNonce(testAddress), common.Address{seed}, big.NewInt(1000), params.TxGas, nil, nil), signer, testKey)
 			if err != nil {
 				panic(err)
@@ -283,6 +285,17 @@ func (dl *downloadTester) CurrentFastBlock() *types.Block {
 	return dl.genesis
 }
 
+// StableBlock retrieves the final block of the tester's canonical chain.
+func (dl *downloadTester) StableBlock() *types.Block {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stable != nil {
+		return dl.stable
+	}
+	return dl.genesis
+}
+
 // FastSyncCommitHead manually sets the head block to a given hash.
 func (dl *downloadTester) FastSyncCommitHead(hash common.Hash) error {
 	// For now only check that the state trie is correct
@@ -911,6 +924,77 @@ func testBoundedHeavyForkedSync(t *testing.T, protocol int, mode SyncMode) {
 	}
 }
 
+// Tests that forks rooted below the stable block are rejected however recent they
+// are, as they would rewrite final blocks.
+func TestStableForkedSync63Full(t *testing.T) { testStableForkedSync(t, 63, FullSync) }
+func TestStableForkedSync63Fast(t *testing.T) { testStableForkedSync(t, 63, FastSync) }
+func TestStableForkedSync64Full(t *testing.T) { testStableForkedSync(t, 64, FullSync) }
+func TestStableForkedSync64Fast(t *testing.T) { testStableForkedSync(t, 64, FastSync) }
+
+func testStableForkedSync(t *testing.T, protocol int, mode SyncMode) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	// Create a short fork, well within the ancestor limit
+	common, fork := 13, 20
+	hashesA, hashesB, headersA, headersB, blocksA, blocksB, receiptsA, receiptsB := tester.makeChainFork(common+fork, fork, tester.genesis, nil, true)
+
+	tester.newPeer("original", protocol, hashesA, headersA, blocksA, receiptsA)
+	tester.newPeer("rewriter", protocol, hashesB, headersB, blocksB, receiptsB)
+
+	if err := tester.sync("original", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, common+fork+1)
+
+	// Finalize a block past the fork point and ensure the fork is rejected
+	tester.lock.Lock()
+	tester.stable = blocksA[hashesA[len(hashesA)-1-(common+5)]]
+	tester.lock.Unlock()
+
+	if err := tester.sync("rewriter", nil, mode); err != errInvalidAncestor {
+		t.Fatalf("sync failure mismatch: have %v, want %v", err, errInvalidAncestor)
+	}
+}
+
+// Tests that a peer extending the local chain is synced with even if the stable
+// block is above every header of the sparse head fetch, the common ancestor being
+// found at the local head.
+func TestStableHeadSync63Full(t *testing.T) { testStableHeadSync(t, 63, FullSync) }
+func TestStableHeadSync63Fast(t *testing.T) { testStableHeadSync(t, 63, FastSync) }
+func TestStableHeadSync64Full(t *testing.T) { testStableHeadSync(t, 64, FullSync) }
+func TestStableHeadSync64Fast(t *testing.T) { testStableHeadSync(t, 64, FastSync) }
+
+func testStableHeadSync(t *testing.T, protocol int, mode SyncMode) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	// Create a chain whose head isn't sampled by the sparse head fetch
+	targetBlocks, extension := 30, 10
+	hashes, headers, blocks, receipts := tester.makeChain(targetBlocks+extension, 0, tester.genesis, nil, false)
+
+	tester.newPeer("short", protocol, hashes[extension:], headers, blocks, receipts)
+	if err := tester.sync("short", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, targetBlocks+1)
+
+	// Finalize the local head and ensure the extension is still accepted
+	tester.lock.Lock()
+	tester.stable = blocks[hashes[extension]]
+	tester.lock.Unlock()
+
+	tester.newPeer("long", protocol, hashes, headers, blocks, receipts)
+	if err := tester.sync("long", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise extension: %v", err)
+	}
+	assertOwnChain(t, tester, targetBlocks+extension+1)
+}
+
 // Tests that an inactive downloader will not accept incoming block headers and
 // bodies.
 func TestInactiveDownloader62(t *testing.T) {
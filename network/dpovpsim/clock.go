@@ -0,0 +1,41 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovpsim
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/LoveBlock/loveblock/common/mclock"
+)
+
+// skewedClock is the system clock shifted by an adjustable offset. Timers are
+// relative, so only the reported time is affected.
+type skewedClock struct {
+	mclock.System
+	skew int64 // Offset from the system clock in nanoseconds
+}
+
+// Now implements mclock.Clock.
+func (c *skewedClock) Now() time.Time {
+	return time.Now().Add(time.Duration(atomic.LoadInt64(&c.skew)))
+}
+
+// setSkew sets the offset of the clock from the system clock.
+func (c *skewedClock) setSkew(skew time.Duration) {
+	atomic.StoreInt64(&c.skew, int64(skew))
+}
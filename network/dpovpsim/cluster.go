@@ -0,0 +1,507 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package dpovpsim runs networks of star and satellite nodes in process on top
+// of p2p/simulations, to exercise the star rotation, timeouts, forks and the
+// finality of the DPoVP consensus under partitions, crashes and clock skew.
+//
+// The star list of common/dpovp is process wide, so all nodes of a cluster share
+// a single one. Every node starts from the same genesis stars and the simulated
+// chains take their stars from the star registry, so the nodes agree on them, but
+// reloading the star list of a single node, or running clusters with different
+// stars side by side, isn't supported.
+package dpovpsim
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/LoveBlock/loveblock/common"
+	commonDpovp "github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/common/hexutil"
+	"github.com/LoveBlock/loveblock/consensus/dpovp"
+	"github.com/LoveBlock/loveblock/core"
+	"github.com/LoveBlock/loveblock/core/types"
+	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/network"
+	"github.com/LoveBlock/loveblock/network/downloader"
+	"github.com/LoveBlock/loveblock/node"
+	"github.com/LoveBlock/loveblock/p2p/discover"
+	"github.com/LoveBlock/loveblock/p2p/simulations"
+	"github.com/LoveBlock/loveblock/p2p/simulations/adapters"
+	"github.com/LoveBlock/loveblock/params"
+)
+
+// serviceName is the name of the simulation service running a full node.
+const serviceName = "loveblock"
+
+// pollInterval is the interval at which the wait conditions are checked.
+const pollInterval = 50 * time.Millisecond
+
+var (
+	errNotRunning   = errors.New("node not running")
+	errNotConnected = errors.New("nodes not connected")
+)
+
+// Config is the setup of a simulated network.
+type Config struct {
+	Stars      int                 // Number of star nodes producing blocks
+	Satellites int                 // Number of satellite nodes following the chain
+	Dpovp      *params.DpovpConfig // Consensus parameters, short sub-second slots if nil
+}
+
+// defaultDpovp are the consensus parameters of simulations not configuring any,
+// with sub-second slots to keep scenarios short.
+var defaultDpovp = params.DpovpConfig{
+	Timeout:        1000,
+	Sleeptime:      300,
+	SealHashBlock:  big.NewInt(0),
+	MilliTimeBlock: big.NewInt(0),
+}
+
+// Node is a star or satellite node of a simulated network.
+type Node struct {
+	Name     string
+	ID       discover.NodeID
+	Star     bool
+	Coinbase common.Address // Address of the star, zero for satellites
+
+	key     *ecdsa.PrivateKey // Node key, also the signing key of stars
+	clock   *skewedClock
+	cluster *Cluster
+}
+
+// Cluster is a network of star and satellite nodes running in process. Every
+// star signs with its own key, and the genesis block registers all of them as
+// the initial star list.
+type Cluster struct {
+	genesis *core.Genesis
+	network *simulations.Network
+	nodes   []*Node
+	byID    map[discover.NodeID]*Node
+
+	lock sync.Mutex
+}
+
+// New creates a simulated network of the given numbers of stars and satellites.
+// The nodes are created but not started.
+func New(config *Config) (*Cluster, error) {
+	if config.Stars < 1 {
+		return nil, errors.New("simulation needs at least one star")
+	}
+	dpovpConfig := config.Dpovp
+	if dpovpConfig == nil {
+		dpovpConfig = &defaultDpovp
+	}
+	c := &Cluster{byID: make(map[discover.NodeID]*Node)}
+
+	var (
+		stars        commonDpovp.StarList
+		genesisStars []core.GenesisStar
+	)
+	for i := 0; i < config.Stars+config.Satellites; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		n := &Node{key: key, clock: new(skewedClock), cluster: c, Star: i < config.Stars}
+		copy(n.ID[:], crypto.FromECDSAPub(&key.PublicKey)[1:])
+		if n.Star {
+			n.Name = fmt.Sprintf("star%02d", i)
+			n.Coinbase = crypto.PubkeyToAddress(key.PublicKey)
+			stars = append(stars, commonDpovp.AddrNodeIDMapping{Addr: n.Coinbase, Pubkey: n.ID[:]})
+			genesisStars = append(genesisStars, core.GenesisStar{Address: n.Coinbase, Pubkey: n.ID[:]})
+		} else {
+			n.Name = fmt.Sprintf("satellite%02d", i-config.Stars)
+		}
+		c.nodes = append(c.nodes, n)
+		c.byID[n.ID] = n
+	}
+	// The star list is shared by all nodes of the process, seed it with the
+	// genesis stars instead of reading a starlist file
+	commonDpovp.SetStarList(stars)
+
+	// The slots of the first round start at the genesis time, carried in
+	// milliseconds in the nonce from the MilliTimeBlock fork on
+	now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	c.genesis = &core.Genesis{
+		Config:     &params.ChainConfig{ChainId: big.NewInt(1337), Dpovp: dpovpConfig},
+		Timestamp:  now / 1000,
+		Nonce:      now,
+		GasLimit:   params.GenesisGasLimit,
+		Difficulty: big.NewInt(1),
+		Alloc:      core.GenesisAlloc{},
+		Stars:      genesisStars,
+	}
+	adapter := adapters.NewSimAdapter(adapters.Services{serviceName: c.newService})
+	c.network = simulations.NewNetwork(adapter, &simulations.NetworkConfig{DefaultService: serviceName})
+
+	for _, n := range c.nodes {
+		if _, err := c.network.NewNodeWithConfig(&adapters.NodeConfig{ID: n.ID, PrivateKey: n.key, Name: n.Name, Services: []string{serviceName}}); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// newService creates the full node of a simulated node.
+func (c *Cluster) newService(ctx *adapters.ServiceContext) (node.Service, error) {
+	n := c.byID[ctx.Config.ID]
+	if n == nil {
+		return nil, fmt.Errorf("unknown simulation node %s", ctx.Config.ID)
+	}
+	config := network.DefaultConfig
+	config.Genesis = c.genesis
+	config.NetworkId = c.genesis.Config.ChainId.Uint64()
+	config.SyncMode = downloader.FullSync
+	config.Lovebase = n.Coinbase
+	config.Clock = n.clock
+	config.NodeMode = network.NodeModeSatellite
+	if n.Star {
+		config.NodeMode = network.NodeModeStar
+		commonDpovp.SetStarKey(n.Coinbase, n.key)
+	}
+	return network.New(ctx.NodeContext, &config)
+}
+
+// Nodes returns all nodes of the network, stars first.
+func (c *Cluster) Nodes() []*Node {
+	return append([]*Node(nil), c.nodes...)
+}
+
+// Stars returns the star nodes of the network.
+func (c *Cluster) Stars() []*Node {
+	var stars []*Node
+	for _, n := range c.nodes {
+		if n.Star {
+			stars = append(stars, n)
+		}
+	}
+	return stars
+}
+
+// Satellites returns the satellite nodes of the network.
+func (c *Cluster) Satellites() []*Node {
+	var satellites []*Node
+	for _, n := range c.nodes {
+		if !n.Star {
+			satellites = append(satellites, n)
+		}
+	}
+	return satellites
+}
+
+// Start starts all nodes, connects every pair of them and lets the stars mine.
+func (c *Cluster) Start() error {
+	for _, n := range c.nodes {
+		if err := c.network.Start(n.ID); err != nil {
+			return err
+		}
+	}
+	if err := c.Heal(); err != nil {
+		return err
+	}
+	for _, n := range c.nodes {
+		if err := n.startMining(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown stops all nodes and drops the signing keys of the stars.
+func (c *Cluster) Shutdown() {
+	c.network.Shutdown()
+	for _, n := range c.Stars() {
+		commonDpovp.SetStarKey(n.Coinbase, nil)
+	}
+}
+
+// Partition splits the network into the given groups of nodes, disconnecting
+// every pair of nodes in different groups. Nodes not in any group are left
+// connected to everyone.
+func (c *Cluster) Partition(groups ...[]*Node) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	group := make(map[discover.NodeID]int)
+	for i, nodes := range groups {
+		for _, n := range nodes {
+			group[n.ID] = i + 1
+		}
+	}
+	for i, one := range c.nodes {
+		for _, other := range c.nodes[i+1:] {
+			if group[one.ID] == 0 || group[other.ID] == 0 || group[one.ID] == group[other.ID] {
+				continue
+			}
+			if err := c.disconnect(one, other); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Heal connects every pair of running nodes, ending all partitions.
+func (c *Cluster) Heal() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for i, one := range c.nodes {
+		for _, other := range c.nodes[i+1:] {
+			if err := c.connect(one, other); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// connect connects two nodes unless they are connected or one of them is down.
+// The caller must hold the lock.
+func (c *Cluster) connect(one, other *Node) error {
+	if !one.Running() || !other.Running() {
+		return nil
+	}
+	if conn := c.network.GetConn(one.ID, other.ID); conn != nil && conn.Up {
+		return nil
+	}
+	return c.network.Connect(one.ID, other.ID)
+}
+
+// disconnect disconnects two nodes if they are connected. The caller must hold
+// the lock.
+func (c *Cluster) disconnect(one, other *Node) error {
+	if conn := c.network.GetConn(one.ID, other.ID); conn == nil || !conn.Up {
+		return nil
+	}
+	// Drop the static peer entries on both sides, or the nodes reconnect
+	if err := c.network.Disconnect(one.ID, other.ID); err != nil {
+		return err
+	}
+	client, err := c.network.GetNode(other.ID).Client()
+	if err != nil {
+		return err
+	}
+	return client.Call(nil, "admin_removePeer", string(c.network.GetNode(one.ID).Addr()))
+}
+
+// Crash stops the given node. Its chain is lost, a restarted node syncs from its
+// peers again.
+func (c *Cluster) Crash(n *Node) error {
+	return c.network.Stop(n.ID)
+}
+
+// Restart starts a crashed node again and connects it to the running nodes. A
+// star waits to catch up with the chain of its peers before it mines again, so
+// it doesn't produce blocks on top of its empty chain.
+func (c *Cluster) Restart(ctx context.Context, n *Node) error {
+	var target uint64
+	for _, other := range c.nodes {
+		if head := other.Head(); other != n && head != nil && head.Number.Uint64() > target {
+			target = head.Number.Uint64()
+		}
+	}
+	if err := c.network.Start(n.ID); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	for _, other := range c.nodes {
+		if other != n {
+			if err := c.connect(n, other); err != nil {
+				c.lock.Unlock()
+				return err
+			}
+		}
+	}
+	c.lock.Unlock()
+
+	if err := c.WaitHead(ctx, []*Node{n}, target); err != nil {
+		return err
+	}
+	return n.startMining()
+}
+
+// SetClockSkew sets the offset of the node's clock from the system clock, which
+// its consensus engine and miner schedule slots with.
+func (c *Cluster) SetClockSkew(n *Node, skew time.Duration) {
+	n.clock.setSkew(skew)
+}
+
+// Running reports whether the node is up.
+func (n *Node) Running() bool {
+	return n.Service() != nil
+}
+
+// Service returns the full node running on the simulated node, or nil if the
+// node is down.
+func (n *Node) Service() *network.Loveblock {
+	node := n.cluster.network.GetNode(n.ID)
+	if node == nil || !node.Up {
+		return nil
+	}
+	sim, ok := node.Node.(*adapters.SimNode)
+	if !ok {
+		return nil
+	}
+	for _, service := range sim.Services() {
+		if service, ok := service.(*network.Loveblock); ok {
+			return service
+		}
+	}
+	return nil
+}
+
+// startMining lets the node produce blocks if it is a running star.
+func (n *Node) startMining() error {
+	service := n.Service()
+	if !n.Star || service == nil {
+		return nil
+	}
+	return service.StartMining(true)
+}
+
+// Head returns the head of the node's chain, or nil if the node is down.
+func (n *Node) Head() *types.Header {
+	if service := n.Service(); service != nil {
+		return service.BlockChain().CurrentHeader()
+	}
+	return nil
+}
+
+// Stable returns the latest stable block of the node's chain, or nil if the node
+// is down.
+func (n *Node) Stable() *types.Header {
+	if service := n.Service(); service != nil {
+		if block := service.BlockChain().StableBlock(); block != nil {
+			return block.Header()
+		}
+	}
+	return nil
+}
+
+// Liveness returns the production statistics of the stars over the given number
+// of blocks up to the node's head.
+func (n *Node) Liveness(window uint64) (*dpovp.Liveness, error) {
+	node := n.cluster.network.GetNode(n.ID)
+	if node == nil || !node.Up {
+		return nil, errNotRunning
+	}
+	client, err := node.Client()
+	if err != nil {
+		return nil, err
+	}
+	var liveness dpovp.Liveness
+	if err := client.Call(&liveness, "dpovp_getLiveness", hexutil.Uint64(window)); err != nil {
+		return nil, err
+	}
+	return &liveness, nil
+}
+
+// Missed returns the number of slots the star missed over the given number of
+// blocks up to the node's head.
+func (n *Node) Missed(star *Node, window uint64) (uint64, error) {
+	liveness, err := n.Liveness(window)
+	if err != nil {
+		return 0, err
+	}
+	for _, stat := range liveness.Stars {
+		if stat.Address == star.Coinbase {
+			return stat.Missed, nil
+		}
+	}
+	return 0, nil
+}
+
+// WaitHead waits until the heads of all given nodes reach the given number.
+func (c *Cluster) WaitHead(ctx context.Context, nodes []*Node, number uint64) error {
+	return c.wait(ctx, "head", nodes, number, (*Node).Head)
+}
+
+// WaitStable waits until the stable blocks of all given nodes reach the given
+// number.
+func (c *Cluster) WaitStable(ctx context.Context, nodes []*Node, number uint64) error {
+	return c.wait(ctx, "stable block", nodes, number, (*Node).Stable)
+}
+
+// wait polls the given block of the nodes until all of them reach number.
+func (c *Cluster) wait(ctx context.Context, what string, nodes []*Node, number uint64, block func(*Node) *types.Header) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		var lagging *Node
+		for _, n := range nodes {
+			if header := block(n); header == nil || header.Number.Uint64() < number {
+				lagging = n
+				break
+			}
+		}
+		if lagging == nil {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			have := "none"
+			if header := block(lagging); header != nil {
+				have = header.Number.String()
+			}
+			return fmt.Errorf("%s: %s %s didn't reach %d: %v", lagging.Name, what, have, number, ctx.Err())
+		}
+	}
+}
+
+// CheckCanonical checks that all given nodes have the same canonical block at
+// the given number.
+func (c *Cluster) CheckCanonical(nodes []*Node, number uint64) error {
+	var (
+		hash  common.Hash
+		first *Node
+	)
+	for _, n := range nodes {
+		service := n.Service()
+		if service == nil {
+			return fmt.Errorf("%s: %v", n.Name, errNotRunning)
+		}
+		block := service.BlockChain().GetBlockByNumber(number)
+		if block == nil {
+			return fmt.Errorf("%s: no canonical block %d", n.Name, number)
+		}
+		if first == nil {
+			hash, first = block.Hash(), n
+			continue
+		}
+		if block.Hash() != hash {
+			return fmt.Errorf("block %d differs: %s has %x, %s has %x", number, first.Name, hash[:4], n.Name, block.Hash().Bytes()[:4])
+		}
+	}
+	return nil
+}
+
+// CheckPeers checks that the given nodes are connected to each other.
+func (c *Cluster) CheckPeers(one, other *Node) error {
+	if conn := c.network.GetConn(one.ID, other.ID); conn == nil || !conn.Up {
+		return fmt.Errorf("%s and %s: %v", one.Name, other.Name, errNotConnected)
+	}
+	return nil
+}
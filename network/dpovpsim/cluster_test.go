@@ -0,0 +1,130 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovpsim
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Tests that the stars of a simulated network take turns producing blocks the
+// satellites follow, that the rotation moves past a crashed star, and that the
+// star catches up once restarted.
+func TestStarCrash(t *testing.T) {
+	cluster, err := New(&Config{Stars: 4, Satellites: 1})
+	if err != nil {
+		t.Fatalf("failed to create simulation: %v", err)
+	}
+	defer cluster.Shutdown()
+	if err := cluster.Start(); err != nil {
+		t.Fatalf("failed to start simulation: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	nodes := cluster.Nodes()
+	if err := cluster.WaitHead(ctx, nodes, 6); err != nil {
+		t.Fatalf("chain didn't progress: %v", err)
+	}
+	if err := cluster.CheckCanonical(nodes, 5); err != nil {
+		t.Fatalf("nodes disagree: %v", err)
+	}
+	// Crash a star, the others keep producing blocks in its place
+	stars := cluster.Stars()
+	crashed := stars[3]
+	if err := cluster.Crash(crashed); err != nil {
+		t.Fatalf("failed to crash star: %v", err)
+	}
+	running := append(stars[:3:3], cluster.Satellites()...)
+	next := stars[0].Head().Number.Uint64() + 6
+	if err := cluster.WaitHead(ctx, running, next); err != nil {
+		t.Fatalf("chain stalled after crash: %v", err)
+	}
+	if err := cluster.CheckCanonical(running, next-1); err != nil {
+		t.Fatalf("nodes disagree after crash: %v", err)
+	}
+	missed, err := stars[0].Missed(crashed, 6)
+	if err != nil {
+		t.Fatalf("failed to retrieve liveness: %v", err)
+	}
+	if missed == 0 {
+		t.Errorf("crashed star missed no slots")
+	}
+	// Restart the star, it syncs and takes its turns again
+	if err := cluster.Restart(ctx, crashed); err != nil {
+		t.Fatalf("failed to restart star: %v", err)
+	}
+	next = stars[0].Head().Number.Uint64() + 4
+	if err := cluster.WaitHead(ctx, nodes, next); err != nil {
+		t.Fatalf("restarted star didn't catch up: %v", err)
+	}
+	if err := cluster.CheckCanonical(nodes, next-1); err != nil {
+		t.Errorf("nodes disagree after restart: %v", err)
+	}
+}
+
+// Tests that a star cut off from the others can't finalize blocks on its own,
+// and that it drops its side chain for the chain of the majority once the
+// partition heals.
+func TestPartition(t *testing.T) {
+	cluster, err := New(&Config{Stars: 4})
+	if err != nil {
+		t.Fatalf("failed to create simulation: %v", err)
+	}
+	defer cluster.Shutdown()
+	if err := cluster.Start(); err != nil {
+		t.Fatalf("failed to start simulation: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	stars := cluster.Stars()
+	if err := cluster.WaitStable(ctx, stars, 3); err != nil {
+		t.Fatalf("chain didn't finalize: %v", err)
+	}
+	// Isolate a star, the other three still form a quorum
+	majority, minority := stars[:3], stars[3:]
+	if err := cluster.Partition(majority, minority); err != nil {
+		t.Fatalf("failed to partition network: %v", err)
+	}
+	if err := cluster.CheckPeers(majority[0], minority[0]); err == nil {
+		t.Fatalf("partitioned stars still connected")
+	}
+	next := majority[0].Stable().Number.Uint64() + 6
+	if err := cluster.WaitStable(ctx, majority, next); err != nil {
+		t.Fatalf("majority didn't finalize: %v", err)
+	}
+	isolated := minority[0].Stable().Number.Uint64()
+	if isolated >= next {
+		t.Fatalf("isolated star finalized block %d on its own", isolated)
+	}
+	if err := cluster.CheckCanonical(stars, isolated); err != nil {
+		t.Fatalf("conflicting stable blocks: %v", err)
+	}
+	// Heal the partition, the isolated star follows the majority again
+	if err := cluster.Heal(); err != nil {
+		t.Fatalf("failed to heal partition: %v", err)
+	}
+	next = majority[0].Head().Number.Uint64() + 4
+	if err := cluster.WaitStable(ctx, stars, next); err != nil {
+		t.Fatalf("isolated star didn't rejoin: %v", err)
+	}
+	if err := cluster.CheckCanonical(stars, next); err != nil {
+		t.Errorf("nodes disagree after healing: %v", err)
+	}
+}
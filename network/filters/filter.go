@@ -38,6 +38,7 @@ type Backend interface {
 
 	SubscribeTxPreEvent(chan<- core.TxPreEvent) event.Subscription
 	SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription
+	SubscribeChainStableEvent(ch chan<- core.ChainStableEvent) event.Subscription
 	SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription
 	SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription
 
@@ -109,6 +110,19 @@ func (f *Filter) Logs(ctx context.Context) ([]*types.Log, error) {
 	if f.end == -1 {
 		end = head
 	}
+	// Resolve the stable tag, ranges ending at it only return final logs
+	if f.begin == rpc.StableBlockNumber.Int64() || f.end == rpc.StableBlockNumber.Int64() {
+		stable, err := f.backend.HeaderByNumber(ctx, rpc.StableBlockNumber)
+		if stable == nil {
+			return nil, err
+		}
+		if f.begin == rpc.StableBlockNumber.Int64() {
+			f.begin = stable.Number.Int64()
+		}
+		if f.end == rpc.StableBlockNumber.Int64() {
+			end = stable.Number.Uint64()
+		}
+	}
 	// Gather all indexed logs, and finish with non indexed ones
 	var (
 		logs []*types.Log
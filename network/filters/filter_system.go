@@ -51,6 +51,8 @@ const (
 	PendingTransactionsSubscription
 	// BlocksSubscription queries hashes for blocks that are imported
 	BlocksSubscription
+	// StableBlocksSubscription queries headers of blocks that become stable
+	StableBlocksSubscription
 	// LastSubscription keeps track of the last index
 	LastIndexSubscription
 )
@@ -66,6 +68,8 @@ const (
 	logsChanSize = 10
 	// chainEvChanSize is the size of channel listening to ChainEvent.
 	chainEvChanSize = 10
+	// stableEvChanSize is the size of channel listening to ChainStableEvent.
+	stableEvChanSize = 10
 )
 
 var (
@@ -266,6 +270,22 @@ func (es *EventSystem) SubscribeNewHeads(headers chan *types.Header) *Subscripti
 	return es.subscribe(sub)
 }
 
+// SubscribeNewStableHeads creates a subscription that writes the header of a block
+// that became the stable block of the chain.
+func (es *EventSystem) SubscribeNewStableHeads(headers chan *types.Header) *Subscription {
+	sub := &subscription{
+		id:        rpc.NewID(),
+		typ:       StableBlocksSubscription,
+		created:   time.Now(),
+		logs:      make(chan []*types.Log),
+		hashes:    make(chan common.Hash),
+		headers:   headers,
+		installed: make(chan struct{}),
+		err:       make(chan error),
+	}
+	return es.subscribe(sub)
+}
+
 // SubscribePendingTxEvents creates a subscription that writes transaction hashes for
 // transactions that enter the transaction pool.
 func (es *EventSystem) SubscribePendingTxEvents(hashes chan common.Hash) *Subscription {
@@ -320,6 +340,10 @@ func (es *EventSystem) broadcast(filters filterIndex, ev interface{}) {
 		for _, f := range filters[PendingTransactionsSubscription] {
 			f.hashes <- e.Tx.Hash()
 		}
+	case core.ChainStableEvent:
+		for _, f := range filters[StableBlocksSubscription] {
+			f.headers <- e.Block.Header()
+		}
 	case core.ChainEvent:
 		for _, f := range filters[BlocksSubscription] {
 			f.headers <- e.Block.Header()
@@ -426,6 +450,9 @@ func (es *EventSystem) eventLoop() {
 		// Subscribe ChainEvent
 		chainEvCh  = make(chan core.ChainEvent, chainEvChanSize)
 		chainEvSub = es.backend.SubscribeChainEvent(chainEvCh)
+		// Subscribe ChainStableEvent
+		stableEvCh  = make(chan core.ChainStableEvent, stableEvChanSize)
+		stableEvSub = es.backend.SubscribeChainStableEvent(stableEvCh)
 	)
 
 	// Unsubscribe all events
@@ -434,6 +461,7 @@ func (es *EventSystem) eventLoop() {
 	defer rmLogsSub.Unsubscribe()
 	defer logsSub.Unsubscribe()
 	defer chainEvSub.Unsubscribe()
+	defer stableEvSub.Unsubscribe()
 
 	for i := UnknownSubscription; i < LastIndexSubscription; i++ {
 		index[i] = make(map[rpc.ID]*subscription)
@@ -456,6 +484,8 @@ func (es *EventSystem) eventLoop() {
 			es.broadcast(index, ev)
 		case ev := <-chainEvCh:
 			es.broadcast(index, ev)
+		case ev := <-stableEvCh:
+			es.broadcast(index, ev)
 
 		case f := <-es.install:
 			if f.typ == MinedAndPendingLogsSubscription {
@@ -485,6 +515,8 @@ func (es *EventSystem) eventLoop() {
 			return
 		case <-chainEvSub.Err():
 			return
+		case <-stableEvSub.Err():
+			return
 		}
 	}
 }
@@ -45,6 +45,7 @@ type testBackend struct {
 	rmLogsFeed *event.Feed
 	logsFeed   *event.Feed
 	chainFeed  *event.Feed
+	stableFeed *event.Feed
 }
 
 func (b *testBackend) ChainDb() lovedb.Database {
@@ -61,6 +62,9 @@ func (b *testBackend) HeaderByNumber(ctx context.Context, blockNr rpc.BlockNumbe
 	if blockNr == rpc.LatestBlockNumber {
 		hash = core.GetHeadBlockHash(b.db)
 		num = core.GetBlockNumber(b.db, hash)
+	} else if blockNr == rpc.StableBlockNumber {
+		hash = core.GetHeadStableBlockHash(b.db)
+		num = core.GetBlockNumber(b.db, hash)
 	} else {
 		num = uint64(blockNr)
 		hash = core.GetCanonicalHash(b.db, num)
@@ -100,6 +104,10 @@ func (b *testBackend) SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subsc
 	return b.chainFeed.Subscribe(ch)
 }
 
+func (b *testBackend) SubscribeChainStableEvent(ch chan<- core.ChainStableEvent) event.Subscription {
+	return b.stableFeed.Subscribe(ch)
+}
+
 func (b *testBackend) BloomStatus() (uint64, uint64) {
 	return params.BloomBitsBlocks, b.sections
 }
@@ -146,7 +154,7 @@ func TestBlockSubscription(t *testing.T) {
 		rmLogsFeed  = new(event.Feed)
 		logsFeed    = new(event.Feed)
 		chainFeed   = new(event.Feed)
-		backend     = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed}
+		backend     = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed, new(event.Feed)}
 		api         = NewPublicFilterAPI(backend, false)
 		genesis     = new(core.Genesis).MustCommit(db)
 		chain, _    = core.GenerateChain(params.TestChainConfig, genesis, dpovp.NewFaker(), db, 10, func(i int, gen *core.BlockGen) {})
@@ -192,6 +200,46 @@ func TestBlockSubscription(t *testing.T) {
 	<-sub1.Err()
 }
 
+// TestStableBlockSubscription tests if a stable block subscription returns the
+// headers of the blocks that became stable.
+func TestStableBlockSubscription(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mux        = new(event.TypeMux)
+		db, _      = lovedb.NewMemDatabase()
+		stableFeed = new(event.Feed)
+		backend    = &testBackend{mux, db, 0, new(event.Feed), new(event.Feed), new(event.Feed), new(event.Feed), stableFeed}
+		api        = NewPublicFilterAPI(backend, false)
+		genesis    = new(core.Genesis).MustCommit(db)
+		chain, _   = core.GenerateChain(params.TestChainConfig, genesis, dpovp.NewFaker(), db, 10, func(i int, gen *core.BlockGen) {})
+	)
+	heads := make(chan *types.Header)
+	sub := api.events.SubscribeNewStableHeads(heads)
+	defer sub.Unsubscribe()
+
+	// Only every other block is proven final, the subscription must not see the rest
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i < len(chain); i += 2 {
+			select {
+			case header := <-heads:
+				if header.Hash() != chain[i].Hash() {
+					t.Errorf("stable head %d mismatch: have %x, want %x", i, header.Hash(), chain[i].Hash())
+				}
+			case <-time.After(time.Second):
+				t.Errorf("stable head %d not received", i)
+				return
+			}
+		}
+	}()
+	for i := 1; i < len(chain); i += 2 {
+		stableFeed.Send(core.ChainStableEvent{Block: chain[i]})
+	}
+	<-done
+}
+
 // TestPendingTxFilter tests whether pending tx filters retrieve all pending transactions that are posted to the event mux.
 func TestPendingTxFilter(t *testing.T) {
 	t.Parallel()
@@ -203,7 +251,7 @@ func TestPendingTxFilter(t *testing.T) {
 		rmLogsFeed = new(event.Feed)
 		logsFeed   = new(event.Feed)
 		chainFeed  = new(event.Feed)
-		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed}
+		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed, new(event.Feed)}
 		api        = NewPublicFilterAPI(backend, false)
 
 		transactions = []*types.Transaction{
@@ -266,7 +314,7 @@ func TestLogFilterCreation(t *testing.T) {
 		rmLogsFeed = new(event.Feed)
 		logsFeed   = new(event.Feed)
 		chainFeed  = new(event.Feed)
-		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed}
+		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed, new(event.Feed)}
 		api        = NewPublicFilterAPI(backend, false)
 
 		testCases = []struct {
@@ -315,7 +363,7 @@ func TestInvalidLogFilterCreation(t *testing.T) {
 		rmLogsFeed = new(event.Feed)
 		logsFeed   = new(event.Feed)
 		chainFeed  = new(event.Feed)
-		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed}
+		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed, new(event.Feed)}
 		api        = NewPublicFilterAPI(backend, false)
 	)
 
@@ -345,7 +393,7 @@ func TestLogFilter(t *testing.T) {
 		rmLogsFeed = new(event.Feed)
 		logsFeed   = new(event.Feed)
 		chainFeed  = new(event.Feed)
-		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed}
+		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed, new(event.Feed)}
 		api        = NewPublicFilterAPI(backend, false)
 
 		firstAddr      = common.HexToAddress("0x1111111111111111111111111111111111111111")
@@ -464,7 +512,7 @@ func TestPendingLogsSubscription(t *testing.T) {
 		rmLogsFeed = new(event.Feed)
 		logsFeed   = new(event.Feed)
 		chainFeed  = new(event.Feed)
-		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed}
+		backend    = &testBackend{mux, db, 0, txFeed, rmLogsFeed, logsFeed, chainFeed, new(event.Feed)}
 		api        = NewPublicFilterAPI(backend, false)
 
 		firstAddr      = common.HexToAddress("0x1111111111111111111111111111111111111111")
@@ -8,6 +8,7 @@ import (
 
 	"github.com/LoveBlock/loveblock/common"
 	"github.com/LoveBlock/loveblock/common/hexutil"
+	"github.com/LoveBlock/loveblock/common/mclock"
 	"github.com/LoveBlock/loveblock/core"
 	"github.com/LoveBlock/loveblock/network/downloader"
 	"github.com/LoveBlock/loveblock/network/gasprice"
@@ -38,6 +39,7 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		EnablePreimageRecording bool
 		DocRoot                 string `toml:"-"`
 		NodeMode                NodeMode
+		Clock                   mclock.Clock `toml:"-"`
 	}
 	var enc Config
 	enc.Genesis = c.Genesis
@@ -60,6 +62,7 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.EnablePreimageRecording = c.EnablePreimageRecording
 	enc.DocRoot = c.DocRoot
 	enc.NodeMode = c.NodeMode
+	enc.Clock = c.Clock
 	return &enc, nil
 }
 
@@ -86,6 +89,7 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		EnablePreimageRecording *bool
 		DocRoot                 *string `toml:"-"`
 		NodeMode                *NodeMode
+		Clock                   mclock.Clock `toml:"-"`
 	}
 	var dec Config
 	if err := unmarshal(&dec); err != nil {
@@ -151,5 +155,8 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.NodeMode != nil {
 		c.NodeMode = *dec.NodeMode
 	}
+	if dec.Clock != nil {
+		c.Clock = dec.Clock
+	}
 	return nil
 }
@@ -50,6 +50,22 @@ const (
 	// txChanSize is the size of channel listening to TxPreEvent.
 	// The number is referenced from the size of tx pool.
 	txChanSize = 4096
+
+	// evidenceChanSize is the size of channel listening to EvidenceEvent.
+	evidenceChanSize = 16
+
+	// starListChanSize is the size of channel listening to StarListEvent.
+	starListChanSize = 1
+
+	// chainHeadChanSize is the size of channel listening to ChainHeadEvent.
+	chainHeadChanSize = 10
+
+	// maxFinalityFetch is the amount of finality certificates to serve per request.
+	maxFinalityFetch = 256
+
+	// maxStableAnnounce is the maximum number of blocks announced to satellites at
+	// once when the stable block skips ahead.
+	maxStableAnnounce = 32
 )
 
 // errIncompatibleConfig is returned if the requested protocols and configs are
@@ -76,12 +92,23 @@ type ProtocolManager struct {
 	peers      *peerSet // sman 主节点网络连接
 	peersDelay *peerSet // sman 普通节点网络连接
 
+	classifyLock sync.Mutex // Serialises moving peers between peers and peersDelay
+
+	announced    uint64     // Number of the last stable block announced to satellites
+	announceLock sync.Mutex // Serialises the announcements to satellites
+
 	SubProtocols []p2p.Protocol
 
 	eventMux      *event.TypeMux
 	txCh          chan core.TxPreEvent
 	txSub         event.Subscription
 	minedBlockSub *event.TypeMuxSubscription
+	evidenceCh    chan core.EvidenceEvent
+	evidenceSub   event.Subscription
+	starListCh    chan dpovp.StarListEvent
+	starListSub   event.Subscription
+	chainHeadCh   chan core.ChainHeadEvent
+	chainHeadSub  event.Subscription
 
 	// channels for fetcher, syncer, txsyncLoop
 	newPeerCh   chan *peer
@@ -116,6 +143,10 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		log.Warn("Blockchain not empty, fast sync disabled")
 		mode = downloader.FullSync
 	}
+	if mode == downloader.FastSync && dpovp.GenesisStarList() != nil {
+		log.Warn("Star registry needs the chain state, fast sync disabled")
+		mode = downloader.FullSync
+	}
 	if mode == downloader.FastSync {
 		manager.fastSync = uint32(1)
 	}
@@ -161,7 +192,11 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 	manager.downloader = downloader.New(mode, chaindb, manager.eventMux, blockchain, nil, manager.removePeer)
 
 	// 验证头部 调用engine的验证程序
+	// Blocks conflicting with the stable block fail validation, dropping the peer
 	validator := func(header *types.Header) error {
+		if blockchain.ConflictsWithStable(header) {
+			return core.ErrStableConflict
+		}
 		return engine.VerifyHeader(blockchain, header, true)
 	}
 	// 获取当前区块的高度
@@ -185,12 +220,44 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		manager.BroadcastConsensusInfo(hash, num, hasFlag)
 	}
 	// sman broadcast new block hash to satellite node
-	manager.blockchain.BroadcastBlock2Satellite = func(hash common.Hash, number uint64) {
-		for _, peer := range manager.peersDelay.TotalPeers() {
-			peer.SendNewBlockHashes([]common.Hash{hash}, []uint64{number})
+	// The finality certificate follows the hash, so that satellites can verify the
+	// block is final themselves instead of trusting the star.
+	manager.blockchain.BroadcastBlock2Satellite = manager.announceStable
+	return manager, nil
+}
+
+// announceStable announces a block that became stable to the satellites. The
+// stable block may skip ahead of blocks that never gathered a quorum themselves,
+// those are announced along, as satellites only import announced blocks.
+func (pm *ProtocolManager) announceStable(hash common.Hash, number uint64) {
+	pm.announceLock.Lock()
+	defer pm.announceLock.Unlock()
+
+	if number <= pm.announced {
+		return
+	}
+	var (
+		hashes  = []common.Hash{hash}
+		numbers = []uint64{number}
+	)
+	if pm.announced > 0 {
+		header := pm.blockchain.GetHeader(hash, number)
+		for header != nil && header.Number.Uint64() > pm.announced+1 && len(hashes) < maxStableAnnounce {
+			if header = pm.blockchain.GetHeader(header.ParentHash, header.Number.Uint64()-1); header != nil {
+				hashes = append([]common.Hash{header.Hash()}, hashes...)
+				numbers = append([]uint64{header.Number.Uint64()}, numbers...)
+			}
+		}
+	}
+	pm.announced = number
+
+	cert := pm.blockchain.GetFinalityCertificate(hash)
+	for _, peer := range pm.peersDelay.TotalPeers() {
+		peer.SendNewBlockHashes(hashes, numbers)
+		if cert != nil {
+			peer.SendFinality([]*types.FinalityCertificate{cert})
 		}
 	}
-	return manager, nil
 }
 
 // sman 移除网络节点
@@ -233,6 +300,63 @@ func (pm *ProtocolManager) removePeerDelay(id string) {
 	}
 }
 
+// registerPeer adds a peer to the star peers if its node key is in the current
+// star list, or to the satellite peers otherwise.
+func (pm *ProtocolManager) registerPeer(p *peer) error {
+	pm.classifyLock.Lock()
+	defer pm.classifyLock.Unlock()
+
+	// sman 获取公钥
+	pubKey := crypto.FromECDSAPub(p.Peer.Pubkey)
+	if pm.blockchain.CurrentStars().IndexByNodeKey(pubKey) == -1 { // 不在主节点中
+		return pm.peersDelay.Register(p)
+	}
+	return pm.peers.Register(p)
+}
+
+// unregisterPeer removes a disconnecting peer from the peer set it is in.
+func (pm *ProtocolManager) unregisterPeer(id string) {
+	pm.classifyLock.Lock()
+	defer pm.classifyLock.Unlock()
+
+	pm.removePeer(id)
+	pm.removePeerDelay(id)
+}
+
+// reclassifyPeers moves the connected peers between the star and satellite peer
+// sets after the star list changed.
+func (pm *ProtocolManager) reclassifyPeers() {
+	pm.classifyLock.Lock()
+	defer pm.classifyLock.Unlock()
+
+	stars := pm.blockchain.CurrentStars()
+	for _, p := range pm.peers.TotalPeers() {
+		if stars.IndexByNodeKey(crypto.FromECDSAPub(p.Peer.Pubkey)) == -1 {
+			pm.movePeer(p, pm.peers, pm.peersDelay)
+		}
+	}
+	for _, p := range pm.peersDelay.TotalPeers() {
+		if stars.IndexByNodeKey(crypto.FromECDSAPub(p.Peer.Pubkey)) != -1 {
+			pm.movePeer(p, pm.peersDelay, pm.peers)
+		}
+	}
+}
+
+// movePeer moves a peer from one peer set to the other, dropping it if that
+// fails. The caller must hold classifyLock.
+func (pm *ProtocolManager) movePeer(p *peer, from, to *peerSet) {
+	if err := from.Unregister(p.id); err != nil {
+		return
+	}
+	if err := to.Register(p); err != nil {
+		p.Log().Debug("Loveblock peer reclassification failed", "err", err)
+		pm.downloader.UnregisterPeer(p.id)
+		p.Peer.Disconnect(p2p.DiscUselessPeer)
+		return
+	}
+	p.Log().Debug("Reclassified Loveblock peer", "star", to == pm.peers)
+}
+
 func (pm *ProtocolManager) Start(maxPeers int) {
 	pm.maxPeers = maxPeers
 
@@ -245,6 +369,18 @@ func (pm *ProtocolManager) Start(maxPeers int) {
 	pm.minedBlockSub = pm.eventMux.Subscribe(core.NewMinedBlockEvent{})
 	go pm.minedBroadcastLoop()
 
+	// broadcast equivocation evidence
+	pm.evidenceCh = make(chan core.EvidenceEvent, evidenceChanSize)
+	pm.evidenceSub = pm.blockchain.SubscribeEvidenceEvent(pm.evidenceCh)
+	go pm.evidenceBroadcastLoop()
+
+	// reclassify peers on star list changes
+	pm.starListCh = make(chan dpovp.StarListEvent, starListChanSize)
+	pm.starListSub = dpovp.SubscribeStarListEvent(pm.starListCh)
+	pm.chainHeadCh = make(chan core.ChainHeadEvent, chainHeadChanSize)
+	pm.chainHeadSub = pm.blockchain.SubscribeChainHeadEvent(pm.chainHeadCh)
+	go pm.starListLoop()
+
 	// start sync handlers
 	go pm.syncer()
 	go pm.txsyncLoop()
@@ -255,6 +391,9 @@ func (pm *ProtocolManager) Stop() {
 
 	pm.txSub.Unsubscribe()         // quits txBroadcastLoop
 	pm.minedBlockSub.Unsubscribe() // quits blockBroadcastLoop
+	pm.evidenceSub.Unsubscribe()   // quits evidenceBroadcastLoop
+	pm.starListSub.Unsubscribe()   // quits starListLoop
+	pm.chainHeadSub.Unsubscribe()  // quits starListLoop
 
 	// Quit the sync loop.
 	// After this send has completed, no new peers will be accepted.
@@ -268,6 +407,7 @@ func (pm *ProtocolManager) Stop() {
 	// sessions which are already established but not added to pm.peers yet
 	// will exit when they try to register.
 	pm.peers.Close()
+	pm.peersDelay.Close()
 
 	// Wait for all peer handler goroutines and the loops to come down.
 	pm.wg.Wait()
@@ -303,23 +443,12 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	if rw, ok := p.rw.(*meteredMsgReadWriter); ok {
 		rw.Init(p.version)
 	}
-	// sman 获取公钥
-	pubKey := crypto.FromECDSAPub(p.Peer.Pubkey)
 	// sman 判断是否在主节点列表中
-	if dpovp.GetCoreNodeIndexByPubkey(pubKey) == -1 { // 不在主节点中
-		// Register the peer locally
-		if err := pm.peersDelay.Register(p); err != nil {
-			p.Log().Error("Loveblock peer registration failed", "err", err)
-			return err
-		}
-		defer pm.removePeerDelay(p.id)
-	} else {
-		if err := pm.peers.Register(p); err != nil {
-			p.Log().Error("Loveblock peer registration failed", "err", err)
-			return err
-		}
-		defer pm.removePeer(p.id)
+	if err := pm.registerPeer(p); err != nil {
+		p.Log().Error("Loveblock peer registration failed", "err", err)
+		return err
 	}
+	defer pm.unregisterPeer(p.id)
 
 	// Register the peer in the downloader. If the downloader considers it banned, we disconnect
 	if err := pm.downloader.RegisterPeer(p.id, p.version, p); err != nil {
@@ -622,6 +751,76 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			pm.blockchain.ProcConsensusMsg(msg, crypto.FromECDSAPub(p.Pubkey))
 		}
 
+	case msg.Code == EvidenceMsg:
+		// Evidence of equivocating stars arrived, verify and relay anything new
+		var evidence []*types.Evidence
+		if err := msg.Decode(&evidence); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		for i, ev := range evidence {
+			if ev == nil {
+				return errResp(ErrDecode, "evidence %d is nil", i)
+			}
+			// Evidence may reference blocks we don't have yet, don't punish the peer
+			if _, err := pm.blockchain.AddEvidence(ev); err != nil {
+				p.Log().Debug("Discarded evidence", "hash", ev.Hash(), "err", err)
+			}
+		}
+
+	case msg.Code == AggregateVoteMsg:
+		// Aggregated BLS votes arrived, merge them into the ones we know
+		var vote aggregateVoteData
+		if err := msg.Decode(&vote); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if vote.Signers == nil {
+			return errResp(ErrDecode, "aggregated vote without signers")
+		}
+		pm.blockchain.ProcAggregateVote(vote.Hash, vote.Number, vote.Signers, vote.Aggregate)
+
+	case msg.Code == GetFinalityMsg:
+		// Decode the retrieval message
+		var hashes []common.Hash
+		if err := msg.Decode(&hashes); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Gather the certificates of the blocks known to be final, skipping the rest
+		certs := make([]*types.FinalityCertificate, 0, len(hashes))
+		for _, hash := range hashes {
+			if len(certs) >= maxFinalityFetch {
+				break
+			}
+			if cert := pm.blockchain.GetFinalityCertificate(hash); cert != nil {
+				certs = append(certs, cert)
+			}
+		}
+		return p.SendFinality(certs)
+
+	case msg.Code == FinalityMsg:
+		// A batch of finality certificates arrived, store the ones we can verify
+		var certs []*types.FinalityCertificate
+		if err := msg.Decode(&certs); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		for i, cert := range certs {
+			if cert == nil {
+				return errResp(ErrDecode, "finality certificate %d is nil", i)
+			}
+			// Certificates may reference blocks we don't have yet, don't punish the peer
+			added, err := pm.blockchain.AddFinalityCertificate(cert)
+			if err != nil {
+				p.Log().Debug("Discarded finality certificate", "hash", cert.Hash, "err", err)
+			}
+			// Relay new proofs to satellites that aren't connected to any star
+			if added {
+				for _, peer := range pm.peersDelay.TotalPeers() {
+					if peer != p {
+						peer.SendFinality([]*types.FinalityCertificate{cert})
+					}
+				}
+			}
+		}
+
 	case msg.Code == NewBlockMsg:
 		// Retrieve and decode the propagated block
 		var request newBlockData
@@ -734,9 +933,20 @@ func (pm *ProtocolManager) BroadcastConsensusInfo(hash common.Hash, number uint6
 	conInfo.Hash = hash
 	conInfo.Number = number
 	conInfo.HasConsensus = uint8(0)
+	if hasFlag && pm.chainconfig.Dpovp != nil && pm.chainconfig.Dpovp.BLSVotes {
+		// 使用BLS时广播本节点已聚合的全部确认
+		signers, aggregate, ok := pm.blockchain.ConsensusAggregate(hash)
+		if !ok {
+			return
+		}
+		vote := &aggregateVoteData{Hash: hash, Number: number, Signers: signers, Aggregate: aggregate}
+		for _, peer := range pm.peers.TotalPeers() {
+			peer.SendAggregateVote(vote)
+		}
+		return
+	}
 	if hasFlag {
-		privKey := dpovp.GetPrivKey()                   // 获取私钥
-		signInfo, err := crypto.Sign(hash[:], &privKey) // 获取签名
+		signInfo, err := dpovp.SignAs(pm.blockchain.Coinbase(), hash[:]) // 获取签名
 		if err != nil {
 			return
 		}
@@ -761,6 +971,53 @@ func (self *ProtocolManager) minedBroadcastLoop() {
 	}
 }
 
+// BroadcastEvidence propagates equivocation evidence to all star and satellite peers.
+func (pm *ProtocolManager) BroadcastEvidence(evidence *types.Evidence) {
+	peers := append(pm.peers.TotalPeers(), pm.peersDelay.TotalPeers()...)
+	for _, peer := range peers {
+		peer.SendEvidence([]*types.Evidence{evidence})
+	}
+	log.Trace("Broadcast evidence", "hash", evidence.Hash(), "recipients", len(peers))
+}
+
+// Evidence broadcast loop
+func (pm *ProtocolManager) evidenceBroadcastLoop() {
+	for {
+		select {
+		case event := <-pm.evidenceCh:
+			pm.BroadcastEvidence(event.Evidence)
+
+		// Err() channel will be closed when unsubscribing.
+		case <-pm.evidenceSub.Err():
+			return
+		}
+	}
+}
+
+// Star list change loop
+// A reloaded star list only applies from a later block on, the peers are
+// reclassified once the chain head reaches the block before it.
+func (pm *ProtocolManager) starListLoop() {
+	var pending uint64 // First block of a reloaded star list not in effect yet (0 = none)
+	for {
+		select {
+		case ev := <-pm.starListCh:
+			pending = ev.Number
+		case <-pm.chainHeadCh:
+
+		// Err() channel will be closed when unsubscribing.
+		case <-pm.starListSub.Err():
+			return
+		case <-pm.chainHeadSub.Err():
+			return
+		}
+		if pending != 0 && pm.blockchain.CurrentBlock().NumberU64()+1 >= pending {
+			pm.reclassifyPeers()
+			pending = 0
+		}
+	}
+}
+
 func (self *ProtocolManager) txBroadcastLoop() {
 	for {
 		select {
@@ -21,6 +21,7 @@ import (
 	"math/big"
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/LoveBlock/loveblock/common"
 	"github.com/LoveBlock/loveblock/core"
@@ -303,7 +304,7 @@ func testGetNodeData(t *testing.T, protocol int) {
 	acc1Addr := crypto.PubkeyToAddress(acc1Key.PublicKey)
 	acc2Addr := crypto.PubkeyToAddress(acc2Key.PublicKey)
 
-	signer := types.NewDefaultSigner(params.AllLovehashProtocolChanges.ChainId)
+	signer := types.NewDefaultSigner(params.TestChainConfig.ChainId)
 	// Create a chain generator with some simple transactions (blatantly stolen from @fjl/chain_markets_test)
 	generator := func(i int, block *core.BlockGen) {
 		switch i {
@@ -395,7 +396,7 @@ func testGetReceipt(t *testing.T, protocol int) {
 	acc1Addr := crypto.PubkeyToAddress(acc1Key.PublicKey)
 	acc2Addr := crypto.PubkeyToAddress(acc2Key.PublicKey)
 
-	signer := types.NewDefaultSigner(params.AllLovehashProtocolChanges.ChainId)
+	signer := types.NewDefaultSigner(params.TestChainConfig.ChainId)
 	// Create a chain generator with some simple transactions (blatantly stolen from @fjl/chain_markets_test)
 	generator := func(i int, block *core.BlockGen) {
 		switch i {
@@ -443,3 +444,42 @@ func testGetReceipt(t *testing.T, protocol int) {
 		t.Errorf("receipts mismatch: %v", err)
 	}
 }
+
+// Tests that the blocks a stable block skipped ahead of are announced to the
+// satellites along with it, as satellites only import announced blocks, and that
+// no block is announced twice.
+func TestAnnounceStable(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 5, nil, nil)
+	peer, _ := newTestPeer("satellite", 63, pm, true)
+	defer peer.close()
+
+	for start := time.Now(); pm.peersDelay.Len() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("satellite not registered")
+		}
+	}
+	announce := func(number uint64) {
+		pm.announceStable(pm.blockchain.GetBlockByNumber(number).Hash(), number)
+	}
+	expect := func(numbers ...uint64) {
+		var announces newBlockHashesData
+		for _, number := range numbers {
+			announces = append(announces, struct {
+				Hash   common.Hash
+				Number uint64
+			}{pm.blockchain.GetBlockByNumber(number).Hash(), number})
+		}
+		if err := p2p.ExpectMsg(peer.app, NewBlockHashesMsg, announces); err != nil {
+			t.Errorf("announcement of %v mismatch: %v", numbers, err)
+		}
+	}
+	go announce(2)
+	expect(2)
+
+	// Blocks at or below the last announced one are not announced again
+	go announce(1)
+	go announce(2)
+
+	go announce(5)
+	expect(3, 4, 5)
+}
@@ -28,6 +28,7 @@ import (
 	"testing"
 
 	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/consensus/dpovp"
 	"github.com/LoveBlock/loveblock/core"
 	"github.com/LoveBlock/loveblock/core/types"
 	"github.com/LoveBlock/loveblock/core/vm"
@@ -114,7 +115,7 @@ func (p *testTxPool) Pending() (map[common.Address]types.Transactions, error) {
 
 	batches := make(map[common.Address]types.Transactions)
 	for _, tx := range p.pool {
-		from, _ := types.Sender(types.NewDefaultSigner(params.AllLovehashProtocolChanges.ChainId), tx)
+		from, _ := types.Sender(types.NewDefaultSigner(params.TestChainConfig.ChainId), tx)
 		batches[from] = append(batches[from], tx)
 	}
 	for _, batch := range batches {
@@ -130,7 +131,7 @@ func (p *testTxPool) SubscribeTxPreEvent(ch chan<- core.TxPreEvent) event.Subscr
 // newTestTransaction create a new dummy transaction.
 func newTestTransaction(from *ecdsa.PrivateKey, nonce uint64, datasize int) *types.Transaction {
 	tx := types.NewTransaction(nonce, common.Address{}, big.NewInt(0), 100000, big.NewInt(0), make([]byte, datasize))
-	tx, _ = types.SignTx(tx, types.NewDefaultSigner(params.AllLovehashProtocolChanges.ChainId), from)
+	tx, _ = types.SignTx(tx, types.NewDefaultSigner(params.TestChainConfig.ChainId), from)
 	return tx
 }
 
@@ -160,6 +160,31 @@ func (p *peer) SendConsensusInfo(data newConsensusData) error {
 	return p2p.Send(p.rw, NewConsensusMsg, data)
 }
 
+// SendEvidence propagates evidence of stars sealing conflicting blocks to a
+// remote peer.
+func (p *peer) SendEvidence(evidence []*types.Evidence) error {
+	return p2p.Send(p.rw, EvidenceMsg, evidence)
+}
+
+// SendAggregateVote propagates the aggregated BLS votes of a block to a remote
+// star peer.
+func (p *peer) SendAggregateVote(data *aggregateVoteData) error {
+	return p2p.Send(p.rw, AggregateVoteMsg, data)
+}
+
+// SendFinality sends a batch of finality certificates, corresponding to the ones
+// requested.
+func (p *peer) SendFinality(certs []*types.FinalityCertificate) error {
+	return p2p.Send(p.rw, FinalityMsg, certs)
+}
+
+// RequestFinality fetches the finality certificates of a batch of blocks from a
+// remote node.
+func (p *peer) RequestFinality(hashes []common.Hash) error {
+	p.Log().Debug("Fetching batch of finality certificates", "count", len(hashes))
+	return p2p.Send(p.rw, GetFinalityMsg, hashes)
+}
+
 // 发送新的完整的block
 // SendNewBlock propagates an entire block to a remote peer.
 func (p *peer) SendNewBlock(block *types.Block, td *big.Int) error {
@@ -57,6 +57,10 @@ const (
 	BlockBodiesMsg     = 0x06 //区块体集消息
 	NewBlockMsg        = 0x07 // 新的完整的block消息
 	NewConsensusMsg    = 0x08 // sman 区块确认消息
+	EvidenceMsg        = 0x09 // 主节点同一slot内签发两个区块的证据
+	GetFinalityMsg     = 0x0a // 请求区块的最终性证明
+	FinalityMsg        = 0x0b // 区块的最终性证明集消息
+	AggregateVoteMsg   = 0x0c // sman 聚合的BLS区块确认消息
 
 	// Protocol messages belonging to network/63
 	GetNodeDataMsg = 0x0d
@@ -130,6 +134,14 @@ type newBlockHashesData []struct {
 // sman for: NewConsensusMsg
 type newConsensusData []blockConsensusData
 
+// aggregateVoteData is the network packet of the BLS votes collected for a block.
+type aggregateVoteData struct {
+	Hash      common.Hash // 区块hash
+	Number    uint64      // 区块高度
+	Signers   *big.Int    // 已聚合确认的主节点 按位计算
+	Aggregate []byte      // 聚合签名
+}
+
 // getBlockHeadersData represents a block header query.
 type getBlockHeadersData struct {
 	Origin  hashOrNumber // Block from which to retrieve headers
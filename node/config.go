@@ -81,6 +81,22 @@ type Config struct {
 	// scrypt KDF at the expense of security.
 	UseLightweightKDF bool `toml:",omitempty"`
 
+	// StarSigner is the address of the account signing the blocks and consensus
+	// votes of a star node. If unset, they are signed with the node key, tying the
+	// star's identity to its devp2p identity.
+	StarSigner common.Address `toml:",omitempty"`
+
+	// StarSignerPasswordFile is the file holding the password that decrypts the
+	// StarSigner account and its BLS key in the keystore. The account itself is
+	// not unlocked, so it can't be used through the account APIs.
+	StarSignerPasswordFile string `toml:",omitempty"`
+
+	// ExternalSigner is the IPC endpoint of an external signer holding the key of
+	// the StarSigner account, which is then not looked up in the keystore. The
+	// signer has to serve account_signHash(address, hash), returning a 65 byte
+	// deterministic secp256k1 signature of the hash.
+	ExternalSigner string `toml:",omitempty"`
+
 	// IPCPath is the requested location to place the IPC endpoint. If the path is
 	// a simple file name, it is placed inside the data directory (or on the root
 	// pipe path on Windows), whereas if it's a resolvable path name (absolute or
@@ -27,7 +27,6 @@ import (
 	"sync"
 
 	"github.com/LoveBlock/loveblock/accounts"
-	"github.com/LoveBlock/loveblock/common/dpovp"
 	"github.com/LoveBlock/loveblock/event"
 	"github.com/LoveBlock/loveblock/internal/debug"
 	"github.com/LoveBlock/loveblock/log"
@@ -48,6 +47,7 @@ type Node struct {
 
 	serverConfig p2p.Config
 	server       *p2p.Server // Currently running P2P networking layer
+	signerClient *rpc.Client // Connection to the external signer, if any
 
 	serviceFuncs []ServiceConstructor     // Service constructors (in dependency order)
 	services     map[reflect.Type]Service // Currently running services
@@ -164,7 +164,9 @@ func (n *Node) Start() error {
 		n.serverConfig.NodeDatabase = n.config.NodeDB()
 	}
 	running := &p2p.Server{Config: n.serverConfig}
-	dpovp.SetPrivKey(n.serverConfig.PrivateKey) // sman for dpovp
+	if err := n.openStarSigner(); err != nil {
+		return err
+	}
 	n.log.Info("Starting peer-to-peer node", "instance", n.serverConfig.Name)
 
 	// Otherwise copy and specialize the P2P configuration
@@ -500,6 +502,10 @@ func (n *Node) Stop() error {
 	n.server.Stop()
 	n.services = nil
 	n.server = nil
+	if n.signerClient != nil {
+		n.signerClient.Close()
+		n.signerClient = nil
+	}
 
 	// Release instance directory lock.
 	if n.instanceDirLock != nil {
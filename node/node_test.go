@@ -17,13 +17,20 @@
 package node
 
 import (
+	"bytes"
+	"crypto/ecdsa"
 	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/LoveBlock/loveblock/accounts/keystore"
+	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/common/hexutil"
 	"github.com/LoveBlock/loveblock/crypto"
 	"github.com/LoveBlock/loveblock/p2p"
 	"github.com/LoveBlock/loveblock/rpc"
@@ -573,3 +580,124 @@ func TestAPIGather(t *testing.T) {
 		}
 	}
 }
+
+// TestSigner is an external signer serving the signatures of a single key.
+type TestSigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func (s *TestSigner) SignHash(account common.Address, hash hexutil.Bytes) (hexutil.Bytes, error) {
+	if account != crypto.PubkeyToAddress(s.key.PublicKey) {
+		return nil, errors.New("unknown account")
+	}
+	return crypto.Sign(hash, s.key)
+}
+
+// Tests that star nodes sign with the configured keystore account or external
+// signer rather than with their node key.
+func TestNodeStarSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary data directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	signerAddr := func() common.Address {
+		return common.BytesToAddress(crypto.Keccak256(dpovp.GetPubkey()[1:])[12:])
+	}
+	// Without a signer account the node key signs
+	stack, _ := New(testNodeConfig())
+	if err := stack.Start(); err != nil {
+		t.Fatalf("failed to start node: %v", err)
+	}
+	stack.Stop()
+	if have, want := signerAddr(), crypto.PubkeyToAddress(testNodeKey.PublicKey); have != want {
+		t.Errorf("node key signer mismatch: have %x, want %x", have, want)
+	}
+	// Keystore accounts are unlocked with the password file
+	ks := keystore.NewKeyStore(filepath.Join(dir, "keystore"), keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.NewAccount("secret")
+	if err != nil {
+		t.Fatalf("failed to create account: %v", err)
+	}
+	password := filepath.Join(dir, "password")
+	ioutil.WriteFile(password, []byte("secret\n"), 0600)
+
+	config := testNodeConfig()
+	config.KeyStoreDir = filepath.Join(dir, "keystore")
+	config.UseLightweightKDF = true
+	config.StarSigner = account.Address
+	config.StarSignerPasswordFile = filepath.Join(dir, "wrong")
+	ioutil.WriteFile(config.StarSignerPasswordFile, []byte("wrong"), 0600)
+
+	stack, _ = New(config)
+	if err := stack.Start(); err == nil {
+		stack.Stop()
+		t.Fatalf("node started with wrong signer password")
+	}
+	config.StarSignerPasswordFile = password
+	stack, _ = New(config)
+	if err := stack.Start(); err != nil {
+		t.Fatalf("failed to start node: %v", err)
+	}
+	// The account signs for the star only, it must stay locked for everyone else
+	nodeKs := stack.AccountManager().Backends(keystore.KeyStoreType)[0].(*keystore.KeyStore)
+	if _, err := nodeKs.SignHash(account, crypto.Keccak256([]byte("tx"))); err != keystore.ErrLocked {
+		t.Errorf("star signer account unlocked: have %v, want %v", err, keystore.ErrLocked)
+	}
+	stack.Stop()
+	if have := signerAddr(); have != account.Address {
+		t.Errorf("keystore signer mismatch: have %x, want %x", have, account.Address)
+	}
+	// The signer votes with the BLS key kept in the keystore
+	blsKey, err := ks.BLSKey(account.Address, "secret")
+	if err != nil {
+		t.Fatalf("failed to open BLS key: %v", err)
+	}
+	if !bytes.Equal(dpovp.GetBLSKey().Marshal(), blsKey.Marshal()) {
+		t.Errorf("star signer doesn't vote with its keystore BLS key")
+	}
+	// External signers are reached over IPC
+	key, _ := crypto.GenerateKey()
+	server := rpc.NewServer()
+	server.RegisterName("account", &TestSigner{key})
+	endpoint := filepath.Join(dir, "signer.ipc")
+	listener, err := rpc.CreateIPCListener(endpoint)
+	if err != nil {
+		t.Fatalf("failed to listen on signer endpoint: %v", err)
+	}
+	go server.ServeListener(listener)
+	defer listener.Close()
+
+	// Signers answering with the key of another account are refused
+	config = testNodeConfig()
+	config.StarSigner = account.Address
+	config.KeyStoreDir = filepath.Join(dir, "keystore")
+	config.UseLightweightKDF = true
+	config.StarSignerPasswordFile = password
+	config.ExternalSigner = endpoint
+	stack, _ = New(config)
+	if err := stack.Start(); err == nil {
+		stack.Stop()
+		t.Fatalf("node started with external signer of another account")
+	}
+	config = testNodeConfig()
+	config.StarSigner = crypto.PubkeyToAddress(key.PublicKey)
+	config.ExternalSigner = endpoint
+	stack, _ = New(config)
+	if err := stack.Start(); err != nil {
+		t.Fatalf("failed to start node: %v", err)
+	}
+	defer stack.Stop()
+	if have := signerAddr(); have != config.StarSigner {
+		t.Errorf("external signer mismatch: have %x, want %x", have, config.StarSigner)
+	}
+	hash := crypto.Keccak256([]byte("block"))
+	sig, err := dpovp.Sign(hash)
+	if err != nil {
+		t.Fatalf("failed to sign with external signer: %v", err)
+	}
+	if pub, _ := crypto.Ecrecover(hash, sig); !bytes.Equal(pub, crypto.FromECDSAPub(&key.PublicKey)) {
+		t.Errorf("external signature from wrong key")
+	}
+}
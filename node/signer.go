@@ -0,0 +1,133 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/LoveBlock/loveblock/accounts"
+	"github.com/LoveBlock/loveblock/accounts/keystore"
+	"github.com/LoveBlock/loveblock/common"
+	"github.com/LoveBlock/loveblock/common/dpovp"
+	"github.com/LoveBlock/loveblock/common/hexutil"
+	"github.com/LoveBlock/loveblock/crypto"
+	"github.com/LoveBlock/loveblock/rpc"
+)
+
+// openStarSigner sets up the key signing the blocks and consensus votes of the
+// node: an external signer, a keystore account or, by default, the
+// node key. Star signers vote with a BLS key of their own, kept in the keystore
+// and encrypted with the star signer password.
+func (n *Node) openStarSigner() error {
+	signer := n.config.StarSigner
+	switch {
+	case n.config.ExternalSigner != "":
+		if signer == (common.Address{}) {
+			return fmt.Errorf("external signer %s needs a star signer account", n.config.ExternalSigner)
+		}
+		ks := n.accman.Backends(keystore.KeyStoreType)[0].(*keystore.KeyStore)
+		password, err := readPasswordFile(n.config.StarSignerPasswordFile)
+		if err != nil {
+			return err
+		}
+		blsKey, err := ks.BLSKey(signer, password)
+		if err != nil {
+			return fmt.Errorf("can't open BLS key of star signer %x: %v", signer, err)
+		}
+		client, err := rpc.Dial(n.config.ExternalSigner)
+		if err != nil {
+			return fmt.Errorf("can't connect to external signer: %v", err)
+		}
+		addr, err := dpovp.SetSigner(externalSignerFn(client, signer), blsKey)
+		if err != nil {
+			client.Close()
+			return fmt.Errorf("external signer failed: %v", err)
+		}
+		if addr != signer {
+			client.Close()
+			return fmt.Errorf("external signer key mismatch: have %x, want %x", addr, signer)
+		}
+		n.signerClient = client
+		n.log.Info("Signing with external signer", "address", signer, "endpoint", n.config.ExternalSigner)
+
+	case signer != (common.Address{}):
+		ks := n.accman.Backends(keystore.KeyStoreType)[0].(*keystore.KeyStore)
+		account, err := ks.Find(accounts.Account{Address: signer})
+		if err != nil {
+			return ErrSignerUnknown
+		}
+		password, err := readPasswordFile(n.config.StarSignerPasswordFile)
+		if err != nil {
+			return err
+		}
+		// Decrypt the key for the signer only, unlocking the account would let
+		// anyone with access to the account APIs sign with it
+		keyjson, err := ioutil.ReadFile(account.URL.Path)
+		if err != nil {
+			return fmt.Errorf("can't read star signer %x: %v", signer, err)
+		}
+		key, err := keystore.DecryptKey(keyjson, password)
+		if err != nil {
+			return fmt.Errorf("can't unlock star signer %x: %v", signer, err)
+		}
+		if key.Address != signer {
+			return fmt.Errorf("star signer key mismatch: have %x, want %x", key.Address, signer)
+		}
+		blsKey, err := ks.BLSKey(signer, password)
+		if err != nil {
+			return fmt.Errorf("can't open BLS key of star signer %x: %v", signer, err)
+		}
+		if _, err := dpovp.SetSigner(func(hash []byte) ([]byte, error) { return crypto.Sign(hash, key.PrivateKey) }, blsKey); err != nil {
+			return err
+		}
+		n.log.Info("Signing with keystore account", "address", signer)
+
+	default:
+		dpovp.SetPrivKey(n.serverConfig.PrivateKey) // sman for dpovp
+	}
+	return nil
+}
+
+// externalSignerFn returns a signer requesting the signatures of the given
+// account from an external signer.
+func externalSignerFn(client *rpc.Client, account common.Address) dpovp.SignerFn {
+	return func(hash []byte) ([]byte, error) {
+		var sig hexutil.Bytes
+		if err := client.Call(&sig, "account_signHash", account, hexutil.Bytes(hash)); err != nil {
+			return nil, err
+		}
+		if len(sig) != 65 {
+			return nil, fmt.Errorf("invalid signature length %d from external signer", len(sig))
+		}
+		return sig, nil
+	}
+}
+
+// readPasswordFile returns the first line of the given password file.
+func readPasswordFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password file: %v", err)
+	}
+	lines := strings.Split(string(text), "\n")
+	return strings.TrimRight(lines[0], "\r"), nil
+}
@@ -31,15 +31,18 @@ var (
 
 var (
 	// MainnetChainConfig is the chain parameters to run a node on the main network.
+	//
+	// Blocks below SealHashBlock are sealed over the coinbase only, so their seals
+	// can be replayed on other chains; stars have to upgrade before that height.
 	MainnetChainConfig = &ChainConfig{
 		ChainId: big.NewInt(20180627),
-		Dpovp:   &DpovpConfig{Timeout: 10 * 1000, Sleeptime: 3 * 1000},
+		Dpovp:   &DpovpConfig{Timeout: 10 * 1000, Sleeptime: 3 * 1000, SealHashBlock: big.NewInt(6000000)},
 	}
 
 	// TestnetChainConfig contains the chain parameters to run a node on the Test network.
 	TestnetChainConfig = &ChainConfig{
 		ChainId: big.NewInt(100),
-		Dpovp:   &DpovpConfig{Timeout: 10 * 1000, Sleeptime: 3 * 1000},
+		Dpovp:   &DpovpConfig{Timeout: 10 * 1000, Sleeptime: 3 * 1000, SealHashBlock: big.NewInt(4000000)},
 	}
 
 	// AllLovehashProtocolChanges contains every protocol change (EIPs) introduced
@@ -48,9 +51,9 @@ var (
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
 	// AllLovehashProtocolChanges = &ChainConfig{big.NewInt(1337), &DpovpConfig{Timeout: 10 * 1000, Sleeptime: 3 * 1000}}
-	AllLovehashProtocolChanges = &ChainConfig{big.NewInt(20180627), &DpovpConfig{Timeout: 10 * 1000, Sleeptime: 3 * 1000}}
+	AllLovehashProtocolChanges = &ChainConfig{big.NewInt(20180627), big.NewInt(0), big.NewInt(0), &DpovpConfig{Timeout: 10 * 1000, Sleeptime: 3 * 1000, SealHashBlock: big.NewInt(0)}}
 
-	TestChainConfig = &ChainConfig{big.NewInt(1), new(DpovpConfig)}
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), big.NewInt(0), &DpovpConfig{SealHashBlock: big.NewInt(0)}}
 )
 
 // ChainConfig is the core config which determines the blockchain settings.
@@ -61,6 +64,9 @@ var (
 type ChainConfig struct {
 	ChainId *big.Int `json:"chainId"` // Chain id identifies the current chain and is used for replay protection
 
+	ConstantinopleBlock *big.Int `json:"constantinopleBlock,omitempty"` // Constantinople switch block (nil = no fork, 0 = already activated)
+	IstanbulBlock       *big.Int `json:"istanbulBlock,omitempty"`       // Istanbul switch block (nil = no fork, 0 = already activated)
+
 	// Various consensus engines
 	Dpovp *DpovpConfig `json:"dpovp,omitempty"` // sman for dpovp
 }
@@ -69,6 +75,116 @@ type ChainConfig struct {
 type DpovpConfig struct {
 	Timeout   int64 `json:"Timeout"`   // Number of timeout between blocks to produce millsecond
 	Sleeptime int64 `json:"Sleeptime"` // Time of one block is produced and before ohter node begin produce another block millsecond
+
+	Epoch uint64 `json:"epoch,omitempty"` // Number of blocks after which the star list is checkpointed (0 = fixed star list)
+
+	SealHashBlock  *big.Int `json:"sealHashBlock,omitempty"`  // Seal switch block (nil = no fork, 0 = already on full header seal)
+	MilliTimeBlock *big.Int `json:"milliTimeBlock,omitempty"` // Millisecond timestamp switch block (nil = no fork, 0 = already on millisecond timestamps)
+
+	QuorumNumerator   uint64 `json:"quorumNumerator,omitempty"`   // Fraction of the stars a stable block's confirmations must exceed (0 = 2/3)
+	QuorumDenominator uint64 `json:"quorumDenominator,omitempty"` // Denominator of the quorum fraction
+
+	BLSVotes bool `json:"blsVotes,omitempty"` // Whether stars confirm blocks with aggregated BLS votes instead of secp256k1 signatures
+
+	SlashPolicy  string   `json:"slashPolicy,omitempty"`  // Punishment of stars caught equivocating (empty = record evidence only)
+	SlashPenalty *big.Int `json:"slashPenalty,omitempty"` // Amount burnt from the coinbase of an equivocating star
+
+	JailThreshold uint64 `json:"jailThreshold,omitempty"` // Consecutive missed slots after which a star leaves the rotation (0 = never)
+	JailPeriod    uint64 `json:"jailPeriod,omitempty"`    // Number of blocks a jailed star stays out of the rotation at least
+
+	BlockReward       *big.Int `json:"blockReward,omitempty"`       // Reward of the blocks before the first reduction in wei (nil = 5 LOVE)
+	ReductionInterval uint64   `json:"reductionInterval,omitempty"` // Number of blocks between two reward reductions (0 = constant reward)
+	ReductionPercent  uint64   `json:"reductionPercent,omitempty"`  // Percent cut from the reward at every reduction (50 = halving)
+	MaxIssuance       *big.Int `json:"maxIssuance,omitempty"`       // Cap on the sum of all block rewards in wei (nil = unlimited)
+
+	Treasury       common.Address `json:"treasury,omitempty"`       // Recipient of the treasury shares of rewards and fees
+	RewardTreasury uint64         `json:"rewardTreasury,omitempty"` // Percent of the block reward paid to the treasury
+	RewardBurn     uint64         `json:"rewardBurn,omitempty"`     // Percent of the block reward burnt
+	FeeTreasury    uint64         `json:"feeTreasury,omitempty"`    // Percent of the transaction fees paid to the treasury
+	FeeBurn        uint64         `json:"feeBurn,omitempty"`        // Percent of the transaction fees burnt
+}
+
+// DefaultBlockReward is the reward of the blocks before the first reduction in
+// wei, on chains not configuring one.
+var DefaultBlockReward = big.NewInt(5 * Love)
+
+// Punishments of star nodes caught sealing two blocks in the same slot.
+const (
+	SlashPolicyNone    = ""        // Evidence is only recorded
+	SlashPolicyPenalty = "penalty" // SlashPenalty is burnt from the offender's coinbase
+	SlashPolicyRemove  = "remove"  // SlashPenalty is burnt and the offender is removed from the star registry
+)
+
+// IsCheckpoint returns whether the block with the given number is an epoch
+// checkpoint, carrying the star list of the following epoch in its extra data.
+func (c *DpovpConfig) IsCheckpoint(number uint64) bool {
+	return c.Epoch != 0 && number%c.Epoch == 0
+}
+
+// Quorum returns the number of star confirmations a block needs to become stable
+// among the given number of stars: strictly more than the configured fraction of
+// them, two thirds unless configured otherwise. For stars with confirmation
+// weights, stars is their total weight and the quorum a weight as well. The
+// quorum is never below a single confirmation, so blocks without any star
+// scheduled for them can't become stable.
+func (c *DpovpConfig) Quorum(stars int) int {
+	if stars < 1 {
+		return 1
+	}
+	num, den := uint64(2), uint64(3)
+	if c != nil && c.QuorumNumerator != 0 && c.QuorumDenominator != 0 {
+		num, den = c.QuorumNumerator, c.QuorumDenominator
+	}
+	if quorum := uint64(stars)*num/den + 1; quorum < uint64(stars) {
+		return int(quorum)
+	}
+	return stars
+}
+
+// SplitsFees returns whether transaction fees are shared with the treasury or
+// burnt. If so, fees are not paid to the coinbase by the state transition but
+// distributed by the consensus engine when finalizing the block.
+func (c *DpovpConfig) SplitsFees() bool {
+	return c.FeeTreasury != 0 || c.FeeBurn != 0
+}
+
+// IsSealHash returns whether num is either equal to the seal hash fork block or
+// greater. From this block on the block seal covers the whole sealing hash of
+// the header and the chain id instead of the coinbase only.
+func (c *DpovpConfig) IsSealHash(num *big.Int) bool {
+	return isForked(c.SealHashBlock, num)
+}
+
+// IsMilliTime returns whether num is either equal to the millisecond timestamp
+// fork block or greater. From this block on the header nonce carries the block
+// time in milliseconds, the header time staying in seconds.
+func (c *DpovpConfig) IsMilliTime(num *big.Int) bool {
+	return c != nil && isForked(c.MilliTimeBlock, num)
+}
+
+// IsConstantinople returns whether num is either equal to the Constantinople fork
+// block or greater. From this block on the EVM supports CREATE2 and EXTCODEHASH.
+func (c *ChainConfig) IsConstantinople(num *big.Int) bool {
+	return isForked(c.ConstantinopleBlock, num)
+}
+
+// IsIstanbul returns whether num is either equal to the Istanbul fork block or
+// greater. From this block on the EVM supports CHAINID and SELFBALANCE, and the
+// state reading opcodes are repriced.
+func (c *ChainConfig) IsIstanbul(num *big.Int) bool {
+	return isForked(c.IstanbulBlock, num)
+}
+
+// IsSealHash returns whether num is either equal to the seal hash fork block of
+// the consensus engine or greater.
+func (c *ChainConfig) IsSealHash(num *big.Int) bool {
+	return c.Dpovp != nil && c.Dpovp.IsSealHash(num)
+}
+
+// IsMilliTime returns whether num is either equal to the millisecond timestamp
+// fork block of the consensus engine or greater.
+func (c *ChainConfig) IsMilliTime(num *big.Int) bool {
+	return c.Dpovp.IsMilliTime(num)
 }
 
 // String implements the fmt.Stringer interface.
@@ -80,12 +196,165 @@ func (c *ChainConfig) String() string {
 	default:
 		engine = "unknown"
 	}
-	return fmt.Sprintf("{ChainID: %v Engine: %v}",
+	return fmt.Sprintf("{ChainID: %v Constantinople: %v Istanbul: %v Engine: %v}",
 		c.ChainId,
+		c.ConstantinopleBlock,
+		c.IstanbulBlock,
 		engine,
 	)
 }
 
+// GasTable returns the gas table corresponding to the current phase.
+//
+// The returned GasTable's fields shouldn't, under any circumstances, be changed.
+func (c *ChainConfig) GasTable(num *big.Int) GasTable {
+	switch {
+	case c.IsIstanbul(num):
+		return GasTableIstanbul
+	case c.IsConstantinople(num):
+		return GasTableConstantinople
+	default:
+		return DefaultGasTable
+	}
+}
+
+// forkBlock is a protocol change scheduled at a block of the chain.
+type forkBlock struct {
+	name  string   // Name of the fork in compatibility errors
+	block *big.Int // Block the change activates at (nil = not scheduled)
+}
+
+// forks returns the protocol changes scheduled by the config. Every fork block
+// added to the config must be listed here, so that rescheduling it below the
+// head of an existing chain is detected on restart.
+func (c *ChainConfig) forks() []forkBlock {
+	var dpovp DpovpConfig
+	if c.Dpovp != nil {
+		dpovp = *c.Dpovp
+	}
+	return []forkBlock{
+		{"Constantinople fork block", c.ConstantinopleBlock},
+		{"Istanbul fork block", c.IstanbulBlock},
+		{"seal hash fork block", dpovp.SealHashBlock},
+		{"millisecond time fork block", dpovp.MilliTimeBlock},
+	}
+}
+
+// consensusParam is a parameter blocks are verified against from the genesis
+// block on, rendered as a number for mismatch errors.
+type consensusParam struct {
+	name  string   // Name of the parameter in mismatch errors
+	value *big.Int // Value of the parameter (nil = default)
+}
+
+// slashPolicies numbers the slashing policies in mismatch errors.
+var slashPolicies = []string{SlashPolicyNone, SlashPolicyPenalty, SlashPolicyRemove}
+
+// consensusParams returns the parameters of the chain that have no fork block of
+// their own. Every such parameter added to the config must be listed here, so
+// that changing it on an existing chain is detected on restart.
+func (c *ChainConfig) consensusParams() []consensusParam {
+	var dpovp DpovpConfig
+	if c.Dpovp != nil {
+		dpovp = *c.Dpovp
+	}
+	num := func(n uint64) *big.Int { return new(big.Int).SetUint64(n) }
+
+	blockReward := dpovp.BlockReward
+	if blockReward == nil {
+		blockReward = DefaultBlockReward
+	}
+	blsVotes, slashPolicy := num(0), big.NewInt(-1)
+	if dpovp.BLSVotes {
+		blsVotes = num(1)
+	}
+	for i, policy := range slashPolicies {
+		if dpovp.SlashPolicy == policy {
+			slashPolicy = big.NewInt(int64(i))
+		}
+	}
+	return []consensusParam{
+		{"chain id", c.ChainId},
+		{"dpovp epoch", num(dpovp.Epoch)},
+		{"dpovp quorum numerator", num(dpovp.QuorumNumerator)},
+		{"dpovp quorum denominator", num(dpovp.QuorumDenominator)},
+		{"dpovp bls votes", blsVotes},
+		{"dpovp slash policy", slashPolicy},
+		{"dpovp slash penalty", dpovp.SlashPenalty},
+		{"dpovp jail threshold", num(dpovp.JailThreshold)},
+		{"dpovp jail period", num(dpovp.JailPeriod)},
+		{"dpovp block reward", blockReward},
+		{"dpovp reduction interval", num(dpovp.ReductionInterval)},
+		{"dpovp reduction percent", num(dpovp.ReductionPercent)},
+		{"dpovp max issuance", dpovp.MaxIssuance},
+		{"dpovp treasury", new(big.Int).SetBytes(dpovp.Treasury[:])},
+		{"dpovp reward treasury share", num(dpovp.RewardTreasury)},
+		{"dpovp reward burn share", num(dpovp.RewardBurn)},
+		{"dpovp fee treasury share", num(dpovp.FeeTreasury)},
+		{"dpovp fee burn share", num(dpovp.FeeBurn)},
+	}
+}
+
+// CheckConsensusParams checks whether the parameters without a fork block of a
+// chain with blocks beyond the genesis block would be changed by the new config.
+// Unlike fork blocks, such changes can't be accommodated by rewinding the chain.
+func (c *ChainConfig) CheckConsensusParams(newcfg *ChainConfig, height uint64) *ConsensusParamError {
+	if height == 0 {
+		return nil
+	}
+	stored, params := c.consensusParams(), newcfg.consensusParams()
+	for i := range stored {
+		if !configNumEqual(stored[i].value, params[i].value) {
+			return &ConsensusParamError{What: stored[i].name, StoredConfig: stored[i].value, NewConfig: params[i].value}
+		}
+	}
+	return nil
+}
+
+// CheckCompatible checks whether scheduled fork transitions have been imported
+// with a mismatching chain configuration.
+func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64) *ConfigCompatError {
+	bhead := new(big.Int).SetUint64(height)
+
+	// Iterate checkCompatible to find the lowest conflict.
+	var lasterr *ConfigCompatError
+	for {
+		err := c.checkCompatible(newcfg, bhead)
+		if err == nil || (lasterr != nil && err.RewindTo == lasterr.RewindTo) {
+			break
+		}
+		lasterr = err
+		bhead.SetUint64(err.RewindTo)
+	}
+	return lasterr
+}
+
+func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *ConfigCompatError {
+	stored, forks := c.forks(), newcfg.forks()
+	for i := range stored {
+		if isForkIncompatible(stored[i].block, forks[i].block, head) {
+			return newCompatError(stored[i].name, stored[i].block, forks[i].block)
+		}
+	}
+	return nil
+}
+
+// isForkIncompatible returns true if a fork scheduled at s1 cannot be rescheduled to
+// block s2 because head is already past the fork.
+func isForkIncompatible(s1, s2, head *big.Int) bool {
+	return (isForked(s1, head) || isForked(s2, head)) && !configNumEqual(s1, s2)
+}
+
+func configNumEqual(x, y *big.Int) bool {
+	if x == nil {
+		return y == nil
+	}
+	if y == nil {
+		return x == nil
+	}
+	return x.Cmp(y) == 0
+}
+
 // ConfigCompatError is raised if the locally-stored blockchain is initialised with a
 // ChainConfig that would alter the past.
 type ConfigCompatError struct {
@@ -96,17 +365,50 @@ type ConfigCompatError struct {
 	RewindTo uint64
 }
 
+func newCompatError(what string, storedblock, newblock *big.Int) *ConfigCompatError {
+	var rew *big.Int
+	switch {
+	case storedblock == nil:
+		rew = newblock
+	case newblock == nil || storedblock.Cmp(newblock) < 0:
+		rew = storedblock
+	default:
+		rew = newblock
+	}
+	err := &ConfigCompatError{what, storedblock, newblock, 0}
+	if rew != nil && rew.Sign() > 0 {
+		err.RewindTo = rew.Uint64() - 1
+	}
+	return err
+}
+
 func (err *ConfigCompatError) Error() string {
 	return fmt.Sprintf("mismatching %s in database (have %d, want %d, rewindto %d)", err.What, err.StoredConfig, err.NewConfig, err.RewindTo)
 }
 
+// ConsensusParamError is raised if the locally-stored blockchain is initialised
+// with a ChainConfig changing a parameter that applies from the genesis block on,
+// such as the chain id or the epoch length. The chain can't be rewound to adopt
+// the new value, it has to be synced again from genesis.
+type ConsensusParamError struct {
+	What string
+	// values of the parameter in the stored and new configurations
+	StoredConfig, NewConfig *big.Int
+}
+
+func (err *ConsensusParamError) Error() string {
+	return fmt.Sprintf("mismatching %s in database (have %d, want %d), resync from genesis to change it", err.What, err.StoredConfig, err.NewConfig)
+}
+
 // Rules wraps ChainConfig and is merely syntatic sugar or can be used for functions
 // that do not have or require information about the block.
 //
 // Rules is a one time interface meaning that it shouldn't be used in between transition
 // phases.
 type Rules struct {
-	ChainId *big.Int
+	ChainId                      *big.Int
+	IsConstantinople, IsIstanbul bool
+	IsSealHash, IsMilliTime      bool
 }
 
 func (c *ChainConfig) Rules(num *big.Int) Rules {
@@ -114,5 +416,19 @@ func (c *ChainConfig) Rules(num *big.Int) Rules {
 	if chainId == nil {
 		chainId = new(big.Int)
 	}
-	return Rules{ChainId: new(big.Int).Set(chainId)}
+	return Rules{
+		ChainId:          new(big.Int).Set(chainId),
+		IsConstantinople: c.IsConstantinople(num),
+		IsIstanbul:       c.IsIstanbul(num),
+		IsSealHash:       c.IsSealHash(num),
+		IsMilliTime:      c.IsMilliTime(num),
+	}
+}
+
+// isForked returns whether a fork scheduled at block s is active at the given head block.
+func isForked(s, head *big.Int) bool {
+	if s == nil || head == nil {
+		return false
+	}
+	return s.Cmp(head) <= 0
 }
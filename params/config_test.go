@@ -0,0 +1,165 @@
+// Copyright 2018 The loveblock Authors
+// This file is part of the loveblock library.
+//
+// The loveblock library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The loveblock library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the loveblock library. If not, see <http://www.gnu.org/licenses/>.
+
+package params
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestCheckCompatible(t *testing.T) {
+	type test struct {
+		stored, new *ChainConfig
+		head        uint64
+		wantErr     *ConfigCompatError
+	}
+	tests := []test{
+		{stored: AllLovehashProtocolChanges, new: AllLovehashProtocolChanges, head: 0, wantErr: nil},
+		{stored: AllLovehashProtocolChanges, new: AllLovehashProtocolChanges, head: 100, wantErr: nil},
+		{
+			stored:  &ChainConfig{Dpovp: &DpovpConfig{SealHashBlock: big.NewInt(10)}},
+			new:     &ChainConfig{Dpovp: &DpovpConfig{SealHashBlock: big.NewInt(20)}},
+			head:    9,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{Dpovp: &DpovpConfig{SealHashBlock: big.NewInt(0)}},
+			new:    &ChainConfig{Dpovp: &DpovpConfig{}},
+			head:   3,
+			wantErr: &ConfigCompatError{
+				What:         "seal hash fork block",
+				StoredConfig: big.NewInt(0),
+				NewConfig:    nil,
+				RewindTo:     0,
+			},
+		},
+		{
+			stored: &ChainConfig{Dpovp: &DpovpConfig{MilliTimeBlock: big.NewInt(30)}},
+			new:    &ChainConfig{Dpovp: &DpovpConfig{MilliTimeBlock: big.NewInt(25)}},
+			head:   25,
+			wantErr: &ConfigCompatError{
+				What:         "millisecond time fork block",
+				StoredConfig: big.NewInt(30),
+				NewConfig:    big.NewInt(25),
+				RewindTo:     24,
+			},
+		},
+		{
+			// The lowest of several conflicting forks determines the rewind
+			stored: &ChainConfig{Dpovp: &DpovpConfig{SealHashBlock: big.NewInt(30), MilliTimeBlock: big.NewInt(10)}},
+			new:    &ChainConfig{Dpovp: &DpovpConfig{SealHashBlock: big.NewInt(25), MilliTimeBlock: big.NewInt(20)}},
+			head:   40,
+			wantErr: &ConfigCompatError{
+				What:         "millisecond time fork block",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    big.NewInt(20),
+				RewindTo:     9,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		err := test.stored.CheckCompatible(test.new, test.head)
+		if !reflect.DeepEqual(err, test.wantErr) {
+			t.Errorf("error mismatch:\nstored: %v\nnew: %v\nhead: %v\nerr: %v\nwant: %v", test.stored, test.new, test.head, err, test.wantErr)
+		}
+	}
+}
+
+func TestCheckConsensusParams(t *testing.T) {
+	tests := []struct {
+		stored, new *ChainConfig
+		head        uint64
+		wantErr     *ConsensusParamError
+	}{
+		{stored: AllLovehashProtocolChanges, new: AllLovehashProtocolChanges, head: 100, wantErr: nil},
+		{
+			stored:  &ChainConfig{ChainId: big.NewInt(1), Dpovp: &DpovpConfig{Epoch: 100}},
+			new:     &ChainConfig{ChainId: big.NewInt(2), Dpovp: &DpovpConfig{Epoch: 200}},
+			head:    0,
+			wantErr: nil,
+		},
+		{
+			stored:  &ChainConfig{ChainId: big.NewInt(1)},
+			new:     &ChainConfig{ChainId: big.NewInt(2)},
+			head:    1,
+			wantErr: &ConsensusParamError{What: "chain id", StoredConfig: big.NewInt(1), NewConfig: big.NewInt(2)},
+		},
+		{
+			stored:  &ChainConfig{Dpovp: &DpovpConfig{Epoch: 100}},
+			new:     &ChainConfig{Dpovp: &DpovpConfig{Epoch: 200}},
+			head:    1,
+			wantErr: &ConsensusParamError{What: "dpovp epoch", StoredConfig: big.NewInt(100), NewConfig: big.NewInt(200)},
+		},
+		{
+			stored:  &ChainConfig{Dpovp: &DpovpConfig{SlashPolicy: SlashPolicyPenalty}},
+			new:     &ChainConfig{Dpovp: &DpovpConfig{SlashPolicy: SlashPolicyRemove}},
+			head:    10,
+			wantErr: &ConsensusParamError{What: "dpovp slash policy", StoredConfig: big.NewInt(1), NewConfig: big.NewInt(2)},
+		},
+		{
+			stored:  &ChainConfig{Dpovp: &DpovpConfig{BlockReward: big.NewInt(5)}},
+			new:     &ChainConfig{Dpovp: &DpovpConfig{}},
+			head:    10,
+			wantErr: &ConsensusParamError{What: "dpovp block reward", StoredConfig: big.NewInt(5), NewConfig: DefaultBlockReward},
+		},
+		{
+			// An explicit default reward is the same as none
+			stored:  &ChainConfig{Dpovp: &DpovpConfig{}},
+			new:     &ChainConfig{Dpovp: &DpovpConfig{BlockReward: big.NewInt(5 * Love)}},
+			head:    10,
+			wantErr: nil,
+		},
+		{
+			stored:  &ChainConfig{Dpovp: &DpovpConfig{FeeBurn: 10}},
+			new:     &ChainConfig{Dpovp: &DpovpConfig{FeeBurn: 20}},
+			head:    10,
+			wantErr: &ConsensusParamError{What: "dpovp fee burn share", StoredConfig: big.NewInt(10), NewConfig: big.NewInt(20)},
+		},
+	}
+	for _, test := range tests {
+		err := test.stored.CheckConsensusParams(test.new, test.head)
+		if !reflect.DeepEqual(err, test.wantErr) {
+			t.Errorf("error mismatch:\nstored: %v\nnew: %v\nhead: %v\nerr: %v\nwant: %v", test.stored, test.new, test.head, err, test.wantErr)
+		}
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	half := &DpovpConfig{QuorumNumerator: 1, QuorumDenominator: 2}
+	tests := []struct {
+		config *DpovpConfig
+		stars  int
+		want   int
+	}{
+		{nil, 0, 1},
+		{nil, -1, 1},
+		{nil, 1, 1},
+		{nil, 3, 3},
+		{nil, 4, 3},
+		{nil, 100, 67},
+		{half, 0, 1},
+		{half, 4, 3},
+		{half, 5, 3},
+	}
+	for _, test := range tests {
+		if quorum := test.config.Quorum(test.stars); quorum != test.want {
+			t.Errorf("quorum of %d stars: have %d, want %d", test.stars, quorum, test.want)
+		}
+	}
+}
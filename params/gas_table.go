@@ -16,9 +16,11 @@
 
 package params
 
+// GasTable organizes gas prices for different phases of the chain.
 type GasTable struct {
 	ExtcodeSize uint64
 	ExtcodeCopy uint64
+	ExtcodeHash uint64
 	Balance     uint64
 	SLoad       uint64
 	Calls       uint64
@@ -35,6 +37,7 @@ type GasTable struct {
 }
 
 var (
+	// DefaultGasTable contains the gas prices of the chain before any fork.
 	DefaultGasTable = GasTable{
 		ExtcodeSize: 700,
 		ExtcodeCopy: 700,
@@ -46,4 +49,34 @@ var (
 
 		CreateBySuicide: 25000,
 	}
+
+	// GasTableConstantinople contains the gas prices for the Constantinople
+	// phase, pricing the EXTCODEHASH opcode.
+	GasTableConstantinople = GasTable{
+		ExtcodeSize: 700,
+		ExtcodeCopy: 700,
+		ExtcodeHash: 400,
+		Balance:     400,
+		SLoad:       200,
+		Calls:       700,
+		Suicide:     5000,
+		ExpByte:     50,
+
+		CreateBySuicide: 25000,
+	}
+
+	// GasTableIstanbul contains the gas prices for the Istanbul phase, raising
+	// the prices of the state reading opcodes (EIP-1884).
+	GasTableIstanbul = GasTable{
+		ExtcodeSize: 700,
+		ExtcodeCopy: 700,
+		ExtcodeHash: 700,
+		Balance:     700,
+		SLoad:       800,
+		Calls:       700,
+		Suicide:     5000,
+		ExpByte:     50,
+
+		CreateBySuicide: 25000,
+	}
 )